@@ -0,0 +1,665 @@
+package cli
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage"
+	"update-manifest/pkg/storage/azure"
+	"update-manifest/pkg/storage/file"
+	"update-manifest/pkg/storage/s3"
+	"update-manifest/pkg/storage/sftp"
+)
+
+// backendFlags holds the flags selecting the bucket a command works on.
+type backendFlags struct {
+	destination string
+	endpoint    string
+	region      string
+	pathStyle   bool
+	accountID   string
+	bucket      string
+	roleARN     string
+	stsEndpoint string
+	sse         string
+	sseKMSKey   string
+	retries     int
+	retryDelay  time.Duration
+
+	partSize         byteSize
+	concurrency      int
+	disableMultipart bool
+
+	// journal records multipart uploads for resuming them, if set.
+	journal storage.UploadJournal
+}
+
+// addBackendFlags registers the bucket connection flags on fs. Credentials
+// are only read from the environment, to keep them out of process listings.
+func addBackendFlags(fs *flag.FlagSet) *backendFlags {
+	f := &backendFlags{}
+	fs.StringVar(&f.destination, "destination", "", "destination URL: s3://bucket, az://container, sftp://user@host/path or file:///path/to/dir (default $DESTINATION, or the S3 bucket $BUCKET)")
+	fs.StringVar(&f.endpoint, "endpoint", "", "S3-compatible endpoint, e.g. https://minio.example.com:9000 (default $ENDPOINT, or Cloudflare R2 from $ACCOUNT_ID)")
+	fs.StringVar(&f.region, "region", "", "bucket region (default $REGION)")
+	fs.BoolVar(&f.pathStyle, "path-style", false, "use path-style bucket addressing (default $PATH_STYLE)")
+	fs.StringVar(&f.accountID, "account-id", "", "Cloudflare account ID (default $ACCOUNT_ID)")
+	fs.StringVar(&f.bucket, "bucket", "", "S3 bucket when no destination is set (default $BUCKET)")
+	fs.StringVar(&f.roleARN, "role-arn", "", "role to assume with the OIDC token of the CI job through STS AssumeRoleWithWebIdentity instead of using $ACCESS_KEY and $ACCESS_SECRET (default $ROLE_ARN)")
+	fs.StringVar(&f.stsEndpoint, "sts-endpoint", "", "STS to exchange the OIDC token of the CI job at for credentials, e.g. that of MinIO (default $STS_ENDPOINT, or AWS STS with --role-arn)")
+	fs.StringVar(&f.sse, "sse", "", "server-side encryption of S3 uploads: s3 (SSE-S3), kms (SSE-KMS) or c (SSE-C, with the base64 encoded 256-bit key $SSE_CUSTOMER_KEY) (default $SSE)")
+	fs.StringVar(&f.sseKMSKey, "sse-kms-key-id", "", "KMS key ID or ARN SSE-KMS encrypts with, instead of the default key of the bucket (default $SSE_KMS_KEY_ID)")
+	fs.Var(&f.partSize, "part-size", "size of the parts of multipart uploads, e.g. 64MiB (default $PART_SIZE, or chosen from the object size)")
+	fs.IntVar(&f.concurrency, "upload-concurrency", 0, "number of parts uploaded in parallel, each buffered in memory (default $UPLOAD_CONCURRENCY, or 1)")
+	fs.BoolVar(&f.disableMultipart, "disable-multipart", false, "upload S3 objects in a single request, limiting them to 5 GiB (default $DISABLE_MULTIPART)")
+	fs.IntVar(&f.retries, "retries", -1, "number of times a failed bucket operation is retried (default $RETRIES, or 3)")
+	fs.DurationVar(&f.retryDelay, "retry-delay", 0, "delay before the first retry, doubled for each further one (default $RETRY_DELAY, or 1s)")
+	return f
+}
+
+// open connects to the configured destination, retrying failed operations.
+// Flags take precedence over the environment. Missing connection settings are
+// added to in, and every setting missing from in is reported before
+// connecting.
+func (f *backendFlags) open(in *inputs) (storage.Backend, error) {
+	policy, err := f.retryPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.resolveUpload(); err != nil {
+		return nil, err
+	}
+
+	backend, err := f.connect(in)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewRetry(backend, policy), nil
+}
+
+// resolveUpload fills the upload tuning left unset by flags from
+// $PART_SIZE, $UPLOAD_CONCURRENCY and $DISABLE_MULTIPART.
+func (f *backendFlags) resolveUpload() error {
+	if f.partSize == 0 {
+		if value, exists := lookupEnv("PART_SIZE"); exists {
+			if err := f.partSize.Set(value); err != nil {
+				return fmt.Errorf("PART_SIZE is not a size: %w", err)
+			}
+		}
+	}
+
+	if f.concurrency == 0 {
+		if value, exists := lookupEnv("UPLOAD_CONCURRENCY"); exists {
+			concurrency, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("UPLOAD_CONCURRENCY is not a number: %w", err)
+			}
+			f.concurrency = concurrency
+		}
+	}
+	if f.concurrency < 0 {
+		return fmt.Errorf("upload concurrency %d is negative", f.concurrency)
+	}
+
+	if !f.disableMultipart {
+		if value, exists := lookupEnv("DISABLE_MULTIPART"); exists {
+			disable, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("DISABLE_MULTIPART is not a boolean: %w", err)
+			}
+			f.disableMultipart = disable
+		}
+	}
+	return nil
+}
+
+// retryPolicy returns the retry policy from the flags or $RETRIES and
+// $RETRY_DELAY.
+func (f *backendFlags) retryPolicy() (storage.RetryPolicy, error) {
+	policy := storage.RetryPolicy{
+		Retries:   f.retries,
+		BaseDelay: f.retryDelay,
+		MaxDelay:  30 * time.Second,
+		OnRetry: func(op, key string, attempt int, err error) {
+			slog.Warn("retrying bucket operation", "op", op, "key", key, "attempt", attempt, "err", err)
+		},
+	}
+
+	if policy.Retries < 0 {
+		policy.Retries = 3
+		if value, exists := lookupEnv("RETRIES"); exists {
+			retries, err := strconv.Atoi(value)
+			if err != nil {
+				return policy, fmt.Errorf("RETRIES is not a number: %w", err)
+			}
+			policy.Retries = retries
+		}
+	}
+
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = time.Second
+		if value, exists := lookupEnv("RETRY_DELAY"); exists {
+			delay, err := time.ParseDuration(value)
+			if err != nil {
+				return policy, fmt.Errorf("RETRY_DELAY is not a duration: %w", err)
+			}
+			policy.BaseDelay = delay
+		}
+	}
+
+	return policy, nil
+}
+
+// connect connects to the configured destination.
+func (f *backendFlags) connect(in *inputs) (storage.Backend, error) {
+	destination := f.destination
+	if destination == "" {
+		destination = getenv("DESTINATION")
+	}
+	if destination == "" {
+		return f.openS3(in, "")
+	}
+	slog.Debug("opening destination", "destination", destination)
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination: %w", err)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return f.openS3(in, u.Host)
+	case "az":
+		return f.openAzure(in, u.Host)
+	case "file":
+		if err := in.err(); err != nil {
+			return nil, err
+		}
+		return file.New(strings.TrimPrefix(destination, "file://"))
+	case "sftp":
+		if err := in.err(); err != nil {
+			return nil, err
+		}
+		return openSFTP(u)
+	}
+	return nil, fmt.Errorf("invalid destination: unsupported scheme %q", u.Scheme)
+}
+
+// openS3 connects to bucket, or $BUCKET when empty. Without an endpoint,
+// Cloudflare R2 is used. The keys $ACCESS_KEY and $ACCESS_SECRET are used
+// when set; otherwise, unless a role is assumed with the OIDC token of the
+// job, credentials are resolved by the standard AWS chain.
+func (f *backendFlags) openS3(in *inputs, bucket string) (storage.Backend, error) {
+	endpoint := f.endpoint
+	if endpoint == "" {
+		endpoint = getenv("ENDPOINT")
+	}
+
+	region := f.region
+	if region == "" {
+		region = getenv("REGION")
+	}
+
+	pathStyle := f.pathStyle
+	if !pathStyle {
+		if value, exists := lookupEnv("PATH_STYLE"); exists {
+			var err error
+			if pathStyle, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("PATH_STYLE is not a boolean: %w", err)
+			}
+		}
+	}
+
+	if endpoint == "" {
+		accountID := in.require(f.accountID, "account-id", "ACCOUNT_ID")
+		endpoint = fmt.Sprintf("%s.r2.cloudflarestorage.com", accountID)
+		if region == "" {
+			region = "auto"
+		}
+	}
+
+	roleARN := f.roleARN
+	if roleARN == "" {
+		roleARN = getenv("ROLE_ARN")
+	}
+	stsEndpoint := f.stsEndpoint
+	if stsEndpoint == "" {
+		stsEndpoint = getenv("STS_ENDPOINT")
+	}
+	federated := roleARN != "" || stsEndpoint != ""
+
+	// without static keys nor a role to assume, the standard AWS chain is
+	// tried, e.g. for the instance profile of the runner
+	var accessKey, accessSecret string
+	chained := !federated && getenv("ACCESS_KEY") == "" && getenv("ACCESS_SECRET") == ""
+	if !federated && !chained {
+		accessKey = in.require("", "", "ACCESS_KEY")
+		accessSecret = in.require("", "", "ACCESS_SECRET")
+	}
+	if bucket == "" {
+		bucket = in.require(f.bucket, "bucket", "BUCKET")
+	}
+
+	if err := in.err(); err != nil {
+		return nil, err
+	}
+
+	encryption, err := f.encryption()
+	if err != nil {
+		return nil, err
+	}
+
+	var creds *credentials.Credentials
+	if federated {
+		if stsEndpoint == "" {
+			stsEndpoint = awsSTSEndpoint(region)
+		}
+		slog.Debug("exchanging OIDC token for credentials", "sts", stsEndpoint, "role", roleARN)
+		if creds, err = webIdentityCredentials(stsEndpoint, roleARN); err != nil {
+			return nil, err
+		}
+	} else if chained {
+		slog.Debug("resolving credentials from the AWS credential chain")
+		creds = s3.DefaultCredentials()
+		if value, err := creds.Get(); err != nil || value.SignerType.IsAnonymous() {
+			return nil, errors.New("no S3 credentials: set ACCESS_KEY and ACCESS_SECRET, or provide AWS credentials through $AWS_ACCESS_KEY_ID, a shared credentials or SSO profile, or the role of the machine")
+		}
+	}
+	slog.Debug("connecting to bucket", "endpoint", endpoint, "bucket", bucket, "region", region)
+
+	backend, err := s3.New(s3.Options{
+		Endpoint:     endpoint,
+		AccessKey:    accessKey,
+		AccessSecret: accessSecret,
+		Credentials:  creds,
+		Region:       region,
+		Bucket:       bucket,
+		PathStyle:    pathStyle,
+		Encryption:   encryption,
+
+		PartSize:         uint64(f.partSize),
+		Concurrency:      uint(f.concurrency),
+		DisableMultipart: f.disableMultipart,
+		Journal:          f.journal,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", endpoint, err)
+	}
+
+	return backend, nil
+}
+
+// encryption returns the server-side encryption of uploads selected by
+// --sse or $SSE, if any.
+func (f *backendFlags) encryption() (encrypt.ServerSide, error) {
+	sse := f.sse
+	if sse == "" {
+		sse = getenv("SSE")
+	}
+	kmsKey := f.sseKMSKey
+	if kmsKey == "" {
+		kmsKey = getenv("SSE_KMS_KEY_ID")
+	}
+	if kmsKey != "" && sse != "kms" {
+		return nil, errors.New("an SSE-KMS key ID needs --sse kms")
+	}
+
+	switch sse {
+	case "":
+		return nil, nil
+	case "s3":
+		return encrypt.NewSSE(), nil
+	case "kms":
+		return encrypt.NewSSEKMS(kmsKey, nil)
+	case "c":
+		key, err := base64.StdEncoding.DecodeString(getenv("SSE_CUSTOMER_KEY"))
+		if err != nil {
+			return nil, fmt.Errorf("SSE_CUSTOMER_KEY is not base64 encoded: %w", err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("SSE_CUSTOMER_KEY is %d bytes long, SSE-C needs a 256-bit key", len(key))
+		}
+		return encrypt.NewSSEC(key)
+	}
+	return nil, fmt.Errorf("unknown server-side encryption %q, expected s3, kms or c", sse)
+}
+
+// openAzure connects to container in the account named by
+// $AZURE_STORAGE_ACCOUNT. A SAS token in $AZURE_STORAGE_SAS_TOKEN is used when
+// set; otherwise credentials are resolved by azidentity, which covers service
+// principals configured with $AZURE_TENANT_ID, $AZURE_CLIENT_ID and
+// $AZURE_CLIENT_SECRET.
+func (f *backendFlags) openAzure(in *inputs, container string) (storage.Backend, error) {
+	serviceURL := getenv("AZURE_STORAGE_ENDPOINT")
+	if serviceURL == "" {
+		account := in.require("", "", "AZURE_STORAGE_ACCOUNT")
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+
+	if err := in.err(); err != nil {
+		return nil, err
+	}
+
+	opts := azure.Options{
+		ServiceURL: serviceURL,
+		Container:  container,
+		SASToken:   getenv("AZURE_STORAGE_SAS_TOKEN"),
+
+		BlockSize:   int64(f.partSize),
+		Concurrency: f.concurrency,
+	}
+
+	if opts.SASToken == "" {
+		credential, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve azure credentials: %w", err)
+		}
+		opts.Credential = credential
+	}
+
+	backend, err := azure.New(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", serviceURL, err)
+	}
+	return backend, nil
+}
+
+// openSFTP connects to the SSH server of a sftp://user@host[:port]/path
+// destination. The private key is read from $SSH_KEY_FILE or $SSH_KEY,
+// decrypted with $SSH_KEY_PASSPHRASE if needed, falling back to the agent at
+// $SSH_AUTH_SOCK. Host keys are checked against $SSH_KNOWN_HOSTS, or
+// ~/.ssh/known_hosts by default.
+func openSFTP(u *url.URL) (storage.Backend, error) {
+	auth, err := sshAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	knownHosts := getenv("SSH_KNOWN_HOSTS")
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate known_hosts: %w", err)
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	user := u.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+
+	backend, err := sftp.New(sftp.Options{
+		Addr:            addr,
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Root:            strings.TrimPrefix(u.Path, "/"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	return backend, nil
+}
+
+func sshAuth() ([]ssh.AuthMethod, error) {
+	var key []byte
+	if path, exists := lookupEnv("SSH_KEY_FILE"); exists {
+		var err error
+		if key, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read ssh key: %w", err)
+		}
+	} else if value, exists := lookupEnv("SSH_KEY"); exists {
+		key = []byte(value)
+	}
+
+	if key != nil {
+		var signer ssh.Signer
+		var err error
+		if passphrase, exists := lookupEnv("SSH_KEY_PASSPHRASE"); exists {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ssh key: %w", err)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+
+	socket, exists := os.LookupEnv("SSH_AUTH_SOCK")
+	if !exists {
+		return nil, fmt.Errorf("SSH_KEY_FILE, SSH_KEY or SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ssh agent: %w", err)
+	}
+	return []ssh.AuthMethod{ssh.PublicKeysCallback(agent.NewClient(conn).Signers)}, nil
+}
+
+// openJournal opens the upload journal at $UPLOAD_JOURNAL, or in the user
+// cache directory by default.
+func openJournal() (*storage.FileJournal, error) {
+	path := getenv("UPLOAD_JOURNAL")
+	if path == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate upload journal: %w", err)
+		}
+		path = filepath.Join(cache, "update-manifest", "uploads.json")
+	}
+	return storage.OpenFileJournal(path)
+}
+
+// artifactCache returns the directory previous artifacts are cached in to
+// generate patches: dir, $PATCH_CACHE, or one in the user cache directory
+// by default. It returns "" if there is none, and they are not cached.
+func artifactCache(dir string) string {
+	if dir == "" {
+		dir = getenv("PATCH_CACHE")
+	}
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(cache, "update-manifest", "artifacts")
+	}
+	return dir
+}
+
+// byteSize is a size flag accepting binary and decimal units, e.g. 64MiB or
+// 100MB.
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+func (s *byteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *byteSize) Set(value string) error {
+	number, unit := value, int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			number, unit = strings.TrimSuffix(value, u.suffix), u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*s = byteSize(n * unit)
+	return nil
+}
+
+// addRetentionFlag registers the flag limiting the release history.
+func addRetentionFlag(fs *flag.FlagSet) *int {
+	return fs.Int("keep-releases", -1, "number of releases to keep in the history of a channel, 0 for all (default $KEEP_RELEASES, or 10)")
+}
+
+// resolveRetention returns the release history limit from the flag value or
+// $KEEP_RELEASES.
+func resolveRetention(flagValue int) (int, error) {
+	if flagValue >= 0 {
+		return flagValue, nil
+	}
+
+	value, exists := lookupEnv("KEEP_RELEASES")
+	if !exists {
+		return 10, nil
+	}
+
+	keep, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("KEEP_RELEASES is not a number: %w", err)
+	}
+	return keep, nil
+}
+
+// loadPublisher loads the manifest of appID, signing it on save with the
+// keys selected by flags or the environment. Commands that never save the
+// manifest pass nil flags, which only load the keys of $SIGNING_KEY,
+// $VAULT_TRANSIT_KEY and $KMS_KEY.
+func loadPublisher(ctx context.Context, backend storage.Backend, appID string, flags *signingFlags) (*manifest.Publisher, error) {
+	if flags == nil {
+		flags = &signingFlags{keys: &stringList{}, transitKey: new(string), kmsKey: new(string)}
+	}
+	signers, err := loadSigners(ctx, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	publisher := manifest.NewPublisher(backend, appID)
+	publisher.SignWith(signers...)
+	if flags.threshold != nil {
+		threshold, cosigners, err := loadSignaturePolicy(flags)
+		if err != nil {
+			return nil, err
+		}
+		if threshold > len(signers)+len(cosigners) {
+			return nil, fmt.Errorf("signature threshold %d exceeds the %d signing and cosigner keys", threshold, len(signers)+len(cosigners))
+		}
+		if threshold > 0 || len(cosigners) > 0 {
+			publisher.RequireSignatures(threshold, cosigners...)
+		}
+	}
+	if flags.expiry != nil {
+		expiry, err := loadSignatureExpiry(flags)
+		if err != nil {
+			return nil, err
+		}
+		publisher.ExpireSignatures(expiry)
+	}
+	publisher.UseCachePolicy(loadCachePolicy())
+	if flags.timestamp != nil {
+		tsa := *flags.timestamp
+		if tsa == "" {
+			tsa = getenv("TIMESTAMP_URL")
+		}
+		if tsa != "" && len(signers) == 0 {
+			return nil, errors.New("timestamping the manifest signature needs a signing key")
+		}
+		if tsa != "" {
+			publisher.TimestampWith(&signing.TimestampAuthority{URL: tsa})
+		}
+	}
+	if flags.tuf != nil {
+		tuf := *flags.tuf
+		if value, exists := lookupEnv("TUF"); exists && !tuf {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("TUF is not a boolean: %w", err)
+			}
+			tuf = enabled
+		}
+		if tuf && len(signers) == 0 {
+			return nil, errors.New("TUF metadata needs a signing key")
+		}
+		if tuf {
+			publisher.MaintainTUF(manifest.DefaultTUFExpiry)
+		}
+	}
+	if flags.rekor != nil {
+		rekor := *flags.rekor
+		if rekor == "" {
+			rekor = getenv("REKOR_URL")
+		}
+		if rekor != "" && len(signers) == 0 {
+			return nil, errors.New("recording the manifest signature in a transparency log needs a signing key")
+		}
+		if rekor != "" {
+			publisher.RecordIn(&signing.TransparencyLog{URL: rekor})
+		}
+	}
+	if flags.minisignKey != nil {
+		fileSigners, err := loadFileSigners(flags)
+		if err != nil {
+			return nil, err
+		}
+		publisher.SignFilesWith(fileSigners...)
+		if err := flags.feeds.configure(publisher, fileSigners); err != nil {
+			return nil, err
+		}
+	}
+	expiry, err := loadURLExpiry()
+	if err != nil {
+		return nil, err
+	}
+	if expiry > 0 {
+		publisher.PresignURLs(expiry)
+	}
+	purger, err := loadPurger()
+	if err != nil {
+		return nil, err
+	}
+	// nothing a dry run pretends to write is purged
+	if purger != nil && !isDryRun(backend) {
+		publisher.PurgeWith(purger)
+	}
+	if err := publisher.Load(ctx); err != nil {
+		return nil, err
+	}
+	return publisher, nil
+}
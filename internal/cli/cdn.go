@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"errors"
+
+	"update-manifest/pkg/cdn"
+	"update-manifest/pkg/manifest"
+)
+
+// loadPurger returns the CDN purger of the objects the manifest is saved
+// with: the Cloudflare zone $CLOUDFLARE_ZONE_ID, purged with the API token
+// $CLOUDFLARE_API_TOKEN, serving the bucket at $CDN_URL, or $PUBLIC_URL. The
+// API is reached at $CLOUDFLARE_API_URL if set. It returns nil if no zone is
+// set.
+func loadPurger() (manifest.Purger, error) {
+	zone := getenv("CLOUDFLARE_ZONE_ID")
+	if zone == "" {
+		return nil, nil
+	}
+
+	token := getenv("CLOUDFLARE_API_TOKEN")
+	if token == "" {
+		return nil, errors.New("purging the Cloudflare cache needs CLOUDFLARE_API_TOKEN")
+	}
+	baseURL := getenv("CDN_URL")
+	if baseURL == "" {
+		baseURL = getenv("PUBLIC_URL")
+	}
+	if baseURL == "" {
+		return nil, errors.New("purging the Cloudflare cache needs CDN_URL or PUBLIC_URL, the URL the zone serves the bucket at")
+	}
+	return &cdn.Cloudflare{ZoneID: zone, Token: token, BaseURL: baseURL, APIURL: getenv("CLOUDFLARE_API_URL")}, nil
+}
+
+// loadCachePolicy returns the Cache-Control headers objects are stored with:
+// $CACHE_CONTROL_MUTABLE for the manifest, feeds and signatures, and
+// $CACHE_CONTROL_IMMUTABLE for artifacts and everything else named by its
+// content. Either defaults to that of manifest.DefaultCachePolicy, and is not
+// set if empty.
+func loadCachePolicy() manifest.CachePolicy {
+	policy := manifest.DefaultCachePolicy
+	if value, exists := lookupEnv("CACHE_CONTROL_MUTABLE"); exists {
+		policy.Mutable = value
+	}
+	if value, exists := lookupEnv("CACHE_CONTROL_IMMUTABLE"); exists {
+		policy.Immutable = value
+	}
+	return policy
+}
@@ -0,0 +1,205 @@
+// Package cli implements the update-manifest command line interface.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// command is a single subcommand of the CLI.
+type command struct {
+	name    string
+	summary string
+	// run executes the command with the arguments following its name.
+	run func(ctx context.Context, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		initCommand,
+		publishCommand,
+		issueTokenCommand,
+		listCommand,
+		inspectCommand,
+		downloadCommand,
+		wingetCommand,
+		homebrewCommand,
+		scoopCommand,
+		diffCommand,
+		migrateCommand,
+		promoteCommand,
+		rollbackCommand,
+		yankCommand,
+		pruneCommand,
+		gcCommand,
+		rolloutCommand,
+		watchdogCommand,
+		verifyCommand,
+		keygenCommand,
+		signCommand,
+		refreshTUFCommand,
+		resignCommand,
+		refreshURLsCommand,
+		validateCommand,
+		statsCommand,
+		serveCommand,
+	}
+}
+
+// Run executes the subcommand named by args[0] and returns the process exit
+// code. Without arguments it publishes, so existing env-driven pipelines keep
+// working.
+func Run(ctx context.Context, args []string) int {
+	name := "publish"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		usage()
+		return 0
+	}
+
+	if err := loadConfig(); err != nil {
+		slog.Error("failed to load configuration", "err", err)
+		return 1
+	}
+	if err := setupLogging(); err != nil {
+		slog.Error("failed to set up logging", "err", err)
+		return 1
+	}
+	if err := loadSecretFiles(); err != nil {
+		slog.Error("failed to load secrets", "err", err)
+		return 1
+	}
+	if path := getenv("VAULT_PATH"); path != "" {
+		if err := loadVaultSecrets(ctx, path); err != nil {
+			slog.Error("failed to load secrets", "err", err)
+			return 1
+		}
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		if err := cmd.run(ctx, args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return 0
+			}
+			if ctx.Err() != nil {
+				slog.Error(name+" interrupted", "err", err)
+				return 130
+			}
+			slog.Error(name+" failed", "err", err)
+			return 1
+		}
+		return 0
+	}
+
+	slog.Error("unknown command", "command", name)
+	usage()
+	return 2
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: update-manifest <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintf(os.Stderr, "Secrets, i.e. %s, are also read from the file named by the setting suffixed with _FILE.\n", strings.Join(secretSettings, ", "))
+}
+
+// newFlagSet returns a flag set for cmd that reports errors instead of exiting
+// and carries the log level flags.
+func newFlagSet(cmd string) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	addLogFlags(fs)
+	addVaultFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: update-manifest %s [flags]\n", cmd)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// inputs resolves the required settings of a command from its flags, the
+// environment and the configuration file, collecting every missing one so
+// they are reported together.
+type inputs struct {
+	missing []string
+}
+
+// require returns value, or the setting env when value is empty. flagName is
+// the flag mirroring env, if any.
+func (in *inputs) require(value, flagName, env string) string {
+	if value != "" {
+		return value
+	}
+	if value, exists := lookupEnv(env); exists {
+		return value
+	}
+
+	if flagName == "" {
+		in.missing = append(in.missing, env)
+	} else {
+		in.missing = append(in.missing, fmt.Sprintf("--%s (or %s)", flagName, env))
+	}
+	return ""
+}
+
+// flag records the flag flagName as missing when value is empty.
+func (in *inputs) flag(value, flagName string) {
+	if value == "" {
+		in.missing = append(in.missing, "--"+flagName)
+	}
+}
+
+// err reports every missing setting, or nil.
+func (in *inputs) err() error {
+	if len(in.missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("missing required inputs: %s", strings.Join(in.missing, ", "))
+}
+
+// addTimeoutFlag registers the flag limiting the run time of a command.
+func addTimeoutFlag(fs *flag.FlagSet) *time.Duration {
+	return fs.Duration("timeout", 0, "abort the command after this long, e.g. 10m (default $TIMEOUT, or no limit)")
+}
+
+// withTimeout returns ctx limited to timeout, or $TIMEOUT when timeout is 0.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc, error) {
+	if timeout == 0 {
+		if value, exists := lookupEnv("TIMEOUT"); exists {
+			var err error
+			if timeout, err = time.ParseDuration(value); err != nil {
+				return nil, nil, fmt.Errorf("TIMEOUT is not a duration: %w", err)
+			}
+		}
+	}
+
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+// addAppIDFlag registers the flag naming the application.
+func addAppIDFlag(fs *flag.FlagSet) *string {
+	return fs.String("app-id", "", "application ID (default $APP_ID)")
+}
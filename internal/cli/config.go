@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFiles are looked up in the working directory when
+// $UPDATE_MANIFEST_CONFIG is not set.
+var defaultConfigFiles = []string{".update-manifest.yaml", ".update-manifest.yml"}
+
+// config holds the settings of the configuration file, keyed by the lower
+// case name of the environment variable they stand in for, e.g.
+//
+//	bucket: releases
+//	app_id: myapp
+//	channel: beta
+var config = map[string]string{}
+
+// loadConfig reads the configuration file named by $UPDATE_MANIFEST_CONFIG,
+// or the first default file present in the working directory.
+func loadConfig() error {
+	path, explicit := os.LookupEnv("UPDATE_MANIFEST_CONFIG")
+	if !explicit {
+		for _, name := range defaultConfigFiles {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+	}
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to decode config file %s: %w", path, err)
+	}
+
+	config = make(map[string]string, len(values))
+	for key, value := range values {
+		switch value.(type) {
+		case string, bool, int, float64:
+			config[strings.ToLower(key)] = fmt.Sprint(value)
+		case nil:
+		default:
+			return fmt.Errorf("config file %s: %s must be a scalar", path, key)
+		}
+	}
+	return nil
+}
+
+// lookupEnv returns the value of the environment variable name, falling back
+// to the Vault secrets read, then to the configuration file. Flags take
+// precedence over all of them at call sites.
+func lookupEnv(name string) (string, bool) {
+	if value, exists := os.LookupEnv(name); exists {
+		return value, true
+	}
+	if value, exists := secrets[strings.ToLower(name)]; exists {
+		return value, true
+	}
+	value, exists := config[strings.ToLower(name)]
+	return value, exists
+}
+
+// getenv is like os.Getenv but consults the configuration file.
+func getenv(name string) string {
+	value, _ := lookupEnv(name)
+	return value
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/storage"
+)
+
+var diffCommand = &command{
+	name:    "diff",
+	summary: "Show what changed between the manifest and a local file or a backup",
+	run:     runDiff,
+}
+
+func runDiff(ctx context.Context, args []string) error {
+	fs := newFlagSet("diff")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	output := fs.String("output", "text", "output format: text or json")
+	from := fs.String("from", "", "backup to compare from, e.g. myapp/history/manifest-20240102T150405.000Z.json (default the live manifest)")
+	to := fs.String("to", "", "backup to compare to")
+	file := fs.String("file", "", "local manifest file to compare to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+	if (*to == "") == (*file == "") {
+		return errors.New("exactly one of --to and --file is required")
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	before, err := storedManifest(ctx, backend, *appID, *from)
+	if err != nil {
+		return err
+	}
+
+	var after *manifest.Manifest
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		after = &manifest.Manifest{}
+		if err := json.Unmarshal(data, after); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", *file, err)
+		}
+	} else if after, err = storedManifest(ctx, backend, *appID, *to); err != nil {
+		return err
+	}
+
+	changes := manifest.Diff(before, after)
+	if *output == "json" {
+		return writeJSON(os.Stdout, append([]manifest.Change{}, changes...))
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("manifests are identical")
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	return nil
+}
+
+// storedManifest fetches the backup of the manifest of appID under key, or
+// the live manifest if key is empty. A missing live manifest is empty.
+func storedManifest(ctx context.Context, backend storage.Backend, appID, key string) (*manifest.Manifest, error) {
+	m := &manifest.Manifest{}
+	if key == "" {
+		data, err := readObject(ctx, backend, manifest.ManifestKey(appID))
+		if errors.Is(err, storage.ErrNotExist) {
+			return m, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+		}
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		return m, nil
+	}
+
+	data, err := readObject(ctx, backend, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest backup %s: %w", key, err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest backup %s: %w", key, err)
+	}
+	return m, nil
+}
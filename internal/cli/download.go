@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"update-manifest/pkg/manifest"
+)
+
+var downloadCommand = &command{
+	name:    "download",
+	summary: "Fetch a published artifact and verify its checksum",
+	run:     runDownload,
+}
+
+func runDownload(ctx context.Context, args []string) error {
+	fs := newFlagSet("download")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	channel := fs.String("channel", "", "channel of the release, e.g. stable")
+	platform := fs.String("platform", "", "platform of the artifact, e.g. linux/amd64")
+	variant := fs.String("variant", "", "variant of the artifact, e.g. v3")
+	kind := fs.String("kind", "", "kind of the artifact, e.g. installer (default the executable)")
+	version := fs.String("version", "", "recorded version to download (default the current release)")
+	output := fs.String("o", "", "file to write the artifact to (default the platform name, e.g. linux-amd64, in the current directory)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	in.flag(*channel, "channel")
+	in.flag(*platform, "platform")
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := loadPublisher(ctx, backend, *appID, nil)
+	if err != nil {
+		return err
+	}
+
+	ch, ok := publisher.Manifest().Channel[*channel]
+	if !ok {
+		return fmt.Errorf("%w: %s", manifest.ErrChannelNotFound, *channel)
+	}
+	release := ch.Current()
+	if *version != "" && *version != ch.Version {
+		if release = ch.Find(*version); release == nil {
+			return fmt.Errorf("%w: %s in channel %s", manifest.ErrReleaseNotFound, *version, *channel)
+		}
+	}
+	artifact := release.FindArtifact(*platform)
+	if artifact == nil {
+		return fmt.Errorf("release %s of channel %s has no artifact for %s", release.Version, *channel, *platform)
+	}
+	if *kind != "" || *variant != "" {
+		if artifact = artifact.Select(*kind, *variant); artifact == nil {
+			return fmt.Errorf("artifact %s of release %s has no %s", *platform, release.Version, strings.TrimSpace(*kind+" "+*variant))
+		}
+	}
+
+	name := *output
+	if name == "" {
+		name = strings.ReplaceAll(*platform, "/", "-")
+	}
+
+	reader, _, err := backend.Get(ctx, artifact.Binary)
+	if err != nil {
+		return fmt.Errorf("failed to fetch artifact: %w", err)
+	}
+	defer reader.Close()
+
+	hasher, err := manifest.NewHash(artifact.ChecksumAlgo)
+	if err != nil {
+		return err
+	}
+
+	// the artifact is written next to its destination and only renamed into
+	// place once its checksum matches
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), reader)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to fetch artifact: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if checksum := hex.EncodeToString(hasher.Sum(nil)); checksum != artifact.Checksum {
+		return fmt.Errorf("downloaded artifact has checksum %s, want %s", checksum, artifact.Checksum)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+
+	slog.Info("downloaded artifact", "version", release.Version, "platform", strings.Join(strings.Fields(*platform+" "+*variant+" "+*kind), " "), "path", name, "size", size, "checksum", artifact.Checksum)
+	return nil
+}
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"flag"
+
+	"update-manifest/pkg/storage"
+)
+
+// addDryRunFlag registers the flag turning off every write to the bucket.
+func addDryRunFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("dry-run", false, "compute checksums and the new manifest, print them and write nothing to the bucket")
+}
+
+// isDryRun reports whether backend or a backend it decorates only records
+// writes.
+func isDryRun(backend storage.Backend) bool {
+	for backend != nil {
+		if _, ok := backend.(*storage.DryRun); ok {
+			return true
+		}
+		wrapper, ok := backend.(storage.Wrapper)
+		if !ok {
+			break
+		}
+		backend = wrapper.Unwrap()
+	}
+	return false
+}
+
+// dryRun wraps backend so that writes are only recorded when enabled.
+func dryRun(backend storage.Backend, enabled bool) storage.Backend {
+	if !enabled {
+		return backend
+	}
+	return storage.NewDryRun(backend)
+}
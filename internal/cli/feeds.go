@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/signing"
+)
+
+// feedFlags select the feeds of other update frameworks published with the
+// manifest, derived from it on every save.
+type feedFlags struct {
+	downloadURL     *string
+	sparklePlatform *string
+	sparkleKind     *string
+	electron        *bool
+	electronKind    *string
+	tauri           *bool
+	tauriKind       *string
+	squirrel        *bool
+	squirrelPackage *string
+	squirrelKind    *string
+	zsync           *bool
+}
+
+// addFeedFlags registers the flags selecting the published feeds.
+func addFeedFlags(fs *flag.FlagSet) *feedFlags {
+	return &feedFlags{
+		downloadURL:     fs.String("download-url", "", "public URL of the bucket feeds download artifacts from (default $DOWNLOAD_URL, or $PUBLIC_URL)"),
+		sparklePlatform: fs.String("sparkle-platform", "", "platform whose artifact the Sparkle appcast offers, the first darwin platform of a release if empty (default $SPARKLE_PLATFORM)"),
+		sparkleKind:     fs.String("sparkle-kind", "", "artifact kind the Sparkle appcast offers, e.g. a zip of the application bundle, instead of the artifact itself (default $SPARKLE_KIND)"),
+		electron:        fs.Bool("electron", false, "also publish the latest.yml feeds of electron-updater of every channel under <app-id>/electron/<channel>, which need sha256 or sha512 checksums (default $ELECTRON)"),
+		electronKind:    fs.String("electron-kind", "", "artifact kind the electron-updater feeds offer where a platform has one, e.g. installer, instead of the artifact itself (default $ELECTRON_KIND)"),
+		tauri:           fs.Bool("tauri", false, "also publish the latest.json feed of the Tauri updater of every channel under <app-id>/tauri/<channel>, which needs artifacts signed with --minisign-key (default $TAURI)"),
+		tauriKind:       fs.String("tauri-kind", "", "artifact kind the Tauri feeds offer where a platform has one, e.g. updater, instead of the artifact itself (default $TAURI_KIND)"),
+		squirrel:        fs.Bool("squirrel", false, "also publish the RELEASES file of Squirrel.Windows of every channel under <app-id>/squirrel/<channel>, with a copy of the full package it offers (default $SQUIRREL)"),
+		squirrelPackage: fs.String("squirrel-package", "", "NuGet package ID the Squirrel.Windows packages are named by (default $SQUIRREL_PACKAGE, or the app ID)"),
+		squirrelKind:    fs.String("squirrel-kind", "", "artifact kind of the Windows platform holding the full nupkg package (default $SQUIRREL_KIND, or nupkg)"),
+		zsync:           fs.Bool("zsync", false, "also publish zsync control files of the artifacts and of every channel under <app-id>/zsync/<channel>, and embed the update information of AppImages naming them (default $ZSYNC)"),
+	}
+}
+
+// configure makes publisher write the feeds selected by f: a Sparkle
+// appcast when fileSigners hold a Sparkle key, and the electron-updater,
+// Tauri, Squirrel.Windows and zsync feeds if enabled by their flags or
+// $ELECTRON, $TAURI, $SQUIRREL and $ZSYNC.
+func (f *feedFlags) configure(publisher *manifest.Publisher, fileSigners []signing.FileSigner) error {
+	for _, signer := range fileSigners {
+		if signer.Format() == signing.FormatSparkle {
+			if err := f.configureSparkle(publisher); err != nil {
+				return err
+			}
+		}
+	}
+
+	electron := *f.electron
+	if value, exists := lookupEnv("ELECTRON"); exists && !electron {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("ELECTRON is not a boolean: %w", err)
+		}
+		electron = enabled
+	}
+	if electron {
+		downloadURL, err := f.baseURL()
+		if err != nil {
+			return err
+		}
+		kind := *f.electronKind
+		if kind == "" {
+			kind = getenv("ELECTRON_KIND")
+		}
+		publisher.WriteElectronFeeds(manifest.ElectronFeed{BaseURL: downloadURL, Kind: kind})
+	}
+
+	tauri := *f.tauri
+	if value, exists := lookupEnv("TAURI"); exists && !tauri {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("TAURI is not a boolean: %w", err)
+		}
+		tauri = enabled
+	}
+	if tauri {
+		downloadURL, err := f.baseURL()
+		if err != nil {
+			return err
+		}
+		kind := *f.tauriKind
+		if kind == "" {
+			kind = getenv("TAURI_KIND")
+		}
+		publisher.WriteTauriFeeds(manifest.TauriFeed{BaseURL: downloadURL, Kind: kind})
+	}
+
+	squirrel := *f.squirrel
+	if value, exists := lookupEnv("SQUIRREL"); exists && !squirrel {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("SQUIRREL is not a boolean: %w", err)
+		}
+		squirrel = enabled
+	}
+	if squirrel {
+		pkg := *f.squirrelPackage
+		if pkg == "" {
+			pkg = getenv("SQUIRREL_PACKAGE")
+		}
+		kind := *f.squirrelKind
+		if kind == "" {
+			kind = getenv("SQUIRREL_KIND")
+		}
+		if kind == "" {
+			kind = "nupkg"
+		}
+		publisher.WriteSquirrelFeeds(manifest.SquirrelFeed{Package: pkg, Kind: kind})
+	}
+
+	zsync := *f.zsync
+	if value, exists := lookupEnv("ZSYNC"); exists && !zsync {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("ZSYNC is not a boolean: %w", err)
+		}
+		zsync = enabled
+	}
+	if zsync {
+		downloadURL, err := f.baseURL()
+		if err != nil {
+			return err
+		}
+		publisher.WriteZsyncFeeds(manifest.ZsyncFeed{BaseURL: downloadURL})
+	}
+	return nil
+}
+
+// configureSparkle makes publisher write the Sparkle appcasts.
+func (f *feedFlags) configureSparkle(publisher *manifest.Publisher) error {
+	downloadURL, err := f.baseURL()
+	if err != nil {
+		return err
+	}
+	platform := *f.sparklePlatform
+	if platform == "" {
+		platform = getenv("SPARKLE_PLATFORM")
+	}
+	if platform != "" {
+		if platform, err = manifest.NormalizePlatform(platform); err != nil {
+			return err
+		}
+	}
+	kind := *f.sparkleKind
+	if kind == "" {
+		kind = getenv("SPARKLE_KIND")
+	}
+
+	publisher.WriteAppcasts(manifest.Appcast{BaseURL: downloadURL, Platform: platform, Kind: kind})
+	return nil
+}
+
+// baseURL returns the public URL feeds download artifacts from.
+func (f *feedFlags) baseURL() (string, error) {
+	downloadURL := *f.downloadURL
+	if downloadURL == "" {
+		downloadURL = getenv("DOWNLOAD_URL")
+	}
+	if downloadURL == "" {
+		downloadURL = getenv("PUBLIC_URL")
+	}
+	if downloadURL == "" {
+		return "", errors.New("feeds need the public URL of the bucket, set --download-url or $DOWNLOAD_URL")
+	}
+	return downloadURL, nil
+}
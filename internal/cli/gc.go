@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/stats"
+	"update-manifest/pkg/storage"
+	"update-manifest/pkg/telemetry"
+)
+
+var gcCommand = &command{
+	name:    "gc",
+	summary: "Delete the objects of an application that no manifest refers to",
+	run:     runGC,
+}
+
+func runGC(ctx context.Context, args []string) error {
+	fs := newFlagSet("gc")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	minAge := fs.Duration("min-age", 24*time.Hour, "only delete objects older than this, sparing those of publishes in progress")
+	keepBackups := fs.Int("keep-backups", 0, "number of manifest backups to keep, 0 for all; older ones are deleted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("gc", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, *appID, nil)
+	if err != nil {
+		return err
+	}
+
+	objects, err := backend.List(ctx, *appID+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	referenced, err := referencedKeys(ctx, backend, *appID, publisher.Manifest(), objects, *keepBackups)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-*minAge)
+	var deleted, size int64
+	for _, object := range objects {
+		if referenced[object.Key] || object.LastModified.After(cutoff) {
+			continue
+		}
+		if err := backend.Delete(ctx, object.Key); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", object.Key, err)
+		}
+		if !*dryRunMode {
+			slog.Info("deleted orphaned object", "key", object.Key, "size", object.Size)
+		}
+		deleted++
+		size += object.Size
+	}
+
+	return rep.finish(publisher, "collected garbage", "objects", deleted, "bytes", size)
+}
+
+// referencedKeys returns the keys of the manifest m of appID, its signatures,
+// key list, client manifest, TUF metadata, feeds, telemetry and download
+// stats, the keep newest of its backups among objects, or all for keep <= 0,
+// the staged manifest, and of every object those manifests refer to,
+// including their release history.
+func referencedKeys(ctx context.Context, backend storage.Backend, appID string, m *manifest.Manifest, objects []storage.ObjectInfo, keep int) (map[string]bool, error) {
+	referenced := map[string]bool{
+		manifest.PendingKey(appID): true,
+		stats.Key(appID):           true,
+	}
+	for _, key := range manifest.PublishedKeys(appID) {
+		referenced[key] = true
+	}
+	for _, key := range m.Keys() {
+		referenced[key] = true
+	}
+	if err := referenceChunks(ctx, backend, m, referenced); err != nil {
+		return nil, err
+	}
+
+	// the artifacts of a staged release are not in the manifest yet
+	pending, _, err := manifest.LoadPending(ctx, backend, appID)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		var staged manifest.Manifest
+		if err := json.Unmarshal(pending.Manifest, &staged); err != nil {
+			return nil, fmt.Errorf("failed to decode staged manifest: %w", err)
+		}
+		for _, key := range staged.Keys() {
+			referenced[key] = true
+		}
+		if err := referenceChunks(ctx, backend, &staged, referenced); err != nil {
+			return nil, err
+		}
+	}
+
+	derived := append(manifest.DerivedPrefixes(appID), telemetry.Prefix(appID))
+
+	var backups []string
+	for _, object := range objects {
+		for _, prefix := range derived {
+			if strings.HasPrefix(object.Key, prefix) {
+				referenced[object.Key] = true
+			}
+		}
+		if strings.HasPrefix(object.Key, appID+"/history/") {
+			backups = append(backups, object.Key)
+		}
+	}
+	// backup keys sort by the time they were taken
+	slices.Sort(backups)
+	if keep > 0 && len(backups) > keep {
+		backups = backups[len(backups)-keep:]
+	}
+
+	for _, key := range backups {
+		referenced[key] = true
+
+		data, err := readObject(ctx, backend, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch manifest backup %s: %w", key, err)
+		}
+		var backup manifest.Manifest
+		if err := json.Unmarshal(data, &backup); err != nil {
+			return nil, fmt.Errorf("failed to decode manifest backup %s: %w", key, err)
+		}
+		for _, key := range backup.Keys() {
+			referenced[key] = true
+		}
+		if err := referenceChunks(ctx, backend, &backup, referenced); err != nil {
+			return nil, err
+		}
+	}
+	return referenced, nil
+}
+
+// referenceChunks adds the keys of the chunks listed by the chunk indexes of
+// m to referenced. An index that is gone references nothing, as no client
+// can assemble its artifact from the chunks anymore.
+func referenceChunks(ctx context.Context, backend storage.Backend, m *manifest.Manifest, referenced map[string]bool) error {
+	for _, chunks := range m.ChunkIndexes() {
+		data, err := readObject(ctx, backend, chunks.Key)
+		if errors.Is(err, storage.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch chunk index %s: %w", chunks.Key, err)
+		}
+		index, err := manifest.ReadChunkIndex(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to read chunk index %s: %w", chunks.Key, err)
+		}
+		for _, chunk := range index.Chunks {
+			referenced[chunks.ChunkKey(chunk.Checksum)] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"update-manifest/pkg/manifest"
+)
+
+var homebrewCommand = &command{
+	name:    "homebrew",
+	summary: "Render the Homebrew cask or formula of a published release",
+	run:     runHomebrew,
+}
+
+func runHomebrew(ctx context.Context, args []string) error {
+	fs := newFlagSet("homebrew")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	channel := fs.String("channel", "", "channel of the release, e.g. stable")
+	version := fs.String("version", "", "recorded version to render (default the current release)")
+	name := fs.String("name", "", "token of the cask or name of the formula, e.g. my-app (default $HOMEBREW_NAME, or the app ID)")
+	cask := fs.Bool("cask", false, "render a cask installing a macOS application instead of a formula (default $HOMEBREW_CASK)")
+	description := fs.String("description", "", "short description of the package (default $HOMEBREW_DESCRIPTION)")
+	homepage := fs.String("homepage", "", "homepage of the package (default $HOMEBREW_HOMEPAGE)")
+	license := fs.String("license", "", "SPDX license of the formula, e.g. MIT (default $HOMEBREW_LICENSE)")
+	app := fs.String("app", "", "application bundle the cask installs, e.g. MyApp.app (default $HOMEBREW_APP)")
+	binary := fs.String("binary", "", "name the formula installs the executable as (default $HOMEBREW_BINARY, or the name)")
+	kind := fs.String("kind", "", "artifact kind to install, e.g. dmg, instead of the artifact itself, leaving out platforms without one (default $HOMEBREW_KIND)")
+	downloadURL := fs.String("download-url", "", "public URL of the bucket Homebrew downloads artifacts from (default $DOWNLOAD_URL, or $PUBLIC_URL)")
+	output := fs.String("o", "", "file to write the definition to (default print it)")
+	tap := fs.String("tap", "", "git checkout of a tap to write the definition to under Casks or Formula and commit it (default $HOMEBREW_TAP)")
+	push := fs.Bool("push", false, "push the commit to the tap (default $HOMEBREW_PUSH)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	in.flag(*channel, "channel")
+	*appID = in.require(*appID, "app-id", "APP_ID")
+	pkg := manifest.HomebrewPackage{
+		Name:        *name,
+		Cask:        *cask,
+		Description: in.require(*description, "description", "HOMEBREW_DESCRIPTION"),
+		Homepage:    in.require(*homepage, "homepage", "HOMEBREW_HOMEPAGE"),
+		License:     *license,
+		App:         *app,
+		Binary:      *binary,
+		Kind:        *kind,
+	}
+	if pkg.Name == "" {
+		pkg.Name = getenv("HOMEBREW_NAME")
+	}
+	if pkg.Name == "" {
+		pkg.Name = *appID
+	}
+	if value, exists := lookupEnv("HOMEBREW_CASK"); exists && !pkg.Cask {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("HOMEBREW_CASK is not a boolean: %w", err)
+		}
+		pkg.Cask = enabled
+	}
+	if pkg.License == "" {
+		pkg.License = getenv("HOMEBREW_LICENSE")
+	}
+	if pkg.App == "" {
+		pkg.App = getenv("HOMEBREW_APP")
+	}
+	if pkg.Binary == "" {
+		pkg.Binary = getenv("HOMEBREW_BINARY")
+	}
+	if pkg.Kind == "" {
+		pkg.Kind = getenv("HOMEBREW_KIND")
+	}
+	if pkg.BaseURL, err = packageDownloadURL(*downloadURL); err != nil {
+		return err
+	}
+	if *tap == "" {
+		*tap = getenv("HOMEBREW_TAP")
+	}
+	pushTap := *push
+	if value, exists := lookupEnv("HOMEBREW_PUSH"); exists && !pushTap {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("HOMEBREW_PUSH is not a boolean: %w", err)
+		}
+		pushTap = enabled
+	}
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := loadPublisher(ctx, backend, *appID, nil)
+	if err != nil {
+		return err
+	}
+
+	release, err := packagedRelease(publisher.Manifest(), *channel, *version)
+	if err != nil {
+		return err
+	}
+
+	definition, err := publisher.Homebrew(ctx, release, pkg)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *tap != "":
+		return commitTap(ctx, *tap, manifest.HomebrewPath(pkg), definition, fmt.Sprintf("%s %s", pkg.Name, release.Version), pushTap)
+	case *output != "":
+		if err := os.WriteFile(*output, definition, 0o644); err != nil {
+			return err
+		}
+		slog.Info("wrote Homebrew definition", "version", release.Version, "path", *output)
+		return nil
+	default:
+		_, err := os.Stdout.Write(definition)
+		return err
+	}
+}
+
+// commitTap writes definition to file of the tap checked out in dir and
+// commits it with message, pushing the commit if push is set. An unchanged
+// definition is not committed.
+func commitTap(ctx context.Context, dir, file string, definition []byte, message string, push bool) error {
+	path := filepath.Join(dir, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, definition, 0o644); err != nil {
+		return err
+	}
+
+	git := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+	if err := git("add", "--", file); err != nil {
+		return err
+	}
+	// diff exits with 1 when the staged definition changed
+	if err := git("diff", "--cached", "--quiet", "--", file); err == nil {
+		slog.Info("Homebrew definition is up to date", "path", path)
+		return nil
+	}
+	if err := git("commit", "-m", message, "--", file); err != nil {
+		return err
+	}
+	slog.Info("committed Homebrew definition", "path", path)
+
+	if !push {
+		return nil
+	}
+	if err := git("push"); err != nil {
+		return err
+	}
+	slog.Info("pushed Homebrew definition", "tap", dir)
+	return nil
+}
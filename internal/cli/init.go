@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+var initCommand = &command{
+	name:    "init",
+	summary: "Create an empty manifest for a new application",
+	run:     runInit,
+}
+
+func runInit(ctx context.Context, args []string) error {
+	fs := newFlagSet("init")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	channels := fs.String("channels", "stable", "comma-separated channels to create, e.g. stable,beta")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	in.flag(*channels, "channels")
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("init", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, *appID, signingFlags)
+	if err != nil {
+		return err
+	}
+	if publisher.Exists() {
+		return fmt.Errorf("the manifest of %s already exists", *appID)
+	}
+
+	var names []string
+	for _, name := range strings.Split(*channels, ",") {
+		name = strings.TrimSpace(name)
+		if err := publisher.AddChannel(name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+
+	if err := publisher.Save(ctx); err != nil {
+		return err
+	}
+	return rep.finish(publisher, "created manifest", "key", rep.result.ManifestKey, "channels", strings.Join(names, ","))
+}
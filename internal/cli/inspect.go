@@ -0,0 +1,259 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/storage"
+)
+
+var inspectCommand = &command{
+	name:    "inspect",
+	summary: "Show the artifact record of one platform of a release",
+	run:     runInspect,
+}
+
+// inspected is the artifact record printed by inspect.
+type inspected struct {
+	Channel   string    `json:"channel"`
+	Version   string    `json:"version"`
+	Platform  string    `json:"platform"`
+	Variant   string    `json:"variant,omitempty"`
+	Kind      string    `json:"kind,omitempty"`
+	Current   bool      `json:"current"`
+	Status    string    `json:"status,omitempty"`
+	Build     time.Time `json:"build"`
+	Mandatory bool      `json:"mandatory,omitempty"`
+	Notes     string    `json:"notes,omitempty"`
+	NotesKey  string    `json:"notes_key,omitempty"`
+
+	manifest.Artifact
+	// Size is nil when the object is missing.
+	Size      *int64 `json:"size"`
+	PatchSize *int64 `json:"patch_size,omitempty"`
+	// URL downloads the artifact: signed when the backend can sign URLs,
+	// otherwise below the public URL.
+	URL          string     `json:"url,omitempty"`
+	URLExpiresAt *time.Time `json:"url_expires_at,omitempty"`
+}
+
+func runInspect(ctx context.Context, args []string) error {
+	fs := newFlagSet("inspect")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	channel := fs.String("channel", "", "channel of the release, e.g. stable")
+	platform := fs.String("platform", "", "platform of the artifact, e.g. windows/amd64")
+	variant := fs.String("variant", "", "variant of the artifact, e.g. v3")
+	kind := fs.String("kind", "", "kind of the artifact, e.g. installer (default the executable)")
+	version := fs.String("version", "", "recorded version to inspect (default the current release)")
+	urlExpiry := fs.Duration("url-expiry", time.Hour, "validity of the signed download URL")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	in.flag(*channel, "channel")
+	in.flag(*platform, "platform")
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	rep, err := newReport("inspect", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := loadPublisher(ctx, backend, *appID, nil)
+	if err != nil {
+		return err
+	}
+
+	ch, ok := publisher.Manifest().Channel[*channel]
+	if !ok {
+		return fmt.Errorf("%w: %s", manifest.ErrChannelNotFound, *channel)
+	}
+	release, current := ch.Current(), true
+	if *version != "" && *version != ch.Version {
+		if release = ch.Find(*version); release == nil {
+			return fmt.Errorf("%w: %s in channel %s", manifest.ErrReleaseNotFound, *version, *channel)
+		}
+		current = false
+	}
+	artifact := release.FindArtifact(*platform)
+	if artifact == nil {
+		return fmt.Errorf("release %s of channel %s has no artifact for %s", release.Version, *channel, *platform)
+	}
+	if *kind != "" || *variant != "" {
+		if artifact = artifact.Select(*kind, *variant); artifact == nil {
+			return fmt.Errorf("artifact %s of release %s has no %s", *platform, release.Version, strings.TrimSpace(*kind+" "+*variant))
+		}
+	}
+
+	record := inspected{
+		Channel:   *channel,
+		Version:   release.Version,
+		Platform:  *platform,
+		Variant:   *variant,
+		Kind:      *kind,
+		Current:   current,
+		Status:    releaseStatus(release),
+		Build:     release.Build,
+		Mandatory: release.Mandatory,
+		Notes:     release.Notes,
+		NotesKey:  release.NotesKey,
+		Artifact:  *artifact,
+	}
+	if record.Size, err = objectSize(ctx, backend, artifact.Binary); err != nil {
+		return err
+	}
+	if artifact.Patch != "" {
+		if record.PatchSize, err = objectSize(ctx, backend, artifact.Patch); err != nil {
+			return err
+		}
+	}
+
+	url, err := storage.PresignGet(ctx, backend, artifact.Binary, *urlExpiry)
+	switch {
+	case err == nil:
+		expires := time.Now().Add(*urlExpiry).UTC()
+		record.URL, record.URLExpiresAt = url, &expires
+	case errors.Is(err, errors.ErrUnsupported):
+		record.URL = outputFlags.objectURL(artifact.Binary)
+	default:
+		return fmt.Errorf("failed to sign download URL: %w", err)
+	}
+
+	if rep.json {
+		return writeJSON(os.Stdout, record)
+	}
+
+	size := func(size *int64) string {
+		if size == nil {
+			return "missing"
+		}
+		return formatBytes(*size)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "channel:\t%s\n", record.Channel)
+	fmt.Fprintf(w, "version:\t%s\n", record.Version)
+	if !current {
+		fmt.Fprintf(w, "current version:\t%s\n", ch.Version)
+	}
+	if record.Status != "" {
+		fmt.Fprintf(w, "status:\t%s\n", record.Status)
+	}
+	if release.YankReason != "" {
+		fmt.Fprintf(w, "yank reason:\t%s\n", release.YankReason)
+	}
+	fmt.Fprintf(w, "build:\t%s\n", record.Build.Format(time.RFC3339))
+	if record.Mandatory {
+		fmt.Fprintf(w, "mandatory:\tyes\n")
+	}
+	if record.NotesKey != "" {
+		fmt.Fprintf(w, "notes:\t%s\n", record.NotesKey)
+	} else if record.Notes != "" {
+		fmt.Fprintf(w, "notes:\t%s\n", strings.ReplaceAll(strings.TrimSpace(record.Notes), "\n", "\n\t"))
+	}
+	fmt.Fprintf(w, "platform:\t%s\n", record.Platform)
+	switch {
+	case record.Variant != "":
+		fmt.Fprintf(w, "variant:\t%s\n", record.Variant)
+	case record.Kind != "":
+		fmt.Fprintf(w, "kind:\t%s\n", record.Kind)
+	default:
+		if len(artifact.Variants) > 0 {
+			fmt.Fprintf(w, "variants:\t%s\n", strings.Join(sortedKeys(artifact.Variants), ", "))
+		}
+		if len(artifact.Kinds) > 0 {
+			fmt.Fprintf(w, "kinds:\t%s\n", strings.Join(sortedKeys(artifact.Kinds), ", "))
+		}
+	}
+	fmt.Fprintf(w, "key:\t%s\n", artifact.Binary)
+	fmt.Fprintf(w, "checksum:\t%s (%s)\n", artifact.Checksum, artifact.HashAlgorithm())
+	if artifact.Sidecar != "" {
+		fmt.Fprintf(w, "checksum file:\t%s\n", artifact.Sidecar)
+	}
+	if artifact.Zsync != "" {
+		fmt.Fprintf(w, "zsync file:\t%s\n", artifact.Zsync)
+	}
+	if artifact.Signature != "" {
+		fmt.Fprintf(w, "signature:\t%s\n", artifact.Signature)
+		fmt.Fprintf(w, "signed by:\t%s\n", strings.Join(artifact.SignedBy, ", "))
+	}
+	for _, format := range sortedKeys(artifact.Signatures) {
+		fmt.Fprintf(w, "%s signature:\t%s\n", format, artifact.Signatures[format])
+		if identity, ok := artifact.Identities[format]; ok {
+			fmt.Fprintf(w, "%s signed by:\t%s (%s)\n", format, identity.Subject, identity.Issuer)
+		}
+	}
+	fmt.Fprintf(w, "size:\t%s\n", size(record.Size))
+	if artifact.MinOS != "" {
+		fmt.Fprintf(w, "minimum os:\t%s\n", artifact.MinOS)
+	}
+	if archive := artifact.Archive; archive != nil {
+		fmt.Fprintf(w, "archive:\t%s, %d files\n", archive.Format, len(archive.Files))
+		if archive.Root != "" {
+			fmt.Fprintf(w, "archive root:\t%s\n", archive.Root)
+		}
+		if archive.Executable != "" {
+			fmt.Fprintf(w, "archive executable:\t%s\n", archive.Executable)
+		}
+	}
+	if compressed := artifact.Compressed; compressed != nil {
+		fmt.Fprintf(w, "compressed:\t%s\n", compressed.Key)
+		fmt.Fprintf(w, "compressed checksum:\t%s\n", compressed.Checksum)
+		fmt.Fprintf(w, "compressed size:\t%s (%s)\n", formatBytes(compressed.Size), compressed.Format)
+	}
+	if chunks := artifact.Chunks; chunks != nil {
+		fmt.Fprintf(w, "chunk index:\t%s\n", chunks.Key)
+		fmt.Fprintf(w, "chunks:\t%d under %s (%s)\n", chunks.Count, chunks.Prefix, chunks.Format)
+	}
+	if artifact.Patch != "" {
+		fmt.Fprintf(w, "patch:\t%s\n", artifact.Patch)
+		fmt.Fprintf(w, "patch checksum:\t%s\n", artifact.PatchChecksum)
+		fmt.Fprintf(w, "patch from:\t%s\n", artifact.PatchFrom)
+		fmt.Fprintf(w, "patch format:\t%s\n", artifact.PatchFormat)
+		fmt.Fprintf(w, "patch size:\t%s\n", size(record.PatchSize))
+	}
+	for _, delta := range artifact.Patches {
+		fmt.Fprintf(w, "older patch:\t%s from %s (%s, %s)\n", delta.Key, delta.From, delta.Format, formatBytes(delta.Size))
+	}
+	if record.URL != "" {
+		fmt.Fprintf(w, "url:\t%s\n", record.URL)
+	}
+	if record.URLExpiresAt != nil {
+		fmt.Fprintf(w, "url expires:\t%s\n", record.URLExpiresAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// objectSize returns the size of the object stored under key, or nil if it
+// is missing.
+func objectSize(ctx context.Context, backend storage.Backend, key string) (*int64, error) {
+	info, err := backend.Stat(ctx, key)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	return &info.Size, nil
+}
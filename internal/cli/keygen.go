@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"update-manifest/pkg/signing"
+)
+
+var keygenCommand = &command{
+	name:    "keygen",
+	summary: "Generate an encrypted signing key and print its public key for the client",
+	run:     runKeygen,
+}
+
+func runKeygen(_ context.Context, args []string) error {
+	fs := newFlagSet("keygen")
+	keyType := fs.String("type", "ed25519", "type of the key: ed25519 for --signing-key, or minisign for --minisign-key")
+	out := fs.String("out", "", "file to write the private key to, readable by its owner only, with the public key written next to it suffixed with .pub")
+	force := fs.Bool("force", false, "overwrite existing key files")
+	unencrypted := fs.Bool("unencrypted", false, "write the private key unencrypted, e.g. to store it in a secret manager")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	in := &inputs{}
+	in.flag(*out, "out")
+	if err := in.err(); err != nil {
+		return err
+	}
+
+	var setting string
+	switch *keyType {
+	case "ed25519":
+		setting = "SIGNING_KEY_PASSPHRASE"
+	case "minisign":
+		setting = "MINISIGN_PASSWORD"
+	default:
+		return fmt.Errorf("unknown key type %q, expected ed25519 or minisign", *keyType)
+	}
+	var passphrase string
+	if !*unencrypted {
+		var err error
+		if passphrase, err = readPassphrase(setting); err != nil {
+			return err
+		}
+	}
+
+	var private, public []byte
+	var id, printed string
+	if *keyType == "ed25519" {
+		key, err := signing.GenerateKey()
+		if err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+		if private, err = signing.MarshalPrivateKey(key, passphrase); err != nil {
+			return fmt.Errorf("failed to encode private key: %w", err)
+		}
+		publicKey, err := signing.NewPublicKey(key.Public())
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalPKIXPublicKey(publicKey.Key)
+		if err != nil {
+			return fmt.Errorf("failed to encode public key: %w", err)
+		}
+		// the base64 PKIX key is what signing.ParsePublicKey, and so the
+		// TrustedKeys of the updater, take
+		id, printed = publicKey.ID, base64.StdEncoding.EncodeToString(der)
+		public = []byte(printed + "\n")
+	} else {
+		key, err := signing.GenerateMinisignKey()
+		if err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+		if private, err = key.Marshal(passphrase); err != nil {
+			return fmt.Errorf("failed to encode private key: %w", err)
+		}
+		public = []byte(key.PublicKey().String())
+		id, printed = key.ID(), strings.Split(strings.TrimSpace(string(public)), "\n")[1]
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !*force {
+		flags |= os.O_EXCL
+	}
+	if err := writeKeyFile(*out, private, flags, 0o600); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	if err := writeKeyFile(*out+".pub", public, flags, 0o644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+
+	slog.Info("generated key", "type", *keyType, "id", id, "path", *out, "encrypted", passphrase != "")
+	fmt.Println(printed)
+	return nil
+}
+
+// readPassphrase returns the passphrase to encrypt a new key with: the
+// setting, or the first line of stdin when that is not a terminal, which
+// would echo it.
+func readPassphrase(setting string) (string, error) {
+	if passphrase := getenv(setting); passphrase != "" {
+		return passphrase, nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice != 0 {
+		return "", fmt.Errorf("no passphrase: set %s or %s_FILE, pipe it to stdin, or pass --unencrypted", setting, setting)
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		return "", errors.New("no passphrase: stdin is empty")
+	}
+	return passphrase, nil
+}
+
+// writeKeyFile writes data to the file at path, opened with flags.
+func writeKeyFile(path string, data []byte, flags int, perm os.FileMode) error {
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
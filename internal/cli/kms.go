@@ -0,0 +1,259 @@
+package cli
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage/s3"
+)
+
+// kmsScope is the OAuth 2.0 scope Google access tokens for Cloud KMS are
+// requested with.
+const kmsScope = "https://www.googleapis.com/auth/cloudkms"
+
+// loadKMSSigner returns the signer of the cloud KMS key named by uri, like
+// cosign does: awskms://[endpoint]/<key ID, ARN or alias> for AWS KMS, with
+// credentials of the standard AWS chain, or gcpkms://<key version name> for
+// Google Cloud KMS, at $GCP_KMS_ENDPOINT if set, with the access token of
+// googleAccessToken. The endpoint of AWS KMS is also read from
+// $AWS_ENDPOINT_URL_KMS, and the region of keys not named by their ARN from
+// $AWS_REGION.
+func loadKMSSigner(ctx context.Context, uri string) (signing.Signer, error) {
+	switch {
+	case strings.HasPrefix(uri, "awskms://"):
+		endpoint, keyID, _ := strings.Cut(strings.TrimPrefix(uri, "awskms://"), "/")
+		if keyID == "" {
+			return nil, fmt.Errorf("invalid KMS key %q: no key ID", uri)
+		}
+		if endpoint != "" {
+			endpoint = "https://" + endpoint
+		} else {
+			endpoint = getenv("AWS_ENDPOINT_URL_KMS")
+		}
+
+		region := getenv("AWS_REGION")
+		if region == "" {
+			region = getenv("AWS_DEFAULT_REGION")
+		}
+		if fields := strings.Split(keyID, ":"); len(fields) > 3 && fields[0] == "arn" {
+			region = fields[3]
+		}
+		if region == "" {
+			return nil, fmt.Errorf("the region of AWS KMS key %s is unknown: name it by its ARN or set AWS_REGION", keyID)
+		}
+
+		kms := &signing.AWSKMS{
+			Region:      region,
+			Endpoint:    endpoint,
+			Credentials: s3.DefaultCredentials(),
+			Client:      &http.Client{Timeout: time.Minute},
+		}
+		return kms.Signer(ctx, keyID)
+
+	case strings.HasPrefix(uri, "gcpkms://"):
+		kms := &signing.GCPKMS{
+			Token:    (&googleToken{}).get,
+			Endpoint: getenv("GCP_KMS_ENDPOINT"),
+			Client:   &http.Client{Timeout: time.Minute},
+		}
+		return kms.Signer(ctx, strings.TrimPrefix(uri, "gcpkms://"))
+	}
+	return nil, fmt.Errorf("invalid KMS key %q: expected awskms:// or gcpkms://", uri)
+}
+
+// googleToken caches the Google access token of googleAccessToken until it
+// is about to expire.
+type googleToken struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (t *googleToken) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Until(t.expires) > time.Minute {
+		return t.token, nil
+	}
+
+	token, lifetime, err := googleAccessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	t.token, t.expires = token, time.Now().Add(lifetime)
+	return token, nil
+}
+
+// googleAccessToken returns a Google access token for Cloud KMS and how long
+// it is valid: $GOOGLE_OAUTH_ACCESS_TOKEN itself, e.g. one exported by the
+// google-github-actions/auth action, one granted for the application
+// default credentials of $GOOGLE_APPLICATION_CREDENTIALS or gcloud, which
+// are a service account key or the refresh token of a user, or the token of
+// the service account of the machine from the metadata server.
+func googleAccessToken(ctx context.Context) (string, time.Duration, error) {
+	if token := getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, time.Hour, nil
+	}
+
+	path := getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			wellKnown := filepath.Join(dir, "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(wellKnown); err == nil {
+				path = wellKnown
+			}
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read Google credentials: %w", err)
+		}
+		return googleCredentialsToken(ctx, data)
+	}
+
+	host := getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	token, lifetime, err := requestGoogleToken(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("no Google credentials: set GOOGLE_APPLICATION_CREDENTIALS, or run on Google Cloud: %w", err)
+	}
+	return token, lifetime, nil
+}
+
+// googleCredentialsToken exchanges the Google credentials file data for an
+// access token.
+func googleCredentialsToken(ctx context.Context, data []byte) (string, time.Duration, error) {
+	var creds struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKeyID string `json:"private_key_id"`
+		PrivateKey   string `json:"private_key"`
+		TokenURI     string `json:"token_uri"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return "", 0, fmt.Errorf("failed to decode Google credentials: %w", err)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	form := url.Values{}
+	switch creds.Type {
+	case "service_account":
+		assertion, err := serviceAccountAssertion(creds.ClientEmail, creds.PrivateKeyID, creds.PrivateKey, creds.TokenURI)
+		if err != nil {
+			return "", 0, err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", creds.ClientID)
+		form.Set("client_secret", creds.ClientSecret)
+		form.Set("refresh_token", creds.RefreshToken)
+	default:
+		return "", 0, fmt.Errorf("unsupported Google credentials of type %q, export an access token to GOOGLE_OAUTH_ACCESS_TOKEN instead", creds.Type)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, creds.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return requestGoogleToken(req)
+}
+
+// serviceAccountAssertion returns the JWT a service account presents to
+// audience to be granted an access token, signed with its PEM encoded
+// PKCS #8 RSA key.
+func serviceAccountAssertion(email, keyID, key, audience string) (string, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return "", errors.New("Google service account key has no PEM encoded private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse Google service account key: %w", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("unsupported Google service account key type %T", parsed)
+	}
+
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iss":   email,
+		"scope": kmsScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// requestGoogleToken sends req for an access token and returns it with its
+// lifetime.
+func requestGoogleToken(req *http.Request) (string, time.Duration, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("failed to get Google access token: unexpected status %s", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", 0, fmt.Errorf("failed to decode Google access token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, errors.New("Google granted an empty access token")
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
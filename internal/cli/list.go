@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"update-manifest/pkg/manifest"
+)
+
+var listCommand = &command{
+	name:    "list",
+	summary: "Print the channels and platforms of the manifest",
+	run:     runList,
+}
+
+// listedRelease is a release printed by list.
+type listedRelease struct {
+	Channel   string           `json:"channel"`
+	Version   string           `json:"version"`
+	Build     time.Time        `json:"build"`
+	Current   bool             `json:"current"`
+	Status    string           `json:"status,omitempty"`
+	Artifacts []listedArtifact `json:"artifacts"`
+}
+
+type listedArtifact struct {
+	Platform string `json:"platform"`
+	Variant  string `json:"variant,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	Key      string `json:"key"`
+	Checksum string `json:"checksum"`
+	// Size is nil when the object is missing.
+	Size  *int64 `json:"size"`
+	Patch string `json:"patch,omitempty"`
+}
+
+func runList(ctx context.Context, args []string) error {
+	fs := newFlagSet("list")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	output := fs.String("output", "text", "output format: text or json")
+	history := fs.Bool("history", false, "also list the recorded releases of every channel")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := loadPublisher(ctx, backend, *appID, nil)
+	if err != nil {
+		return err
+	}
+
+	sizes := make(map[string]*int64)
+	listRelease := func(channel string, release *manifest.Release, current bool) (listedRelease, error) {
+		listed := listedRelease{
+			Channel:   channel,
+			Version:   release.Version,
+			Build:     release.Build,
+			Current:   current,
+			Status:    releaseStatus(release),
+			Artifacts: []listedArtifact{},
+		}
+		add := func(platform, variant, kind string, artifact *manifest.Artifact) error {
+			if artifact.Binary == "" {
+				return nil
+			}
+			size, ok := sizes[artifact.Binary]
+			if !ok {
+				var err error
+				if size, err = objectSize(ctx, backend, artifact.Binary); err != nil {
+					return err
+				}
+				sizes[artifact.Binary] = size
+			}
+
+			listed.Artifacts = append(listed.Artifacts, listedArtifact{
+				Platform: platform,
+				Variant:  variant,
+				Kind:     kind,
+				Key:      artifact.Binary,
+				Checksum: artifact.Checksum,
+				Size:     size,
+				Patch:    artifact.Patch,
+			})
+			return nil
+		}
+		for _, platform := range sortedKeys(release.Artifact) {
+			artifact := release.Artifact[platform]
+			if err := add(platform, "", "", artifact); err != nil {
+				return listed, err
+			}
+			for _, variant := range sortedKeys(artifact.Variants) {
+				if err := add(platform, variant, "", artifact.Variants[variant]); err != nil {
+					return listed, err
+				}
+			}
+			for _, kind := range sortedKeys(artifact.Kinds) {
+				if err := add(platform, "", kind, artifact.Kinds[kind]); err != nil {
+					return listed, err
+				}
+			}
+		}
+		return listed, nil
+	}
+
+	m := publisher.Manifest()
+	releases := []listedRelease{}
+	for _, name := range sortedKeys(m.Channel) {
+		channel := m.Channel[name]
+		listed, err := listRelease(name, &channel.Release, true)
+		if err != nil {
+			return err
+		}
+		releases = append(releases, listed)
+
+		if !*history {
+			continue
+		}
+		for _, release := range channel.Releases {
+			if release.Version == channel.Version {
+				continue
+			}
+			listed, err := listRelease(name, release, false)
+			if err != nil {
+				return err
+			}
+			releases = append(releases, listed)
+		}
+	}
+
+	if *output == "json" {
+		return writeJSON(os.Stdout, releases)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANNEL\tVERSION\tBUILD\tSTATUS\tPLATFORM\tSIZE\tCHECKSUM")
+	for _, release := range releases {
+		status := release.Status
+		if !release.Current {
+			status = strings.TrimSpace("history " + status)
+		}
+		if status == "" {
+			status = "-"
+		}
+
+		for _, artifact := range release.Artifacts {
+			size := "missing"
+			if artifact.Size != nil {
+				size = formatBytes(*artifact.Size)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", release.Channel, release.Version,
+				release.Build.Format(time.RFC3339), status, strings.Join(strings.Fields(artifact.Platform+" "+artifact.Variant+" "+artifact.Kind), " "), size, shortChecksum(artifact.Checksum))
+		}
+		if len(release.Artifacts) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t-\t-\t-\n", release.Channel, release.Version, release.Build.Format(time.RFC3339), status)
+		}
+	}
+	return w.Flush()
+}
+
+// releaseStatus describes the yank and rollout state of release, or returns
+// "" for a release offered to every device.
+func releaseStatus(release *manifest.Release) string {
+	switch {
+	case release.Yanked:
+		return "yanked"
+	case release.RolloutPaused:
+		return "paused"
+	case release.Rollout != nil:
+		return fmt.Sprintf("rollout %d%%", *release.Rollout)
+	}
+	return ""
+}
+
+// shortChecksum abbreviates checksum for display.
+func shortChecksum(checksum string) string {
+	if len(checksum) > 12 {
+		return checksum[:12]
+	}
+	return checksum
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
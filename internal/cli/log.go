@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// logLevel is the minimum level logged, lowered by --verbose and raised by
+// --quiet.
+var logLevel = new(slog.LevelVar)
+
+// addLogFlags registers the flags controlling the log level on fs.
+func addLogFlags(fs *flag.FlagSet) {
+	fs.BoolFunc("verbose", "log debug messages", levelFlag(slog.LevelDebug))
+	fs.BoolFunc("quiet", "only log warnings and errors", levelFlag(slog.LevelWarn))
+}
+
+func levelFlag(level slog.Level) func(string) error {
+	return func(value string) error {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			logLevel.Set(level)
+		}
+		return nil
+	}
+}
+
+// setupLogging makes the default logger write to stderr in the format named
+// by $LOG_FORMAT, text or json, with secrets redacted.
+func setupLogging() error {
+	opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: redactAttr}
+
+	var handler slog.Handler
+	switch format := getenv("LOG_FORMAT"); format {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
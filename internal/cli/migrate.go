@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"update-manifest/pkg/manifest"
+)
+
+var migrateCommand = &command{
+	name:    "migrate",
+	summary: "Move stored objects to a new key layout",
+	run:     runMigrate,
+}
+
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: update-manifest migrate rename-prefix [flags]")
+	}
+	action, args := args[0], args[1:]
+	if action != "rename-prefix" {
+		return fmt.Errorf("unknown migrate action %q", action)
+	}
+
+	fs := newFlagSet("migrate " + action)
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	from := fs.String("from", "", "key prefix to move objects from (default {app}/artifect/)")
+	to := fs.String("to", "", "key prefix to move objects to (default {app}/artifact/)")
+	deleteOld := fs.Bool("delete-old", false, "delete the objects under the old prefix once the manifest refers to the copies; backups made before the migration then refer to missing objects")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+	if *from == "" {
+		*from = manifest.LegacyArtifactPrefix(*appID)
+	}
+	if *to == "" {
+		*to = *appID + "/artifact/"
+	}
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("migrate "+action, *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, *appID, signingFlags)
+	if err != nil {
+		return err
+	}
+
+	// copies only written to the dry run cannot be downloaded again
+	renamed, err := publisher.RenamePrefix(ctx, *from, *to, !*dryRunMode)
+	if err != nil {
+		return err
+	}
+	if len(renamed) == 0 {
+		return rep.finish(publisher, "nothing to migrate", "from", *from)
+	}
+
+	// the manifest has to refer to the copies before the originals go
+	if err := publisher.Save(ctx); err != nil {
+		return err
+	}
+
+	if *deleteOld {
+		retained := make(map[string]bool)
+		for _, key := range publisher.Manifest().Keys() {
+			retained[key] = true
+		}
+		for _, key := range renamed {
+			if retained[key] {
+				continue
+			}
+			if err := backend.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", key, err)
+			}
+			if !*dryRunMode {
+				slog.Info("deleted migrated object", "key", key)
+			}
+		}
+	}
+
+	return rep.finish(publisher, "migrated objects", "from", *from, "to", *to, "objects", len(renamed))
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"strings"
+	"time"
+
+	"update-manifest/pkg/notify"
+)
+
+// notifyFlags select the systems told about a published release.
+type notifyFlags struct {
+	webhooks *stringList
+	slack    *stringList
+	discord  *stringList
+	email    *stringList
+}
+
+// addNotifyFlags registers the notification flags on fs.
+func addNotifyFlags(fs *flag.FlagSet) *notifyFlags {
+	f := &notifyFlags{webhooks: &stringList{}, slack: &stringList{}, discord: &stringList{}, email: &stringList{}}
+	fs.Var(f.webhooks, "webhook-url", "URL to POST a JSON description of the release to once it is published, signed with the HMAC-SHA256 key $WEBHOOK_SECRET in the "+notify.SignatureHeader+" header if set; prefixed with <channel>= for the releases of that channel only (repeatable, default the comma-separated URLs of $WEBHOOK_URLS)")
+	fs.Var(f.slack, "slack-webhook-url", "Slack incoming webhook to announce the release in once it is published; prefixed with <channel>= for the releases of that channel only, e.g. beta=https://hooks.slack.com/services/... (repeatable, default the comma-separated URLs of $SLACK_WEBHOOK_URLS)")
+	fs.Var(f.discord, "discord-webhook-url", "Discord webhook to announce the release in once it is published; prefixed with <channel>= for the releases of that channel only (repeatable, default the comma-separated URLs of $DISCORD_WEBHOOK_URLS)")
+	fs.Var(f.email, "email-to", "address to mail an announcement of the release with its notes and download links to once it is published, e.g. a mailing list, through the SMTP server $SMTP_ADDR as $EMAIL_FROM, logged in with $SMTP_USERNAME and $SMTP_PASSWORD if set; prefixed with <channel>= for the releases of that channel only (repeatable, default the comma-separated addresses of $EMAIL_TO)")
+	return f
+}
+
+// loadNotifiers returns the notifiers configured by f: a webhook for every
+// URL of the flags, or of $WEBHOOK_URLS, signed with $WEBHOOK_SECRET, and
+// the Slack and Discord webhooks of the flags, or of $SLACK_WEBHOOK_URLS and
+// $DISCORD_WEBHOOK_URLS, and an email to the addresses of the flags, or of
+// $EMAIL_TO, of every channel.
+func loadNotifiers(f *notifyFlags) ([]notify.Notifier, error) {
+	var notifiers []notify.Notifier
+	secret := getenv("WEBHOOK_SECRET")
+	for _, target := range notifyTargets(*f.webhooks, "WEBHOOK_URLS") {
+		notifiers = append(notifiers, notify.OnChannels(&notify.Webhook{URL: target.url, Secret: secret}, target.channels...))
+	}
+	for _, target := range notifyTargets(*f.slack, "SLACK_WEBHOOK_URLS") {
+		notifiers = append(notifiers, notify.OnChannels(&notify.Slack{URL: target.url}, target.channels...))
+	}
+	for _, target := range notifyTargets(*f.discord, "DISCORD_WEBHOOK_URLS") {
+		notifiers = append(notifiers, notify.OnChannels(&notify.Discord{URL: target.url}, target.channels...))
+	}
+
+	recipients := map[string][]string{}
+	for _, target := range notifyTargets(*f.email, "EMAIL_TO") {
+		channel := strings.Join(target.channels, ",")
+		recipients[channel] = append(recipients[channel], target.url)
+	}
+	if len(recipients) == 0 {
+		return notifiers, nil
+	}
+	addr, from := getenv("SMTP_ADDR"), getenv("EMAIL_FROM")
+	if addr == "" || from == "" {
+		return nil, errors.New("mailing release announcements needs SMTP_ADDR, the host:port of the SMTP server, and EMAIL_FROM")
+	}
+	for _, channel := range sortedKeys(recipients) {
+		email := &notify.Email{Addr: addr, Username: getenv("SMTP_USERNAME"), Password: getenv("SMTP_PASSWORD"), From: from, To: recipients[channel]}
+		var channels []string
+		if channel != "" {
+			channels = []string{channel}
+		}
+		notifiers = append(notifiers, notify.OnChannels(email, channels...))
+	}
+	return notifiers, nil
+}
+
+// notifyTarget is a URL, or email address, to notify of the releases of
+// channels, or of every channel if there are none.
+type notifyTarget struct {
+	url      string
+	channels []string
+}
+
+// notifyTargets parses the [<channel>=]<url> values, or the comma-separated
+// ones of the setting if there are none.
+func notifyTargets(values []string, setting string) []notifyTarget {
+	if len(values) == 0 {
+		for _, value := range strings.Split(getenv(setting), ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+
+	targets := make([]notifyTarget, 0, len(values))
+	for _, value := range values {
+		// the = of a query follows the scheme of the url
+		channel, url, found := strings.Cut(value, "=")
+		if !found || strings.ContainsAny(channel, ":/") {
+			targets = append(targets, notifyTarget{url: value})
+			continue
+		}
+		targets = append(targets, notifyTarget{url: url, channels: []string{channel}})
+	}
+	return targets
+}
+
+// notifyRelease tells the notifiers about release. The release is published
+// by then, so failures are only logged: publishing it again would not tell
+// the notifiers that failed any more than it did.
+func notifyRelease(ctx context.Context, notifiers []notify.Notifier, release *notify.Release) {
+	if len(notifiers) == 0 {
+		return
+	}
+	if err := notify.All(ctx, release, notifiers...); err != nil {
+		slog.Warn("failed to notify of release", "err", err)
+		return
+	}
+	slog.Info("notified of release", "notifiers", len(notifiers))
+}
+
+// release describes the release the command published, with the public URLs
+// of f, its artifacts having checksums made with algo.
+func (r *report) release(f *outputFlags, algo string) *notify.Release {
+	release := &notify.Release{
+		AppID:       r.result.AppID,
+		Channel:     r.result.Channel,
+		Version:     r.result.Version,
+		ManifestURL: r.result.ManifestURL,
+		Published:   time.Now().UTC().Truncate(time.Second),
+	}
+	for _, artifact := range r.result.Artifacts {
+		release.Artifacts = append(release.Artifacts, notify.Artifact{
+			Platform:     artifact.Platform,
+			Variant:      artifact.Variant,
+			Kind:         artifact.Kind,
+			Key:          artifact.Key,
+			Checksum:     artifact.Checksum,
+			ChecksumAlgo: algo,
+			URL:          f.objectURL(artifact.Key),
+		})
+	}
+	return release
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// defaultOIDCAudience is the audience ID tokens are requested for, which
+// AWS STS accepts by default.
+const defaultOIDCAudience = "sts.amazonaws.com"
+
+// awsSTSEndpoint returns the AWS STS endpoint of region, or the global one.
+func awsSTSEndpoint(region string) string {
+	if region == "" || region == "auto" {
+		return "https://sts.amazonaws.com"
+	}
+	return fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+}
+
+// webIdentityCredentials returns the temporary credentials the STS at
+// endpoint grants the OIDC identity of the CI job with
+// AssumeRoleWithWebIdentity, assuming roleARN if set, and renewed from a new
+// ID token as they expire. The first ones are obtained right away, so a
+// rejected token is reported before anything is uploaded.
+func webIdentityCredentials(endpoint, roleARN string) (*credentials.Credentials, error) {
+	audience := getenv("OIDC_AUDIENCE")
+	if audience == "" {
+		audience = defaultOIDCAudience
+	}
+
+	creds := credentials.New(&credentials.STSWebIdentity{
+		Client:      &http.Client{Timeout: time.Minute},
+		STSEndpoint: endpoint,
+		RoleARN:     roleARN,
+		GetWebIDTokenExpiry: func() (*credentials.WebIdentityToken, error) {
+			token, err := ciIDToken(audience)
+			if err != nil {
+				return nil, err
+			}
+			return &credentials.WebIdentityToken{Token: token}, nil
+		},
+	})
+	if _, err := creds.Get(); err != nil {
+		return nil, fmt.Errorf("failed to exchange the OIDC token of the job for credentials at %s: %w", endpoint, err)
+	}
+	return creds, nil
+}
+
+// ciIDToken returns an OIDC ID token of the CI job for audience, the default
+// one of GitHub Actions, the URL of the repository owner, when empty:
+// $WEB_IDENTITY_TOKEN itself, e.g. one GitLab issues through id_tokens, the
+// content of the file $WEB_IDENTITY_TOKEN_FILE, or one requested from
+// GitHub Actions, which issues them to jobs with the id-token: write
+// permission.
+func ciIDToken(audience string) (string, error) {
+	if token := getenv("WEB_IDENTITY_TOKEN"); token != "" {
+		return token, nil
+	}
+	if path := getenv("WEB_IDENTITY_TOKEN_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read OIDC token: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	requestURL, requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"), os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return "", errors.New("no OIDC token: set WEB_IDENTITY_TOKEN or WEB_IDENTITY_TOKEN_FILE, or grant the GitHub Actions job the id-token: write permission")
+	}
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid ACTIONS_ID_TOKEN_REQUEST_URL: %w", err)
+	}
+	query := u.Query()
+	if audience != "" {
+		query.Set("audience", audience)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request GitHub Actions OIDC token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to request GitHub Actions OIDC token: unexpected status %s", resp.Status)
+	}
+
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode GitHub Actions OIDC token: %w", err)
+	}
+	if body.Value == "" {
+		return "", errors.New("GitHub Actions returned an empty OIDC token")
+	}
+	return body.Value, nil
+}
@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage"
+)
+
+// outputFlags holds the flags selecting how a command reports its result.
+type outputFlags struct {
+	format    string
+	publicURL string
+}
+
+// addOutputFlags registers the output flags on fs.
+func addOutputFlags(fs *flag.FlagSet) *outputFlags {
+	f := &outputFlags{}
+	fs.StringVar(&f.format, "output", "text", "output format: text or json")
+	fs.StringVar(&f.publicURL, "public-url", "", "public URL of the bucket, used to report the manifest URL (default $PUBLIC_URL)")
+	return f
+}
+
+// report collects the outcome of a command and prints it as text or JSON.
+type report struct {
+	json   bool
+	start  time.Time
+	dryRun *storage.DryRun
+
+	mu     sync.Mutex
+	result result
+}
+
+// result is the JSON document printed by --output json.
+type result struct {
+	Command     string                      `json:"command"`
+	AppID       string                      `json:"app_id"`
+	Channel     string                      `json:"channel,omitempty"`
+	Version     string                      `json:"version,omitempty"`
+	Artifacts   []artifactResult            `json:"artifacts,omitempty"`
+	Uploaded    []string                    `json:"uploaded"`
+	Deleted     []string                    `json:"deleted,omitempty"`
+	ManifestKey string                      `json:"manifest_key"`
+	ManifestURL string                      `json:"manifest_url,omitempty"`
+	Backup      string                      `json:"backup,omitempty"`
+	Staged      string                      `json:"staged,omitempty"`
+	SignedBy    map[string]signing.Identity `json:"signed_by,omitempty"`
+	DryRun      bool                        `json:"dry_run"`
+	Manifest    *manifest.Manifest          `json:"manifest,omitempty"`
+	Duration    int64                       `json:"duration_ms"`
+}
+
+type artifactResult struct {
+	Platform string `json:"platform"`
+	Variant  string `json:"variant,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	Key      string `json:"key"`
+	Checksum string `json:"checksum"`
+	Patch    string `json:"patch,omitempty"`
+}
+
+// newReport starts the report of command on the manifest of appID.
+func newReport(command, appID string, f *outputFlags) (*report, error) {
+	r := &report{
+		start: time.Now(),
+		result: result{
+			Command:     command,
+			AppID:       appID,
+			Uploaded:    []string{},
+			ManifestKey: manifest.ManifestKey(appID),
+		},
+	}
+
+	switch f.format {
+	case "text":
+	case "json":
+		r.json = true
+	default:
+		return nil, fmt.Errorf("unknown output format %q", f.format)
+	}
+
+	r.result.ManifestURL = f.objectURL(r.result.ManifestKey)
+	return r, nil
+}
+
+// objectURL returns the public URL of the object stored under key, or "" if
+// no public URL is set.
+func (f *outputFlags) objectURL(key string) string {
+	publicURL := f.publicURL
+	if publicURL == "" {
+		publicURL = getenv("PUBLIC_URL")
+	}
+	if publicURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(publicURL, "/") + "/" + key
+}
+
+// track returns backend with every successful write recorded in the report.
+func (r *report) track(backend storage.Backend) storage.Backend {
+	if dryRun, ok := backend.(*storage.DryRun); ok {
+		r.dryRun = dryRun
+		r.result.DryRun = true
+	}
+	return &trackingBackend{Backend: backend, report: r}
+}
+
+// artifact records an artifact published by the command.
+func (r *report) artifact(platform, variant, kind string, artifact *manifest.Artifact) {
+	r.result.Artifacts = append(r.result.Artifacts, artifactResult{
+		Platform: platform,
+		Variant:  variant,
+		Kind:     kind,
+		Key:      artifact.Binary,
+		Checksum: artifact.Checksum,
+		Patch:    artifact.Patch,
+	})
+}
+
+// uploaded reports whether the command wrote key.
+func (r *report) uploaded(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Contains(r.result.Uploaded, key)
+}
+
+// finish reports the outcome of the command on the manifest of publisher: it
+// logs msg with attrs in text mode, or prints the result as JSON. A dry run
+// logs the planned writes and prints the manifest instead. A manifest staged
+// for want of signatures is reported as such instead of msg.
+func (r *report) finish(publisher *manifest.Publisher, msg string, attrs ...any) error {
+	m := publisher.Manifest()
+	if publisher.Staged() != nil {
+		r.result.Staged = manifest.PendingKey(r.result.AppID)
+		msg = "staged manifest: add the missing signatures with sign, then publish it with publish --finalize"
+		attrs = append(attrs, "staged", r.result.Staged)
+	}
+	r.result.Backup = publisher.Backup()
+	r.result.SignedBy = publisher.Identities()
+	r.result.Duration = time.Since(r.start).Milliseconds()
+
+	if r.json {
+		if r.dryRun != nil {
+			r.result.Manifest = m
+		}
+		return writeJSON(os.Stdout, r.result)
+	}
+
+	if r.dryRun == nil {
+		if r.result.Backup != "" {
+			attrs = append(attrs, "backup", r.result.Backup)
+		}
+		for _, format := range sortedKeys(r.result.SignedBy) {
+			attrs = append(attrs, format+"_identity", r.result.SignedBy[format].Subject, format+"_issuer", r.result.SignedBy[format].Issuer)
+		}
+		slog.Info(msg, attrs...)
+		return nil
+	}
+
+	for _, write := range r.dryRun.Writes() {
+		slog.Info("would upload", "key", write.Key, "size", write.Size)
+	}
+	for _, key := range r.dryRun.Deletes() {
+		slog.Info("would delete", "key", key)
+	}
+	return writeJSON(os.Stdout, m)
+}
+
+// writeJSON prints v as indented JSON.
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	return nil
+}
+
+// trackingBackend records the keys written through it in a report.
+type trackingBackend struct {
+	storage.Backend
+	report *report
+}
+
+func (b *trackingBackend) Unwrap() storage.Backend {
+	return b.Backend
+}
+
+func (b *trackingBackend) Put(ctx context.Context, key string, r io.Reader, size int64, opts storage.PutOptions) error {
+	if err := b.Backend.Put(ctx, key, r, size, opts); err != nil {
+		return err
+	}
+	slog.Debug("uploaded object", "key", key, "size", size)
+
+	b.report.mu.Lock()
+	defer b.report.mu.Unlock()
+	b.report.result.Uploaded = append(b.report.result.Uploaded, key)
+	return nil
+}
+
+func (b *trackingBackend) Move(ctx context.Context, src, dst string) error {
+	if err := b.Backend.Move(ctx, src, dst); err != nil {
+		return err
+	}
+
+	b.report.mu.Lock()
+	defer b.report.mu.Unlock()
+	for i, key := range b.report.result.Uploaded {
+		if key == src {
+			b.report.result.Uploaded[i] = dst
+		}
+	}
+	return nil
+}
+
+func (b *trackingBackend) Delete(ctx context.Context, key string) error {
+	if err := b.Backend.Delete(ctx, key); err != nil {
+		return err
+	}
+	slog.Debug("deleted object", "key", key)
+
+	b.report.mu.Lock()
+	defer b.report.mu.Unlock()
+	if i := slices.Index(b.report.result.Uploaded, key); i >= 0 {
+		b.report.result.Uploaded = slices.Delete(b.report.result.Uploaded, i, i+1)
+		return nil
+	}
+	b.report.result.Deleted = append(b.report.result.Deleted, key)
+	return nil
+}
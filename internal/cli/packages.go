@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"update-manifest/pkg/manifest"
+)
+
+// packagedRelease returns the release of channel that package manager
+// definitions are rendered for: the recorded version, or the current
+// release if version is empty.
+func packagedRelease(m *manifest.Manifest, channel, version string) (*manifest.Release, error) {
+	ch, ok := m.Channel[channel]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", manifest.ErrChannelNotFound, channel)
+	}
+	if version == "" {
+		version = ch.Version
+	}
+	// the recorded release of the current version holds only the artifacts
+	// published for it
+	release := ch.Find(version)
+	if release == nil && version == ch.Version {
+		release = &ch.Release
+	}
+	if release == nil {
+		return nil, fmt.Errorf("%w: %s in channel %s", manifest.ErrReleaseNotFound, version, channel)
+	}
+	return release, nil
+}
+
+// packageDownloadURL returns the public URL package managers download
+// artifacts from: downloadURL, or $DOWNLOAD_URL or $PUBLIC_URL.
+func packageDownloadURL(downloadURL string) (string, error) {
+	if downloadURL == "" {
+		downloadURL = getenv("DOWNLOAD_URL")
+	}
+	if downloadURL == "" {
+		downloadURL = getenv("PUBLIC_URL")
+	}
+	if downloadURL == "" {
+		return "", errors.New("package managers need the public URL of the bucket, set --download-url or $DOWNLOAD_URL")
+	}
+	return downloadURL, nil
+}
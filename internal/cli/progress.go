@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// addProgressFlag registers the flag selecting how upload progress is shown.
+func addProgressFlag(fs *flag.FlagSet) *string {
+	return fs.String("progress", "auto", "upload progress: bar, log, off, or auto for a bar on terminals and logs otherwise")
+}
+
+// progressReader reports how far an executable has been read.
+type progressReader struct {
+	r        io.ReadSeeker
+	label    string
+	size     int64
+	bar      bool
+	interval time.Duration
+
+	position atomic.Int64
+	start    time.Time
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// newProgressReader starts reporting the progress of reading r, of the given
+// size, in mode. It returns r unchanged when progress is off.
+func newProgressReader(mode, label string, r io.ReadSeeker, size int64) (io.ReadSeeker, func(), error) {
+	var bar bool
+	switch mode {
+	case "auto":
+		bar = isTerminal(os.Stderr)
+	case "bar":
+		bar = true
+	case "log":
+	case "off":
+		return r, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown progress mode %q", mode)
+	}
+
+	p := &progressReader{
+		r:        r,
+		label:    label,
+		size:     size,
+		bar:      bar,
+		interval: 10 * time.Second,
+		start:    time.Now(),
+		done:     make(chan struct{}),
+	}
+	if bar {
+		p.interval = 250 * time.Millisecond
+	}
+
+	p.wg.Add(1)
+	go p.report()
+	return p, p.stop, nil
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.position.Add(int64(n))
+	return n, err
+}
+
+func (p *progressReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := p.r.Seek(offset, whence)
+	if err == nil {
+		p.position.Store(position)
+	}
+	return position, err
+}
+
+func (p *progressReader) report() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			if p.bar {
+				p.draw()
+				fmt.Fprintln(os.Stderr)
+			}
+			return
+		case <-ticker.C:
+			if p.bar {
+				p.draw()
+			} else {
+				position := p.position.Load()
+				slog.Info("upload progress", "platform", p.label, "percent", percent(position, p.size), "bytes", position, "size", p.size)
+			}
+		}
+	}
+}
+
+// draw redraws the progress bar on the current line of stderr.
+func (p *progressReader) draw() {
+	const width = 30
+
+	position := p.position.Load()
+	filled := width * percent(position, p.size) / 100
+
+	rate := float64(position) / max(time.Since(p.start).Seconds(), 0.001)
+	fmt.Fprintf(os.Stderr, "\r%s [%s%s] %3d%% %s / %s %s/s ", p.label,
+		strings.Repeat("=", filled), strings.Repeat(" ", width-filled),
+		percent(position, p.size), formatBytes(position), formatBytes(p.size), formatBytes(int64(rate)))
+}
+
+func (p *progressReader) stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+func percent(position, size int64) int {
+	if size <= 0 {
+		return 100
+	}
+	return int(min(position*100/size, 100))
+}
+
+// formatBytes formats n with a binary unit, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,69 @@
+package cli
+
+import "context"
+
+var promoteCommand = &command{
+	name:    "promote",
+	summary: "Copy the release of one channel into another",
+	run:     runPromote,
+}
+
+func runPromote(ctx context.Context, args []string) error {
+	fs := newFlagSet("promote")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	keepReleases := addRetentionFlag(fs)
+	from := fs.String("from", "", "channel to promote from, e.g. beta")
+	to := fs.String("to", "", "channel to promote to, e.g. stable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	in.flag(*from, "from")
+	in.flag(*to, "to")
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("promote", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	keep, err := resolveRetention(*keepReleases)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := loadPublisher(ctx, backend, *appID, signingFlags)
+	if err != nil {
+		return err
+	}
+	publisher.KeepReleases(keep)
+
+	if err := publisher.Promote(*from, *to); err != nil {
+		return err
+	}
+
+	if err := publisher.Save(ctx); err != nil {
+		return err
+	}
+	version := publisher.Manifest().Channel[*to].Version
+	rep.result.Channel, rep.result.Version = *to, version
+	return rep.finish(publisher, "promoted release", "from", *from, "to", *to, "version", version)
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+)
+
+var pruneCommand = &command{
+	name:    "prune",
+	summary: "Drop old releases and delete the artifacts only they referenced",
+	run:     runPrune,
+}
+
+func runPrune(ctx context.Context, args []string) error {
+	fs := newFlagSet("prune")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	keepReleases := fs.Int("keep", -1, "number of releases to keep in the history of a channel, 0 for all (default $KEEP_RELEASES, or 10)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	keep, err := resolveRetention(*keepReleases)
+	if err != nil {
+		return err
+	}
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("prune", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, *appID, signingFlags)
+	if err != nil {
+		return err
+	}
+	referenced := publisher.Manifest().Keys()
+
+	dropped, err := publisher.Prune(keep)
+	if err != nil {
+		return err
+	}
+	if dropped == 0 {
+		return rep.finish(publisher, "nothing to prune", "keep", keep)
+	}
+
+	// the manifest is saved first so it never refers to a deleted object, and
+	// whatever the saved manifest still refers to after merging concurrent
+	// changes is kept
+	if err := publisher.Save(ctx); err != nil {
+		return err
+	}
+
+	retained := make(map[string]bool)
+	for _, key := range publisher.Manifest().Keys() {
+		retained[key] = true
+	}
+
+	var deleted int
+	for _, key := range referenced {
+		if retained[key] {
+			continue
+		}
+		if err := backend.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+		deleted++
+	}
+
+	return rep.finish(publisher, "pruned releases", "releases", dropped, "objects", deleted)
+}
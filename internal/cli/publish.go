@@ -0,0 +1,532 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"gopkg.in/yaml.v3"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/storage"
+)
+
+var publishCommand = &command{
+	name:    "publish",
+	summary: "Upload executables and record them in the manifest",
+	run:     runPublish,
+}
+
+// releasePlan lists the executables published together as one version.
+type releasePlan struct {
+	Channel   string            `yaml:"channel"`
+	Version   string            `yaml:"version"`
+	Artifacts []plannedArtifact `yaml:"artifacts"`
+}
+
+type plannedArtifact struct {
+	Platform string `yaml:"platform"`
+	Variant  string `yaml:"variant"`
+	Kind     string `yaml:"kind"`
+	Path     string `yaml:"path"`
+	MinOS    string `yaml:"min_os"`
+	// Archive publishes the file at Path, a .tar.gz, .tgz or .zip, as an
+	// archive of an application bundle with Executable inside it.
+	// A directory at Path is published as a bundle: archived to a tar.gz
+	// and recorded with the name of the directory as its root.
+	Archive    bool   `yaml:"archive"`
+	Executable string `yaml:"executable"`
+
+	bundle bool
+}
+
+// name describes the artifact in messages, e.g. linux/amd64 v3.
+func (a plannedArtifact) name() string {
+	return strings.Join(strings.Fields(a.Platform+" "+a.Variant+" "+a.Kind), " ")
+}
+
+// filename is the name the artifact is downloaded as: that of its file, or
+// of its bundled directory with the extension of the archive it is packed in.
+func (a plannedArtifact) filename() string {
+	name := filepath.Base(filepath.Clean(a.Path))
+	if a.bundle {
+		name += "." + manifest.ArchiveTarGz
+	}
+	return name
+}
+
+func runPublish(ctx context.Context, args []string) error {
+	fs := newFlagSet("publish")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	channel := fs.String("channel", "", "channel to publish to, e.g. stable (default $CHANNEL)")
+	version := fs.String("version", "", "version to publish, e.g. 1.4.2 (default $VERSION)")
+	platform := fs.String("platform", "", "platform of the executable, e.g. linux/amd64 (default $PLATFORM)")
+	variant := fs.String("variant", "", "publish the executable as a variant of the platform for more capable CPUs, e.g. v3")
+	kind := fs.String("kind", "", "publish the file as another kind of artifact of the platform than its executable, e.g. installer or portable")
+	executablePath := fs.String("path", "", "path of the executable, or of a directory to publish as a bundle, e.g. MyApp.app (default $EXECUTABLE_PATH)")
+	archive := fs.Bool("archive", false, "publish the file, a .tar.gz, .tgz or .zip, as an archive of an application bundle and record its files")
+	archiveExecutable := fs.String("archive-executable", "", "slash separated path of the executable inside the archive")
+	config := fs.String("config", "", "release file listing the executables of every platform")
+	generatePatch := fs.Bool("patch", false, "generate a delta patch from the previous artifact of the platform, fetched from the destination")
+	patchVersions := fs.Int("patch-versions", 1, "number of the last published versions of the platform to generate patches from with --patch, so clients more than one version behind get a patch too")
+	patchMaxSize := fs.Int("patch-max-size", 80, "largest size of a patch in percent of the size of the executable; larger ones are not published and clients download the full artifact instead, 0 for no limit")
+	patchConcurrency := fs.Int("patch-concurrency", 1, "number of patches generated at a time, each holding two executables in memory")
+	patchCache := fs.String("patch-cache", "", "directory to cache the previous artifacts fetched to generate patches in (default $PATCH_CACHE, or in the user cache directory)")
+	patchExecutable := fs.Bool("patch-executable", false, "make the patches of ELF and PE executables with their calls and jumps rewritten to the layout of the previous version, so shifted code does not bloat them; older clients download the full artifact instead")
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	notifyFlags := addNotifyFlags(fs)
+	progress := addProgressFlag(fs)
+	resume := fs.Bool("resume", false, "resume the interrupted S3 uploads of the executables, kept in $UPLOAD_JOURNAL")
+	skipExisting := fs.Bool("skip-existing", false, "hash the executables first and skip uploading those already stored")
+	signArtifacts := fs.Bool("sign-artifacts", false, "also upload a detached signature of every artifact by the signing keys")
+	sidecar := fs.Bool("sidecar", false, "also upload a checksum file next to every artifact, e.g. <key>.sha256, for verifying manual downloads")
+	compress := fs.Bool("compress", false, "also upload a zstd compressed copy of every executable for clients to download instead")
+	chunks := fs.Bool("chunks", false, "also upload every executable split into content-defined chunks, so clients download only the chunks they do not hold")
+	verifyUpload := fs.Bool("verify-upload", false, "download the uploaded artifacts and patches again and check their checksums")
+	keepReleases := addRetentionFlag(fs)
+	hashAlgo := fs.String("hash", "", "checksum algorithm of the artifacts: blake2b, sha256, sha512 or blake3 (default $CHECKSUM_ALGO, or "+manifest.DefaultHashAlgorithm+")")
+	keyTemplate := fs.String("key-template", "", "layout of artifact keys, e.g. {app}/{channel}/{version}/{platform}/{checksum} (default $KEY_TEMPLATE, or "+string(manifest.DefaultKeyTemplate)+")")
+	allowDowngrade := fs.Bool("allow-downgrade", false, "allow publishing a version lower than the current one of the channel")
+	rollout := fs.Int("rollout", -1, "offer a new version to this percentage of devices only")
+	minOS := fs.String("min-os", "", "oldest operating system version the executables run on, e.g. 12.0 (default the min_os of each artifact of the release file)")
+	minVersion := fs.String("min-version", "", "oldest client version the channel still supports; older clients must update")
+	mandatory := fs.Bool("mandatory", false, "mark the version as an update clients cannot skip")
+	notesFile := fs.String("notes-file", "", "file holding the release notes of the version, - for stdin")
+	notesObject := fs.Bool("notes-object", false, "store the release notes as a separate object referenced by the manifest instead of inline")
+	finalize := fs.Bool("finalize", false, "publish the manifest staged for want of signatures once sign added enough of them, instead of a release")
+	presignedToken := fs.String("presigned", "", "upload token written by issue-token, whose presigned URLs the release is published through instead of bucket credentials (default $UPLOAD_TOKEN)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if *finalize {
+		return runFinalize(ctx, backendFlags, *appID, signingFlags, *dryRunMode, outputFlags)
+	}
+
+	if *presignedToken == "" {
+		*presignedToken = getenv("UPLOAD_TOKEN")
+	}
+	var token *manifest.UploadToken
+	if *presignedToken != "" {
+		if token, err = loadUploadToken(*presignedToken); err != nil {
+			return err
+		}
+		if *appID == "" {
+			*appID = token.AppID
+		}
+		if *appID != token.AppID {
+			return fmt.Errorf("upload token is for %s, not %s", token.AppID, *appID)
+		}
+		if *generatePatch || *resume {
+			return errors.New("patches and resumed uploads cannot be published with an upload token")
+		}
+	}
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	var plan *releasePlan
+	if *config != "" {
+		var err error
+		if plan, err = loadReleasePlan(*config); err != nil {
+			return err
+		}
+	} else {
+		plan = &releasePlan{Artifacts: []plannedArtifact{{
+			Platform: in.require(*platform, "platform", "PLATFORM"),
+			Variant:  *variant,
+			Kind:     *kind,
+			Path:     in.require(*executablePath, "path", "EXECUTABLE_PATH"),
+
+			Archive:    *archive,
+			Executable: *archiveExecutable,
+		}}}
+	}
+	// flags override the release file, which overrides the environment
+	if *channel != "" || plan.Channel == "" {
+		plan.Channel = in.require(*channel, "channel", "CHANNEL")
+	}
+	if *version != "" || plan.Version == "" {
+		plan.Version = in.require(*version, "version", "VERSION")
+	}
+
+	for i, artifact := range plan.Artifacts {
+		// a missing platform is reported with the other missing inputs
+		if artifact.Platform == "" {
+			continue
+		}
+		if plan.Artifacts[i].Platform, err = manifest.NormalizePlatform(artifact.Platform); err != nil {
+			return err
+		}
+		artifact = plan.Artifacts[i]
+		if artifact.Variant != "" && !manifest.ValidVariant(artifact.Variant) {
+			return fmt.Errorf("variant %q of %s is not lower case letters, digits and dots", artifact.Variant, artifact.Platform)
+		}
+		if artifact.Kind != "" && !manifest.ValidVariant(artifact.Kind) {
+			return fmt.Errorf("kind %q of %s is not lower case letters, digits and dots", artifact.Kind, artifact.Platform)
+		}
+		if artifact.Kind != "" && artifact.Variant != "" {
+			return fmt.Errorf("%s: artifact kinds have no variants", artifact.Platform)
+		}
+		if artifact.MinOS == "" {
+			plan.Artifacts[i].MinOS = *minOS
+		}
+		if minOS := plan.Artifacts[i].MinOS; minOS != "" && !manifest.ValidOSVersion(minOS) {
+			return fmt.Errorf("operating system version %q of %s is not dot separated numbers", minOS, artifact.Platform)
+		}
+		if info, err := os.Stat(artifact.Path); err == nil && info.IsDir() {
+			plan.Artifacts[i].Archive, plan.Artifacts[i].bundle = true, true
+		} else if artifact.Archive && manifest.ArchiveFormat(artifact.Path) == "" {
+			return fmt.Errorf("%s is not a .tar.gz, .tgz or .zip archive", artifact.Path)
+		}
+		if !plan.Artifacts[i].Archive && artifact.Executable != "" {
+			return fmt.Errorf("%s: the executable inside an archive is given for a file not published as an archive", artifact.name())
+		}
+	}
+
+	if *hashAlgo == "" {
+		*hashAlgo = getenv("CHECKSUM_ALGO")
+	}
+	if *hashAlgo == "" {
+		*hashAlgo = manifest.DefaultHashAlgorithm
+	}
+	if _, err := manifest.NewHash(*hashAlgo); err != nil {
+		return err
+	}
+
+	if *resume {
+		if backendFlags.journal, err = openJournal(); err != nil {
+			return err
+		}
+	}
+
+	var backend storage.Backend
+	if token != nil {
+		if len(plan.Artifacts) > len(token.Artifacts) {
+			return fmt.Errorf("upload token was issued for %d artifacts, not %d", len(token.Artifacts), len(plan.Artifacts))
+		}
+		backend, err = backendFlags.openPresigned(token)
+	} else {
+		backend, err = backendFlags.open(in)
+	}
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("publish", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	// open every executable and read every archive before uploading anything
+	executables := make([]*os.File, len(plan.Artifacts))
+	archives := make([]*manifest.Archive, len(plan.Artifacts))
+	for i, artifact := range plan.Artifacts {
+		open := os.Open
+		if artifact.bundle {
+			open = archiveBundle
+		}
+		executable, err := open(artifact.Path)
+		if err != nil {
+			return fmt.Errorf("failed to open executable: %w", err)
+		}
+		defer executable.Close()
+		if artifact.bundle {
+			defer os.Remove(executable.Name())
+		}
+		executables[i] = executable
+
+		if artifact.Archive {
+			if archives[i], err = readArchive(executable, artifact.Executable, *hashAlgo); err != nil {
+				return fmt.Errorf("%s: %w", artifact.name(), err)
+			}
+		}
+		if artifact.bundle {
+			archives[i].Root = filepath.Base(filepath.Clean(artifact.Path))
+		}
+	}
+
+	keep, err := resolveRetention(*keepReleases)
+	if err != nil {
+		return err
+	}
+
+	var notes []byte
+	switch *notesFile {
+	case "":
+	case "-":
+		if notes, err = io.ReadAll(os.Stdin); err != nil {
+			return fmt.Errorf("failed to read release notes: %w", err)
+		}
+	default:
+		if notes, err = os.ReadFile(*notesFile); err != nil {
+			return fmt.Errorf("failed to read release notes: %w", err)
+		}
+	}
+
+	keys := manifest.DefaultKeyTemplate
+	if *keyTemplate == "" {
+		*keyTemplate = getenv("KEY_TEMPLATE")
+	}
+	if *keyTemplate != "" {
+		if keys, err = manifest.ParseKeyTemplate(*keyTemplate); err != nil {
+			return err
+		}
+	}
+
+	if *patchVersions < 1 {
+		return fmt.Errorf("number of versions to patch from %d is less than 1", *patchVersions)
+	}
+	if *patchConcurrency < 1 {
+		return fmt.Errorf("patch concurrency %d is less than 1", *patchConcurrency)
+	}
+	if *patchMaxSize < 0 {
+		return fmt.Errorf("largest patch size %d%% is negative", *patchMaxSize)
+	}
+
+	var rolloutPercent *int
+	if *rollout >= 0 {
+		if *rollout > 100 {
+			return fmt.Errorf("rollout percentage %d is not between 0 and 100", *rollout)
+		}
+		rolloutPercent = rollout
+	}
+
+	notifiers, err := loadNotifiers(notifyFlags)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := loadPublisher(ctx, backend, *appID, signingFlags)
+	if err != nil {
+		return err
+	}
+	publisher.KeepReleases(keep)
+	publisher.UseKeyTemplate(keys)
+	if token != nil {
+		publisher.UseUploadToken(token)
+	}
+	if *generatePatch {
+		publisher.CacheArtifactsIn(artifactCache(*patchCache))
+	}
+	if err := publisher.UseHashAlgorithm(*hashAlgo); err != nil {
+		return err
+	}
+	if !publisher.Exists() {
+		slog.Warn("no manifest found, publishing creates it; run init to create it beforehand", "key", manifest.ManifestKey(*appID))
+	}
+
+	requests := make([]manifest.ReleaseRequest, len(plan.Artifacts))
+	for i, artifact := range plan.Artifacts {
+		executableStat, err := executables[i].Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat executable: %w", err)
+		}
+
+		requests[i] = manifest.ReleaseRequest{
+			Channel:         plan.Channel,
+			Version:         plan.Version,
+			Platform:        artifact.Platform,
+			Variant:         artifact.Variant,
+			Kind:            artifact.Kind,
+			MinOS:           artifact.MinOS,
+			Archive:         archives[i],
+			Build:           executableStat.ModTime(),
+			Executable:      executables[i],
+			Size:            executableStat.Size(),
+			Filename:        artifact.filename(),
+			Patch:           *generatePatch,
+			PatchVersions:   *patchVersions,
+			PatchMaxSize:    *patchMaxSize,
+			PatchExecutable: *patchExecutable,
+			Sign:            *signArtifacts,
+			Sidecar:         *sidecar,
+			Compress:        *compress,
+			Chunks:          *chunks,
+			Rollout:         rolloutPercent,
+			Mandatory:       *mandatory,
+
+			AllowDowngrade: *allowDowngrade,
+			UploadID:       uploadID(*resume, *appID, artifact.Platform, artifact.Path, executableStat),
+			SkipExisting:   *skipExisting,
+			Notes:          string(notes),
+			NotesObject:    *notesObject,
+			// a dry run uploads nothing that could be downloaded again
+			VerifyUpload: *verifyUpload && !*dryRunMode,
+		}
+	}
+
+	if *generatePatch {
+		if err := publisher.PreparePatches(ctx, requests, *patchConcurrency, func(job manifest.PatchJob) {
+			name := plannedArtifact{Platform: job.Platform, Variant: job.Variant, Kind: job.Kind}.name()
+			if job.Patch.Key == "" {
+				slog.Info("patch too large, skipped", "platform", name, "from", job.From, "duration", job.Duration.Round(time.Millisecond))
+				return
+			}
+			slog.Info("generated patch", "platform", name, "from", job.From, "size", job.Patch.Size, "duration", job.Duration.Round(time.Millisecond))
+		}); err != nil {
+			return err
+		}
+	}
+
+	// the manifest is only written once every artifact is uploaded, so a
+	// failed run can be repeated as is
+	for i, artifact := range plan.Artifacts {
+		name := artifact.name()
+		req := requests[i]
+		executable, stopProgress, err := newProgressReader(*progress, name, req.Executable, req.Size)
+		if err != nil {
+			return err
+		}
+
+		req.Executable = executable
+		uploaded, err := publisher.AddRelease(ctx, req)
+		stopProgress()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		rep.artifact(artifact.Platform, artifact.Variant, artifact.Kind, uploaded)
+		if *dryRunMode {
+			slog.Info("computed artifact checksum", "platform", name, "checksum", uploaded.Checksum)
+			continue
+		}
+		if !rep.uploaded(uploaded.Binary) {
+			slog.Info("artifact already exists, skipped upload", "platform", name, "key", uploaded.Binary)
+			continue
+		}
+		slog.Info("uploaded artifact", "platform", name, "key", uploaded.Binary)
+	}
+
+	if *minVersion != "" {
+		if err := publisher.SetMinVersion(plan.Channel, *minVersion); err != nil {
+			return err
+		}
+	}
+
+	if err := publisher.Save(ctx); err != nil {
+		return err
+	}
+	rep.result.Channel, rep.result.Version = plan.Channel, plan.Version
+	if !*dryRunMode && publisher.Staged() == nil {
+		release := rep.release(outputFlags, *hashAlgo)
+		release.Notes = string(notes)
+		if *notesObject && len(notes) > 0 {
+			release.NotesURL = outputFlags.objectURL(manifest.NotesKey(*appID, plan.Version))
+		}
+		notifyRelease(ctx, notifiers, release)
+	}
+	return rep.finish(publisher, "uploaded manifest", "channel", plan.Channel, "version", plan.Version)
+}
+
+// loadReleasePlan reads a release file. Relative executable paths are
+// resolved against its directory. The channel and version may be left out and
+// given by flags or the environment instead.
+func loadReleasePlan(path string) (*releasePlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read release file: %w", err)
+	}
+
+	var plan releasePlan
+	if err := yaml.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to decode release file: %w", err)
+	}
+
+	if len(plan.Artifacts) == 0 {
+		return nil, errors.New("release file lists no artifacts")
+	}
+
+	seen := make(map[string]bool)
+	for i, artifact := range plan.Artifacts {
+		if artifact.Platform == "" || artifact.Path == "" {
+			return nil, fmt.Errorf("artifact %d of the release file needs a platform and a path", i+1)
+		}
+		platform, err := manifest.NormalizePlatform(artifact.Platform)
+		if err != nil {
+			return nil, fmt.Errorf("artifact %d of the release file: %w", i+1, err)
+		}
+		plan.Artifacts[i].Platform = platform
+		name := plan.Artifacts[i].name()
+		if seen[name] {
+			return nil, fmt.Errorf("platform %s is listed twice in the release file", name)
+		}
+		seen[name] = true
+
+		if !filepath.IsAbs(artifact.Path) {
+			plan.Artifacts[i].Path = filepath.Join(filepath.Dir(path), artifact.Path)
+		}
+	}
+
+	return &plan, nil
+}
+
+// archiveBundle archives the directory dir to a temporary tar.gz file, which
+// the caller removes, and returns it opened for reading. The file carries the
+// newest modification time of the bundle, so it identifies an unchanged
+// bundle across runs just like the executable it replaces.
+func archiveBundle(dir string) (*os.File, error) {
+	file, err := os.CreateTemp("", ".bundle-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+
+	modified, err := manifest.WriteBundle(file, dir)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(file.Name(), modified, modified)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return os.Open(file.Name())
+}
+
+// readArchive lists the files of the archive file and hashes them with algo.
+// The file is read again from the start when it is uploaded.
+func readArchive(file *os.File, executable, algo string) (*manifest.Archive, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat archive: %w", err)
+	}
+	if executable != "" && !manifest.ValidArchivePath(executable) {
+		return nil, fmt.Errorf("executable %q is not a relative slash separated path inside the archive", executable)
+	}
+	return manifest.ReadArchive(file, stat.Size(), manifest.ArchiveFormat(file.Name()), executable, algo)
+}
+
+// uploadID returns a staging ID that stays the same while the executable is
+// unchanged, so an interrupted upload can be resumed, or "" for a random one.
+func uploadID(resume bool, appID, platform, path string, stat os.FileInfo) string {
+	if !resume {
+		return ""
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sum := blake2b.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%d\x00%d", appID, platform, path, stat.Size(), stat.ModTime().UnixNano())))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"errors"
+
+	"update-manifest/pkg/manifest"
+)
+
+var refreshTUFCommand = &command{
+	name:    "refresh-tuf",
+	summary: "Sign new TUF metadata for the manifest before its timestamp expires",
+	run:     runRefreshTUF,
+}
+
+func runRefreshTUF(ctx context.Context, args []string) error {
+	fs := newFlagSet("refresh-tuf")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	timestampExpiry := fs.Duration("timestamp-expiry", manifest.DefaultTUFExpiry.Timestamp, "how long the new TUF timestamp is valid; refresh more often than that")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("refresh-tuf", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, *appID, signingFlags)
+	if err != nil {
+		return err
+	}
+	if *timestampExpiry <= 0 {
+		return errors.New("timestamp expiry must be positive")
+	}
+	expiry := manifest.DefaultTUFExpiry
+	expiry.Timestamp = *timestampExpiry
+	publisher.MaintainTUF(expiry)
+
+	if err := publisher.RefreshTUF(ctx); err != nil {
+		return err
+	}
+	return rep.finish(publisher, "refreshed TUF metadata", "key", manifest.TUFKey(*appID, "timestamp.json"))
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"update-manifest/pkg/manifest"
+)
+
+var refreshURLsCommand = &command{
+	name:    "refresh-urls",
+	summary: "Write the client manifest again with new presigned URLs before they expire",
+	run:     runRefreshURLs,
+}
+
+func runRefreshURLs(ctx context.Context, args []string) error {
+	fs := newFlagSet("refresh-urls")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	urlExpiry := fs.Duration("url-expiry", 0, "how long the new presigned URLs are valid; refresh more often than that (default $PRESIGN_URLS, or 24h)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("refresh-urls", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, *appID, nil)
+	if err != nil {
+		return err
+	}
+	if *urlExpiry < 0 {
+		return errors.New("URL expiry must not be negative")
+	}
+	if *urlExpiry > 0 {
+		publisher.PresignURLs(*urlExpiry)
+	}
+
+	if err := publisher.RefreshURLs(ctx); err != nil {
+		return err
+	}
+	return rep.finish(publisher, "refreshed presigned URLs", "key", manifest.ClientManifestKey(*appID))
+}
+
+// loadURLExpiry returns how long the presigned URLs of the client manifest
+// are valid, read from $PRESIGN_URLS, or 0 if no client manifest is written.
+func loadURLExpiry() (time.Duration, error) {
+	value := getenv("PRESIGN_URLS")
+	if value == "" {
+		return 0, nil
+	}
+	expiry, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("PRESIGN_URLS is not a duration: %w", err)
+	}
+	if expiry <= 0 {
+		return 0, errors.New("PRESIGN_URLS must be positive")
+	}
+	return expiry, nil
+}
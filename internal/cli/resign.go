@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"context"
+
+	"update-manifest/pkg/manifest"
+)
+
+var resignCommand = &command{
+	name:    "resign",
+	summary: "Sign the manifest again, unchanged, before its signature expires",
+	run:     runResign,
+}
+
+func runResign(ctx context.Context, args []string) error {
+	fs := newFlagSet("resign")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("resign", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, *appID, signingFlags)
+	if err != nil {
+		return err
+	}
+	if err := publisher.Resign(ctx); err != nil {
+		return err
+	}
+	return rep.finish(publisher, "re-signed manifest", "key", manifest.SignatureKey(*appID))
+}
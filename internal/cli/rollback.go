@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"context"
+	"errors"
+)
+
+var rollbackCommand = &command{
+	name:    "rollback",
+	summary: "Restore a previously published version of a channel",
+	run:     runRollback,
+}
+
+func runRollback(ctx context.Context, args []string) error {
+	fs := newFlagSet("rollback")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	channel := fs.String("channel", "", "channel to roll back, e.g. stable")
+	to := fs.String("to", "", "version to restore, e.g. 1.4.2")
+	restore := fs.String("restore", "", "replace the whole manifest with a backup, e.g. myapp/history/manifest-20240102T150405.000Z.json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	if *restore == "" {
+		in.flag(*channel, "channel")
+		in.flag(*to, "to")
+	} else if *channel != "" || *to != "" {
+		return errors.New("--restore replaces every channel and cannot be combined with --channel or --to")
+	}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("rollback", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, *appID, signingFlags)
+	if err != nil {
+		return err
+	}
+
+	if *restore != "" {
+		if err := publisher.Restore(ctx, *restore); err != nil {
+			return err
+		}
+		if err := publisher.Save(ctx); err != nil {
+			return err
+		}
+		return rep.finish(publisher, "restored manifest", "from", *restore)
+	}
+
+	if err := publisher.Rollback(*channel, *to); err != nil {
+		return err
+	}
+
+	if err := publisher.Save(ctx); err != nil {
+		return err
+	}
+	rep.result.Channel, rep.result.Version = *channel, *to
+	return rep.finish(publisher, "rolled back channel", "channel", *channel, "version", *to)
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var rolloutCommand = &command{
+	name:    "rollout",
+	summary: "Set, pause or resume the staged rollout of a channel",
+	run:     runRollout,
+}
+
+func runRollout(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: update-manifest rollout set|pause|resume [flags]")
+	}
+	action, args := args[0], args[1:]
+
+	fs := newFlagSet("rollout " + action)
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	channel := fs.String("channel", "", "channel whose current release is rolled out, e.g. stable")
+	var percentage *int
+	if action == "set" {
+		percentage = fs.Int("percentage", -1, "percentage of devices offered the release")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	in.flag(*channel, "channel")
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("rollout "+action, *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, *appID, signingFlags)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "set":
+		if *percentage < 0 {
+			return errors.New("--percentage is required")
+		}
+		err = publisher.SetRollout(*channel, *percentage)
+	case "pause":
+		err = publisher.PauseRollout(*channel)
+	case "resume":
+		err = publisher.ResumeRollout(*channel)
+	default:
+		return fmt.Errorf("unknown rollout action %q", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := publisher.Save(ctx); err != nil {
+		return err
+	}
+
+	release := publisher.Manifest().Channel[*channel].Release
+	state := "full"
+	if release.Rollout != nil {
+		state = fmt.Sprintf("%d%%", *release.Rollout)
+	}
+	if release.RolloutPaused {
+		state += ", paused"
+	}
+	rep.result.Channel, rep.result.Version = *channel, release.Version
+	return rep.finish(publisher, "updated rollout", "channel", *channel, "version", release.Version, "state", state)
+}
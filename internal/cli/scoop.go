@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"update-manifest/pkg/manifest"
+)
+
+var scoopCommand = &command{
+	name:    "scoop",
+	summary: "Render the Scoop manifest of a published release",
+	run:     runScoop,
+}
+
+func runScoop(ctx context.Context, args []string) error {
+	fs := newFlagSet("scoop")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	channel := fs.String("channel", "", "channel of the release, e.g. stable")
+	version := fs.String("version", "", "recorded version to render (default the current release)")
+	description := fs.String("description", "", "short description of the package (default $SCOOP_DESCRIPTION)")
+	homepage := fs.String("homepage", "", "homepage of the package (default $SCOOP_HOMEPAGE)")
+	license := fs.String("license", "", "SPDX license of the package, e.g. MIT (default $SCOOP_LICENSE)")
+	bin := fs.String("bin", "", "name the executable is installed as (default $SCOOP_BIN, or <app-id>.exe)")
+	kind := fs.String("kind", "", "artifact kind to install, e.g. zip, instead of the artifact itself, leaving out platforms without one (default $SCOOP_KIND)")
+	downloadURL := fs.String("download-url", "", "public URL of the bucket Scoop downloads artifacts and checks for updates from (default $DOWNLOAD_URL, or $PUBLIC_URL)")
+	output := fs.String("o", "", "file to write the manifest to, e.g. bucket/<app>.json of a Scoop bucket (default print it)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	in.flag(*channel, "channel")
+	*appID = in.require(*appID, "app-id", "APP_ID")
+	pkg := manifest.ScoopPackage{
+		Description: *description,
+		Homepage:    *homepage,
+		License:     *license,
+		Kind:        *kind,
+		Bin:         *bin,
+	}
+	if pkg.Description == "" {
+		pkg.Description = getenv("SCOOP_DESCRIPTION")
+	}
+	if pkg.Homepage == "" {
+		pkg.Homepage = getenv("SCOOP_HOMEPAGE")
+	}
+	if pkg.License == "" {
+		pkg.License = getenv("SCOOP_LICENSE")
+	}
+	if pkg.Kind == "" {
+		pkg.Kind = getenv("SCOOP_KIND")
+	}
+	if pkg.Bin == "" {
+		pkg.Bin = getenv("SCOOP_BIN")
+	}
+	if pkg.Bin == "" {
+		pkg.Bin = *appID + ".exe"
+	}
+	if pkg.BaseURL, err = packageDownloadURL(*downloadURL); err != nil {
+		return err
+	}
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := loadPublisher(ctx, backend, *appID, nil)
+	if err != nil {
+		return err
+	}
+
+	release, err := packagedRelease(publisher.Manifest(), *channel, *version)
+	if err != nil {
+		return err
+	}
+
+	data, err := publisher.Scoop(ctx, *channel, release, pkg)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return err
+	}
+	slog.Info("wrote Scoop manifest", "version", release.Version, "path", *output)
+	return nil
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// secretSettings are the settings holding secrets. Each is read from the file
+// named by the setting suffixed with _FILE when that is set instead, e.g.
+// $ACCESS_SECRET_FILE naming a Docker secret, and their values are redacted
+// from the log.
+var secretSettings = []string{
+	"ACCESS_KEY",
+	"ACCESS_SECRET",
+	"AZURE_STORAGE_SAS_TOKEN",
+	"CLOUDFLARE_API_TOKEN",
+	"DISCORD_WEBHOOK_URLS",
+	"GOOGLE_OAUTH_ACCESS_TOKEN",
+	"GPG_PASSPHRASE",
+	"MINISIGN_PASSWORD",
+	"SIGNING_KEY",
+	"SIGNING_KEY_PASSPHRASE",
+	"SLACK_WEBHOOK_URLS",
+	"SMTP_PASSWORD",
+	"SSH_KEY",
+	"SSH_KEY_PASSPHRASE",
+	"SSE_CUSTOMER_KEY",
+	"TELEMETRY_TOKEN",
+	"VAULT_TOKEN",
+	"WEBHOOK_SECRET",
+	"WEBHOOK_URLS",
+	"WEB_IDENTITY_TOKEN",
+}
+
+// rereadSecretFiles are the secret settings whose _FILE setting is read each
+// time the secret is used rather than by loadSecretFiles, as the file may be
+// replaced meanwhile, e.g. a Kubernetes service account token.
+var rereadSecretFiles = []string{"WEB_IDENTITY_TOKEN"}
+
+// minRedactedLength is the length of the shortest secret redacted from the
+// log. Shorter values would garble it more than they could leak.
+const minRedactedLength = 6
+
+// secrets holds the settings read from secret files and the fields of the
+// Vault secrets read with --vault-path or $VAULT_PATH, keyed like config.
+var secrets = map[string]string{}
+
+// loadSecretFiles reads the secret settings whose _FILE setting is set into
+// secrets, without the line break ending the file.
+func loadSecretFiles() error {
+	for _, name := range secretSettings {
+		path, exists := lookupEnv(name + "_FILE")
+		if !exists || slices.Contains(rereadSecretFiles, name) {
+			continue
+		}
+		if _, exists := lookupEnv(name); exists {
+			return fmt.Errorf("both %s and %s_FILE are set", name, name)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s_FILE: %w", name, err)
+		}
+		secrets[strings.ToLower(name)] = strings.TrimRight(string(data), "\r\n")
+		slog.Debug("read secret file", "setting", name, "path", path)
+	}
+	return nil
+}
+
+// redactAttr replaces the secrets in the value of a, e.g. in the error of a
+// request echoing its credentials, before the log handler writes it.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	value := a.Value.String()
+	if redacted := redact(value); redacted != value {
+		a.Value = slog.StringValue(redacted)
+	}
+	return a
+}
+
+// redact replaces the values of the secret settings in s.
+func redact(s string) string {
+	for _, name := range secretSettings {
+		if secret := getenv(name); len(secret) >= minRedactedLength {
+			s = strings.ReplaceAll(s, secret, "[REDACTED]")
+		}
+	}
+	return s
+}
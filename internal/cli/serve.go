@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"update-manifest/internal/server"
+	"update-manifest/pkg/telemetry"
+)
+
+var serveCommand = &command{
+	name:    "serve",
+	summary: "Serve manifests and artifacts over HTTP",
+	run:     runServe,
+}
+
+func runServe(ctx context.Context, args []string) error {
+	fs := newFlagSet("serve")
+	backendFlags := addBackendFlags(fs)
+	listen := fs.String("listen", "", "address to listen on (default $LISTEN, or :8080)")
+	acceptTelemetry := fs.Bool("telemetry", false, "accept reports of the outcome of updates at POST /telemetry, written to <app-id>/telemetry in the backend as JSON lines (default $TELEMETRY)")
+	telemetryToken := fs.String("telemetry-token", "", "bearer token the reports must carry, which clients send as client.Client.TelemetryToken; required with --telemetry, since watchdog acts on the failures reported (default $TELEMETRY_TOKEN)")
+	telemetryInterval := fs.Duration("telemetry-interval", time.Minute, "how often the telemetry reported is written to the backend, each time as a new object")
+	metrics := fs.Bool("metrics", false, "expose request counts, manifest fetches per channel, artifact bytes sent and request durations at /metrics for Prometheus (default $METRICS)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	addr := *listen
+	if addr == "" {
+		addr = getenv("LISTEN")
+	}
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	backend, err := backendFlags.open(&inputs{})
+	if err != nil {
+		return err
+	}
+
+	if value, exists := lookupEnv("METRICS"); exists && !*metrics {
+		if *metrics, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("METRICS is not a boolean: %w", err)
+		}
+	}
+	if value, exists := lookupEnv("TELEMETRY"); exists && !*acceptTelemetry {
+		if *acceptTelemetry, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("TELEMETRY is not a boolean: %w", err)
+		}
+	}
+	if *telemetryToken == "" {
+		*telemetryToken = getenv("TELEMETRY_TOKEN")
+	}
+	if *acceptTelemetry && *telemetryToken == "" {
+		// anyone reaching the server could report failures under made up
+		// device IDs and make watchdog roll the release back otherwise
+		return errors.New("--telemetry requires a token: pass --telemetry-token or set TELEMETRY_TOKEN")
+	}
+	if *telemetryInterval <= 0 {
+		return errors.New("telemetry interval must be positive")
+	}
+	handler := server.New(backend)
+	if *metrics {
+		handler.ExposeMetrics()
+	}
+	var recorder *telemetry.Recorder
+	if *acceptTelemetry {
+		recorder = telemetry.NewRecorder(backend)
+		handler.AcceptTelemetry(recorder, *telemetryToken)
+		go recorder.Run(ctx, *telemetryInterval)
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+		// the reports received since the last flush would be lost otherwise
+		if recorder != nil {
+			if err := recorder.Flush(shutdownCtx); err != nil {
+				slog.Error("failed to write telemetry", "err", err)
+			}
+		}
+	}()
+
+	slog.Info("listening", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-stopped
+	return nil
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"context"
+	"log/slog"
+
+	"update-manifest/pkg/manifest"
+)
+
+var signCommand = &command{
+	name:    "sign",
+	summary: "Add signatures to a manifest staged for want of them, for publish --finalize",
+	run:     runSign,
+}
+
+func runSign(ctx context.Context, args []string) error {
+	fs := newFlagSet("sign")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSignerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+	signers, err := loadSigners(ctx, signingFlags)
+	if err != nil {
+		return err
+	}
+
+	publisher := manifest.NewPublisher(backend, *appID)
+	publisher.SignWith(signers...)
+	publisher.UseCachePolicy(loadCachePolicy())
+	signed, required, err := publisher.SignPending(ctx)
+	if err != nil {
+		return err
+	}
+
+	msg := "signed staged manifest"
+	if signed >= required {
+		msg += ", publish it with publish --finalize"
+	}
+	slog.Info(msg, "key", manifest.PendingKey(*appID), "signatures", signed, "required", required)
+	return nil
+}
+
+// runFinalize publishes the staged manifest of appID once it is signed by
+// enough keys, for publish --finalize.
+func runFinalize(ctx context.Context, backendFlags *backendFlags, appID string, signingFlags *signingFlags, dryRunMode bool, outputFlags *outputFlags) error {
+	in := &inputs{}
+	appID = in.require(appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("publish", appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, appID, signingFlags)
+	if err != nil {
+		return err
+	}
+	if err := publisher.Finalize(ctx); err != nil {
+		return err
+	}
+	return rep.finish(publisher, "published staged manifest", "key", rep.result.ManifestKey)
+}
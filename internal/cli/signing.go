@@ -0,0 +1,286 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"update-manifest/pkg/signing"
+)
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// signingFlags select the keys the manifest is signed with, and the feeds
+// published with it.
+type signingFlags struct {
+	keys        *stringList
+	transitKey  *string
+	kmsKey      *string
+	minisignKey *string
+	gpgKey      *string
+	cosign      *bool
+	sparkleKey  *string
+	timestamp   *string
+	rekor       *string
+	tuf         *bool
+	threshold   *int
+	cosigners   *stringList
+	expiry      *time.Duration
+	feeds       *feedFlags
+}
+
+// addSigningFlags registers the flags selecting the manifest signing keys.
+func addSigningFlags(fs *flag.FlagSet) *signingFlags {
+	flags := addSignerFlags(fs)
+	flags.minisignKey = fs.String("minisign-key", "", "minisign secret key file used to also sign the manifest in the minisign format, decrypted with $MINISIGN_PASSWORD (default $MINISIGN_KEY)")
+	flags.gpgKey = fs.String("gpg-key", "", "key of the gpg keyring, e.g. its fingerprint, used to also sign the manifest with an ASCII-armored OpenPGP signature, unlocked with $GPG_PASSPHRASE if set (default $GPG_KEY)")
+	flags.cosign = fs.Bool("cosign", false, "also sign the manifest keyless with cosign, as the OIDC identity of the environment such as a GitHub Actions workflow with id-token: write (default $COSIGN_KEYLESS)")
+	flags.sparkleKey = fs.String("sparkle-key", "", "Sparkle EdDSA private key file, as exported by generate_keys -x, used to publish a Sparkle appcast of every channel, whose artifacts --sign-artifacts signs for Sparkle (default $SPARKLE_KEY)")
+	flags.timestamp = fs.String("timestamp-url", "", "RFC 3161 timestamp authority to obtain a trusted timestamp of the manifest signature from, e.g. https://freetsa.org/tsr (default $TIMESTAMP_URL)")
+	flags.rekor = fs.String("rekor-url", "", "Rekor transparency log to record the manifest signature in, e.g. https://rekor.sigstore.dev (default $REKOR_URL)")
+	flags.tuf = fs.Bool("tuf", false, "also maintain TUF root, targets, snapshot and timestamp metadata of the manifest under <app-id>/tuf, signed by the signing key (default $TUF)")
+	flags.threshold = fs.Int("signature-threshold", 0, "number of keys, of the signing keys and --cosigner-key, the manifest needs signatures of; a manifest signed by fewer is staged for sign to add the others and publish --finalize to publish it (default $SIGNATURE_THRESHOLD, or 1)")
+	flags.cosigners = &stringList{}
+	fs.Var(flags.cosigners, "cosigner-key", "file holding the public key of another signer of the manifest, as written by keygen, published in the key list (repeatable, default the comma-separated base64 keys of $COSIGNER_KEYS)")
+	flags.expiry = fs.Duration("signature-expiry", 0, "how long the manifest signature is valid, e.g. 720h; clients reject the manifest once it expires, so re-sign it with resign more often than that (default $SIGNATURE_EXPIRY, or forever)")
+	flags.feeds = addFeedFlags(fs)
+	return flags
+}
+
+// addSignerFlags registers the flags selecting the keys the manifest is
+// signed with, the only ones of commands signing nothing else.
+func addSignerFlags(fs *flag.FlagSet) *signingFlags {
+	return &signingFlags{
+		keys:       signingKeys(fs),
+		transitKey: fs.String("vault-transit-key", "", "Ed25519 key of a Vault transit engine, as <mount>/<name>, e.g. transit/releases, used to sign the manifest without the key leaving Vault (default $VAULT_TRANSIT_KEY)"),
+		kmsKey:     fs.String("kms-key", "", "cloud KMS key used to sign the manifest without the key leaving the KMS: awskms:///<key ID, ARN or alias> of an ECC_NIST_P256 AWS KMS key, or gcpkms://projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>/cryptoKeyVersions/<version> of an EC_SIGN_P256_SHA256 Google Cloud KMS key (default $KMS_KEY)"),
+	}
+}
+
+// signingKeys registers the repeatable --signing-key flag.
+func signingKeys(fs *flag.FlagSet) *stringList {
+	keys := &stringList{}
+	fs.Var(keys, "signing-key", "Ed25519 private key file used to sign the manifest, decrypted with $SIGNING_KEY_PASSPHRASE if encrypted, repeatable: to rotate keys, sign with both the old and the new key until clients have picked up the new key from the published key list, then drop the old one (default $SIGNING_KEY)")
+	return keys
+}
+
+// loadSigners returns the manifest signers configured by flags: the key files
+// or the SIGNING_KEY environment variable, decrypted with
+// $SIGNING_KEY_PASSPHRASE, the Vault transit key or $VAULT_TRANSIT_KEY, and
+// the cloud KMS key or $KMS_KEY. It returns no signers when none is set.
+func loadSigners(ctx context.Context, flags *signingFlags) ([]signing.Signer, error) {
+	var signers []signing.Signer
+
+	var keys [][]byte
+	for _, path := range *flags.keys {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key: %w", err)
+		}
+		keys = append(keys, data)
+	}
+	if len(keys) == 0 {
+		if key, exists := lookupEnv("SIGNING_KEY"); exists {
+			keys = append(keys, []byte(key))
+		}
+	}
+	for _, data := range keys {
+		key, err := signing.ParseEncryptedPrivateKey(data, getenv("SIGNING_KEY_PASSPHRASE"))
+		if err != nil {
+			return nil, err
+		}
+		signer, err := signing.NewEd25519Signer(key)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	transitKey := *flags.transitKey
+	if transitKey == "" {
+		transitKey = getenv("VAULT_TRANSIT_KEY")
+	}
+	if transitKey != "" {
+		signer, err := loadTransitSigner(ctx, transitKey)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	kmsKey := *flags.kmsKey
+	if kmsKey == "" {
+		kmsKey = getenv("KMS_KEY")
+	}
+	if kmsKey != "" {
+		signer, err := loadKMSSigner(ctx, kmsKey)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// loadSignaturePolicy returns the number of keys the manifest needs
+// signatures of configured by flags, or $SIGNATURE_THRESHOLD, 0 if unset, and
+// the public keys of the cosigners, from the key files or $COSIGNER_KEYS.
+func loadSignaturePolicy(flags *signingFlags) (int, []signing.PublicKey, error) {
+	threshold := *flags.threshold
+	if value, exists := lookupEnv("SIGNATURE_THRESHOLD"); exists && threshold == 0 {
+		var err error
+		if threshold, err = strconv.Atoi(value); err != nil {
+			return 0, nil, fmt.Errorf("SIGNATURE_THRESHOLD is not a number: %w", err)
+		}
+	}
+	if threshold < 0 {
+		return 0, nil, fmt.Errorf("signature threshold %d is negative", threshold)
+	}
+
+	var inline []string
+	for _, path := range *flags.cosigners {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read cosigner key: %w", err)
+		}
+		inline = append(inline, string(data))
+	}
+	if len(inline) == 0 {
+		for _, key := range strings.Split(getenv("COSIGNER_KEYS"), ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				inline = append(inline, key)
+			}
+		}
+	}
+
+	cosigners := make([]signing.PublicKey, 0, len(inline))
+	for _, s := range inline {
+		key, err := signing.ParsePublicKey(s)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid cosigner key: %w", err)
+		}
+		cosigners = append(cosigners, key)
+	}
+	return threshold, cosigners, nil
+}
+
+// loadSignatureExpiry returns how long the manifest signature is valid as
+// configured by flags or $SIGNATURE_EXPIRY, 0 for forever.
+func loadSignatureExpiry(flags *signingFlags) (time.Duration, error) {
+	expiry := *flags.expiry
+	if value, exists := lookupEnv("SIGNATURE_EXPIRY"); exists && expiry == 0 {
+		var err error
+		if expiry, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("SIGNATURE_EXPIRY is not a duration: %w", err)
+		}
+	}
+	if expiry < 0 {
+		return 0, fmt.Errorf("signature expiry %s is negative", expiry)
+	}
+	return expiry, nil
+}
+
+// loadFileSigners returns the signers of other signature formats configured
+// by flags: the minisign key file, or the one at the path in the MINISIGN_KEY
+// environment variable, the gpg key or $GPG_KEY, keyless cosign signing if
+// enabled by the flag or the COSIGN_KEYLESS environment variable, and the
+// Sparkle key file or $SPARKLE_KEY. gpg and cosign are run from $GPG and
+// $COSIGN if set.
+func loadFileSigners(flags *signingFlags) ([]signing.FileSigner, error) {
+	var signers []signing.FileSigner
+
+	minisignKey := *flags.minisignKey
+	if minisignKey == "" {
+		minisignKey = getenv("MINISIGN_KEY")
+	}
+	if minisignKey != "" {
+		data, err := os.ReadFile(minisignKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read minisign key: %w", err)
+		}
+		key, err := signing.ParseMinisignKey(data, getenv("MINISIGN_PASSWORD"))
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, key)
+	}
+
+	gpgKey := *flags.gpgKey
+	if gpgKey == "" {
+		gpgKey = getenv("GPG_KEY")
+	}
+	if gpgKey != "" {
+		signers = append(signers, &signing.GPGSigner{Command: getenv("GPG"), Key: gpgKey, Passphrase: getenv("GPG_PASSPHRASE")})
+	}
+
+	keyless := *flags.cosign
+	if value, exists := lookupEnv("COSIGN_KEYLESS"); exists && !keyless {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("COSIGN_KEYLESS is not a boolean: %w", err)
+		}
+		keyless = enabled
+	}
+	if keyless {
+		signers = append(signers, &signing.CosignSigner{Command: getenv("COSIGN")})
+	}
+
+	sparkleKey := *flags.sparkleKey
+	if sparkleKey == "" {
+		sparkleKey = getenv("SPARKLE_KEY")
+	}
+	if sparkleKey != "" {
+		data, err := os.ReadFile(sparkleKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Sparkle key: %w", err)
+		}
+		key, err := signing.ParseSparkleKey(data)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, key)
+	}
+	return signers, nil
+}
+
+// loadPublicKeys parses the given inline keys and key files, falling back to
+// the PUBLIC_KEY environment variable.
+func loadPublicKeys(inline, files []string) ([]signing.PublicKey, error) {
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key: %w", err)
+		}
+		inline = append(inline, string(data))
+	}
+
+	if len(inline) == 0 {
+		if key, exists := lookupEnv("PUBLIC_KEY"); exists {
+			inline = append(inline, key)
+		}
+	}
+
+	keys := make([]signing.PublicKey, 0, len(inline))
+	for _, s := range inline {
+		key, err := signing.ParsePublicKey(s)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,226 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"update-manifest/pkg/semver"
+	"update-manifest/pkg/stats"
+	"update-manifest/pkg/storage"
+)
+
+var statsCommand = &command{
+	name:    "stats",
+	summary: "Print the download counts of the releases, or count them from access logs with stats ingest-logs",
+	run:     runStats,
+}
+
+// statsVersion is a version printed by stats.
+type statsVersion struct {
+	Version   string           `json:"version"`
+	Downloads int64            `json:"downloads"`
+	Platforms map[string]int64 `json:"platforms"`
+}
+
+func runStats(ctx context.Context, args []string) error {
+	if len(args) > 0 && args[0] == "ingest-logs" {
+		return runIngestLogs(ctx, args[1:])
+	}
+
+	fs := newFlagSet("stats")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	output := fs.String("output", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	counts, _, err := stats.Load(ctx, backend, *appID)
+	if err != nil {
+		return err
+	}
+
+	// newest version first, those that are not semantic versions last
+	versions := sortedKeys(counts.Downloads)
+	slices.SortStableFunc(versions, func(a, b string) int {
+		va, errA := semver.Parse(a)
+		vb, errB := semver.Parse(b)
+		switch {
+		case errA != nil && errB != nil:
+			return 0
+		case errA != nil:
+			return 1
+		case errB != nil:
+			return -1
+		}
+		return vb.Compare(va)
+	})
+	listed := []statsVersion{}
+	for _, version := range versions {
+		listed = append(listed, statsVersion{Version: version, Downloads: counts.Total(version), Platforms: counts.Downloads[version]})
+	}
+
+	if *output == "json" {
+		return writeJSON(os.Stdout, listed)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tPLATFORM\tDOWNLOADS")
+	for _, version := range listed {
+		for _, platform := range sortedKeys(version.Platforms) {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", version.Version, platform, version.Platforms[platform])
+		}
+		fmt.Fprintf(w, "%s\tall\t%d\n", version.Version, version.Downloads)
+	}
+	if !counts.Updated.IsZero() {
+		fmt.Fprintf(w, "\nupdated %s from %d logs\n", counts.Updated.Format(time.RFC3339), len(counts.Ingested))
+	}
+	return w.Flush()
+}
+
+func runIngestLogs(ctx context.Context, args []string) error {
+	fs := newFlagSet("stats ingest-logs")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	dryRunMode := fs.Bool("dry-run", false, "count the downloads and log them without storing the stats")
+	files := &stringList{}
+	fs.Var(files, "log", "access log file to count the downloads of, S3 server access log or Cloudflare Logpush, gzip compressed or not, or - for stdin (repeatable)")
+	prefix := fs.String("log-prefix", "", "prefix of the access logs in the bucket to count the downloads of, e.g. logs/, as delivered by S3 server access logging or Logpush (default $LOG_PREFIX)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+	if *prefix == "" {
+		*prefix = getenv("LOG_PREFIX")
+	}
+	if len(*files) == 0 && *prefix == "" {
+		return errors.New("no logs: pass --log or --log-prefix, or set LOG_PREFIX")
+	}
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+	backend = dryRun(backend, *dryRunMode)
+
+	publisher, err := loadPublisher(ctx, backend, *appID, nil)
+	if err != nil {
+		return err
+	}
+	counter := stats.NewCounter(publisher.Manifest())
+	counts, etag, err := stats.Load(ctx, backend, *appID)
+	if err != nil {
+		return err
+	}
+
+	var logs, skipped int
+	var counted, ignored int64
+	ingest := func(source, name string, r io.Reader) error {
+		if counts.HasIngested(source) {
+			slog.Debug("skipped log counted before", "log", name)
+			skipped++
+			return nil
+		}
+		n, i, err := counter.Count(counts, r)
+		if err != nil {
+			return fmt.Errorf("failed to count downloads of %s: %w", name, err)
+		}
+		counts.Ingested = append(counts.Ingested, source)
+		logs++
+		counted += n
+		ignored += i
+		slog.Debug("counted downloads", "log", name, "downloads", n, "ignored", i)
+		return nil
+	}
+
+	for _, path := range *files {
+		data, err := readLogFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read log: %w", err)
+		}
+		digest := sha256.Sum256(data)
+		if err := ingest("sha256:"+hex.EncodeToString(digest[:]), path, bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	if *prefix != "" {
+		objects, err := backend.List(ctx, *prefix)
+		if err != nil {
+			return fmt.Errorf("failed to list logs: %w", err)
+		}
+		for _, object := range objects {
+			if counts.HasIngested(object.Key) {
+				skipped++
+				continue
+			}
+			if err := ingestLogObject(ctx, backend, object.Key, ingest); err != nil {
+				return err
+			}
+		}
+	}
+
+	if logs > 0 {
+		if err := stats.Save(ctx, backend, counts, etag); err != nil {
+			return err
+		}
+	}
+	slog.Info("counted downloads", "app_id", *appID, "logs", logs, "skipped", skipped, "downloads", counted, "ignored", ignored, "dry_run", *dryRunMode)
+	return nil
+}
+
+// ingestLogObject passes the log under key in backend to ingest, named by
+// its key.
+func ingestLogObject(ctx context.Context, backend storage.Backend, key string, ingest func(source, name string, r io.Reader) error) error {
+	reader, _, err := backend.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to fetch log %s: %w", key, err)
+	}
+	defer reader.Close()
+	return ingest(key, key, reader)
+}
+
+// readLogFile returns the content of the log file at path, or of stdin for
+// -.
+func readLogFile(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
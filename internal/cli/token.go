@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/storage"
+	"update-manifest/pkg/storage/presigned"
+)
+
+var issueTokenCommand = &command{
+	name:    "issue-token",
+	summary: "Presign the uploads of a release for publish --presigned on a machine without bucket credentials",
+	run:     runIssueToken,
+}
+
+func runIssueToken(ctx context.Context, args []string) error {
+	fs := newFlagSet("issue-token")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	artifacts := fs.Int("artifacts", 1, "number of artifacts the release publishes, each uploaded through a URL of its own")
+	expiry := fs.Duration("expiry", time.Hour, "validity of the presigned URLs")
+	tokenPath := fs.String("token", "", "file to write the upload token to, readable by its owner only (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+	if *artifacts < 1 {
+		return fmt.Errorf("number of artifacts %d is less than 1", *artifacts)
+	}
+	if *expiry <= 0 {
+		return fmt.Errorf("expiry %s is not positive", *expiry)
+	}
+
+	token, err := manifest.IssueUploadToken(ctx, backend, *appID, *artifacts, *expiry)
+	if err != nil {
+		return err
+	}
+	if *tokenPath == "" {
+		return writeJSON(os.Stdout, token)
+	}
+
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*tokenPath, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write upload token: %w", err)
+	}
+	slog.Info("issued upload token", "path", *tokenPath, "artifacts", *artifacts, "expires", token.Expires)
+	return nil
+}
+
+// loadUploadToken reads the upload token file written by issue-token.
+func loadUploadToken(path string) (*manifest.UploadToken, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload token: %w", err)
+	}
+	var token manifest.UploadToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to decode upload token: %w", err)
+	}
+	if token.AppID == "" {
+		return nil, fmt.Errorf("upload token %s names no application", path)
+	}
+	return &token, nil
+}
+
+// openPresigned returns the backend reaching the bucket through the URLs of
+// token, retrying like the backends of f.
+func (f *backendFlags) openPresigned(token *manifest.UploadToken) (storage.Backend, error) {
+	policy, err := f.retryPolicy()
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewRetry(presigned.New(token.Get, token.Put, nil), policy), nil
+}
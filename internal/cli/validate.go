@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/storage"
+)
+
+var validateCommand = &command{
+	name:    "validate",
+	summary: "Check the manifest against the schema and the objects it refers to",
+	run:     runValidate,
+}
+
+func runValidate(ctx context.Context, args []string) error {
+	fs := newFlagSet("validate")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	output := fs.String("output", "text", "output format: text or json")
+	skipObjects := fs.Bool("skip-objects", false, "do not check that the artifacts and patches exist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	data, err := readObject(ctx, backend, manifest.ManifestKey(*appID))
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+
+	problems := manifest.Validate(data)
+
+	var m manifest.Manifest
+	if !*skipObjects && json.Unmarshal(data, &m) == nil {
+		for _, key := range m.Keys() {
+			if _, err := backend.Stat(ctx, key); errors.Is(err, storage.ErrNotExist) {
+				problems = append(problems, manifest.Problem{Path: key, Message: "referenced object does not exist"})
+			} else if err != nil {
+				return fmt.Errorf("failed to stat %s: %w", key, err)
+			}
+		}
+	}
+
+	if *output == "json" {
+		if err := writeJSON(os.Stdout, struct {
+			Valid    bool               `json:"valid"`
+			Problems []manifest.Problem `json:"problems"`
+		}{len(problems) == 0, append([]manifest.Problem{}, problems...)}); err != nil {
+			return err
+		}
+	} else {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("manifest has %d problems", len(problems))
+	}
+	if *output == "text" {
+		fmt.Println("manifest is valid")
+	}
+	return nil
+}
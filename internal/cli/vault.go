@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"update-manifest/pkg/vault"
+)
+
+// vaultClient is the client of the Vault server, authenticated on first use.
+var vaultClient *vault.Client
+
+// addVaultFlag registers --vault-path on fs. Its secret is read as the flags
+// are parsed, so the settings it holds are found by the command like those
+// of the environment.
+func addVaultFlag(fs *flag.FlagSet) {
+	fs.Func("vault-path", "Vault KV secret, e.g. secret/data/releases, whose fields stand in for the environment variables they are named after, e.g. ACCESS_KEY or SIGNING_KEY, read from $VAULT_ADDR after the one of $VAULT_PATH", func(path string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		return loadVaultSecrets(ctx, path)
+	})
+}
+
+// loadVaultSecrets reads the KV secret at path into secrets.
+func loadVaultSecrets(ctx context.Context, path string) error {
+	client, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	fields, err := client.ReadSecret(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range fields {
+		switch value.(type) {
+		case string, bool, float64:
+			secrets[strings.ToLower(name)] = fmt.Sprint(value)
+		case nil:
+		default:
+			return fmt.Errorf("Vault secret %s: %s must be a scalar", path, name)
+		}
+	}
+	slog.Debug("read Vault secret", "path", path, "fields", len(fields))
+	return nil
+}
+
+// openVault returns the client of the Vault server at $VAULT_ADDR, in the
+// namespace $VAULT_NAMESPACE if set. It is authenticated by $VAULT_TOKEN, by
+// logging in as $VAULT_ROLE with the OIDC token of the CI job through the JWT
+// auth method at $VAULT_AUTH_PATH, jwt by default, or by the token the vault
+// CLI stores in ~/.vault-token.
+func openVault(ctx context.Context) (*vault.Client, error) {
+	if vaultClient != nil {
+		return vaultClient, nil
+	}
+
+	address := getenv("VAULT_ADDR")
+	if address == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	client := &vault.Client{
+		Address:   address,
+		Token:     getenv("VAULT_TOKEN"),
+		Namespace: getenv("VAULT_NAMESPACE"),
+		Client:    &http.Client{Timeout: time.Minute},
+	}
+
+	if role := getenv("VAULT_ROLE"); client.Token == "" && role != "" {
+		mount := getenv("VAULT_AUTH_PATH")
+		if mount == "" {
+			mount = "jwt"
+		}
+		jwt, err := ciIDToken(getenv("VAULT_AUDIENCE"))
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("logging in to Vault", "address", address, "auth", mount, "role", role)
+		if err := client.Login(ctx, mount, role, jwt); err != nil {
+			return nil, err
+		}
+	} else if client.Token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if data, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				client.Token = strings.TrimSpace(string(data))
+			}
+		}
+	}
+	if client.Token == "" {
+		return nil, errors.New("no Vault token: set VAULT_TOKEN, or VAULT_ROLE to log in with the OIDC token of the CI job")
+	}
+
+	vaultClient = client
+	return client, nil
+}
+
+// loadTransitSigner returns the signer of the Vault transit key named as
+// <mount>/<name>, e.g. transit/releases.
+func loadTransitSigner(ctx context.Context, key string) (*vault.TransitSigner, error) {
+	i := strings.LastIndex(key, "/")
+	if i <= 0 || i == len(key)-1 {
+		return nil, fmt.Errorf("invalid Vault transit key %q: expected <mount>/<name>", key)
+	}
+	client, err := openVault(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.TransitSigner(ctx, key[:i], key[i+1:])
+}
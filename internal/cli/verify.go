@@ -0,0 +1,246 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage"
+)
+
+var verifyCommand = &command{
+	name:    "verify",
+	summary: "Verify the detached signatures of the manifest and its artifacts",
+	run:     runVerify,
+}
+
+func runVerify(ctx context.Context, args []string) error {
+	fs := newFlagSet("verify")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	var inline, files stringList
+	fs.Var(&inline, "public-key", "trusted public key, base64 or PEM (repeatable)")
+	fs.Var(&files, "public-key-file", "file holding a trusted public key (repeatable)")
+	minisignKey := fs.String("minisign-public-key", "", "minisign public key file to verify the minisign signatures with instead")
+	artifacts := fs.Bool("artifacts", false, "also verify the detached signatures of every signed artifact against its recorded checksum")
+	transparencyLog := fs.Bool("transparency-log", false, "also check that the transparency log entries of the manifest signature record it and prove their inclusion in the log")
+	timestamp := fs.Bool("timestamp", false, "also check that the RFC 3161 timestamp of the manifest signature covers it and report its time; verify the signature of the timestamp authority with openssl ts -verify")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if *minisignKey != "" {
+		return verifyMinisign(ctx, backendFlags, *appID, *minisignKey, *artifacts)
+	}
+
+	keys, err := loadPublicKeys(inline, files)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("no public key given")
+	}
+
+	in := &inputs{}
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	data, err := readObject(ctx, backend, manifest.ManifestKey(*appID))
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+
+	envelope, err := readObject(ctx, backend, manifest.SignatureKey(*appID))
+	if err != nil {
+		return fmt.Errorf("failed to fetch signature: %w", err)
+	}
+
+	if err := signing.VerifyDetached(data, envelope, keys...); err != nil {
+		return err
+	}
+
+	slog.Info("manifest signature is valid", "app", *appID)
+	if *transparencyLog {
+		if err := verifyLogEntries(ctx, backend, *appID, data, envelope); err != nil {
+			return err
+		}
+	}
+	if *timestamp {
+		response, err := readObject(ctx, backend, manifest.TimestampKey(*appID))
+		if err != nil {
+			return fmt.Errorf("failed to fetch timestamp: %w", err)
+		}
+		signed, err := signing.VerifyTimestamp(response, envelope)
+		if err != nil {
+			return err
+		}
+		slog.Info("manifest signature is timestamped", "time", signed)
+	}
+	if !*artifacts {
+		return nil
+	}
+
+	var m manifest.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	verified := make(map[string]bool)
+	for _, name := range sortedKeys(m.Channel) {
+		channel := m.Channel[name]
+		for _, release := range append([]*manifest.Release{&channel.Release}, channel.Releases...) {
+			for _, platform := range sortedKeys(release.Artifact) {
+				for _, artifact := range release.Artifact[platform].All() {
+					if artifact.Signature == "" || verified[artifact.Signature] {
+						continue
+					}
+					envelope, err := readObject(ctx, backend, artifact.Signature)
+					if err != nil {
+						return fmt.Errorf("failed to fetch signature %s: %w", artifact.Signature, err)
+					}
+					if err := signing.VerifyDetached(manifest.ArtifactMessage(artifact.ChecksumAlgo, artifact.Checksum), envelope, keys...); err != nil {
+						return fmt.Errorf("%s: %w", artifact.Binary, err)
+					}
+					verified[artifact.Signature] = true
+				}
+			}
+		}
+	}
+	slog.Info("artifact signatures are valid", "artifacts", len(verified))
+	return nil
+}
+
+// verifyMinisign verifies the minisign signatures of the manifest of appID,
+// and of its artifacts if artifacts is set, against the minisign public key
+// in the file at path.
+func verifyMinisign(ctx context.Context, backendFlags *backendFlags, appID, path string, artifacts bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read minisign public key: %w", err)
+	}
+	key, err := signing.ParseMinisignPublicKey(string(data))
+	if err != nil {
+		return err
+	}
+
+	in := &inputs{}
+	appID = in.require(appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	data, err = readObject(ctx, backend, manifest.ManifestKey(appID))
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	signature, err := readObject(ctx, backend, manifest.ManifestKey(appID)+".minisig")
+	if err != nil {
+		return fmt.Errorf("failed to fetch minisign signature: %w", err)
+	}
+	if _, err := key.Verify(bytes.NewReader(data), signature); err != nil {
+		return err
+	}
+
+	slog.Info("manifest minisign signature is valid", "app", appID, "key", key.ID())
+	if !artifacts {
+		return nil
+	}
+
+	var m manifest.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	verified := make(map[string]bool)
+	for _, name := range sortedKeys(m.Channel) {
+		channel := m.Channel[name]
+		for _, release := range append([]*manifest.Release{&channel.Release}, channel.Releases...) {
+			for _, platform := range sortedKeys(release.Artifact) {
+				for _, artifact := range release.Artifact[platform].All() {
+					signatureKey := artifact.Signatures[signing.FormatMinisign]
+					if signatureKey == "" || verified[signatureKey] {
+						continue
+					}
+					signature, err := readObject(ctx, backend, signatureKey)
+					if err != nil {
+						return fmt.Errorf("failed to fetch signature %s: %w", signatureKey, err)
+					}
+					// minisign signs the content of the artifact, which is
+					// streamed rather than read into memory
+					reader, _, err := backend.Get(ctx, artifact.Binary)
+					if err != nil {
+						return fmt.Errorf("failed to fetch %s: %w", artifact.Binary, err)
+					}
+					_, err = key.Verify(reader, signature)
+					reader.Close()
+					if err != nil {
+						return fmt.Errorf("%s: %w", artifact.Binary, err)
+					}
+					verified[signatureKey] = true
+				}
+			}
+		}
+	}
+	slog.Info("artifact minisign signatures are valid", "artifacts", len(verified))
+	return nil
+}
+
+// verifyLogEntries checks the transparency log entries of the signatures of
+// envelope over the manifest of appID in data.
+func verifyLogEntries(ctx context.Context, backend storage.Backend, appID string, data, envelope []byte) error {
+	marshaledEntries, err := readObject(ctx, backend, manifest.TransparencyLogKey(appID))
+	if err != nil {
+		return fmt.Errorf("failed to fetch transparency log entries: %w", err)
+	}
+	var entries []*signing.LogEntry
+	if err := json.Unmarshal(marshaledEntries, &entries); err != nil {
+		return fmt.Errorf("failed to decode transparency log entries: %w", err)
+	}
+	var parsed signing.Envelope
+	if err := json.Unmarshal(envelope, &parsed); err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	for _, signature := range parsed.Signatures {
+		i := slices.IndexFunc(entries, func(entry *signing.LogEntry) bool { return entry.KeyID == signature.KeyID })
+		if i < 0 {
+			return fmt.Errorf("signature of key %s is not recorded in the transparency log", signature.KeyID)
+		}
+		if err := entries[i].Verify(parsed.SignedMessage(data), signature.Value); err != nil {
+			return fmt.Errorf("transparency log entry %s: %w", entries[i].UUID, err)
+		}
+		slog.Info("manifest signature is in the transparency log", "key", signature.KeyID, "uuid", entries[i].UUID, "index", entries[i].LogIndex, "time", time.Unix(entries[i].IntegratedTime, 0).UTC())
+	}
+	return nil
+}
+
+// readObject reads the whole object stored under key.
+func readObject(ctx context.Context, backend storage.Backend, key string) ([]byte, error) {
+	reader, _, err := backend.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
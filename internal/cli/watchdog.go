@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/storage"
+	"update-manifest/pkg/telemetry"
+)
+
+var watchdogCommand = &command{
+	name:    "watchdog",
+	summary: "Roll a channel back, or reduce its rollout, when the reported updates to it fail too often",
+	run:     runWatchdog,
+}
+
+// watchdog holds the settings of the watchdog command.
+type watchdog struct {
+	backend        storage.Backend
+	appID          string
+	channel        string
+	signingFlags   *signingFlags
+	outputFlags    *outputFlags
+	dryRun         bool
+	window         time.Duration
+	maxFailureRate float64
+	minDevices     int
+	action         string
+	reduceTo       int
+}
+
+func runWatchdog(ctx context.Context, args []string) error {
+	fs := newFlagSet("watchdog")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	channel := fs.String("channel", "", "channel whose current release is watched, e.g. stable")
+	window := fs.Duration("window", time.Hour, "how far back the telemetry reported by serve --telemetry is taken into account")
+	maxFailureRate := fs.Float64("max-failure-rate", 0.05, "share of the devices reporting a failed update to the release, between 0 and 1, above which the watchdog acts")
+	minDevices := fs.Int("min-devices", 20, "fewest devices that must have reported within the window for the watchdog to act")
+	action := fs.String("action", "rollback", "what to do when the failure rate is exceeded: rollback to the previous release that is not yanked, or reduce the rollout to --reduce-to")
+	reduceTo := fs.Int("reduce-to", 0, "rollout percentage --action reduce sets, unless the release is offered to fewer devices already")
+	interval := fs.Duration("interval", 0, "check again every interval until interrupted, e.g. 5m, rather than once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case *action != "rollback" && *action != "reduce":
+		return fmt.Errorf("unknown watchdog action %q, expected rollback or reduce", *action)
+	case *maxFailureRate < 0 || *maxFailureRate > 1:
+		return fmt.Errorf("failure rate %g is not between 0 and 1", *maxFailureRate)
+	case *reduceTo < 0 || *reduceTo > 100:
+		return fmt.Errorf("rollout percentage %d is not between 0 and 100", *reduceTo)
+	case *window <= 0:
+		return errors.New("window must be positive")
+	case *interval < 0:
+		return errors.New("interval must not be negative")
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	in.flag(*channel, "channel")
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	w := &watchdog{
+		backend:        backend,
+		appID:          *appID,
+		channel:        *channel,
+		signingFlags:   signingFlags,
+		outputFlags:    outputFlags,
+		dryRun:         *dryRunMode,
+		window:         *window,
+		maxFailureRate: *maxFailureRate,
+		minDevices:     *minDevices,
+		action:         *action,
+		reduceTo:       *reduceTo,
+	}
+	if *interval == 0 {
+		return w.check(ctx)
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		// a failed check, e.g. for want of the bucket, is retried next time
+		if err := w.check(ctx); err != nil {
+			slog.Error("watchdog check failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// check acts on the current release of the channel if the failure rate of
+// the updates to it reported within the window exceeds the maximum. The
+// reports are those serve --telemetry accepted with its telemetry token.
+func (w *watchdog) check(ctx context.Context) error {
+	rep, err := newReport("watchdog", w.appID, w.outputFlags)
+	if err != nil {
+		return err
+	}
+	backend := rep.track(dryRun(w.backend, w.dryRun))
+
+	publisher, err := loadPublisher(ctx, backend, w.appID, w.signingFlags)
+	if err != nil {
+		return err
+	}
+	ch, ok := publisher.Manifest().Channel[w.channel]
+	if !ok {
+		return fmt.Errorf("%w: %s", manifest.ErrChannelNotFound, w.channel)
+	}
+	version := ch.Version
+
+	reports, err := telemetry.Load(ctx, backend, w.appID, time.Now().Add(-w.window))
+	if err != nil {
+		return err
+	}
+	health := telemetry.Summarize(reports, w.channel, version)
+	attrs := []any{"channel", w.channel, "version", version, "devices", health.Devices, "failures", health.Failures}
+	if health.Devices < w.minDevices {
+		slog.Info("too few devices reported to judge release", attrs...)
+		return nil
+	}
+	if health.FailureRate() <= w.maxFailureRate {
+		slog.Info("release is healthy", append(attrs, "failure_rate", health.FailureRate())...)
+		return nil
+	}
+	slog.Warn("updates to release fail too often", append(attrs, "failure_rate", health.FailureRate(), "max_failure_rate", w.maxFailureRate)...)
+
+	rep.result.Channel = w.channel
+	if w.action == "reduce" {
+		if ch.RolloutPaused || (ch.Rollout != nil && *ch.Rollout <= w.reduceTo) {
+			slog.Info("rollout is reduced already", "channel", w.channel, "version", version)
+			return nil
+		}
+		if err := publisher.SetRollout(w.channel, w.reduceTo); err != nil {
+			return err
+		}
+		if err := publisher.Save(ctx); err != nil {
+			return err
+		}
+		rep.result.Version = version
+		return rep.finish(publisher, "reduced rollout", "channel", w.channel, "version", version, "state", fmt.Sprintf("%d%%", w.reduceTo))
+	}
+
+	previous := ch.Previous()
+	if previous == nil {
+		return fmt.Errorf("channel %s has no release older than %s to roll back to", w.channel, version)
+	}
+	if err := publisher.Rollback(w.channel, previous.Version); err != nil {
+		return err
+	}
+	if err := publisher.Save(ctx); err != nil {
+		return err
+	}
+	rep.result.Version = previous.Version
+	return rep.finish(publisher, "rolled back channel", "channel", w.channel, "from", version, "version", previous.Version)
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"update-manifest/pkg/manifest"
+)
+
+var wingetCommand = &command{
+	name:    "winget",
+	summary: "Render the WinGet manifests of a published release",
+	run:     runWinGet,
+}
+
+func runWinGet(ctx context.Context, args []string) error {
+	fs := newFlagSet("winget")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	channel := fs.String("channel", "", "channel of the release, e.g. stable")
+	version := fs.String("version", "", "recorded version to render (default the current release)")
+	identifier := fs.String("package-id", "", "WinGet package identifier, e.g. Publisher.App (default $WINGET_PACKAGE_ID)")
+	publisherName := fs.String("publisher", "", "publisher of the package (default $WINGET_PUBLISHER, or the first segment of the identifier)")
+	name := fs.String("package-name", "", "name of the package (default $WINGET_PACKAGE_NAME, or the last segment of the identifier)")
+	license := fs.String("license", "", "license of the package, e.g. MIT (default $WINGET_LICENSE)")
+	description := fs.String("short-description", "", "short description of the package (default $WINGET_SHORT_DESCRIPTION)")
+	locale := fs.String("locale", "en-US", "default locale of the package")
+	kind := fs.String("kind", "", "artifact kind to install, e.g. installer, instead of the artifact itself, leaving out platforms without one (default $WINGET_KIND)")
+	installerType := fs.String("installer-type", "", "winget installer type, e.g. nullsoft or inno (default $WINGET_INSTALLER_TYPE, or told by the extension of the installer)")
+	silent := fs.String("silent-switch", "", "switch installing an exe installer without interaction, e.g. /S (default $WINGET_SILENT_SWITCH)")
+	downloadURL := fs.String("download-url", "", "public URL of the bucket winget downloads installers from (default $DOWNLOAD_URL, or $PUBLIC_URL)")
+	output := fs.String("o", "", "directory, e.g. a checkout of winget-pkgs, to write the manifests to under manifests/<letter>/<publisher>/<app>/<version> (default print them)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	in.flag(*channel, "channel")
+	*appID = in.require(*appID, "app-id", "APP_ID")
+	pkg := manifest.WinGetPackage{
+		Identifier:       in.require(*identifier, "package-id", "WINGET_PACKAGE_ID"),
+		Publisher:        *publisherName,
+		Name:             *name,
+		License:          in.require(*license, "license", "WINGET_LICENSE"),
+		ShortDescription: in.require(*description, "short-description", "WINGET_SHORT_DESCRIPTION"),
+		Locale:           *locale,
+		BaseURL:          *downloadURL,
+		Kind:             *kind,
+		InstallerType:    *installerType,
+		Silent:           *silent,
+	}
+	if pkg.Publisher == "" {
+		pkg.Publisher = getenv("WINGET_PUBLISHER")
+	}
+	if pkg.Name == "" {
+		pkg.Name = getenv("WINGET_PACKAGE_NAME")
+	}
+	if pkg.Kind == "" {
+		pkg.Kind = getenv("WINGET_KIND")
+	}
+	if pkg.InstallerType == "" {
+		pkg.InstallerType = getenv("WINGET_INSTALLER_TYPE")
+	}
+	if pkg.Silent == "" {
+		pkg.Silent = getenv("WINGET_SILENT_SWITCH")
+	}
+	if pkg.BaseURL, err = packageDownloadURL(pkg.BaseURL); err != nil {
+		return err
+	}
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	publisher, err := loadPublisher(ctx, backend, *appID, nil)
+	if err != nil {
+		return err
+	}
+
+	release, err := packagedRelease(publisher.Manifest(), *channel, *version)
+	if err != nil {
+		return err
+	}
+
+	files, err := publisher.WinGetManifests(ctx, release, pkg)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		for i, file := range files {
+			if i > 0 {
+				fmt.Println("---")
+			}
+			os.Stdout.Write(file.Data)
+		}
+		return nil
+	}
+
+	dir := filepath.Join(*output, filepath.FromSlash(manifest.WinGetDir(pkg.Identifier, release.Version)))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(dir, file.Name), file.Data, 0o644); err != nil {
+			return err
+		}
+	}
+	slog.Info("wrote WinGet manifests", "version", release.Version, "path", dir)
+	return nil
+}
@@ -0,0 +1,71 @@
+package cli
+
+import "context"
+
+var yankCommand = &command{
+	name:    "yank",
+	summary: "Mark a release as broken so clients skip or leave it",
+	run:     runYank,
+}
+
+func runYank(ctx context.Context, args []string) error {
+	fs := newFlagSet("yank")
+	backendFlags := addBackendFlags(fs)
+	appID := addAppIDFlag(fs)
+	timeout := addTimeoutFlag(fs)
+	signingFlags := addSigningFlags(fs)
+	dryRunMode := addDryRunFlag(fs)
+	outputFlags := addOutputFlags(fs)
+	channel := fs.String("channel", "", "channel of the release, e.g. stable")
+	version := fs.String("version", "", "version to yank, e.g. 1.4.3")
+	reason := fs.String("reason", "", "reason shown to clients")
+	undo := fs.Bool("undo", false, "clear the yanked mark instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(ctx, *timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	in := &inputs{}
+	in.flag(*channel, "channel")
+	in.flag(*version, "version")
+	*appID = in.require(*appID, "app-id", "APP_ID")
+
+	backend, err := backendFlags.open(in)
+	if err != nil {
+		return err
+	}
+
+	rep, err := newReport("yank", *appID, outputFlags)
+	if err != nil {
+		return err
+	}
+	backend = rep.track(dryRun(backend, *dryRunMode))
+
+	publisher, err := loadPublisher(ctx, backend, *appID, signingFlags)
+	if err != nil {
+		return err
+	}
+
+	if *undo {
+		err = publisher.Unyank(*channel, *version)
+	} else {
+		err = publisher.Yank(*channel, *version, *reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := publisher.Save(ctx); err != nil {
+		return err
+	}
+	rep.result.Channel, rep.result.Version = *channel, *version
+	if *undo {
+		return rep.finish(publisher, "unyanked release", "channel", *channel, "version", *version)
+	}
+	return rep.finish(publisher, "yanked release", "channel", *channel, "version", *version, "current", publisher.Manifest().Channel[*channel].Version)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the buckets of the
+// request duration histogram, those of the Prometheus client libraries.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics counts the requests a Server answers, for GET /metrics to expose
+// in the Prometheus text format. Apps and channels are only counted when they
+// are in a manifest, so requests for made up ones cannot grow the series
+// without bounds.
+type metrics struct {
+	mu         sync.Mutex
+	requests   map[[2]string]uint64 // by object and status code
+	fetches    map[[2]string]uint64 // of manifests, by app and channel
+	downloaded map[string]uint64    // bytes of artifacts and patches, by app
+	latency    map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // by bucket, not cumulative, the last counting the rest
+	sum    float64
+	count  uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:   make(map[[2]string]uint64),
+		fetches:    make(map[[2]string]uint64),
+		downloaded: make(map[string]uint64),
+		latency:    make(map[string]*histogram),
+	}
+}
+
+// request records a request for an object of the kind, e.g. manifest,
+// answered with status after duration.
+func (m *metrics) request(object string, status int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[[2]string{object, strconv.Itoa(status)}]++
+
+	h := m.latency[object]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		m.latency[object] = h
+	}
+	seconds := duration.Seconds()
+	i, _ := slices.BinarySearch(latencyBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// fetch records a fetch of the manifest of appID by a client of channel, ""
+// if it did not tell.
+func (m *metrics) fetch(appID, channel string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetches[[2]string{appID, channel}]++
+}
+
+// download records size bytes of an artifact or patch of appID sent.
+func (m *metrics) download(appID string, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloaded[appID] += uint64(size)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.write(w)
+}
+
+func (m *metrics) write(w io.Writer) {
+	fmt.Fprintln(w, "# HELP update_manifest_requests_total Requests answered, by object requested and status code.")
+	fmt.Fprintln(w, "# TYPE update_manifest_requests_total counter")
+	for _, labels := range sortedLabels(m.requests) {
+		fmt.Fprintf(w, "update_manifest_requests_total{object=%s,code=%s} %d\n", quote(labels[0]), quote(labels[1]), m.requests[labels])
+	}
+
+	fmt.Fprintln(w, "# HELP update_manifest_manifest_fetches_total Manifests served, by app and the channel the client checks.")
+	fmt.Fprintln(w, "# TYPE update_manifest_manifest_fetches_total counter")
+	for _, labels := range sortedLabels(m.fetches) {
+		fmt.Fprintf(w, "update_manifest_manifest_fetches_total{app=%s,channel=%s} %d\n", quote(labels[0]), quote(labels[1]), m.fetches[labels])
+	}
+
+	fmt.Fprintln(w, "# HELP update_manifest_download_bytes_total Bytes of artifacts and patches sent, by app.")
+	fmt.Fprintln(w, "# TYPE update_manifest_download_bytes_total counter")
+	apps := make([]string, 0, len(m.downloaded))
+	for app := range m.downloaded {
+		apps = append(apps, app)
+	}
+	slices.Sort(apps)
+	for _, app := range apps {
+		fmt.Fprintf(w, "update_manifest_download_bytes_total{app=%s} %d\n", quote(app), m.downloaded[app])
+	}
+
+	fmt.Fprintln(w, "# HELP update_manifest_request_duration_seconds Time taken to answer requests, by object requested.")
+	fmt.Fprintln(w, "# TYPE update_manifest_request_duration_seconds histogram")
+	objects := make([]string, 0, len(m.latency))
+	for object := range m.latency {
+		objects = append(objects, object)
+	}
+	slices.Sort(objects)
+	for _, object := range objects {
+		h := m.latency[object]
+		var cumulative uint64
+		for i, count := range h.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "update_manifest_request_duration_seconds_bucket{object=%s,le=%s} %d\n", quote(object), quote(le), cumulative)
+		}
+		fmt.Fprintf(w, "update_manifest_request_duration_seconds_sum{object=%s} %s\n", quote(object), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "update_manifest_request_duration_seconds_count{object=%s} %d\n", quote(object), h.count)
+	}
+}
+
+// sortedLabels returns the label pairs of counters in order.
+func sortedLabels(counters map[[2]string]uint64) [][2]string {
+	labels := make([][2]string, 0, len(counters))
+	for pair := range counters {
+		labels = append(labels, pair)
+	}
+	slices.SortFunc(labels, func(a, b [2]string) int {
+		if c := strings.Compare(a[0], b[0]); c != 0 {
+			return c
+		}
+		return strings.Compare(a[1], b[1])
+	})
+	return labels
+}
+
+// quote returns the label value s quoted as the text format requires.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// recordingWriter records the status and the body size of a response.
+type recordingWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
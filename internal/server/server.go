@@ -0,0 +1,326 @@
+// Package server serves manifests and the artifacts they reference straight
+// from a storage backend.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"update-manifest/pkg/client"
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/storage"
+	"update-manifest/pkg/telemetry"
+)
+
+// maxTelemetrySize is the largest telemetry report accepted, and
+// maxTelemetryField the longest of its fields but the error, which is cut
+// short to maxTelemetryError.
+const (
+	maxTelemetrySize  = 16 << 10
+	maxTelemetryField = 256
+	maxTelemetryError = 4096
+)
+
+// Server is an http.Handler exposing GET /{app}/manifest.json, its signature,
+// the key list clients rotate their keys with and its signature, the other
+// objects published from the manifest, e.g. its feeds and TUF metadata, and
+// every artifact or patch the manifest references. Other objects in the
+// backend are not reachable.
+type Server struct {
+	backend   storage.Backend
+	mux       *http.ServeMux
+	metrics   *metrics
+	telemetry *telemetry.Recorder
+	// telemetryToken is the bearer token telemetry reports must carry.
+	telemetryToken string
+
+	mu   sync.Mutex
+	apps map[string]*app
+}
+
+// app caches the keys and channels of the manifest with the given ETag, and
+// which of the keys are immutable.
+type app struct {
+	etag      string
+	keys      map[string]bool
+	immutable map[string]bool
+	channels  map[string]bool
+}
+
+// New returns a Server reading from backend.
+func New(backend storage.Backend) *Server {
+	s := &Server{
+		backend: backend,
+		mux:     http.NewServeMux(),
+		apps:    make(map[string]*app),
+	}
+	s.mux.HandleFunc("GET /{app}/{key...}", s.handleObject)
+	return s
+}
+
+// ExposeMetrics makes the server count the requests it answers and expose
+// the counts at GET /metrics in the Prometheus text format: requests by
+// object and status, manifest fetches by app and the channel of
+// client.ChannelHeader, or the channel query parameter, bytes of artifacts
+// sent by app and request durations.
+func (s *Server) ExposeMetrics() {
+	s.metrics = newMetrics()
+	s.mux.Handle("GET /metrics", s.metrics)
+}
+
+// AcceptTelemetry makes the server accept reports of the outcome of updates
+// at POST /telemetry, a JSON telemetry.Report of an app with a manifest
+// carrying token as a bearer token, and record them with recorder. The
+// watchdog acts on the failures reported, so the token keeps those who
+// cannot be trusted with a rollback from reporting.
+func (s *Server) AcceptTelemetry(recorder *telemetry.Recorder, token string) {
+	s.telemetry = recorder
+	s.telemetryToken = token
+	s.mux.HandleFunc("POST /telemetry", s.handleTelemetry)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleObject(w http.ResponseWriter, r *http.Request) {
+	appID := r.PathValue("app")
+	key := appID + "/" + r.PathValue("key")
+
+	object := "artifact"
+	switch key {
+	case manifest.ManifestKey(appID):
+		object = "manifest"
+	case manifest.SignatureKey(appID):
+		object = "signature"
+	case manifest.KeyListKey(appID), manifest.KeyListSignatureKey(appID):
+		object = "key list"
+	default:
+		if manifest.IsPublished(appID, key) {
+			object = "metadata"
+		}
+	}
+	if s.metrics != nil {
+		start := time.Now()
+		recorder := &recordingWriter{ResponseWriter: w}
+		w = recorder
+		defer func() {
+			s.record(r, appID, object, recorder, time.Since(start))
+		}()
+	}
+
+	// notes and signatures are replaced when republished or re-signed, so
+	// only the content addressed objects are cached for good
+	cacheControl := "no-cache"
+	if object == "artifact" {
+		cached, err := s.app(r.Context(), appID)
+		if err != nil {
+			s.error(w, r, err)
+			return
+		}
+		if cached == nil || !cached.keys[key] {
+			object = "unreferenced"
+			http.NotFound(w, r)
+			return
+		}
+		if cached.immutable[key] {
+			cacheControl = "public, max-age=31536000, immutable"
+		}
+	}
+
+	info, err := s.backend.Stat(r.Context(), key)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+info.ETag+`"`)
+	w.Header().Set("Cache-Control", cacheControl)
+
+	body := storage.NewReadSeeker(r.Context(), s.backend, key, info.Size)
+	defer body.Close()
+	http.ServeContent(w, r, "", info.LastModified, body)
+}
+
+func (s *Server) handleTelemetry(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.telemetryToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "invalid telemetry token", http.StatusUnauthorized)
+		return
+	}
+
+	var report telemetry.Report
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTelemetrySize))
+	if err := decoder.Decode(&report); err != nil {
+		http.Error(w, "invalid report: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if report.AppID == "" || report.Version == "" || report.Platform == "" {
+		http.Error(w, "invalid report: app_id, version and platform are required", http.StatusBadRequest)
+		return
+	}
+	// an app is a single path segment, as in the routes of the objects
+	if strings.Contains(report.AppID, "/") || report.AppID == "." || report.AppID == ".." {
+		http.Error(w, "invalid report: invalid app_id", http.StatusBadRequest)
+		return
+	}
+	for _, field := range []string{report.AppID, report.Channel, report.Version, report.FromVersion, report.Platform, report.DeviceID} {
+		if len(field) > maxTelemetryField {
+			http.Error(w, "invalid report: field too long", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(report.Error) > maxTelemetryError {
+		report.Error = report.Error[:maxTelemetryError]
+	}
+
+	// only apps with a manifest are recorded, so reports cannot fill the
+	// bucket with made up ones
+	cached, err := s.app(r.Context(), report.AppID)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	if cached == nil {
+		http.Error(w, "unknown app", http.StatusNotFound)
+		return
+	}
+
+	report.Time = time.Now().UTC()
+	if err := s.telemetry.Record(report); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// record counts the request r for the object of appID answered through
+// recorder after duration in the metrics.
+func (s *Server) record(r *http.Request, appID, object string, recorder *recordingWriter, duration time.Duration) {
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	s.metrics.request(object, status, duration)
+	if status >= http.StatusBadRequest {
+		return
+	}
+
+	switch object {
+	case "manifest":
+		cached, err := s.app(r.Context(), appID)
+		if err != nil || cached == nil {
+			return
+		}
+		channel := r.Header.Get(client.ChannelHeader)
+		if channel == "" {
+			channel = r.URL.Query().Get("channel")
+		}
+		if !cached.channels[channel] {
+			channel = ""
+		}
+		s.metrics.fetch(appID, channel)
+	case "artifact":
+		s.metrics.download(appID, recorder.written)
+	}
+}
+
+// app returns the keys and channels of the current manifest of appID, or nil
+// if there is none. The keys include those of the chunks its chunk indexes
+// list.
+func (s *Server) app(ctx context.Context, appID string) (*app, error) {
+	info, err := s.backend.Stat(ctx, manifest.ManifestKey(appID))
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	cached, ok := s.apps[appID]
+	s.mu.Unlock()
+	if ok && cached.etag == info.ETag {
+		return cached, nil
+	}
+
+	reader, info, err := s.backend.Get(ctx, manifest.ManifestKey(appID))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var m manifest.Manifest
+	if err := json.NewDecoder(reader).Decode(&m); err != nil {
+		return nil, err
+	}
+
+	cached = &app{etag: info.ETag, keys: make(map[string]bool), immutable: make(map[string]bool), channels: make(map[string]bool)}
+	for _, k := range m.Keys() {
+		cached.keys[k] = true
+	}
+	for _, k := range m.ImmutableKeys() {
+		cached.immutable[k] = true
+	}
+	if err := s.allowChunks(ctx, &m, cached); err != nil {
+		return nil, err
+	}
+	for name := range m.Channel {
+		cached.channels[name] = true
+	}
+
+	s.mu.Lock()
+	s.apps[appID] = cached
+	s.mu.Unlock()
+
+	return cached, nil
+}
+
+// allowChunks adds the keys of the chunks listed by the chunk indexes of m to
+// the keys of cached, as immutable ones. An index that is gone lists none.
+func (s *Server) allowChunks(ctx context.Context, m *manifest.Manifest, cached *app) error {
+	for _, chunks := range m.ChunkIndexes() {
+		reader, _, err := s.backend.Get(ctx, chunks.Key)
+		if errors.Is(err, storage.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		index, err := manifest.ReadChunkIndex(reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read chunk index %s: %w", chunks.Key, err)
+		}
+		for _, chunk := range index.Chunks {
+			cached.keys[chunks.ChunkKey(chunk.Checksum)] = true
+			cached.immutable[chunks.ChunkKey(chunk.Checksum)] = true
+		}
+	}
+	return nil
+}
+
+func (s *Server) error(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, storage.ErrNotExist) {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+
+	slog.Error("failed to serve object", "path", r.URL.Path, "err", err)
+	http.Error(w, "failed to read from backend", http.StatusBadGateway)
+}
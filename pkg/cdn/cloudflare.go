@@ -0,0 +1,105 @@
+// Package cdn purges objects from the caches of content delivery networks,
+// so clients see an overwritten object without waiting for its copies to
+// expire.
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultCloudflareAPI is the API a Cloudflare purges through when APIURL
+// is empty.
+const DefaultCloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// cloudflareBatch is the most URLs Cloudflare purges in one request.
+const cloudflareBatch = 30
+
+// Cloudflare purges the objects of a bucket served at BaseURL from the cache
+// of a Cloudflare zone.
+type Cloudflare struct {
+	// ZoneID identifies the zone serving BaseURL.
+	ZoneID string
+	// Token is an API token with the Cache Purge permission of the zone.
+	Token string
+	// BaseURL is the public URL of the bucket, which an object is served
+	// under with its key appended.
+	BaseURL string
+	// APIURL is the Cloudflare API, DefaultCloudflareAPI if empty.
+	APIURL string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// Purge removes the objects under keys from the cache of the zone.
+func (c *Cloudflare) Purge(ctx context.Context, keys []string) error {
+	urls := make([]string, len(keys))
+	for i, key := range keys {
+		urls[i] = strings.TrimSuffix(c.BaseURL, "/") + "/" + key
+	}
+	for len(urls) > 0 {
+		n := min(len(urls), cloudflareBatch)
+		if err := c.purge(ctx, urls[:n]); err != nil {
+			return err
+		}
+		urls = urls[n:]
+	}
+	return nil
+}
+
+func (c *Cloudflare) purge(ctx context.Context, urls []string) error {
+	body, err := json.Marshal(map[string][]string{"files": urls})
+	if err != nil {
+		return err
+	}
+
+	api := c.APIURL
+	if api == "" {
+		api = DefaultCloudflareAPI
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(api, "/")+"/zones/"+c.ZoneID+"/purge_cache", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to purge cache: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to purge cache: %w", err)
+	}
+	var result cloudflareResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to purge cache: unexpected status %s", resp.Status)
+	}
+	if !result.Success {
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("failed to purge cache: %s (code %d)", result.Errors[0].Message, result.Errors[0].Code)
+		}
+		return fmt.Errorf("failed to purge cache: unexpected status %s", resp.Status)
+	}
+	return nil
+}
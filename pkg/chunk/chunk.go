@@ -0,0 +1,109 @@
+// Package chunk splits content into content-defined chunks.
+//
+// Chunk boundaries are found with FastCDC: a gear hash rolls over the
+// content and a boundary is cut where its top bits are zero, with a stricter
+// mask before the average size and a looser one after it, so chunk sizes
+// cluster around the average. As boundaries depend only on the bytes before
+// them, an insertion or deletion changes the chunks around it and leaves the
+// others as they were, and two versions of a file share most of their
+// chunks.
+package chunk
+
+import (
+	"errors"
+	"io"
+)
+
+// Format identifies the chunking parameters in the manifest. Content split
+// with other parameters shares no chunks with content split with these.
+const Format = "fastcdc-64k"
+
+const (
+	// MinSize, AvgSize and MaxSize bound the length of chunks. Only the last
+	// chunk of content may be shorter than MinSize.
+	MinSize = 16 << 10
+	AvgSize = 64 << 10
+	MaxSize = 256 << 10
+)
+
+// the masks test the top bits of the hash, which depend on the last 64 bytes
+// read rather than only on the last few: 2 bits more than the average size
+// needs before it, 2 bits less after it
+const (
+	maskS uint64 = (1<<18 - 1) << (64 - 18)
+	maskL uint64 = (1<<14 - 1) << (64 - 14)
+)
+
+// gear maps every byte to a random value, the same for every run.
+var gear [256]uint64
+
+func init() {
+	// splitmix64 with a fixed seed
+	state := uint64(0x756d2d6368756e6b)
+	for i := range gear {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Split reads r to its end and calls emit with every chunk in order. The
+// chunk passed to emit is only valid until it returns. Split stops at the
+// first error of emit and returns it.
+func Split(r io.Reader, emit func(chunk []byte) error) error {
+	buf := make([]byte, 2*MaxSize)
+	var start, end int
+	var eof bool
+	for {
+		// keep at least MaxSize bytes buffered while there are more
+		if !eof && end-start < MaxSize {
+			copy(buf, buf[start:end])
+			end -= start
+			start = 0
+			n, err := io.ReadFull(r, buf[end:])
+			end += n
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if start == end {
+			return nil
+		}
+
+		n := cut(buf[start:end])
+		if err := emit(buf[start : start+n]); err != nil {
+			return err
+		}
+		start += n
+	}
+}
+
+// cut returns the length of the chunk at the start of data.
+func cut(data []byte) int {
+	n := len(data)
+	if n <= MinSize {
+		return n
+	}
+	n = min(n, MaxSize)
+	normal := min(n, AvgSize)
+
+	var hash uint64
+	i := MinSize
+	for ; i < normal; i++ {
+		hash = hash<<1 + gear[data[i]]
+		if hash&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = hash<<1 + gear[data[i]]
+		if hash&maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
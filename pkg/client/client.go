@@ -0,0 +1,527 @@
+// Package client checks a published manifest for updates.
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/semver"
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/telemetry"
+)
+
+var (
+	// ErrChannelNotFound is returned when the manifest has no such channel.
+	ErrChannelNotFound = errors.New("channel not found in manifest")
+	// ErrPlatformNotFound is returned when the channel has no artifact for
+	// the platform.
+	ErrPlatformNotFound = errors.New("platform not found in channel")
+	// ErrKindNotFound is returned when the artifact of the platform has no
+	// artifact of the requested kind.
+	ErrKindNotFound = errors.New("artifact kind not found for platform")
+	// ErrOSUnsupported is returned when the update does not run on the
+	// operating system version of the client.
+	ErrOSUnsupported = errors.New("update requires a newer operating system")
+)
+
+// Client fetches manifests over HTTP.
+type Client struct {
+	// HTTPClient is used for requests. http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// BaseURL is the URL object keys are resolved against. When empty, it is
+	// the bucket root derived from the manifest URL, i.e. the manifest URL
+	// without its trailing "{app}/manifest.json". The URLs a client manifest
+	// of a private bucket, "{app}/client.json", holds in place of keys are
+	// used as they are.
+	BaseURL string
+	// DeviceID identifies this installation for staged rollouts. Releases
+	// rolled out to less than all devices are not offered without it.
+	DeviceID string
+	// TelemetryToken is the bearer token ReportUpdate sends, that of the
+	// serve command's --telemetry-token.
+	TelemetryToken string
+	// OSVersion is the operating system version of this installation as dot
+	// separated numbers, e.g. 13.4.1. Updates requiring a newer operating
+	// system are refused with ErrOSUnsupported. It is not checked when empty.
+	OSVersion string
+	// Variants are the artifact variants this installation can run, best
+	// first. The variant offered is the first one published, or the artifact
+	// itself if none is. DetectVariants is used when nil.
+	Variants []string
+	// Kind selects the kind of artifact offered, e.g. installer. The
+	// executable itself, or its best variant, is offered when empty.
+	Kind string
+	// TrustedKeys, when set, pins the keys the manifest must carry a
+	// signature of, or SignatureThreshold signatures of, which is checked
+	// before any of it is used, and its signature must not have expired. The
+	// signature and the key list are fetched from next to the manifest, so
+	// this does not work with client manifests, which are not signed.
+	TrustedKeys []signing.PublicKey
+	// RequireExpiry makes the client also reject manifest signatures that
+	// never expire, so a stale manifest cannot be served to it in place of
+	// newer ones for longer than the signatures are valid.
+	RequireExpiry bool
+	// SignatureThreshold is the number of TrustedKeys the manifest needs
+	// signatures of, 1 when 0.
+	SignatureThreshold int
+	// KeyListVersion is the version of the key list TrustedKeys were taken
+	// from, 0 for keys embedded in the application. Key lists no newer are
+	// ignored.
+	KeyListVersion int64
+	// OnKeyRotation, if set, is called when a newer key list signed by as many
+	// trusted keys as the manifest needs is accepted, after which the client
+	// trusts its keys and threshold instead. Applications persist them, and
+	// the version of the list, to pass them as TrustedKeys,
+	// SignatureThreshold and KeyListVersion on their next start, and the keys
+	// to the updater verifying the artifacts.
+	OnKeyRotation func(list *manifest.KeyList, keys []signing.PublicKey)
+
+	mu sync.Mutex
+}
+
+// Update describes a newer release available for the platform.
+type Update struct {
+	Version string
+	Build   time.Time
+
+	// CurrentYanked reports that the running version was yanked. The update
+	// should be installed right away, even if it is not newer.
+	CurrentYanked bool
+	YankReason    string
+	// Unsupported reports that the running version is older than the
+	// minimum version of the channel: the update must be installed before
+	// the application is used.
+	Unsupported bool
+	// Mandatory reports that the update cannot be skipped: the release or a
+	// release published between the running version and it is mandatory.
+	Mandatory bool
+
+	// Variant names the variant of the artifact offered, or is empty for
+	// the artifact itself.
+	Variant string
+	// Kind is the kind of artifact offered, empty for the executable.
+	Kind        string
+	ArtifactURL string
+	Checksum    string
+	// ChecksumAlgo is the algorithm of Checksum and of every other checksum
+	// of the update, as named by the Hash constants of the manifest package.
+	ChecksumAlgo string
+	// SignatureURL downloads the detached signature of the artifact by the
+	// keys with the IDs in SignedBy, or is empty if it is not signed.
+	SignatureURL string
+	SignedBy     []string
+	// SignatureURLs download the detached signatures of the artifact in the
+	// formats of other tools, keyed by format, e.g. minisign.
+	SignatureURLs map[string]string
+	// Size is the length of the artifact in bytes, 0 if unknown.
+	Size int64
+	// Archive describes the files of the artifact if it is an archive of an
+	// application bundle rather than a single executable.
+	Archive *manifest.Archive
+	// CompressedURL downloads a compressed copy of the artifact described by
+	// Compressed, or is empty if none was published. The decompressed copy
+	// has the checksum of the artifact.
+	CompressedURL string
+	Compressed    *manifest.Compressed
+	// ChunkIndexURL downloads the index of the chunks of the artifact
+	// described by Chunks, or is empty if it was not published as chunks.
+	// A chunk is downloaded from ChunkPrefixURL with its checksum appended.
+	ChunkIndexURL  string
+	ChunkPrefixURL string
+	Chunks         *manifest.Chunks
+
+	// PatchURL is empty when no patch was published. The patch applies to the
+	// artifact with checksum PatchFrom.
+	PatchURL      string
+	PatchChecksum string
+	PatchFrom     string
+	PatchFormat   string
+	PatchSize     int64
+	// Patches apply to artifacts older than the one with checksum
+	// PatchFrom, newest first.
+	Patches []Patch
+
+	// Notes are the release notes kept in the manifest. NotesURL is set
+	// instead when they are stored as a separate object.
+	Notes    string
+	NotesURL string
+
+	Artifact *manifest.Artifact
+}
+
+// Patch is a delta that turns the artifact with checksum From into the
+// artifact of an update.
+type Patch struct {
+	URL      string
+	Checksum string
+	From     string
+	Format   string
+	Size     int64
+}
+
+// ChannelHeader is the request header CheckForUpdate names the channel it
+// checks in when fetching the manifest, which the serve command counts
+// manifest fetches by.
+const ChannelHeader = "X-Update-Channel"
+
+// DefaultClient is the Client used by the package level functions.
+var DefaultClient = &Client{}
+
+// CheckForUpdate checks manifestURL using DefaultClient.
+func CheckForUpdate(ctx context.Context, manifestURL, currentVersion, channel, platform string) (*Update, error) {
+	return DefaultClient.CheckForUpdate(ctx, manifestURL, currentVersion, channel, platform)
+}
+
+// CheckForUpdate fetches the manifest and returns the release of channel for
+// platform if it is newer than currentVersion, or if currentVersion was
+// yanked. It returns nil, nil when the current version is up to date or the
+// current release of the channel is yanked.
+//
+// Versions are ordered as semantic versions. If either version is not a
+// valid semantic version, any difference is treated as an update.
+func (c *Client) CheckForUpdate(ctx context.Context, manifestURL, currentVersion, channel, platform string) (*Update, error) {
+	m, err := c.fetchManifest(ctx, manifestURL, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, ok := m.Channel[channel]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrChannelNotFound, channel)
+	}
+
+	// the channel keeps the artifacts of older versions of platforms that
+	// were not published for the current one
+	artifact := ch.Current().FindArtifact(platform)
+	if artifact == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPlatformNotFound, platform)
+	}
+	var variant string
+	if c.Kind != "" {
+		if artifact = artifact.Kinds[c.Kind]; artifact == nil {
+			return nil, fmt.Errorf("%w: %s %s", ErrKindNotFound, platform, c.Kind)
+		}
+	} else {
+		artifact, variant = c.chooseVariant(artifact)
+	}
+	if artifact.Binary == "" {
+		return nil, fmt.Errorf("%w: %s", ErrPlatformNotFound, platform)
+	}
+
+	// never offer a yanked release
+	if ch.Yanked {
+		return nil, nil
+	}
+
+	var currentYanked bool
+	var yankReason string
+	if current := ch.Find(currentVersion); current != nil && current.Yanked {
+		currentYanked, yankReason = true, current.YankReason
+	}
+
+	if ch.Version == currentVersion || !currentYanked && !newer(ch.Version, currentVersion) {
+		return nil, nil
+	}
+
+	// devices on a yanked or unsupported version leave it regardless of the
+	// rollout
+	unsupported := !Supported(ch, currentVersion)
+	if !currentYanked && !unsupported && !c.inRollout(&ch.Release) {
+		return nil, nil
+	}
+
+	if artifact.MinOS != "" && c.OSVersion != "" && compareOSVersions(c.OSVersion, artifact.MinOS) < 0 {
+		return nil, fmt.Errorf("%w: %s needs %s, running %s", ErrOSUnsupported, ch.Version, artifact.MinOS, c.OSVersion)
+	}
+
+	base, err := c.baseURL(manifestURL)
+	if err != nil {
+		return nil, err
+	}
+
+	update := &Update{
+		Version:       ch.Version,
+		Build:         ch.Build,
+		CurrentYanked: currentYanked,
+		YankReason:    yankReason,
+		Unsupported:   unsupported,
+		Mandatory:     mandatory(ch, currentVersion),
+		Variant:       variant,
+		Kind:          c.Kind,
+		ArtifactURL:   resolve(base, artifact.Binary),
+		Checksum:      artifact.Checksum,
+		ChecksumAlgo:  artifact.HashAlgorithm(),
+		Size:          artifact.Size,
+		Archive:       artifact.Archive,
+		Artifact:      artifact,
+	}
+
+	if artifact.Signature != "" {
+		update.SignatureURL = resolve(base, artifact.Signature)
+		update.SignedBy = artifact.SignedBy
+	}
+	for format, key := range artifact.Signatures {
+		if update.SignatureURLs == nil {
+			update.SignatureURLs = make(map[string]string, len(artifact.Signatures))
+		}
+		update.SignatureURLs[format] = resolve(base, key)
+	}
+
+	if artifact.Compressed != nil {
+		update.CompressedURL = resolve(base, artifact.Compressed.Key)
+		update.Compressed = artifact.Compressed
+	}
+
+	if artifact.Chunks != nil {
+		update.ChunkIndexURL = resolve(base, artifact.Chunks.Key)
+		update.ChunkPrefixURL = resolve(base, artifact.Chunks.Prefix)
+		update.Chunks = artifact.Chunks
+	}
+
+	if ch.NotesKey != "" {
+		update.NotesURL = resolve(base, ch.NotesKey)
+	} else {
+		update.Notes = ch.Notes
+	}
+
+	if artifact.Patch != "" {
+		update.PatchURL = resolve(base, artifact.Patch)
+		update.PatchChecksum = artifact.PatchChecksum
+		update.PatchFrom = artifact.PatchFrom
+		update.PatchFormat = artifact.PatchFormat
+		update.PatchSize = artifact.PatchSize
+	}
+	for _, delta := range artifact.Patches {
+		update.Patches = append(update.Patches, Patch{
+			URL:      resolve(base, delta.Key),
+			Checksum: delta.Checksum,
+			From:     delta.From,
+			Format:   delta.Format,
+			Size:     delta.Size,
+		})
+	}
+
+	return update, nil
+}
+
+// FetchManifest downloads and decodes the manifest at manifestURL, after
+// verifying its signature if the client has TrustedKeys.
+func (c *Client) FetchManifest(ctx context.Context, manifestURL string) (*manifest.Manifest, error) {
+	return c.fetchManifest(ctx, manifestURL, "")
+}
+
+// fetchManifest fetches the manifest like FetchManifest, naming the channel
+// checked, if any, in the ChannelHeader of the request for the metrics of
+// the server.
+func (c *Client) fetchManifest(ctx context.Context, manifestURL, channel string) (*manifest.Manifest, error) {
+	var header http.Header
+	if channel != "" {
+		header = http.Header{ChannelHeader: {channel}}
+	}
+	data, err := c.get(ctx, manifestURL, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	if err := c.verifyManifest(ctx, manifestURL, data); err != nil {
+		return nil, err
+	}
+
+	var m manifest.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// ReportUpdate sends report, the outcome of installing an update, to the
+// telemetry endpoint of the serve command at telemetryURL, e.g.
+// https://updates.example.com/telemetry. The report carries the DeviceID of
+// the client unless it names another device.
+func (c *Client) ReportUpdate(ctx context.Context, telemetryURL string, report *telemetry.Report) error {
+	sent := *report
+	if sent.DeviceID == "" {
+		sent.DeviceID = c.DeviceID
+	}
+	body, err := json.Marshal(sent)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telemetryURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.TelemetryToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.TelemetryToken)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to report update: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to report update: %w", &statusError{code: resp.StatusCode, status: resp.Status})
+	}
+	return nil
+}
+
+func (c *Client) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	return c.get(ctx, rawURL, nil)
+}
+
+// get fetches rawURL with the headers header.
+func (c *Client) get(ctx context.Context, rawURL string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// statusError reports a response with another status than OK.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status " + e.status
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) baseURL(manifestURL string) (string, error) {
+	if c.BaseURL != "" {
+		return strings.TrimSuffix(c.BaseURL, "/"), nil
+	}
+
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid manifest url: %w", err)
+	}
+	u.Path = path.Dir(path.Dir(u.Path))
+	u.RawQuery = ""
+	u.Fragment = ""
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// resolve returns the URL of the object under key, which the client
+// manifest already replaced with a presigned URL.
+func resolve(base, key string) string {
+	if strings.HasPrefix(key, "https://") || strings.HasPrefix(key, "http://") {
+		return key
+	}
+	return base + "/" + strings.TrimPrefix(key, "/")
+}
+
+func (c *Client) inRollout(release *manifest.Release) bool {
+	if release.RolloutPaused {
+		return false
+	}
+	if release.Rollout == nil {
+		return true
+	}
+	if c.DeviceID == "" {
+		return *release.Rollout >= 100
+	}
+	return InRollout(c.DeviceID, release.Version, *release.Rollout)
+}
+
+// InRollout reports whether the device falls in the percent share of devices
+// offered version. The decision is deterministic: a device stays in the
+// rollout as the percentage grows, and the version salts the hash so the same
+// devices are not always first.
+func InRollout(deviceID, version string, percent int) bool {
+	sum := blake2b.Sum256([]byte(version + "\x00" + deviceID))
+	bucket := binary.BigEndian.Uint64(sum[:8]) % 100
+	return bucket < uint64(max(percent, 0))
+}
+
+// Supported reports whether currentVersion is at least the minimum version of
+// ch. Versions that are not semantic versions are assumed supported.
+func Supported(ch *manifest.Channel, currentVersion string) bool {
+	if ch.MinVersion == "" {
+		return true
+	}
+	c, err := semver.Compare(currentVersion, ch.MinVersion)
+	return err != nil || c >= 0
+}
+
+// mandatory reports whether the current release of ch or any release recorded
+// since currentVersion is mandatory.
+func mandatory(ch *manifest.Channel, currentVersion string) bool {
+	if ch.Mandatory {
+		return true
+	}
+	for _, release := range ch.Releases {
+		if release.Mandatory && !release.Yanked && newer(release.Version, currentVersion) && !newer(release.Version, ch.Version) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareOSVersions compares two dot separated version numbers, treating
+// missing parts as 0. Parts that are not numbers compare as 0.
+func compareOSVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return cmp.Compare(x, y)
+		}
+	}
+	return 0
+}
+
+// newer reports whether latest should replace current.
+func newer(latest, current string) bool {
+	c, err := semver.Compare(latest, current)
+	if err != nil {
+		return latest != current
+	}
+	return c > 0
+}
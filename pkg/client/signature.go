@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/signing"
+)
+
+// verifyManifest checks that the signature published next to the manifest at
+// manifestURL, whose content is data, is one of a trusted key, after
+// rotating the trusted keys to those of a newer key list if one is
+// published.
+func (c *Client) verifyManifest(ctx context.Context, manifestURL string, data []byte) error {
+	trusted, threshold, version := c.trustedKeys()
+	if len(trusted) == 0 {
+		return nil
+	}
+
+	signatureURL, err := sibling(manifestURL, path.Base(manifest.SignatureKey("")))
+	if err != nil {
+		return err
+	}
+	envelope, err := c.fetch(ctx, signatureURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest signature: %w", err)
+	}
+
+	// a key list that cannot be fetched or is not signed by a trusted key
+	// leaves the trusted keys as they are, and the manifest signature decides
+	list, keys, rotateErr := c.fetchKeyList(ctx, manifestURL, trusted, threshold, version)
+	if list != nil {
+		trusted, threshold = c.rotateKeys(list, keys)
+	}
+	if err := signing.VerifyDetachedThreshold(data, envelope, threshold, trusted...); err != nil {
+		if rotateErr != nil {
+			return fmt.Errorf("failed to verify manifest: %w, and the key list was not accepted: %v", err, rotateErr)
+		}
+		return fmt.Errorf("failed to verify manifest: %w", err)
+	}
+	if c.RequireExpiry {
+		var parsed signing.Envelope
+		if err := json.Unmarshal(envelope, &parsed); err != nil {
+			return fmt.Errorf("failed to decode manifest signature: %w", err)
+		}
+		if parsed.Expires == nil {
+			return errors.New("failed to verify manifest: the signature does not expire")
+		}
+	}
+	return nil
+}
+
+// trustedKeys returns the keys the client trusts, the number of them the
+// manifest needs signatures of and the version of the key list they were
+// taken from.
+func (c *Client) trustedKeys() ([]signing.PublicKey, int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.TrustedKeys, c.SignatureThreshold, c.KeyListVersion
+}
+
+// rotateKeys makes the client trust the keys of list, unless it has accepted
+// a list at least as new meanwhile, and returns the keys it trusts and their
+// threshold.
+func (c *Client) rotateKeys(list *manifest.KeyList, keys []signing.PublicKey) ([]signing.PublicKey, int) {
+	c.mu.Lock()
+	if list.Version <= c.KeyListVersion {
+		defer c.mu.Unlock()
+		return c.TrustedKeys, c.SignatureThreshold
+	}
+	c.TrustedKeys, c.SignatureThreshold, c.KeyListVersion = keys, list.RequiredSignatures(), list.Version
+	c.mu.Unlock()
+
+	if c.OnKeyRotation != nil {
+		c.OnKeyRotation(list, keys)
+	}
+	return keys, list.RequiredSignatures()
+}
+
+// fetchKeyList returns the key list published next to the manifest at
+// manifestURL and its keys if it is newer than version, signed by threshold
+// of the trusted keys and that of the application of the manifest. It
+// returns nil without error when there is no newer list.
+func (c *Client) fetchKeyList(ctx context.Context, manifestURL string, trusted []signing.PublicKey, threshold int, version int64) (*manifest.KeyList, []signing.PublicKey, error) {
+	listURL, err := sibling(manifestURL, path.Base(manifest.KeyListKey("")))
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := c.fetch(ctx, listURL)
+	var status *statusError
+	if errors.As(err, &status) && status.code == http.StatusNotFound {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch key list: %w", err)
+	}
+
+	var list manifest.KeyList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode key list: %w", err)
+	}
+	if list.Version <= version {
+		return nil, nil, nil
+	}
+
+	signatureURL, err := sibling(manifestURL, path.Base(manifest.KeyListSignatureKey("")))
+	if err != nil {
+		return nil, nil, err
+	}
+	envelope, err := c.fetch(ctx, signatureURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch key list signature: %w", err)
+	}
+	if err := signing.VerifyDetachedThreshold(data, envelope, threshold, trusted...); err != nil {
+		return nil, nil, fmt.Errorf("failed to verify key list %d: %w", list.Version, err)
+	}
+	// the keys may sign the lists of other applications too, which must not
+	// rotate those of this one
+	appID, err := manifestAppID(manifestURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if list.AppID != appID {
+		return nil, nil, fmt.Errorf("key list %d is that of %q, not %q", list.Version, list.AppID, appID)
+	}
+	keys, err := list.PublicKeys()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid key list %d: %w", list.Version, err)
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("key list %d has no keys", list.Version)
+	}
+	return &list, keys, nil
+}
+
+// manifestAppID returns the ID of the application of the manifest at
+// manifestURL, the directory it is in.
+func manifestAppID(manifestURL string) (string, error) {
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid manifest url: %w", err)
+	}
+	return path.Base(path.Dir(u.Path)), nil
+}
+
+// sibling returns the URL of the object name in the directory of the
+// manifest at manifestURL, keeping its query.
+func sibling(manifestURL, name string) (string, error) {
+	u, err := url.Parse(manifestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid manifest url: %w", err)
+	}
+	u.Path = path.Join(path.Dir(u.Path), name)
+	u.RawPath = ""
+	return u.String(), nil
+}
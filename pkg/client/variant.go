@@ -0,0 +1,44 @@
+package client
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/klauspost/cpuid/v2"
+
+	"update-manifest/pkg/manifest"
+)
+
+// DetectVariants returns the artifact variants the CPU can run, best first.
+// On amd64 these are the microarchitecture levels as named by GOAMD64, e.g.
+// v3, v2, v1 on a CPU with AVX2. Other architectures have none.
+func DetectVariants() []string {
+	if runtime.GOARCH != "amd64" {
+		return nil
+	}
+
+	var variants []string
+	for level := cpuid.CPU.X64Level(); level >= 1; level-- {
+		variants = append(variants, fmt.Sprintf("v%d", level))
+	}
+	return variants
+}
+
+// chooseVariant returns the first variant of artifact in the preference order
+// of the client, or artifact itself if it has none of them.
+func (c *Client) chooseVariant(artifact *manifest.Artifact) (*manifest.Artifact, string) {
+	if len(artifact.Variants) == 0 {
+		return artifact, ""
+	}
+
+	variants := c.Variants
+	if variants == nil {
+		variants = DetectVariants()
+	}
+	for _, name := range variants {
+		if variant := artifact.Variants[name]; variant != nil && variant.Binary != "" {
+			return variant, name
+		}
+	}
+	return artifact, ""
+}
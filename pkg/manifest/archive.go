@@ -0,0 +1,306 @@
+package manifest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Archive formats an artifact can be published in.
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// Archive describes an artifact that is an archive of an application bundle
+// rather than a single executable, so clients can extract the bundle and
+// verify every file of it.
+type Archive struct {
+	// Format is ArchiveTarGz or ArchiveZip.
+	Format string `json:"format"`
+	// Executable is the slash separated path of the executable in the
+	// archive, if it has one to run.
+	Executable string `json:"executable,omitempty"`
+	// Root is the name of the directory the files belong in, e.g. MyApp.app
+	// for a macOS application bundle published from a directory. The files
+	// are stored relative to it.
+	Root string `json:"root,omitempty"`
+	// Files are the files of the archive sorted by path. Directories are
+	// left out, as they are created for the files they hold.
+	Files []ArchiveFile `json:"files"`
+}
+
+// ArchiveFile is a file of an Archive.
+type ArchiveFile struct {
+	Path string `json:"path"`
+	// Checksum is the digest of the content of a regular file, made with
+	// the checksum algorithm of the artifact.
+	Checksum string `json:"checksum,omitempty"`
+	Size     int64  `json:"size"`
+	// Mode holds the Unix permission bits of the file, e.g. 0755.
+	Mode fs.FileMode `json:"mode"`
+	// Link is the target of a symbolic link, which has no content. Files
+	// without a link are regular files.
+	Link string `json:"link,omitempty"`
+}
+
+// Find returns the file of a at path, or nil.
+func (a *Archive) Find(path string) *ArchiveFile {
+	i := sort.Search(len(a.Files), func(i int) bool { return a.Files[i].Path >= path })
+	if i < len(a.Files) && a.Files[i].Path == path {
+		return &a.Files[i]
+	}
+	return nil
+}
+
+// Clone returns a copy of a that shares no files with it.
+func (a *Archive) Clone() *Archive {
+	if a == nil {
+		return nil
+	}
+	clone := *a
+	clone.Files = append([]ArchiveFile(nil), a.Files...)
+	return &clone
+}
+
+// ArchiveFormat returns the archive format of the file name by its
+// extension, or "" if it is no archive.
+func ArchiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return ArchiveTarGz
+	case strings.HasSuffix(name, ".zip"):
+		return ArchiveZip
+	}
+	return ""
+}
+
+// ValidArchivePath reports whether name is a path an archive may hold: slash
+// separated, relative and staying inside the directory it is extracted to.
+func ValidArchivePath(name string) bool {
+	return name != "" && fs.ValidPath(name) && !strings.Contains(name, `\`)
+}
+
+// ReadArchive lists the files of the archive of format in r, which is size
+// bytes long, and hashes them with the checksum algorithm algo. executable,
+// when set, must be the path of a regular file in it.
+func ReadArchive(r io.ReaderAt, size int64, format, executable, algo string) (*Archive, error) {
+	archive := &Archive{Format: format, Executable: executable}
+
+	hasher, err := NewHash(algo)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case ArchiveTarGz:
+		archive.Files, err = readTarGz(io.NewSectionReader(r, 0, size), hasher)
+	case ArchiveZip:
+		archive.Files, err = readZip(r, size, hasher)
+	default:
+		return nil, fmt.Errorf("unknown archive format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s archive: %w", format, err)
+	}
+
+	sort.Slice(archive.Files, func(i, j int) bool { return archive.Files[i].Path < archive.Files[j].Path })
+	for i := 1; i < len(archive.Files); i++ {
+		if archive.Files[i].Path == archive.Files[i-1].Path {
+			return nil, fmt.Errorf("archive holds %s twice", archive.Files[i].Path)
+		}
+	}
+
+	if executable != "" {
+		file := archive.Find(executable)
+		if file == nil || file.Link != "" {
+			return nil, fmt.Errorf("archive has no executable %s", executable)
+		}
+	}
+	return archive, nil
+}
+
+// WriteBundle writes the directory dir to w as a tar.gz archive of the files
+// below it. The archive only depends on the names, content, permissions and
+// link targets of the files, not on their owners or times, so the same
+// bundle always yields the same archive and checksum. It returns the newest
+// modification time of the files.
+func WriteBundle(w io.Writer, dir string) (time.Time, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	var newest time.Time
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == dir {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+
+		header := &tar.Header{Name: name, Mode: int64(info.Mode().Perm()), Format: tar.FormatPAX}
+		switch {
+		case info.IsDir():
+			header.Typeflag, header.Name = tar.TypeDir, name+"/"
+		case info.Mode()&fs.ModeSymlink != 0:
+			if header.Linkname, err = os.Readlink(file); err != nil {
+				return err
+			}
+			header.Typeflag, header.Linkname = tar.TypeSymlink, filepath.ToSlash(header.Linkname)
+		case info.Mode().IsRegular():
+			header.Typeflag, header.Size = tar.TypeReg, info.Size()
+		default:
+			return fmt.Errorf("%s is not a regular file, directory or link", file)
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		content, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		if _, err := io.CopyN(tw, content, header.Size); err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+	if err := gz.Close(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+	return newest, nil
+}
+
+func readTarGz(r io.Reader, hasher hash.Hash) ([]ArchiveFile, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var files []ArchiveFile
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		file, err := archiveFile(header.Name, header.FileInfo().Mode(), header.Linkname, tr, hasher)
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			files = append(files, *file)
+		}
+	}
+}
+
+func readZip(r io.ReaderAt, size int64, hasher hash.Hash) ([]ArchiveFile, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []ArchiveFile
+	for _, entry := range zr.File {
+		content, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", entry.Name, err)
+		}
+
+		var link string
+		mode := entry.Mode()
+		if mode&fs.ModeSymlink != 0 {
+			// zip stores the target of a link as its content
+			target, err := io.ReadAll(io.LimitReader(content, 4096))
+			if err != nil {
+				content.Close()
+				return nil, fmt.Errorf("failed to read %s: %w", entry.Name, err)
+			}
+			link = string(target)
+		}
+
+		file, err := archiveFile(entry.Name, mode, link, content, hasher)
+		content.Close()
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			files = append(files, *file)
+		}
+	}
+	return files, nil
+}
+
+// archiveFile describes the archive entry name of mode, hashing content with
+// hasher if it is a regular file. It returns nil for directories.
+func archiveFile(name string, mode fs.FileMode, link string, content io.Reader, hasher hash.Hash) (*ArchiveFile, error) {
+	name = strings.TrimPrefix(name, "./")
+	if mode.IsDir() {
+		return nil, nil
+	}
+	if !ValidArchivePath(name) {
+		return nil, fmt.Errorf("archive entry %q is not a relative path inside the archive", name)
+	}
+
+	file := &ArchiveFile{Path: name, Mode: mode.Perm()}
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		if link == "" || path.IsAbs(link) || !fs.ValidPath(path.Join(path.Dir(name), link)) {
+			return nil, fmt.Errorf("link %s points outside the archive", name)
+		}
+		file.Link = link
+	case mode.IsRegular():
+		hasher.Reset()
+		n, err := io.Copy(hasher, content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		file.Checksum, file.Size = hex.EncodeToString(hasher.Sum(nil)), n
+	default:
+		return nil, fmt.Errorf("archive entry %s is not a regular file, directory or link", name)
+	}
+	return file, nil
+}
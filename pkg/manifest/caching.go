@@ -0,0 +1,92 @@
+package manifest
+
+import (
+	"mime"
+	"path"
+	"strings"
+
+	"update-manifest/pkg/storage"
+)
+
+// CachePolicy is the Cache-Control header objects are stored with, for the
+// backends that store it. An empty header leaves caching to the CDN.
+type CachePolicy struct {
+	// Mutable is the header of objects that are overwritten: the manifest,
+	// its signatures and TUF metadata, feeds, release notes and the
+	// signatures of artifacts.
+	Mutable string
+	// Immutable is the header of objects whose key names their content:
+	// artifacts, their compressed copies, chunks, checksum files and zsync
+	// control files, patches and manifest backups.
+	Immutable string
+}
+
+// DefaultCachePolicy has caches revalidate overwritten objects after a
+// minute and keep the others for a year.
+var DefaultCachePolicy = CachePolicy{
+	Mutable:   "public, max-age=60",
+	Immutable: "public, max-age=31536000, immutable",
+}
+
+// UseCachePolicy makes the publisher store objects with the Cache-Control
+// headers of c. Objects are stored without one by default.
+func (p *Publisher) UseCachePolicy(c CachePolicy) {
+	p.caching = c
+}
+
+// artifactOptions returns the options the artifact of req is stored with.
+func (p *Publisher) artifactOptions(req ReleaseRequest) storage.PutOptions {
+	return storage.PutOptions{
+		ContentType:        artifactContentType(req),
+		ContentDisposition: artifactDisposition(req),
+		CacheControl:       p.caching.Immutable,
+	}
+}
+
+// artifactTypes are the media types of the files applications are
+// distributed as by extension, which the MIME tables of the system
+// publishing them may not know.
+var artifactTypes = map[string]string{
+	".apk":      "application/vnd.android.package-archive",
+	".appimage": "application/vnd.appimage",
+	".deb":      "application/vnd.debian.binary-package",
+	".dmg":      "application/x-apple-diskimage",
+	".exe":      "application/vnd.microsoft.portable-executable",
+	".gz":       "application/gzip",
+	".msi":      "application/x-msi",
+	".msix":     "application/msix",
+	".rpm":      "application/x-rpm",
+	".tgz":      "application/gzip",
+	".zip":      "application/zip",
+}
+
+// artifactContentType returns the media type of the artifact of req: that
+// of its archive format, or of the extension of its file name, and
+// application/octet-stream if neither is known.
+func artifactContentType(req ReleaseRequest) string {
+	if req.Archive != nil {
+		switch req.Archive.Format {
+		case ArchiveZip:
+			return "application/zip"
+		case ArchiveTarGz:
+			return "application/gzip"
+		}
+	}
+	ext := strings.ToLower(path.Ext(req.Filename))
+	if contentType, ok := artifactTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); ext != "" && contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// artifactDisposition returns the Content-Disposition header the artifact of
+// req is downloaded as its file name with, or "" if it has none.
+func artifactDisposition(req ReleaseRequest) string {
+	if req.Filename == "" {
+		return ""
+	}
+	return mime.FormatMediaType("attachment", map[string]string{"filename": req.Filename})
+}
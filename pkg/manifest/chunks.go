@@ -0,0 +1,129 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"update-manifest/pkg/chunk"
+	"update-manifest/pkg/storage"
+)
+
+// Chunks describes an artifact split into content-defined chunks, which are
+// stored once under a prefix shared by every artifact of the application.
+// A client splits the executable it runs the same way and only downloads the
+// chunks of the artifact it does not hold already, which for large bundles
+// with small changes is far less than the artifact and needs no patch from
+// the version it runs.
+type Chunks struct {
+	// Format names the chunking parameters, chunk.Format.
+	Format string `json:"format"`
+	// Key is the key of the ChunkIndex of the artifact, and Checksum and
+	// Size those of the index object.
+	Key      string `json:"key"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+	// Count is the number of chunks the artifact is made of.
+	Count int `json:"count"`
+	// Prefix is prepended to the checksum of a chunk to make its key.
+	Prefix string `json:"prefix"`
+}
+
+// ChunkIndex lists the chunks an artifact is made of, in order.
+type ChunkIndex struct {
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Chunk is a chunk of an artifact, identified by its checksum.
+type Chunk struct {
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// ChunkIndexKey returns the object key of the chunk index of the artifact
+// stored under key.
+func ChunkIndexKey(key string) string {
+	return key + ".chunks"
+}
+
+// ChunkPrefix returns the prefix of the keys of the chunks of appID.
+func ChunkPrefix(appID string) string {
+	return appID + "/chunks/"
+}
+
+// ChunkKey returns the object key of the chunk with checksum.
+func (c *Chunks) ChunkKey(checksum string) string {
+	return c.Prefix + checksum
+}
+
+// ReadChunkIndex decodes the chunk index read from r.
+func ReadChunkIndex(r io.Reader) (*ChunkIndex, error) {
+	var index ChunkIndex
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to decode chunk index: %w", err)
+	}
+	return &index, nil
+}
+
+// uploadChunks splits the executable stored under key into chunks, uploads
+// those not stored yet and the index listing them.
+func (p *Publisher) uploadChunks(ctx context.Context, key string, executable io.ReadSeeker) (*Chunks, error) {
+	if _, err := executable.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to beginning of executable: %w", err)
+	}
+
+	chunks := &Chunks{
+		Format: chunk.Format,
+		Key:    ChunkIndexKey(key),
+		Prefix: ChunkPrefix(p.appID),
+	}
+	var index ChunkIndex
+	err := chunk.Split(executable, func(data []byte) error {
+		hasher := p.hasher()
+		hasher.Write(data)
+		checksum := hex.EncodeToString(hasher.Sum(nil))
+		index.Chunks = append(index.Chunks, Chunk{Checksum: checksum, Size: int64(len(data))})
+
+		// chunks are content addressed, so a stored chunk of the same
+		// size is the same chunk
+		chunkKey := chunks.ChunkKey(checksum)
+		info, err := p.backend.Stat(ctx, chunkKey)
+		if err == nil && info.Size == int64(len(data)) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return fmt.Errorf("failed to check for existing chunk: %w", err)
+		}
+		if err := p.backend.Put(ctx, chunkKey, bytes.NewReader(data), int64(len(data)), storage.PutOptions{
+			ContentType:  "application/octet-stream",
+			CacheControl: p.caching.Immutable,
+		}); err != nil {
+			return fmt.Errorf("failed to upload chunk: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(&index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode chunk index: %w", err)
+	}
+	hasher := p.hasher()
+	hasher.Write(data)
+	chunks.Checksum = hex.EncodeToString(hasher.Sum(nil))
+	chunks.Size = int64(len(data))
+	chunks.Count = len(index.Chunks)
+	if err := p.backend.Put(ctx, chunks.Key, bytes.NewReader(data), chunks.Size, storage.PutOptions{
+		ContentType:  "application/json",
+		CacheControl: p.caching.Immutable,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to upload chunk index: %w", err)
+	}
+	return chunks, nil
+}
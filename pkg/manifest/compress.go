@@ -0,0 +1,75 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"update-manifest/pkg/storage"
+)
+
+// CompressionZstd is the format of zstd compressed copies of artifacts.
+const CompressionZstd = "zstd"
+
+// Compressed is a compressed copy of an artifact, published next to it so
+// clients on slow links can download less. Its checksum and size are those
+// of the compressed object; the decompressed content has the checksum and
+// size of the artifact.
+type Compressed struct {
+	Format   string `json:"format"`
+	Key      string `json:"key"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// CompressedKey returns the object key of the zstd compressed copy of the
+// artifact stored under key.
+func CompressedKey(key string) string {
+	return key + ".zst"
+}
+
+// uploadCompressed compresses the executable stored under key with zstd and
+// uploads the copy. It returns nil if compressing does not make the
+// executable smaller, as the copy would only cost storage.
+func (p *Publisher) uploadCompressed(ctx context.Context, key string, executable io.ReadSeeker, size int64) (*Compressed, error) {
+	if _, err := executable.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to beginning of executable: %w", err)
+	}
+
+	var compressed bytes.Buffer
+	encoder, err := zstd.NewWriter(&compressed, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress executable: %w", err)
+	}
+	if _, err := io.Copy(encoder, executable); err != nil {
+		encoder.Close()
+		return nil, fmt.Errorf("failed to compress executable: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress executable: %w", err)
+	}
+	if int64(compressed.Len()) >= size {
+		return nil, nil
+	}
+
+	hasher := p.hasher()
+	hasher.Write(compressed.Bytes())
+	checksum := hasher.Sum(nil)
+	uploaded := &Compressed{
+		Format:   CompressionZstd,
+		Key:      CompressedKey(key),
+		Checksum: hex.EncodeToString(checksum),
+		Size:     int64(compressed.Len()),
+	}
+	if err := p.backend.Put(ctx, uploaded.Key, bytes.NewReader(compressed.Bytes()), uploaded.Size, storage.PutOptions{
+		ContentType:  "application/zstd",
+		CacheControl: p.caching.Immutable,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to upload compressed artifact: %w", err)
+	}
+	return uploaded, nil
+}
@@ -0,0 +1,239 @@
+package manifest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Change kinds.
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Change is one difference between two manifests.
+type Change struct {
+	Channel string `json:"channel"`
+	// Platform is set for changes to an artifact, and Variant or
+	// ArtifactKind for changes to one of its variants or kinds.
+	Platform     string `json:"platform,omitempty"`
+	Variant      string `json:"variant,omitempty"`
+	ArtifactKind string `json:"artifact_kind,omitempty"`
+	// Field names what changed, e.g. version or checksum. It is empty when
+	// the whole channel or platform was added or removed.
+	Field string `json:"field,omitempty"`
+	Kind  string `json:"kind"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	where := c.Channel
+	if c.Platform != "" {
+		where += " " + c.Platform
+	}
+	if c.Variant != "" {
+		where += " " + c.Variant
+	}
+	if c.ArtifactKind != "" {
+		where += " " + c.ArtifactKind
+	}
+
+	subject := c.Field
+	switch {
+	case subject != "":
+	case c.Variant != "":
+		subject = "variant"
+	case c.ArtifactKind != "":
+		subject = "kind"
+	case c.Platform != "":
+		subject = "platform"
+	default:
+		subject = "channel"
+	}
+
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("%s: %s added %s", where, subject, c.New)
+	case Removed:
+		return fmt.Sprintf("%s: %s removed %s", where, subject, c.Old)
+	}
+	return fmt.Sprintf("%s: %s %s -> %s", where, subject, c.Old, c.New)
+}
+
+// Diff returns the changes from before to after per channel and platform,
+// ordered by channel and platform name.
+func Diff(before, after *Manifest) []Change {
+	var changes []Change
+	for _, name := range unionNames(before.Channel, after.Channel) {
+		o, n := before.Channel[name], after.Channel[name]
+		switch {
+		case o == nil && n == nil:
+			continue
+		case o == nil:
+			changes = append(changes, Change{Channel: name, Kind: Added, New: n.Version})
+			continue
+		case n == nil:
+			changes = append(changes, Change{Channel: name, Kind: Removed, Old: o.Version})
+			continue
+		}
+		changes = append(changes, diffChannel(name, o, n)...)
+	}
+	return changes
+}
+
+// diffChannel returns the changes between two states of the named channel.
+func diffChannel(name string, o, n *Channel) []Change {
+	var changes []Change
+	field := func(field, before, after string) {
+		if before != after {
+			changes = append(changes, Change{Channel: name, Field: field, Kind: Changed, Old: before, New: after})
+		}
+	}
+
+	field("version", o.Version, n.Version)
+	if o.Version == n.Version && !o.Build.Equal(n.Build) {
+		field("build", o.Build.UTC().Format(time.RFC3339), n.Build.UTC().Format(time.RFC3339))
+	}
+	field("rollout", rolloutState(&o.Release), rolloutState(&n.Release))
+	field("yanked", strconv.FormatBool(o.Yanked), strconv.FormatBool(n.Yanked))
+	field("min_version", o.MinVersion, n.MinVersion)
+	field("mandatory", strconv.FormatBool(o.Mandatory), strconv.FormatBool(n.Mandatory))
+
+	for _, platform := range unionNames(o.Artifact, n.Artifact) {
+		changes = append(changes, diffArtifact(Change{Channel: name, Platform: platform}, o.Artifact[platform], n.Artifact[platform])...)
+	}
+
+	recorded := make(map[string]bool)
+	for _, release := range o.Releases {
+		recorded[release.Version] = true
+	}
+	for _, release := range n.Releases {
+		if !recorded[release.Version] {
+			changes = append(changes, Change{Channel: name, Field: "release", Kind: Added, New: release.Version})
+		}
+		delete(recorded, release.Version)
+	}
+	for _, release := range o.Releases {
+		if recorded[release.Version] {
+			changes = append(changes, Change{Channel: name, Field: "release", Kind: Removed, Old: release.Version})
+		}
+	}
+	return changes
+}
+
+// diffArtifact returns the changes between two states of the artifact at the
+// channel and platform, or variant, of at.
+func diffArtifact(at Change, o, n *Artifact) []Change {
+	change := func(kind, field, before, after string) Change {
+		c := at
+		c.Kind, c.Field, c.Old, c.New = kind, field, before, after
+		return c
+	}
+
+	switch {
+	case o == nil && n == nil:
+		return nil
+	case o == nil:
+		return []Change{change(Added, "", "", n.Checksum)}
+	case n == nil:
+		return []Change{change(Removed, "", o.Checksum, "")}
+	}
+
+	var changes []Change
+	field := func(field, before, after string) {
+		if before != after {
+			changes = append(changes, change(Changed, field, before, after))
+		}
+	}
+	field("checksum", o.Checksum, n.Checksum)
+	field("checksum_algo", o.HashAlgorithm(), n.HashAlgorithm())
+	field("binary", o.Binary, n.Binary)
+	field("sidecar", o.Sidecar, n.Sidecar)
+	field("zsync", o.Zsync, n.Zsync)
+	field("signed_by", strings.Join(o.SignedBy, ","), strings.Join(n.SignedBy, ","))
+	field("signatures", strings.Join(sortedNames(o.Signatures), ","), strings.Join(sortedNames(n.Signatures), ","))
+	field("patch", o.Patch, n.Patch)
+	field("patches", patchKeys(o.Patches), patchKeys(n.Patches))
+	field("min_os", o.MinOS, n.MinOS)
+	field("archive", archiveState(o.Archive), archiveState(n.Archive))
+	field("compressed", compressedKey(o.Compressed), compressedKey(n.Compressed))
+	field("chunks", chunksKey(o.Chunks), chunksKey(n.Chunks))
+
+	if at.Variant != "" || at.ArtifactKind != "" {
+		return changes
+	}
+	for _, variant := range unionNames(o.Variants, n.Variants) {
+		at := at
+		at.Variant = variant
+		changes = append(changes, diffArtifact(at, o.Variants[variant], n.Variants[variant])...)
+	}
+	for _, kind := range unionNames(o.Kinds, n.Kinds) {
+		at := at
+		at.ArtifactKind = kind
+		changes = append(changes, diffArtifact(at, o.Kinds[kind], n.Kinds[kind])...)
+	}
+	return changes
+}
+
+// unionNames returns the keys of a and b in lexical order.
+func unionNames[V any](a, b map[string]V) []string {
+	names := make(map[string]bool, len(a)+len(b))
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+	return sortedNames(names)
+}
+
+// archiveState describes the archive format and executable of archive.
+func archiveState(archive *Archive) string {
+	switch {
+	case archive == nil:
+		return ""
+	case archive.Executable == "":
+		return archive.Format
+	}
+	return archive.Format + " " + archive.Executable
+}
+
+// compressedKey returns the key of the compressed copy, or "" if there is none.
+func compressedKey(compressed *Compressed) string {
+	if compressed == nil {
+		return ""
+	}
+	return compressed.Key
+}
+
+// chunksKey returns the key of the chunk index, or "" if there is none.
+func chunksKey(chunks *Chunks) string {
+	if chunks == nil {
+		return ""
+	}
+	return chunks.Key
+}
+
+// patchKeys joins the keys of patches.
+func patchKeys(patches []Patch) string {
+	keys := make([]string, len(patches))
+	for i, delta := range patches {
+		keys[i] = delta.Key
+	}
+	return strings.Join(keys, ",")
+}
+
+// rolloutState describes the share of devices release is offered to.
+func rolloutState(release *Release) string {
+	switch {
+	case release.RolloutPaused:
+		return "paused"
+	case release.Rollout != nil:
+		return fmt.Sprintf("%d%%", *release.Rollout)
+	}
+	return "100%"
+}
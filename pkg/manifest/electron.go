@@ -0,0 +1,206 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"update-manifest/pkg/storage"
+)
+
+// ElectronFeed selects the artifacts offered by the electron-updater feeds
+// Save writes for every channel.
+type ElectronFeed struct {
+	// BaseURL is the public URL of the bucket. electron-updater downloads
+	// the artifacts from their keys resolved against it.
+	BaseURL string
+	// Kind, when set, offers the artifact of that kind of each platform that
+	// has one, e.g. the NSIS installer, instead of the artifact itself.
+	Kind string
+}
+
+// ElectronKey returns the object key of the electron-updater feed file name
+// of channel, e.g. latest-mac.yml. The directory of the files is the URL of
+// the generic provider of electron-updater.
+func ElectronKey(appID, channel, name string) string {
+	return fmt.Sprintf("%s/electron/%s/%s", appID, channel, name)
+}
+
+// electronUpdateInfo is the content of a feed file, named by the platforms
+// it is read on.
+type electronUpdateInfo struct {
+	Version              string             `yaml:"version"`
+	Files                []electronFileInfo `yaml:"files"`
+	Path                 string             `yaml:"path"`
+	SHA512               string             `yaml:"sha512,omitempty"`
+	SHA2                 string             `yaml:"sha2,omitempty"`
+	ReleaseDate          string             `yaml:"releaseDate,omitempty"`
+	ReleaseNotes         string             `yaml:"releaseNotes,omitempty"`
+	StagingPercentage    *int               `yaml:"stagingPercentage,omitempty"`
+	MinimumSystemVersion string             `yaml:"minimumSystemVersion,omitempty"`
+}
+
+type electronFileInfo struct {
+	URL    string `yaml:"url"`
+	SHA512 string `yaml:"sha512,omitempty"`
+	SHA2   string `yaml:"sha2,omitempty"`
+	Size   int64  `yaml:"size,omitempty"`
+}
+
+// WriteElectronFeeds makes Save also write the latest.yml, latest-mac.yml
+// and latest-linux.yml files of electron-updater for the current release of
+// every channel under ElectronKey. electron-updater only verifies sha256 and
+// sha512 checksums, so artifacts must be hashed with either. It tells apart
+// the mac builds for Intel and Apple Silicon by arm64 in their URL, which
+// artifact keys contain when laid out by a KeyTemplate with {platform}.
+func (p *Publisher) WriteElectronFeeds(feed ElectronFeed) {
+	p.electron = &feed
+}
+
+// electronFeedName returns the name of the feed file electron-updater reads
+// on platform, or "" if it does not update on it. Linux builds are told
+// apart by the Node.js name of their architecture.
+func electronFeedName(platform string) string {
+	normalized, err := NormalizePlatform(platform)
+	if err != nil {
+		return ""
+	}
+	goos, arch, _ := strings.Cut(normalized, "/")
+	switch goos {
+	case "windows":
+		return "latest.yml"
+	case "darwin":
+		return "latest-mac.yml"
+	case "linux":
+		switch arch {
+		case "amd64":
+			return "latest-linux.yml"
+		case "386":
+			return "latest-linux-ia32.yml"
+		}
+		return "latest-linux-" + arch + ".yml"
+	}
+	return ""
+}
+
+// writeElectronFeeds uploads the feed files of the current release of every
+// channel, and deletes those of a channel whose releases are all yanked.
+func (p *Publisher) writeElectronFeeds(ctx context.Context) error {
+	for _, name := range sortedNames(p.manifest.Channel) {
+		channel := p.manifest.Channel[name]
+		release := channel.Current()
+		remove := release.Yanked || release.Version == ""
+
+		feeds := make(map[string]*electronUpdateInfo)
+		for _, platform := range sortedNames(release.Artifact) {
+			file := electronFeedName(platform)
+			artifact := release.Artifact[platform]
+			if kind := artifact.Select(p.electron.Kind, ""); kind != nil {
+				artifact = kind
+			}
+			if file == "" || artifact.Binary == "" {
+				continue
+			}
+			if remove {
+				feeds[file] = nil
+				continue
+			}
+
+			info, ok := feeds[file]
+			if !ok {
+				info = newElectronUpdateInfo(release)
+				feeds[file] = info
+			}
+			fileInfo, err := p.electronFileInfo(platform, artifact)
+			if err != nil {
+				return err
+			}
+			// the Windows updater installs the first file, which should be
+			// the most common build
+			if strings.HasSuffix(platform, "amd64") {
+				info.Files = append([]electronFileInfo{fileInfo}, info.Files...)
+			} else {
+				info.Files = append(info.Files, fileInfo)
+			}
+			if info.MinimumSystemVersion == "" {
+				info.MinimumSystemVersion = artifact.MinOS
+			}
+		}
+
+		for _, file := range sortedNames(feeds) {
+			key := ElectronKey(p.appID, name, file)
+			if remove {
+				if err := p.backend.Delete(ctx, key); err != nil {
+					return fmt.Errorf("failed to delete electron-updater feed %s: %w", key, err)
+				}
+				continue
+			}
+
+			// clients of electron-updater before version 4 read the first
+			// file from the top level fields
+			info := feeds[file]
+			info.Path, info.SHA512, info.SHA2 = info.Files[0].URL, info.Files[0].SHA512, info.Files[0].SHA2
+			var data bytes.Buffer
+			encoder := yaml.NewEncoder(&data)
+			encoder.SetIndent(2)
+			if err := encoder.Encode(info); err != nil {
+				return fmt.Errorf("failed to encode electron-updater feed %s: %w", key, err)
+			}
+			if err := p.backend.Put(ctx, key, &data, int64(data.Len()), storage.PutOptions{
+				ContentType:  "text/yaml; charset=utf-8",
+				CacheControl: p.caching.Mutable,
+			}); err != nil {
+				return fmt.Errorf("failed to upload electron-updater feed %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// newElectronUpdateInfo returns the feed of release without files. A rollout
+// is offered to the same share of clients by electron-updater, which picks
+// them by an ID of its own.
+func newElectronUpdateInfo(release *Release) *electronUpdateInfo {
+	info := &electronUpdateInfo{
+		Version:      release.Version,
+		ReleaseNotes: release.Notes,
+	}
+	if !release.Build.IsZero() {
+		info.ReleaseDate = release.Build.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+	switch {
+	case release.RolloutPaused:
+		info.StagingPercentage = new(int)
+	case release.Rollout != nil && *release.Rollout < 100:
+		percentage := *release.Rollout
+		info.StagingPercentage = &percentage
+	}
+	return info
+}
+
+// electronFileInfo describes artifact of platform as a file of a feed, with
+// its checksum in the base64 form of sha512 or the hex one of sha2.
+func (p *Publisher) electronFileInfo(platform string, artifact *Artifact) (electronFileInfo, error) {
+	info := electronFileInfo{
+		URL:  strings.TrimSuffix(p.electron.BaseURL, "/") + "/" + artifact.Binary,
+		Size: artifact.Size,
+	}
+	switch artifact.HashAlgorithm() {
+	case HashSHA512:
+		digest, err := hex.DecodeString(artifact.Checksum)
+		if err != nil {
+			return electronFileInfo{}, fmt.Errorf("invalid checksum of the artifact of %s: %w", platform, err)
+		}
+		info.SHA512 = base64.StdEncoding.EncodeToString(digest)
+	case HashSHA256:
+		info.SHA2 = artifact.Checksum
+	default:
+		return electronFileInfo{}, fmt.Errorf("electron-updater cannot verify the %s checksum of the artifact of %s, publish it with sha256 or sha512", artifact.HashAlgorithm(), platform)
+	}
+	return info, nil
+}
@@ -0,0 +1,140 @@
+package manifest
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+	"lukechampine.com/blake3"
+)
+
+// Checksum algorithms artifacts can be hashed with. The BLAKE2b and BLAKE3
+// digests are 256 bits long.
+const (
+	HashBlake2b = "blake2b"
+	HashSHA256  = "sha256"
+	HashSHA512  = "sha512"
+	HashBlake3  = "blake3"
+)
+
+// DefaultHashAlgorithm hashes artifacts unless another algorithm is chosen.
+// Checksums recorded without an algorithm were made with it.
+const DefaultHashAlgorithm = HashBlake2b
+
+// NewHash returns a hash computing checksums with algo, or with
+// DefaultHashAlgorithm when algo is empty.
+func NewHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case HashBlake2b, "":
+		return blake2b.New256(nil)
+	case HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	case HashBlake3:
+		return blake3.New(32, nil), nil
+	}
+	return nil, fmt.Errorf("unknown checksum algorithm %q, want blake2b, sha256, sha512 or blake3", algo)
+}
+
+// HashAlgorithm returns the algorithm of the checksums of a.
+func (a *Artifact) HashAlgorithm() string {
+	if a.ChecksumAlgo == "" {
+		return DefaultHashAlgorithm
+	}
+	return a.ChecksumAlgo
+}
+
+// hasher returns a hash of the algorithm the publisher hashes artifacts
+// with, which UseHashAlgorithm made sure is known.
+func (p *Publisher) hasher() hash.Hash {
+	hasher, _ := NewHash(p.hash)
+	return hasher
+}
+
+// hashingReader hashes an executable while it is uploaded, so it is read from
+// disk once. The hash only covers the executable if it was read from start
+// to end in one go; seeking back to the start begins it anew.
+type hashingReader struct {
+	r      io.ReadSeeker
+	hasher hash.Hash
+	offset int64
+	valid  bool
+}
+
+func newHashingReader(r io.ReadSeeker, hasher hash.Hash) *hashingReader {
+	return &hashingReader{r: r, hasher: hasher, valid: true}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if h.valid {
+		h.hasher.Write(p[:n])
+	}
+	h.offset += int64(n)
+	return n, err
+}
+
+func (h *hashingReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := h.r.Seek(offset, whence)
+	if err != nil {
+		h.valid = false
+		return position, err
+	}
+
+	switch position {
+	case h.offset:
+	case 0:
+		h.hasher.Reset()
+		h.valid = true
+	default:
+		h.valid = false
+	}
+	h.offset = position
+	return position, nil
+}
+
+// checksum returns the hex checksum of the size bytes of the executable.
+// Should the upload not have read them in one go, they are read again.
+func (h *hashingReader) checksum(size int64) (string, error) {
+	if !h.valid || h.offset != size {
+		if _, err := h.r.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		h.hasher.Reset()
+		if _, err := io.Copy(h.hasher, h.r); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.hasher.Sum(nil)), nil
+}
+
+// verifyUpload downloads the object at key and checks that it has the given
+// checksum made with algo. An object that does not is deleted, so publishing
+// again uploads it anew.
+func (p *Publisher) verifyUpload(ctx context.Context, key, algo, checksum string) error {
+	reader, _, err := p.backend.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to download %s for verification: %w", key, err)
+	}
+	defer reader.Close()
+
+	hasher, err := NewHash(algo)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return fmt.Errorf("failed to download %s for verification: %w", key, err)
+	}
+
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != checksum {
+		p.backend.Delete(context.WithoutCancel(ctx), key)
+		return fmt.Errorf("uploaded %s is corrupted: checksum %s does not match %s", key, actual, checksum)
+	}
+	return nil
+}
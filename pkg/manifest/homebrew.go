@@ -0,0 +1,249 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// HomebrewPackage describes the cask or formula the Homebrew definition of a
+// release is rendered as.
+type HomebrewPackage struct {
+	// Name is the token of the cask or the name of the formula, e.g. my-app.
+	Name string
+	// Cask renders a cask installing a macOS application instead of a
+	// formula installing an executable on macOS and Linux.
+	Cask bool
+	// Description and Homepage are required by Homebrew. License is the
+	// SPDX identifier of the license of a formula.
+	Description string
+	Homepage    string
+	License     string
+	// BaseURL is the public URL of the bucket. Homebrew downloads the
+	// artifacts from their keys resolved against it.
+	BaseURL string
+	// Kind, when set, installs the artifact of that kind, e.g. a dmg of the
+	// application, instead of the artifact itself, leaving out the platforms
+	// without one.
+	Kind string
+	// App is the application bundle a cask installs, e.g. MyApp.app.
+	App string
+	// Binary is the name a formula installs its executable as, Name if
+	// empty.
+	Binary string
+}
+
+// HomebrewPath returns the path of the definition of pkg in a tap, e.g.
+// Formula/my-app.rb.
+func HomebrewPath(pkg HomebrewPackage) string {
+	if pkg.Cask {
+		return path.Join("Casks", pkg.Name+".rb")
+	}
+	return path.Join("Formula", pkg.Name+".rb")
+}
+
+// homebrewArch names the architectures of Homebrew by GOARCH.
+var homebrewArch = map[string]string{
+	"arm64": "arm",
+	"amd64": "intel",
+}
+
+// homebrewMacOS names the macOS versions of Homebrew by their major version.
+var homebrewMacOS = map[string]string{
+	"10.15": "catalina",
+	"11":    "big_sur",
+	"12":    "monterey",
+	"13":    "ventura",
+	"14":    "sonoma",
+	"15":    "sequoia",
+	"26":    "tahoe",
+}
+
+// homebrewDownload is the artifact of an architecture a definition
+// downloads.
+type homebrewDownload struct {
+	arch     string
+	url      string
+	sha256   string
+	artifact *Artifact
+}
+
+// Homebrew renders the cask or formula of release as pkg, the content of
+// its HomebrewPath. A cask downloads the artifact of the Apple Silicon and
+// Intel macOS platforms of the release, a formula also those of Linux.
+// Homebrew verifies downloads by their SHA-256 digest, so artifacts hashed
+// with another algorithm are downloaded to compute it.
+func (p *Publisher) Homebrew(ctx context.Context, release *Release, pkg HomebrewPackage) ([]byte, error) {
+	if pkg.Name == "" || strings.ToLower(pkg.Name) != pkg.Name || strings.ContainsAny(pkg.Name, " /\\\"") {
+		return nil, fmt.Errorf("invalid Homebrew name %q, want a lowercase name, e.g. my-app", pkg.Name)
+	}
+	if release.Version == "" {
+		return nil, errors.New("the release has no version")
+	}
+	if pkg.Description == "" || pkg.Homepage == "" {
+		return nil, errors.New("a Homebrew package needs its description and homepage")
+	}
+	if pkg.Cask && pkg.App == "" {
+		return nil, errors.New("a cask needs the application bundle it installs")
+	}
+
+	downloads := make(map[string][]homebrewDownload)
+	for _, platform := range sortedNames(release.Artifact) {
+		normalized, err := NormalizePlatform(platform)
+		if err != nil {
+			continue
+		}
+		goos, arch, _ := strings.Cut(normalized, "/")
+		artifact := release.Artifact[platform].Select(pkg.Kind, "")
+		if goos != "darwin" && (pkg.Cask || goos != "linux") || homebrewArch[arch] == "" || artifact == nil || artifact.Binary == "" {
+			continue
+		}
+
+		digest, err := p.sha256(ctx, artifact)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash the artifact of %s: %w", platform, err)
+		}
+		downloads[goos] = append(downloads[goos], homebrewDownload{
+			arch:     homebrewArch[arch],
+			url:      strings.TrimSuffix(pkg.BaseURL, "/") + "/" + artifact.Binary,
+			sha256:   digest,
+			artifact: artifact,
+		})
+	}
+	if len(downloads) == 0 {
+		return nil, fmt.Errorf("release %s has no artifact Homebrew installs", release.Version)
+	}
+
+	var b strings.Builder
+	if pkg.Cask {
+		writeHomebrewCask(&b, release, pkg, downloads["darwin"])
+	} else {
+		writeHomebrewFormula(&b, release, pkg, downloads)
+	}
+	return []byte(b.String()), nil
+}
+
+// writeHomebrewCask writes the cask downloading downloads.
+func writeHomebrewCask(b *strings.Builder, release *Release, pkg HomebrewPackage, downloads []homebrewDownload) {
+	fmt.Fprintf(b, "cask %s do\n", rubyString(pkg.Name))
+	fmt.Fprintf(b, "  version %s\n\n", rubyString(release.Version))
+	if len(downloads) == 1 {
+		fmt.Fprintf(b, "  sha256 %s\n", rubyString(downloads[0].sha256))
+		fmt.Fprintf(b, "  url %s\n", rubyString(downloads[0].url))
+	} else {
+		writeHomebrewArchs(b, "  ", downloads)
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(b, "  name %s\n", rubyString(strings.TrimSuffix(pkg.App, ".app")))
+	fmt.Fprintf(b, "  desc %s\n", rubyString(pkg.Description))
+	fmt.Fprintf(b, "  homepage %s\n\n", rubyString(pkg.Homepage))
+
+	var depends bool
+	if len(downloads) == 1 {
+		arch := "x86_64"
+		if downloads[0].arch == "arm" {
+			arch = "arm64"
+		}
+		fmt.Fprintf(b, "  depends_on arch: :%s\n", arch)
+		depends = true
+	}
+	// macOS versions are named by their major version since Big Sur
+	major, minor, _ := strings.Cut(downloads[0].artifact.MinOS, ".")
+	if major == "10" {
+		major += "." + strings.SplitN(minor, ".", 2)[0]
+	}
+	if name, ok := homebrewMacOS[major]; ok {
+		fmt.Fprintf(b, "  depends_on macos: \">= :%s\"\n", name)
+		depends = true
+	}
+	if depends {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(b, "  app %s\n", rubyString(pkg.App))
+	b.WriteString("end\n")
+}
+
+// writeHomebrewFormula writes the formula downloading downloads of every
+// operating system.
+func writeHomebrewFormula(b *strings.Builder, release *Release, pkg HomebrewPackage, downloads map[string][]homebrewDownload) {
+	fmt.Fprintf(b, "class %s < Formula\n", homebrewClass(pkg.Name))
+	fmt.Fprintf(b, "  desc %s\n", rubyString(pkg.Description))
+	fmt.Fprintf(b, "  homepage %s\n", rubyString(pkg.Homepage))
+	fmt.Fprintf(b, "  version %s\n", rubyString(release.Version))
+	if pkg.License != "" {
+		fmt.Fprintf(b, "  license %s\n", rubyString(pkg.License))
+	}
+	b.WriteString("\n")
+	for _, goos := range []string{"darwin", "linux"} {
+		if len(downloads[goos]) == 0 {
+			continue
+		}
+		if goos == "darwin" {
+			b.WriteString("  on_macos do\n")
+		} else {
+			b.WriteString("  on_linux do\n")
+		}
+		writeHomebrewArchs(b, "    ", downloads[goos])
+		b.WriteString("  end\n\n")
+	}
+
+	binary := pkg.Binary
+	if binary == "" {
+		binary = pkg.Name
+	}
+	// a download of the executable itself is staged under the name of its
+	// key, which content addressed keys make unknown until then
+	var executable string
+	for _, goos := range []string{"darwin", "linux"} {
+		if len(downloads[goos]) == 0 || executable != "" {
+			continue
+		}
+		if archive := downloads[goos][0].artifact.Archive; archive != nil && archive.Executable != "" {
+			executable = rubyString(archive.Executable)
+		}
+	}
+	if executable == "" {
+		executable = `Dir["*"].first`
+	}
+	b.WriteString("  def install\n")
+	fmt.Fprintf(b, "    bin.install %s => %s\n", executable, rubyString(binary))
+	b.WriteString("  end\n")
+	b.WriteString("end\n")
+}
+
+// writeHomebrewArchs writes the on_arm and on_intel blocks of downloads,
+// indented by indent.
+func writeHomebrewArchs(b *strings.Builder, indent string, downloads []homebrewDownload) {
+	for _, arch := range []string{"arm", "intel"} {
+		for _, download := range downloads {
+			if download.arch != arch {
+				continue
+			}
+			fmt.Fprintf(b, "%son_%s do\n", indent, arch)
+			fmt.Fprintf(b, "%s  sha256 %s\n", indent, rubyString(download.sha256))
+			fmt.Fprintf(b, "%s  url %s\n", indent, rubyString(download.url))
+			fmt.Fprintf(b, "%send\n", indent)
+		}
+	}
+}
+
+// homebrewClass returns the class name Homebrew expects of the formula name,
+// e.g. MyApp for my-app.
+func homebrewClass(name string) string {
+	var class strings.Builder
+	for _, word := range strings.FieldsFunc(strings.ReplaceAll(name, "@", "-AT-"), func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	}) {
+		class.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return class.String()
+}
+
+// rubyString returns s as a double quoted Ruby string literal, escaping
+// interpolation.
+func rubyString(s string) string {
+	return strings.ReplaceAll(strconv.Quote(s), "#", `\#`)
+}
@@ -0,0 +1,184 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage"
+)
+
+// KeyList names the keys the manifests of an application are signed with.
+// It is published signed by every one of them, so clients trusting a key
+// being rotated out learn of its successor from a list that key signed.
+// Rotating keys therefore takes a save signed with both the old and the new
+// keys before the old ones are dropped.
+type KeyList struct {
+	AppID string `json:"app_id"`
+	// Version increases with every change of the keys, so clients refuse to
+	// go back to an older list.
+	Version int64       `json:"version"`
+	Keys    []ListedKey `json:"keys"`
+	// Threshold is the number of the keys the manifest, and the next key
+	// list, need signatures of, 1 when 0.
+	Threshold int `json:"threshold,omitempty"`
+}
+
+// ListedKey is a key of a KeyList.
+type ListedKey struct {
+	ID        string `json:"id"`
+	Algorithm string `json:"algorithm"`
+	// PublicKey is the base64 encoded PKIX DER public key.
+	PublicKey string `json:"public_key"`
+}
+
+// RequiredSignatures returns the number of keys of l the manifest needs
+// signatures of.
+func (l *KeyList) RequiredSignatures() int {
+	return max(l.Threshold, 1)
+}
+
+// verify checks that envelope holds signatures of data by as many keys of l
+// as it requires.
+func (l *KeyList) verify(data []byte, envelope *signing.Envelope) error {
+	keys, err := l.PublicKeys()
+	if err != nil {
+		return err
+	}
+	return signing.VerifyThreshold(data, envelope, l.RequiredSignatures(), keys...)
+}
+
+// KeyListKey returns the object key of the key list of appID.
+func KeyListKey(appID string) string {
+	return fmt.Sprintf("%s/keys.json", appID)
+}
+
+// KeyListSignatureKey returns the object key of the detached signature of
+// the key list of appID.
+func KeyListSignatureKey(appID string) string {
+	return KeyListKey(appID) + ".sig"
+}
+
+// PublicKeys parses the keys of l, checking that they carry the IDs they are
+// listed under.
+func (l *KeyList) PublicKeys() ([]signing.PublicKey, error) {
+	keys := make([]signing.PublicKey, 0, len(l.Keys))
+	for _, listed := range l.Keys {
+		key, err := signing.ParsePublicKey(listed.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", listed.ID, err)
+		}
+		if key.ID != listed.ID || key.Algorithm != listed.Algorithm {
+			return nil, fmt.Errorf("key %s is listed as %s %s", key.ID, listed.Algorithm, listed.ID)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// LoadKeyList returns the key list of appID published in backend, or nil if
+// there is none. Its signature is not verified: that is up to clients, with
+// the keys they trust.
+func LoadKeyList(ctx context.Context, backend storage.Backend, appID string) (*KeyList, error) {
+	reader, _, err := backend.Get(ctx, KeyListKey(appID))
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key list: %w", err)
+	}
+	defer reader.Close()
+
+	var list KeyList
+	if err := json.NewDecoder(reader).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode key list: %w", err)
+	}
+	return &list, nil
+}
+
+// writeKeyList publishes the list of the keys of the signers and cosigners of
+// the publisher, signed by the signers, unless the published list already
+// names exactly those keys.
+func (p *Publisher) writeKeyList(ctx context.Context) error {
+	data, err := p.keyList(ctx)
+	if err != nil || data == nil {
+		return err
+	}
+	envelope, err := signing.Sign(ctx, data, p.signers...)
+	if err != nil {
+		return err
+	}
+	return p.putKeyList(ctx, data, envelope)
+}
+
+// keyList returns the encoded list of the keys of the signers and cosigners
+// of the publisher, or nil if the published list already names exactly those
+// keys with the same threshold.
+func (p *Publisher) keyList(ctx context.Context) ([]byte, error) {
+	published, err := LoadKeyList(ctx, p.backend, p.appID)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &KeyList{AppID: p.appID, Version: 1, Threshold: p.threshold}
+	keys := slices.Clone(p.cosigners)
+	// without a policy of its own the publisher keeps that of a published
+	// list needing several signatures, rather than dropping the keys of the
+	// cosigners by accident
+	if published != nil && published.RequiredSignatures() > 1 && p.threshold == 0 && len(p.cosigners) == 0 {
+		if keys, err = published.PublicKeys(); err != nil {
+			return nil, err
+		}
+		list.Threshold = published.Threshold
+	}
+	for _, signer := range p.signers {
+		keys = append(keys, signer.Public())
+	}
+	for _, key := range keys {
+		if slices.ContainsFunc(list.Keys, func(listed ListedKey) bool { return listed.ID == key.ID }) {
+			continue
+		}
+		der, err := x509.MarshalPKIXPublicKey(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode key %s: %w", key.ID, err)
+		}
+		list.Keys = append(list.Keys, ListedKey{ID: key.ID, Algorithm: key.Algorithm, PublicKey: base64.StdEncoding.EncodeToString(der)})
+	}
+	slices.SortFunc(list.Keys, func(a, b ListedKey) int { return strings.Compare(a.ID, b.ID) })
+	if published != nil {
+		if slices.Equal(published.Keys, list.Keys) && published.RequiredSignatures() == list.RequiredSignatures() {
+			return nil, nil
+		}
+		list.Version = published.Version + 1
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key list: %w", err)
+	}
+	return data, nil
+}
+
+// putKeyList uploads the encoded key list data and its signature envelope.
+func (p *Publisher) putKeyList(ctx context.Context, data []byte, envelope *signing.Envelope) error {
+	marshaledEnvelope, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key list signature: %w", err)
+	}
+
+	opts := storage.PutOptions{ContentType: "application/json", CacheControl: p.caching.Mutable}
+	if err := p.backend.Put(ctx, KeyListKey(p.appID), bytes.NewReader(data), int64(len(data)), opts); err != nil {
+		return fmt.Errorf("failed to upload key list: %w", err)
+	}
+	if err := p.backend.Put(ctx, KeyListSignatureKey(p.appID), bytes.NewReader(marshaledEnvelope), int64(len(marshaledEnvelope)), opts); err != nil {
+		return fmt.Errorf("failed to upload key list signature: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KeyTemplate lays out the object keys of artifacts. It is a key with
+// placeholders in braces: {app}, {channel}, {version}, {platform} and
+// {checksum}, which every template must contain so that artifacts with
+// different content never share a key.
+type KeyTemplate string
+
+// DefaultKeyTemplate is the layout ArtifactKey produces.
+const DefaultKeyTemplate KeyTemplate = "{app}/artifact/{checksum}"
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ParseKeyTemplate checks that s is a valid KeyTemplate.
+func ParseKeyTemplate(s string) (KeyTemplate, error) {
+	if !strings.Contains(s, "{checksum}") {
+		return "", fmt.Errorf("key template %q does not contain {checksum}", s)
+	}
+	for _, placeholder := range placeholderPattern.FindAllString(s, -1) {
+		switch placeholder {
+		case "{app}", "{channel}", "{version}", "{platform}", "{checksum}":
+		default:
+			return "", fmt.Errorf("key template %q has unknown placeholder %s", s, placeholder)
+		}
+	}
+	if strings.HasPrefix(s, "/") || strings.HasSuffix(s, "/") {
+		return "", fmt.Errorf("key template %q must not start or end with /", s)
+	}
+	return KeyTemplate(s), nil
+}
+
+// Key returns the object key of the artifact of req with checksum, published
+// by appID.
+func (t KeyTemplate) Key(appID string, req ReleaseRequest, checksum string) string {
+	return strings.NewReplacer(
+		"{app}", appID,
+		"{channel}", req.Channel,
+		"{version}", req.Version,
+		"{platform}", req.Platform,
+		"{checksum}", checksum,
+	).Replace(string(t))
+}
@@ -0,0 +1,566 @@
+// Package manifest describes the update manifest schema and publishes
+// releases into it.
+package manifest
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"update-manifest/pkg/semver"
+	"update-manifest/pkg/signing"
+)
+
+type Manifest struct {
+	// Channel can be "stable" or "beta"
+	Channel map[string]*Channel `json:"channel"`
+}
+
+// Channel holds the release clients of the channel are offered, inlined, and
+// the releases published to it before.
+type Channel struct {
+	Release
+	Metadata map[string]any `json:"metadata"`
+	// MinVersion is the oldest client version still supported. Clients
+	// running an older version must update before they are used.
+	MinVersion string `json:"min_version,omitempty"`
+	// Releases records every version published to the channel, newest first.
+	Releases []*Release `json:"releases,omitempty"`
+}
+
+// Release is one version of the application and its per-platform artifacts.
+type Release struct {
+	Version  string               `json:"version"`
+	Build    time.Time            `json:"build"`
+	Artifact map[string]*Artifact `json:"artifact"`
+	// Yanked marks a known-broken release clients must not install and
+	// should upgrade away from.
+	Yanked     bool   `json:"yanked,omitempty"`
+	YankReason string `json:"yank_reason,omitempty"`
+	// Rollout is the percentage of devices offered the release. All devices
+	// are offered it when unset. A paused rollout is offered to no device.
+	Rollout       *int `json:"rollout,omitempty"`
+	RolloutPaused bool `json:"rollout_paused,omitempty"`
+	// Mandatory tells clients the update cannot be skipped or postponed,
+	// e.g. because it fixes a security issue.
+	Mandatory bool `json:"mandatory,omitempty"`
+	// Notes describe what changed in the release. They are stored in the
+	// object under NotesKey instead when that is set.
+	Notes    string `json:"notes,omitempty"`
+	NotesKey string `json:"notes_key,omitempty"`
+}
+
+type Artifact struct {
+	Binary   string `json:"binary"`
+	Checksum string `json:"checksum"`
+	// ChecksumAlgo is the algorithm of every checksum of the artifact,
+	// including those of its patch, compressed copy and archived files.
+	// Empty means DefaultHashAlgorithm.
+	ChecksumAlgo string `json:"checksum_algo,omitempty"`
+	// Sidecar is the key of a checksum file published next to the artifact
+	// for verifying it without the manifest, if there is one.
+	Sidecar string `json:"sidecar,omitempty"`
+	// Zsync is the key of the zsync control file published next to the
+	// artifact, if there is one.
+	Zsync string `json:"zsync,omitempty"`
+	// Signature is the key of a detached signature of the artifact, made by
+	// the keys with the IDs in SignedBy over its ArtifactMessage.
+	Signature string   `json:"signature,omitempty"`
+	SignedBy  []string `json:"signed_by,omitempty"`
+	// Signatures are the keys of detached signatures of the artifact in the
+	// formats of other tools, keyed by format, e.g. minisign. They sign the
+	// content of the artifact itself.
+	Signatures map[string]string `json:"signatures,omitempty"`
+	// Identities name who made the keyless signatures among Signatures, by
+	// format, e.g. the GitHub Actions workflow of a cosign signature.
+	Identities map[string]signing.Identity `json:"identities,omitempty"`
+	// Size is the length of the artifact in bytes, 0 if it was published
+	// before sizes were recorded.
+	Size int64 `json:"size,omitempty"`
+	// MinOS is the oldest operating system version the artifact runs on as
+	// dot separated numbers, e.g. 12.0 for macOS 12 or 10.0.17763 for
+	// Windows 10 1809. Any version is accepted when empty.
+	MinOS string `json:"min_os,omitempty"`
+	// Archive describes the files of the artifact when it is an archive of
+	// an application bundle rather than a single executable.
+	Archive *Archive `json:"archive,omitempty"`
+	// Compressed is a compressed copy of the artifact, if one was published.
+	Compressed *Compressed `json:"compressed,omitempty"`
+	// Chunks describes the artifact split into content-defined chunks, if
+	// it was published as chunks too.
+	Chunks *Chunks `json:"chunks,omitempty"`
+	// Patch is the key of a delta from the artifact with checksum PatchFrom.
+	Patch         string `json:"patch"`
+	PatchChecksum string `json:"patch_checksum,omitempty"`
+	PatchFrom     string `json:"patch_from,omitempty"`
+	PatchFormat   string `json:"patch_format,omitempty"`
+	PatchSize     int64  `json:"patch_size,omitempty"`
+	// Patches are deltas from artifacts published before the one with
+	// checksum PatchFrom, newest first, for clients more than one version
+	// behind.
+	Patches  []Patch        `json:"patches,omitempty"`
+	Metadata map[string]any `json:"metadata"`
+	// Variants are builds of the artifact for CPUs with more capabilities,
+	// keyed by capability level, e.g. v3 for amd64 CPUs with AVX2. Clients
+	// fall back to the artifact itself when no variant suits their CPU.
+	Variants map[string]*Artifact `json:"variants,omitempty"`
+	// Kinds are other forms the platform is distributed in, keyed by kind,
+	// e.g. an installer or a portable archive, each with its own checksum
+	// and patch. The artifact itself is the executable the updater replaces.
+	Kinds map[string]*Artifact `json:"kinds,omitempty"`
+}
+
+// ManifestKey returns the object key of the manifest of appID.
+func ManifestKey(appID string) string {
+	return fmt.Sprintf("%s/manifest.json", appID)
+}
+
+// SignatureKey returns the object key of the detached manifest signature.
+func SignatureKey(appID string) string {
+	return ManifestKey(appID) + ".sig"
+}
+
+// TimestampKey returns the object key of the RFC 3161 timestamp response
+// of the detached manifest signature.
+func TimestampKey(appID string) string {
+	return SignatureKey(appID) + ".tsr"
+}
+
+// TransparencyLogKey returns the object key of the transparency log entries
+// of the detached manifest signature.
+func TransparencyLogKey(appID string) string {
+	return SignatureKey(appID) + ".rekor.json"
+}
+
+// PublishedKeys returns the keys of the mutable objects published for the
+// clients of appID under a fixed key: the manifest and its signature, the key
+// list and its signature, and the client manifest.
+func PublishedKeys(appID string) []string {
+	return []string{ManifestKey(appID), SignatureKey(appID), KeyListKey(appID), KeyListSignatureKey(appID), ClientManifestKey(appID)}
+}
+
+// DerivedPrefixes returns the prefixes of the keys of the mutable objects
+// published from the manifest of appID: its signatures in other formats,
+// timestamps and transparency log entries, named by the key of the manifest
+// with their extension appended, its TUF metadata and the feeds of the
+// updaters of other frameworks, kept in directories of their own.
+func DerivedPrefixes(appID string) []string {
+	return []string{ManifestKey(appID) + ".", TUFKey(appID, ""), appID + "/sparkle/", appID + "/electron/", appID + "/tauri/", appID + "/squirrel/", appID + "/zsync/"}
+}
+
+// IsPublished reports whether key is among the PublishedKeys of appID or has
+// one of its DerivedPrefixes.
+func IsPublished(appID, key string) bool {
+	if slices.Contains(PublishedKeys(appID), key) {
+		return true
+	}
+	for _, prefix := range DerivedPrefixes(appID) {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HistoryKey returns the object key of the backup of the manifest of appID
+// taken at t, before the manifest was replaced.
+func HistoryKey(appID string, t time.Time) string {
+	return fmt.Sprintf("%s/history/manifest-%s.json", appID, t.UTC().Format("20060102T150405.000Z"))
+}
+
+// ArtifactKey returns the content-addressed object key of an artifact laid
+// out by DefaultKeyTemplate.
+func ArtifactKey(appID, checksum string) string {
+	return DefaultKeyTemplate.Key(appID, ReleaseRequest{}, checksum)
+}
+
+// NotesKey returns the object key of the release notes of version.
+func NotesKey(appID, version string) string {
+	return fmt.Sprintf("%s/notes/%s.md", appID, version)
+}
+
+// StagingKey returns the object key an artifact is uploaded to before its
+// checksum, and so its final key, is known.
+func StagingKey(appID, id string) string {
+	return fmt.Sprintf("%s/staging/%s", appID, id)
+}
+
+// PatchKey returns the object key of the patch of format between two
+// artifacts. Patches of different formats between the same artifacts differ,
+// so each has a key of its own.
+func PatchKey(appID, fromChecksum, toChecksum, format string) string {
+	return fmt.Sprintf("%s/patch/%s-%s.%s", appID, fromChecksum, toChecksum, format)
+}
+
+// channel returns the named channel, creating it if needed.
+func (m *Manifest) channel(name string) *Channel {
+	if m.Channel == nil {
+		m.Channel = make(map[string]*Channel)
+	}
+
+	if _, ok := m.Channel[name]; !ok {
+		m.Channel[name] = &Channel{
+			Release: Release{Artifact: make(map[string]*Artifact)},
+		}
+	}
+
+	if m.Channel[name].Artifact == nil {
+		m.Channel[name].Artifact = make(map[string]*Artifact)
+	}
+
+	return m.Channel[name]
+}
+
+// Find returns the recorded release of version, or nil.
+func (c *Channel) Find(version string) *Release {
+	for _, release := range c.Releases {
+		if release.Version == version {
+			return release
+		}
+	}
+	return nil
+}
+
+// Previous returns the newest recorded release older than the current one
+// that is not yanked, the one a rollback restores, or nil if there is none.
+func (c *Channel) Previous() *Release {
+	for _, release := range c.Releases {
+		if v, err := semver.Compare(release.Version, c.Version); err == nil && v < 0 && !release.Yanked {
+			return release
+		}
+	}
+	return nil
+}
+
+// Current returns the history entry of the current release of c, which only
+// holds the artifacts of the platforms published for its version, unlike the
+// current release itself, or the current release if there is no entry. The
+// artifact offered for a platform is that of the entry: the current release
+// keeps the artifacts of older versions of platforms not published since.
+func (c *Channel) Current() *Release {
+	if release := c.Find(c.Version); release != nil {
+		return release
+	}
+	return &c.Release
+}
+
+// offered returns the releases of c a feed without rollouts of its own
+// offers every client, newest first: the current release and the older
+// recorded ones, leaving out yanked releases and those rolling out to a
+// share of devices.
+func (c *Channel) offered() []*Release {
+	releases := []*Release{c.Current()}
+	for _, release := range c.Releases {
+		// releases newer than the current one were rolled back
+		if v, err := semver.Compare(release.Version, c.Version); err == nil && v < 0 {
+			releases = append(releases, release)
+		}
+	}
+
+	offered := releases[:0]
+	for _, release := range releases {
+		if release.Version != "" && !release.Yanked && !release.RolloutPaused && (release.Rollout == nil || *release.Rollout >= 100) {
+			offered = append(offered, release)
+		}
+	}
+	return offered
+}
+
+// record stores the current release in its history entry, adding the entry
+// if needed. Only the artifact of platform is copied, as the current release
+// can carry artifacts of platforms not yet published for its version.
+func (c *Channel) record(platform string) {
+	release := c.Find(c.Version)
+	if release == nil {
+		release = &Release{}
+		c.Releases = append([]*Release{release}, c.Releases...)
+	}
+
+	artifacts := release.Artifact
+	if artifacts == nil {
+		artifacts = make(map[string]*Artifact)
+	}
+
+	*release = c.Release
+	release.Artifact = artifacts
+	release.Artifact[platform] = c.Artifact[platform].Clone()
+}
+
+// trim drops all but the keep newest recorded releases. The record of the
+// current release is always kept. keep <= 0 keeps everything.
+func (c *Channel) trim(keep int) {
+	if keep <= 0 || len(c.Releases) <= keep {
+		return
+	}
+
+	kept := c.Releases[:keep:keep]
+	for _, release := range c.Releases[keep:] {
+		if release.Version == c.Version {
+			kept = append(kept, release)
+		}
+	}
+	c.Releases = kept
+}
+
+// artifact returns the artifact of platform in channel, creating it if needed.
+func (c *Channel) artifact(platform string) *Artifact {
+	if _, ok := c.Artifact[platform]; !ok {
+		c.Artifact[platform] = &Artifact{}
+	}
+
+	return c.Artifact[platform]
+}
+
+// current returns the artifact of platform in the current release of channel,
+// or its kind or variant if set, or a zero Artifact if there is none.
+func (m *Manifest) current(channel, platform, kind, variant string) Artifact {
+	if ch, ok := m.Channel[channel]; ok {
+		if artifact := ch.FindArtifact(platform).Select(kind, variant); artifact != nil {
+			return *artifact
+		}
+	}
+	return Artifact{}
+}
+
+// Select returns the artifact of kind of a if kind is set, the variant of a
+// if variant is set, or a itself, and nil if there is no such artifact. Kinds
+// have no variants.
+func (a *Artifact) Select(kind, variant string) *Artifact {
+	switch {
+	case a == nil:
+		return nil
+	case kind != "" && variant != "":
+		return nil
+	case kind != "":
+		return a.Kinds[kind]
+	case variant != "":
+		return a.Variants[variant]
+	}
+	return a
+}
+
+// variant returns the named variant of a, creating it if needed.
+func (a *Artifact) variant(name string) *Artifact {
+	if a.Variants == nil {
+		a.Variants = make(map[string]*Artifact)
+	}
+	if _, ok := a.Variants[name]; !ok {
+		a.Variants[name] = &Artifact{}
+	}
+	return a.Variants[name]
+}
+
+// kind returns the artifact of the named kind of a, creating it if needed.
+func (a *Artifact) kind(name string) *Artifact {
+	if a.Kinds == nil {
+		a.Kinds = make(map[string]*Artifact)
+	}
+	if _, ok := a.Kinds[name]; !ok {
+		a.Kinds[name] = &Artifact{}
+	}
+	return a.Kinds[name]
+}
+
+// All returns a followed by its variants and then its kinds, each in lexical
+// order of their names.
+func (a *Artifact) All() []*Artifact {
+	all := []*Artifact{a}
+	for _, nested := range []map[string]*Artifact{a.Variants, a.Kinds} {
+		for _, name := range sortedNames(nested) {
+			if artifact := nested[name]; artifact != nil {
+				all = append(all, artifact.All()...)
+			}
+		}
+	}
+	return all
+}
+
+// Keys returns the object keys of all artifacts, patches and notes the manifest
+// references, including those of recorded releases, sorted and without duplicates.
+func (m *Manifest) Keys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(key string) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	for _, channel := range m.Channel {
+		for _, release := range append([]*Release{&channel.Release}, channel.Releases...) {
+			add(release.NotesKey)
+			for _, artifact := range release.Artifact {
+				for _, artifact := range artifact.All() {
+					add(artifact.Binary)
+					add(artifact.Sidecar)
+					add(artifact.Zsync)
+					add(artifact.Signature)
+					for _, key := range artifact.Signatures {
+						add(key)
+					}
+					for _, delta := range artifact.AllPatches() {
+						add(delta.Key)
+					}
+					if artifact.Compressed != nil {
+						add(artifact.Compressed.Key)
+					}
+					if artifact.Chunks != nil {
+						add(artifact.Chunks.Key)
+					}
+				}
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// ImmutableKeys returns the keys among Keys of the objects that are never
+// replaced once uploaded: the artifacts, their compressed copies and chunk
+// indexes and the patches. Notes, signatures, checksum files and zsync
+// control files may be uploaded again, so they are not among them.
+func (m *Manifest) ImmutableKeys() []string {
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(key string) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	for _, channel := range m.Channel {
+		for _, release := range append([]*Release{&channel.Release}, channel.Releases...) {
+			for _, artifact := range release.Artifact {
+				for _, artifact := range artifact.All() {
+					add(artifact.Binary)
+					for _, delta := range artifact.AllPatches() {
+						add(delta.Key)
+					}
+					if artifact.Compressed != nil {
+						add(artifact.Compressed.Key)
+					}
+					if artifact.Chunks != nil {
+						add(artifact.Chunks.Key)
+					}
+				}
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// ChunkIndexes returns the chunk descriptions of all artifacts the manifest
+// references, including those of recorded releases, without duplicates. The
+// chunks their indexes list are not among Keys.
+func (m *Manifest) ChunkIndexes() []*Chunks {
+	seen := make(map[string]bool)
+	var indexes []*Chunks
+	for _, name := range sortedNames(m.Channel) {
+		channel := m.Channel[name]
+		for _, release := range append([]*Release{&channel.Release}, channel.Releases...) {
+			for _, platform := range sortedNames(release.Artifact) {
+				for _, artifact := range release.Artifact[platform].All() {
+					if chunks := artifact.Chunks; chunks != nil && !seen[chunks.Key] {
+						seen[chunks.Key] = true
+						indexes = append(indexes, chunks)
+					}
+				}
+			}
+		}
+	}
+	return indexes
+}
+
+// Clone returns a copy of a that shares no maps with it.
+func (a *Artifact) Clone() *Artifact {
+	clone := *a
+	clone.Metadata = cloneMetadata(a.Metadata)
+	clone.SignedBy = append([]string(nil), a.SignedBy...)
+	if a.Signatures != nil {
+		clone.Signatures = make(map[string]string, len(a.Signatures))
+		for format, key := range a.Signatures {
+			clone.Signatures[format] = key
+		}
+	}
+	if a.Identities != nil {
+		clone.Identities = make(map[string]signing.Identity, len(a.Identities))
+		for format, identity := range a.Identities {
+			clone.Identities[format] = identity
+		}
+	}
+	clone.Archive = a.Archive.Clone()
+	if a.Compressed != nil {
+		compressed := *a.Compressed
+		clone.Compressed = &compressed
+	}
+	if a.Chunks != nil {
+		chunks := *a.Chunks
+		clone.Chunks = &chunks
+	}
+	clone.Patches = append([]Patch(nil), a.Patches...)
+	clone.Variants = cloneArtifacts(a.Variants)
+	clone.Kinds = cloneArtifacts(a.Kinds)
+	return &clone
+}
+
+func cloneArtifacts(artifacts map[string]*Artifact) map[string]*Artifact {
+	if artifacts == nil {
+		return nil
+	}
+
+	clone := make(map[string]*Artifact, len(artifacts))
+	for name, artifact := range artifacts {
+		clone[name] = artifact.Clone()
+	}
+	return clone
+}
+
+// Clone returns a deep copy of r.
+func (r *Release) Clone() *Release {
+	clone := *r
+	if r.Rollout != nil {
+		rollout := *r.Rollout
+		clone.Rollout = &rollout
+	}
+	clone.Artifact = make(map[string]*Artifact, len(r.Artifact))
+	for platform, artifact := range r.Artifact {
+		clone.Artifact[platform] = artifact.Clone()
+	}
+	return &clone
+}
+
+func cloneMetadata(metadata map[string]any) map[string]any {
+	if metadata == nil {
+		return nil
+	}
+
+	clone := make(map[string]any, len(metadata))
+	for k, v := range metadata {
+		clone[k] = v
+	}
+	return clone
+}
+
+// ValidVariant reports whether name is a valid variant or kind name: lower
+// case letters, digits and dots, e.g. v3, v8.2 or installer.
+func ValidVariant(name string) bool {
+	return name != "" && strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789.") == ""
+}
+
+// ValidOSVersion reports whether version is dot separated numbers, the form
+// of Artifact.MinOS.
+func ValidOSVersion(version string) bool {
+	for _, part := range strings.Split(version, ".") {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
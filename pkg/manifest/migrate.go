@@ -0,0 +1,183 @@
+package manifest
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"update-manifest/pkg/storage"
+)
+
+// LegacyArtifactPrefix returns the misspelled prefix artifacts of appID were
+// stored under before DefaultKeyTemplate was corrected.
+func LegacyArtifactPrefix(appID string) string {
+	return appID + "/artifect/"
+}
+
+// RenamePrefix copies every artifact and patch the manifest references under
+// the key prefix from to the same key under the prefix to, checking that the
+// copies have the recorded checksums, and points the manifest at the copies.
+// Checksum files are written anew for the copies they belong to. It returns
+// the keys copied from, which the manifest no longer references once it is
+// saved. Set verify to also download every copy again and check its
+// checksum.
+func (p *Publisher) RenamePrefix(ctx context.Context, from, to string, verify bool) ([]string, error) {
+	if from == to {
+		return nil, fmt.Errorf("prefixes %q are the same", from)
+	}
+
+	// the checksum of every referenced key under from, and the artifacts of
+	// the checksum files under it
+	checksums := make(map[string]objectChecksum)
+	sidecars := make(map[string]*Artifact)
+	for _, channel := range p.manifest.Channel {
+		for _, release := range append([]*Release{&channel.Release}, channel.Releases...) {
+			for _, artifact := range release.Artifact {
+				for _, artifact := range artifact.All() {
+					algo := artifact.HashAlgorithm()
+					if strings.HasPrefix(artifact.Binary, from) {
+						checksums[artifact.Binary] = objectChecksum{algo, artifact.Checksum}
+					}
+					for _, delta := range artifact.AllPatches() {
+						if strings.HasPrefix(delta.Key, from) {
+							checksums[delta.Key] = objectChecksum{algo, delta.Checksum}
+						}
+					}
+					if compressed := artifact.Compressed; compressed != nil && strings.HasPrefix(compressed.Key, from) {
+						checksums[compressed.Key] = objectChecksum{algo, compressed.Checksum}
+					}
+					// chunks are shared by the artifacts of the application
+					// and stay under their prefix, only the index moves
+					if chunks := artifact.Chunks; chunks != nil && strings.HasPrefix(chunks.Key, from) {
+						checksums[chunks.Key] = objectChecksum{algo, chunks.Checksum}
+					}
+					// signatures have no recorded checksum, but are only
+					// valid for the checksum of their artifact
+					if strings.HasPrefix(artifact.Signature, from) {
+						checksums[artifact.Signature] = objectChecksum{}
+					}
+					for _, key := range artifact.Signatures {
+						if strings.HasPrefix(key, from) {
+							checksums[key] = objectChecksum{}
+						}
+					}
+					// zsync control files name their artifact by the last
+					// element of its key, which the copy keeps
+					if strings.HasPrefix(artifact.Zsync, from) {
+						checksums[artifact.Zsync] = objectChecksum{}
+					}
+					if strings.HasPrefix(artifact.Sidecar, from) {
+						sidecars[artifact.Sidecar] = artifact
+					}
+				}
+			}
+		}
+	}
+
+	rename := func(key string) string {
+		_, copied := checksums[key]
+		if _, ok := sidecars[key]; copied || ok {
+			return to + strings.TrimPrefix(key, from)
+		}
+		return key
+	}
+
+	renamed := sortedNames(checksums)
+	for _, key := range renamed {
+		target := to + strings.TrimPrefix(key, from)
+		if err := p.copyObject(ctx, key, target, checksums[key]); err != nil {
+			return nil, err
+		}
+		if verify && checksums[key].checksum != "" {
+			if err := p.verifyUpload(ctx, target, checksums[key].algo, checksums[key].checksum); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, key := range sortedNames(sidecars) {
+		artifact := sidecars[key]
+		if err := p.putSidecar(ctx, rename(key), rename(artifact.Binary), artifact.Checksum); err != nil {
+			return nil, err
+		}
+		renamed = append(renamed, key)
+	}
+
+	err := p.change(func(m *Manifest) error {
+		for _, channel := range m.Channel {
+			for _, release := range append([]*Release{&channel.Release}, channel.Releases...) {
+				for _, artifact := range release.Artifact {
+					for _, artifact := range artifact.All() {
+						artifact.Binary = rename(artifact.Binary)
+						artifact.Sidecar = rename(artifact.Sidecar)
+						artifact.Zsync = rename(artifact.Zsync)
+						artifact.Signature = rename(artifact.Signature)
+						for format, key := range artifact.Signatures {
+							artifact.Signatures[format] = rename(key)
+						}
+						artifact.Patch = rename(artifact.Patch)
+						for i := range artifact.Patches {
+							artifact.Patches[i].Key = rename(artifact.Patches[i].Key)
+						}
+						if artifact.Compressed != nil {
+							artifact.Compressed.Key = rename(artifact.Compressed.Key)
+						}
+						if artifact.Chunks != nil {
+							artifact.Chunks.Key = rename(artifact.Chunks.Key)
+						}
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return renamed, nil
+}
+
+// objectChecksum is the checksum of a stored object and its algorithm.
+type objectChecksum struct {
+	algo, checksum string
+}
+
+// copyObject copies the object stored under src to dst, checking that it has
+// the given checksum unless that is empty. The copy is deleted if it does
+// not.
+func (p *Publisher) copyObject(ctx context.Context, src, dst string, checksum objectChecksum) error {
+	reader, info, err := p.backend.Get(ctx, src)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", src, err)
+	}
+	defer reader.Close()
+
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	// objects with a checksum are content addressed, the others are
+	// signatures, which may be replaced
+	cacheControl := p.caching.Mutable
+	if checksum.checksum != "" {
+		cacheControl = p.caching.Immutable
+	}
+
+	hasher, err := NewHash(checksum.algo)
+	if err != nil {
+		return err
+	}
+	if err := p.backend.Put(ctx, dst, io.TeeReader(reader, hasher), info.Size, storage.PutOptions{
+		ContentType:  contentType,
+		CacheControl: cacheControl,
+	}); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+	}
+
+	if actual := hex.EncodeToString(hasher.Sum(nil)); checksum.checksum != "" && actual != checksum.checksum {
+		p.backend.Delete(context.WithoutCancel(ctx), dst)
+		return fmt.Errorf("%s is corrupted: checksum %s does not match %s", src, actual, checksum.checksum)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"update-manifest/pkg/patch"
+	"update-manifest/pkg/storage"
+)
+
+// Patch is a delta that turns the artifact with checksum From into the
+// artifact it is recorded on.
+type Patch struct {
+	Key      string `json:"key"`
+	Checksum string `json:"checksum"`
+	From     string `json:"from"`
+	Format   string `json:"format"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+// AllPatches returns the patch from the previous artifact, if there is one,
+// followed by the patches from older ones.
+func (a *Artifact) AllPatches() []Patch {
+	if a.Patch == "" {
+		return a.Patches
+	}
+	previous := Patch{Key: a.Patch, Checksum: a.PatchChecksum, From: a.PatchFrom, Format: a.PatchFormat, Size: a.PatchSize}
+	return append([]Patch{previous}, a.Patches...)
+}
+
+// setPatch records delta as the patch from the previous artifact, or clears
+// it if delta is the zero Patch.
+func (a *Artifact) setPatch(delta Patch) {
+	a.Patch, a.PatchChecksum, a.PatchFrom, a.PatchFormat, a.PatchSize = delta.Key, delta.Checksum, delta.From, delta.Format, delta.Size
+}
+
+// patchBases returns the artifact of platform, kind and variant in channel
+// followed by those of the older releases recorded in it, until n artifacts
+// with distinct checksums are found. Only artifacts of algo are returned, as
+// clients only recognize the artifact a patch applies to by a checksum of
+// the same algorithm.
+func (m *Manifest) patchBases(channel, platform, kind, variant, algo string, n int) []Artifact {
+	ch, ok := m.Channel[channel]
+	if !ok {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var bases []Artifact
+	for _, release := range append([]*Release{&ch.Release}, ch.Releases...) {
+		if len(bases) >= n {
+			break
+		}
+		artifact := release.FindArtifact(platform).Select(kind, variant)
+		if artifact == nil || artifact.Binary == "" || seen[artifact.Checksum] || artifact.HashAlgorithm() != algo {
+			continue
+		}
+		seen[artifact.Checksum] = true
+		bases = append(bases, *artifact)
+	}
+	return bases
+}
+
+// PatchJob is a patch PreparePatches generated.
+type PatchJob struct {
+	Platform, Variant, Kind string
+	// From is the checksum of the artifact the patch is made from.
+	From string
+	// Patch is the zero Patch if the patch was larger than PatchMaxSize
+	// allows and not uploaded.
+	Patch    Patch
+	Duration time.Duration
+}
+
+// PreparePatches generates and uploads the patches AddRelease makes for
+// each of reqs, up to workers at a time, so publishing many platforms or
+// patches from many versions does not diff them one after another. progress,
+// if not nil, is called as each patch is done, one call at a time. AddRelease
+// then records the prepared patches instead of generating them again, as
+// long as the executable and the previous versions stay those they were made
+// from.
+func (p *Publisher) PreparePatches(ctx context.Context, reqs []ReleaseRequest, workers int, progress func(PatchJob)) error {
+	type job struct {
+		req      ReleaseRequest
+		base     Artifact
+		checksum string
+		new      []byte
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu     sync.Mutex
+		failed error
+		wg     sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failed == nil {
+			failed = err
+			cancel()
+		}
+	}
+	if p.prepared == nil {
+		p.prepared = make(map[string]Patch)
+	}
+
+	jobs := make(chan job)
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				start := time.Now()
+				delta, err := p.uploadPatch(ctx, j.req, j.base, j.checksum, j.new)
+				if err != nil {
+					fail(fmt.Errorf("%s: %w", j.req.Platform, err))
+					continue
+				}
+
+				mu.Lock()
+				p.prepared[PatchKey(p.appID, j.base.Checksum, j.checksum, requestedPatchFormat(j.req))] = delta
+				if progress != nil {
+					progress(PatchJob{
+						Platform: j.req.Platform,
+						Variant:  j.req.Variant,
+						Kind:     j.req.Kind,
+						From:     j.base.Checksum,
+						Patch:    delta,
+						Duration: time.Since(start),
+					})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// executables are read as their first patch is taken up, so no more
+	// than one per worker waits in memory
+dispatch:
+	for _, req := range reqs {
+		if !req.Patch {
+			continue
+		}
+		bases := p.manifest.patchBases(req.Channel, req.Platform, req.Kind, req.Variant, p.hash, max(req.PatchVersions, 1))
+		if len(bases) == 0 {
+			continue
+		}
+		new, err := readExecutable(req.Executable)
+		if err != nil {
+			fail(fmt.Errorf("%s: %w", req.Platform, err))
+			break
+		}
+		hasher := p.hasher()
+		hasher.Write(new)
+		checksum := hex.EncodeToString(hasher.Sum(nil))
+
+		for _, base := range bases {
+			if base.Checksum == checksum {
+				continue
+			}
+			select {
+			case jobs <- job{req, base, checksum, new}:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failed != nil {
+		return failed
+	}
+	return ctx.Err()
+}
+
+// readExecutable reads executable from its start and seeks back to it, where
+// AddRelease expects it.
+func readExecutable(executable io.ReadSeeker) ([]byte, error) {
+	if _, err := executable.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to beginning of executable: %w", err)
+	}
+	data, err := io.ReadAll(executable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read executable: %w", err)
+	}
+	if _, err := executable.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek to beginning of executable: %w", err)
+	}
+	return data, nil
+}
+
+// uploadPatches uploads a patch from each of bases to the executable of req
+// with checksum toChecksum, skipping the bases that are the executable
+// itself and the patches larger than req.PatchMaxSize allows.
+// Patches PreparePatches uploaded are not generated again.
+func (p *Publisher) uploadPatches(ctx context.Context, req ReleaseRequest, bases []Artifact, toChecksum string) ([]Patch, error) {
+	var new []byte
+	var deltas []Patch
+	for _, base := range bases {
+		if base.Checksum == toChecksum {
+			continue
+		}
+		delta, prepared := p.prepared[PatchKey(p.appID, base.Checksum, toChecksum, requestedPatchFormat(req))]
+		if !prepared {
+			var err error
+			if new == nil {
+				new, err = readExecutable(req.Executable)
+			}
+			if err == nil {
+				delta, err = p.uploadPatch(ctx, req, base, toChecksum, new)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if delta.Key == "" {
+			continue
+		}
+		if req.VerifyUpload {
+			if err := p.verifyUpload(ctx, delta.Key, p.hash, delta.Checksum); err != nil {
+				return nil, err
+			}
+		}
+		deltas = append(deltas, delta)
+	}
+	return deltas, nil
+}
+
+// fetchArtifact returns the content of artifact, read from the cache if
+// it is there and fetched from the backend and added to the cache otherwise.
+// Both are verified against the checksum of artifact.
+func (p *Publisher) fetchArtifact(ctx context.Context, artifact Artifact) ([]byte, error) {
+	var path string
+	if p.cache != "" {
+		path = filepath.Join(p.cache, artifact.Checksum)
+		if data, err := os.ReadFile(path); err == nil && p.matches(data, artifact.Checksum) {
+			return data, nil
+		}
+	}
+
+	reader, _, err := p.backend.Get(ctx, artifact.Binary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch previous artifact: %w", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch previous artifact: %w", err)
+	}
+	if !p.matches(data, artifact.Checksum) {
+		return nil, fmt.Errorf("previous artifact %s does not match its checksum %s", artifact.Binary, artifact.Checksum)
+	}
+
+	// an artifact that cannot be cached is fetched again next time
+	if path != "" {
+		writeCached(path, data)
+	}
+	return data, nil
+}
+
+// matches reports whether data has checksum.
+func (p *Publisher) matches(data []byte, checksum string) bool {
+	hasher := p.hasher()
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil)) == checksum
+}
+
+// writeCached writes data to path by renaming a temporary file, so a
+// concurrent publish never reads a partial artifact.
+func writeCached(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".artifact-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// requestedPatchFormat returns the format req asks patches in. The patch of
+// an artifact that is not an executable falls back to patch.Format, but
+// prepared patches are looked up by the format requested.
+func requestedPatchFormat(req ReleaseRequest) string {
+	if req.PatchExecutable {
+		return patch.FormatExecutable
+	}
+	return patch.Format
+}
+
+// uploadPatch computes a delta from the artifact previous to new, the
+// executable of req with checksum toChecksum, verifies that applying it to
+// previous yields new and uploads it. If req.PatchExecutable is set and both
+// are ELF or PE executables of the same architecture, the patch is made by
+// patch.DiffExecutable. It returns the zero Patch without uploading the
+// delta if it is larger than req.PatchMaxSize allows, or if applying it does
+// not reproduce new, so the release is published without that patch.
+func (p *Publisher) uploadPatch(ctx context.Context, req ReleaseRequest, previous Artifact, toChecksum string, new []byte) (Patch, error) {
+	old, err := p.fetchArtifact(ctx, previous)
+	if err != nil {
+		return Patch{}, err
+	}
+
+	var delta bytes.Buffer
+	format := requestedPatchFormat(req)
+	if format == patch.FormatExecutable {
+		if err := patch.DiffExecutable(old, new, &delta); errors.Is(err, patch.ErrNotExecutable) {
+			format = patch.Format
+		} else if err != nil {
+			return Patch{}, fmt.Errorf("failed to generate patch: %w", err)
+		}
+	}
+	if format == patch.Format {
+		if err := patch.Diff(old, new, &delta); err != nil {
+			return Patch{}, fmt.Errorf("failed to generate patch: %w", err)
+		}
+	}
+
+	// a patch almost as large as the executable saves clients little over
+	// downloading it, and costs storage and a download when it fails
+	if req.PatchMaxSize > 0 && int64(delta.Len())*100 > int64(req.PatchMaxSize)*int64(len(new)) {
+		return Patch{}, nil
+	}
+
+	// a patch that does not reproduce the executable is never published, but
+	// clients can still download the executable itself
+	patched, err := patch.Apply(old, bytes.NewReader(delta.Bytes()))
+	if err != nil {
+		slog.Warn("dropped patch that failed verification", "platform", req.Platform, "from", previous.Checksum, "err", err)
+		return Patch{}, nil
+	}
+	if !p.matches(patched, toChecksum) {
+		slog.Warn("dropped patch that failed verification", "platform", req.Platform, "from", previous.Checksum, "err", fmt.Sprintf("the patched artifact does not match checksum %s", toChecksum))
+		return Patch{}, nil
+	}
+
+	hasher := p.hasher()
+	hasher.Write(delta.Bytes())
+	checksum := hasher.Sum(nil)
+	key := PatchKey(p.appID, previous.Checksum, toChecksum, format)
+	if err := p.backend.Put(ctx, key, bytes.NewReader(delta.Bytes()), int64(delta.Len()), storage.PutOptions{
+		ContentType:  "application/octet-stream",
+		CacheControl: p.caching.Immutable,
+	}); err != nil {
+		return Patch{}, fmt.Errorf("failed to upload patch: %w", err)
+	}
+
+	return Patch{
+		Key:      key,
+		Checksum: hex.EncodeToString(checksum),
+		From:     previous.Checksum,
+		Format:   format,
+		Size:     int64(delta.Len()),
+	}, nil
+}
@@ -0,0 +1,280 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage"
+)
+
+// PendingManifest is a manifest staged by Save until it is signed by as many
+// keys as RequireSignatures requires.
+type PendingManifest struct {
+	AppID  string    `json:"app_id"`
+	Staged time.Time `json:"staged"`
+	// Replaces is the ETag of the manifest the staged one replaces, empty if
+	// it creates the manifest. Finalize fails if it was replaced since.
+	Replaces string `json:"replaces,omitempty"`
+	// Manifest is the staged manifest as its signatures cover it.
+	Manifest   []byte           `json:"manifest"`
+	Signatures signing.Envelope `json:"signatures"`
+	// KeyList is the key list staged with the manifest when the keys changed,
+	// signed like it.
+	KeyList           []byte            `json:"key_list,omitempty"`
+	KeyListSignatures *signing.Envelope `json:"key_list_signatures,omitempty"`
+}
+
+// PendingKey returns the object key of the staged manifest of appID.
+func PendingKey(appID string) string {
+	return fmt.Sprintf("%s/pending.json", appID)
+}
+
+// LoadPending returns the staged manifest of appID, or nil if none is, and
+// its ETag.
+func LoadPending(ctx context.Context, backend storage.Backend, appID string) (*PendingManifest, string, error) {
+	reader, info, err := backend.Get(ctx, PendingKey(appID))
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch staged manifest: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch staged manifest: %w", err)
+	}
+	var pending PendingManifest
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, "", fmt.Errorf("failed to decode staged manifest: %w", err)
+	}
+	return &pending, info.ETag, nil
+}
+
+// stage writes the manifest, signed by the signers, as the staged manifest,
+// replacing any staged before, instead of publishing it.
+func (p *Publisher) stage(ctx context.Context) error {
+	marshaledManifest, err := json.Marshal(p.manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	envelope, err := p.signManifest(ctx, marshaledManifest)
+	if err != nil {
+		return err
+	}
+
+	pending := &PendingManifest{
+		AppID:      p.appID,
+		Staged:     time.Now().UTC().Truncate(time.Second),
+		Manifest:   marshaledManifest,
+		Signatures: *envelope,
+	}
+	if p.exists {
+		pending.Replaces = p.etag
+	}
+	if pending.KeyList, err = p.keyList(ctx); err != nil {
+		return err
+	}
+	if pending.KeyList != nil {
+		if pending.KeyListSignatures, err = signing.Sign(ctx, pending.KeyList, p.signers...); err != nil {
+			return err
+		}
+	}
+
+	if err := p.putPending(ctx, pending, ""); err != nil {
+		return err
+	}
+	p.changes = nil
+	p.staged = pending
+	return nil
+}
+
+// SignPending adds the signatures of the signers to the staged manifest and
+// returns the number of keys it is signed by and the number it needs.
+func (p *Publisher) SignPending(ctx context.Context) (signed, required int, err error) {
+	pending, etag, err := LoadPending(ctx, p.backend, p.appID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if pending == nil {
+		return 0, 0, fmt.Errorf("no manifest of %s is staged", p.appID)
+	}
+	if len(p.signers) == 0 {
+		return 0, 0, errors.New("signing the staged manifest needs a signing key")
+	}
+
+	// the signatures sign the expiry of the first, so all must share it
+	envelope, err := signing.SignExpiring(ctx, pending.Manifest, pending.Signatures.Expires, p.signers...)
+	if err != nil {
+		return 0, 0, err
+	}
+	pending.Signatures = mergeSignatures(pending.Signatures, envelope)
+	if pending.KeyList != nil {
+		envelope, err := signing.Sign(ctx, pending.KeyList, p.signers...)
+		if err != nil {
+			return 0, 0, err
+		}
+		var signed signing.Envelope
+		if pending.KeyListSignatures != nil {
+			signed = *pending.KeyListSignatures
+		}
+		merged := mergeSignatures(signed, envelope)
+		pending.KeyListSignatures = &merged
+	}
+
+	// signatures added concurrently by others must not be lost
+	if err := p.putPending(ctx, pending, etag); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return 0, 0, errors.New("the staged manifest was signed or replaced concurrently, sign it again")
+		}
+		return 0, 0, err
+	}
+
+	list, err := p.pendingKeyList(ctx, pending)
+	if err != nil {
+		return 0, 0, err
+	}
+	keys, err := list.PublicKeys()
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(signing.ValidSignatures(pending.Manifest, &pending.Signatures, keys...)), list.RequiredSignatures(), nil
+}
+
+// Finalize publishes the staged manifest once it is signed by as many keys
+// as the key list requires, as it was signed, and the objects Save derives
+// from it. The key list staged with it is only accepted when signed by as
+// many keys of the published list as that requires, so the keys cannot be
+// changed with fewer signatures than a release needs.
+func (p *Publisher) Finalize(ctx context.Context) error {
+	return p.purging(ctx, p.finalize)
+}
+
+func (p *Publisher) finalize(ctx context.Context) error {
+	pending, _, err := LoadPending(ctx, p.backend, p.appID)
+	if err != nil {
+		return err
+	}
+	if pending == nil {
+		return fmt.Errorf("no manifest of %s is staged", p.appID)
+	}
+	list, err := p.pendingKeyList(ctx, pending)
+	if err != nil {
+		return err
+	}
+	if err := list.verify(pending.Manifest, &pending.Signatures); err != nil {
+		return fmt.Errorf("staged manifest: %w", err)
+	}
+	keys, err := list.PublicKeys()
+	if err != nil {
+		return err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(pending.Manifest, &m); err != nil {
+		return fmt.Errorf("failed to decode staged manifest: %w", err)
+	}
+	if err := p.Load(ctx); err != nil {
+		return err
+	}
+	if p.etag != pending.Replaces {
+		return errors.New("the manifest changed since the staged one was made from it, publish the release again")
+	}
+	if err := p.backUp(ctx); err != nil {
+		return err
+	}
+
+	opts := storage.PutOptions{ContentType: "application/json", CacheControl: p.caching.Mutable}
+	if p.exists {
+		opts.IfMatch = p.etag
+	} else {
+		opts.IfNoneMatch = true
+	}
+	if err := p.backend.Put(ctx, ManifestKey(p.appID), bytes.NewReader(pending.Manifest), int64(len(pending.Manifest)), opts); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return errors.New("the manifest changed since the staged one was made from it, publish the release again")
+		}
+		return fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	p.manifest, p.changes, p.staged = &m, nil, nil
+
+	if pending.KeyList != nil {
+		if err := p.putKeyList(ctx, pending.KeyList, pending.KeyListSignatures); err != nil {
+			return err
+		}
+	}
+	if err := p.published(ctx, pending.Manifest, &pending.Signatures, keys); err != nil {
+		return err
+	}
+	if err := p.backend.Delete(ctx, PendingKey(p.appID)); err != nil {
+		return fmt.Errorf("failed to delete staged manifest: %w", err)
+	}
+	return nil
+}
+
+// pendingKeyList returns the key list the signatures of the pending manifest
+// are verified with: the one staged with it, once it is signed by the keys
+// the published one requires, or else the published one.
+func (p *Publisher) pendingKeyList(ctx context.Context, pending *PendingManifest) (*KeyList, error) {
+	published, err := LoadKeyList(ctx, p.backend, p.appID)
+	if err != nil {
+		return nil, err
+	}
+	if pending.KeyList == nil {
+		if published == nil {
+			return nil, errors.New("no key list is published to verify the staged manifest with")
+		}
+		return published, nil
+	}
+
+	var staged KeyList
+	if err := json.Unmarshal(pending.KeyList, &staged); err != nil {
+		return nil, fmt.Errorf("failed to decode staged key list: %w", err)
+	}
+	authority := published
+	if authority == nil {
+		authority = &staged
+	}
+	if pending.KeyListSignatures == nil {
+		return nil, errors.New("staged key list is not signed")
+	}
+	if err := authority.verify(pending.KeyList, pending.KeyListSignatures); err != nil {
+		return nil, fmt.Errorf("staged key list: %w", err)
+	}
+	return &staged, nil
+}
+
+// putPending uploads pending, if the staged manifest still has the ETag
+// etag when that is set.
+func (p *Publisher) putPending(ctx context.Context, pending *PendingManifest, etag string) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return fmt.Errorf("failed to marshal staged manifest: %w", err)
+	}
+	opts := storage.PutOptions{ContentType: "application/json", CacheControl: p.caching.Mutable, IfMatch: etag}
+	if err := p.backend.Put(ctx, PendingKey(p.appID), bytes.NewReader(data), int64(len(data)), opts); err != nil {
+		return fmt.Errorf("failed to upload staged manifest: %w", err)
+	}
+	return nil
+}
+
+// mergeSignatures returns the signatures of envelope and added, those of
+// added replacing the ones of envelope by the same keys.
+func mergeSignatures(envelope signing.Envelope, added *signing.Envelope) signing.Envelope {
+	merged := signing.Envelope{Expires: envelope.Expires}
+	for _, signature := range envelope.Signatures {
+		if !slices.ContainsFunc(added.Signatures, func(s signing.Signature) bool { return s.KeyID == signature.KeyID }) {
+			merged.Signatures = append(merged.Signatures, signature)
+		}
+	}
+	merged.Signatures = append(merged.Signatures, added.Signatures...)
+	return merged
+}
@@ -0,0 +1,104 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// knownOS and knownArch are the GOOS and GOARCH values a platform is made
+	// of.
+	knownOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true,
+		"illumos": true, "ios": true, "js": true, "linux": true, "netbsd": true,
+		"openbsd": true, "plan9": true, "solaris": true, "wasip1": true, "windows": true,
+	}
+	knownArch = map[string]bool{
+		"386": true, "amd64": true, "arm": true, "arm64": true, "loong64": true,
+		"mips": true, "mips64": true, "mips64le": true, "mipsle": true, "ppc64": true,
+		"ppc64le": true, "riscv64": true, "s390x": true, "wasm": true,
+	}
+
+	platformAliases = map[string]string{
+		"win32": "windows/386",
+		"win64": "windows/amd64",
+	}
+	osAliases = map[string]string{
+		"win":   "windows",
+		"macos": "darwin",
+		"mac":   "darwin",
+		"osx":   "darwin",
+	}
+	archAliases = map[string]string{
+		"x64":     "amd64",
+		"x86_64":  "amd64",
+		"x86-64":  "amd64",
+		"x86":     "386",
+		"i386":    "386",
+		"i686":    "386",
+		"ia32":    "386",
+		"aarch64": "arm64",
+		"armv7":   "arm",
+		"armhf":   "arm",
+	}
+)
+
+// NormalizePlatform returns the canonical os/arch form of platform, made of a
+// GOOS and a GOARCH value. It accepts any case, - and _ as separators and
+// common aliases, e.g. win64, Windows-x64 and windows_amd64 all normalize to
+// windows/amd64.
+func NormalizePlatform(platform string) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(platform))
+	if canonical, ok := platformAliases[name]; ok {
+		return canonical, nil
+	}
+
+	// the separator is the first one, as arch aliases such as x86_64 contain
+	// one themselves
+	i := strings.IndexAny(name, "/-_")
+	if i < 0 {
+		return "", fmt.Errorf("platform %q is not of the form os/arch, e.g. linux/amd64", platform)
+	}
+	goos, arch := name[:i], name[i+1:]
+	if alias, ok := osAliases[goos]; ok {
+		goos = alias
+	}
+	if alias, ok := archAliases[arch]; ok {
+		arch = alias
+	}
+
+	if !knownOS[goos] {
+		return "", fmt.Errorf("platform %q has unknown operating system %q", platform, goos)
+	}
+	if !knownArch[arch] {
+		return "", fmt.Errorf("platform %q has unknown architecture %q", platform, arch)
+	}
+	return goos + "/" + arch, nil
+}
+
+// platformKey returns the key of the artifact map of r holding the artifact
+// of platform: platform itself, or a key that normalizes to the same platform,
+// such as linux-amd64 for linux/amd64 in manifests published before platforms
+// were normalized. It returns platform if there is no such key.
+func (r *Release) platformKey(platform string) string {
+	if _, ok := r.Artifact[platform]; ok {
+		return platform
+	}
+
+	canonical, err := NormalizePlatform(platform)
+	if err != nil {
+		return platform
+	}
+	for _, key := range sortedNames(r.Artifact) {
+		if normalized, err := NormalizePlatform(key); err == nil && normalized == canonical {
+			return key
+		}
+	}
+	return platform
+}
+
+// FindArtifact returns the artifact of platform in r, matching keys that
+// normalize to the same platform, or nil.
+func (r *Release) FindArtifact(platform string) *Artifact {
+	return r.Artifact[r.platformKey(platform)]
+}
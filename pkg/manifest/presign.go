@@ -0,0 +1,110 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"update-manifest/pkg/storage"
+)
+
+// DefaultURLExpiry is how long the URLs of the client manifest are valid
+// when PresignURLs is not given an expiry. They must be refreshed with
+// RefreshURLs more often than that between releases.
+const DefaultURLExpiry = 24 * time.Hour
+
+// ClientManifestKey returns the object key of the client manifest of appID,
+// a copy of the manifest whose objects are referenced by presigned URLs.
+func ClientManifestKey(appID string) string {
+	return fmt.Sprintf("%s/client.json", appID)
+}
+
+// PresignURLs makes Save also write the client manifest: a copy of the
+// manifest under ClientManifestKey in which every object of the releases
+// clients are offered is referenced by a URL presigned to download it for
+// expiry, so the bucket needs not be readable by everyone. Clients are
+// pointed at the client manifest instead of the manifest, which must be
+// readable by them as well. Chunks and zsync control files are left out,
+// as they reference further objects by key.
+func (p *Publisher) PresignURLs(expiry time.Duration) {
+	p.presign = expiry
+}
+
+// RefreshURLs writes the client manifest of the loaded manifest again with
+// new presigned URLs, without publishing a release. Run it more often than
+// the URLs expire.
+func (p *Publisher) RefreshURLs(ctx context.Context) error {
+	if !p.exists {
+		return errors.New("no manifest to refresh the presigned URLs of")
+	}
+	expiry := DefaultURLExpiry
+	if p.presign != 0 {
+		expiry = p.presign
+	}
+	return p.writeClientManifest(ctx, expiry)
+}
+
+// writeClientManifest renders the client manifest of the manifest with URLs
+// valid for expiry and uploads it.
+func (p *Publisher) writeClientManifest(ctx context.Context, expiry time.Duration) error {
+	presign := func(key *string) error {
+		if *key == "" {
+			return nil
+		}
+		url, err := storage.PresignGet(ctx, p.backend, *key, expiry)
+		if err != nil {
+			return fmt.Errorf("failed to sign download URL of %s: %w", *key, err)
+		}
+		*key = url
+		return nil
+	}
+
+	client := &Manifest{Channel: make(map[string]*Channel, len(p.manifest.Channel))}
+	for _, name := range sortedNames(p.manifest.Channel) {
+		channel := *p.manifest.Channel[name]
+		channel.Release = *channel.Release.Clone()
+		if err := presign(&channel.NotesKey); err != nil {
+			return err
+		}
+		for _, platform := range sortedNames(channel.Artifact) {
+			for _, artifact := range channel.Artifact[platform].All() {
+				artifact.Chunks, artifact.Zsync = nil, ""
+				keys := []*string{&artifact.Binary, &artifact.Sidecar, &artifact.Signature, &artifact.Patch}
+				for _, format := range sortedNames(artifact.Signatures) {
+					key := artifact.Signatures[format]
+					if err := presign(&key); err != nil {
+						return err
+					}
+					artifact.Signatures[format] = key
+				}
+				if artifact.Compressed != nil {
+					keys = append(keys, &artifact.Compressed.Key)
+				}
+				for i := range artifact.Patches {
+					keys = append(keys, &artifact.Patches[i].Key)
+				}
+				for _, key := range keys {
+					if err := presign(key); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		client.Channel[name] = &channel
+	}
+
+	data, err := json.Marshal(client)
+	if err != nil {
+		return fmt.Errorf("failed to marshal client manifest: %w", err)
+	}
+	if err := p.backend.Put(ctx, ClientManifestKey(p.appID), bytes.NewReader(data), int64(len(data)), storage.PutOptions{
+		ContentType:  "application/json",
+		CacheControl: p.caching.Mutable,
+	}); err != nil {
+		return fmt.Errorf("failed to upload client manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,1123 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"update-manifest/pkg/semver"
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage"
+)
+
+// ErrChannelNotFound is returned when the manifest has no such channel.
+var ErrChannelNotFound = errors.New("channel not found")
+
+// ErrDowngrade is returned when publishing a version lower than the current
+// version of a channel.
+var ErrDowngrade = errors.New("version is lower than the published version")
+
+// Publisher loads the manifest of an application, adds releases to it and
+// writes it back.
+type Publisher struct {
+	backend  storage.Backend
+	appID    string
+	manifest *Manifest
+	signers  []signing.Signer
+	// fileSigners sign the manifest and artifacts in the formats of other
+	// tools.
+	fileSigners []signing.FileSigner
+	timestamps  *signing.TimestampAuthority
+	log         *signing.TransparencyLog
+	tuf         *TUFExpiry
+	appcast     *Appcast
+	electron    *ElectronFeed
+	tauri       *TauriFeed
+	squirrel    *SquirrelFeed
+	zsync       *ZsyncFeed
+	keep        int
+	keys        KeyTemplate
+	hash        string
+	// cache is the directory previous artifacts are kept in, if any.
+	cache string
+	// caching is the Cache-Control of the objects written.
+	caching CachePolicy
+	// presign is how long the URLs of the client manifest are valid, 0 if
+	// none is written.
+	presign time.Duration
+	// token has the keys artifacts are uploaded to, if any, of which the
+	// first uploaded are used.
+	token    *UploadToken
+	uploaded int
+	// purger invalidates the cached copies of the objects Save writes.
+	purger Purger
+	// threshold is the number of keys the manifest needs signatures of, of
+	// the signers and cosigners, and staged is the manifest the last Save
+	// staged for want of them.
+	threshold int
+	cosigners []signing.PublicKey
+	staged    *PendingManifest
+	// signatureExpiry is how long the detached signature of the manifest is
+	// valid, forever when 0.
+	signatureExpiry time.Duration
+	// prepared are the patches uploaded by PreparePatches by key.
+	prepared map[string]Patch
+
+	// etag identifies the loaded manifest, which exists if exists is set.
+	// loaded is the manifest as it was stored.
+	etag   string
+	exists bool
+	loaded []byte
+	backup string
+	// identities name who made the keyless signatures of the manifest
+	// written by the last Save, by format.
+	identities map[string]signing.Identity
+	// changes are the modifications made to the loaded manifest, applied
+	// again when Save finds it was replaced in the meantime.
+	changes []func(*Manifest) error
+}
+
+// ReleaseRequest describes an executable to publish.
+type ReleaseRequest struct {
+	Channel  string
+	Version  string
+	Platform string
+	// Variant, when set, publishes the executable as the variant of that
+	// name of the artifact of the platform, e.g. v3 for amd64 CPUs with AVX2.
+	Variant string
+	// Kind, when set, publishes the executable as the artifact of that kind
+	// of the platform, e.g. installer. Kinds have no variants.
+	Kind string
+	// MinOS is recorded as the oldest operating system version the
+	// executable runs on.
+	MinOS string
+	// Archive, when set, records the executable as an archive holding these
+	// files, as returned by ReadArchive.
+	Archive *Archive
+	// Build is recorded as the build time of the channel.
+	Build time.Time
+
+	Executable io.ReadSeeker
+	Size       int64
+	// Filename is the name the artifact is downloaded as, e.g.
+	// MyApp-1.4.2-setup.exe, which its Content-Disposition and Content-Type
+	// follow. It is downloaded under the last element of its key if empty.
+	Filename string
+
+	// Patch generates a delta from the previously published artifact of the
+	// platform in the channel.
+	Patch bool
+	// PatchVersions is the number of the last published versions of the
+	// platform patches are generated from, the previous one included. 0
+	// means 1.
+	PatchVersions int
+	// PatchMaxSize is the largest size of a patch in percent of the size of
+	// the executable. Larger patches are not published, and clients
+	// download the full artifact instead. 0 means no limit.
+	PatchMaxSize int
+	// PatchExecutable makes the patch of an ELF or PE executable with its
+	// calls and jumps rewritten to the layout of the previous version, so
+	// code shifted by the changes does not bloat it. Applying it needs a
+	// client knowing patch.FormatExecutable.
+	PatchExecutable bool
+	// Rollout, when set, offers a new version to that percentage of devices
+	// only.
+	Rollout *int
+	// AllowDowngrade permits publishing a version lower than the current
+	// version of the channel.
+	AllowDowngrade bool
+	// UploadID names the staging object the executable is uploaded to. An
+	// interrupted upload is resumed by publishing again with the same ID on a
+	// backend that supports it. A random ID is used when empty.
+	UploadID string
+	// SkipExisting hashes the executable before uploading it and skips the
+	// upload if its key already holds an object of the same
+	// size. The executable is read twice when it has to be uploaded.
+	SkipExisting bool
+	// Mandatory marks the version as an update clients cannot skip.
+	Mandatory bool
+	// Notes are the release notes of the version, kept in the manifest
+	// unless NotesObject is set, which stores them as a separate object.
+	// Empty notes leave those of the version unchanged.
+	Notes       string
+	NotesObject bool
+	// Sign also uploads a detached signature of the artifact by the signers
+	// of the publisher set with SignWith, and one in the format of each file
+	// signer set with SignFilesWith. There must be a signer of either.
+	Sign bool
+	// Sidecar also uploads a checksum file next to the artifact, named by
+	// SidecarKey.
+	Sidecar bool
+	// Compress also uploads a zstd compressed copy of the executable, unless
+	// that is not smaller.
+	Compress bool
+	// Chunks also uploads the executable split into content-defined chunks,
+	// leaving out those already stored for another artifact.
+	Chunks bool
+	// VerifyUpload downloads the artifact and patch again after uploading
+	// them and fails unless their checksums match, so a corrupted transfer is
+	// never referenced by the manifest.
+	VerifyUpload bool
+}
+
+// NewPublisher returns a Publisher for the manifest of appID stored in backend.
+func NewPublisher(backend storage.Backend, appID string) *Publisher {
+	return &Publisher{
+		backend:  backend,
+		appID:    appID,
+		manifest: &Manifest{},
+		keys:     DefaultKeyTemplate,
+		hash:     DefaultHashAlgorithm,
+	}
+}
+
+// SignWith makes Save publish a detached signature of the manifest by signers,
+// and the KeyList of their keys when it changes.
+func (p *Publisher) SignWith(signers ...signing.Signer) {
+	p.signers = signers
+}
+
+// RequireSignatures makes the manifest need signatures of threshold keys,
+// those of the signers and of cosigners, the keys of the other people
+// signing releases, all of which are published in the KeyList. Save stages
+// a manifest its signers cannot sign with enough keys instead of publishing
+// it, for SignPending to add the other signatures to and Finalize to publish
+// it then.
+func (p *Publisher) RequireSignatures(threshold int, cosigners ...signing.PublicKey) {
+	p.threshold, p.cosigners = threshold, cosigners
+}
+
+// Staged returns the manifest the last Save or Resign staged instead of
+// publishing it for want of signatures, or nil if it published the manifest.
+func (p *Publisher) Staged() *PendingManifest {
+	return p.staged
+}
+
+// ExpireSignatures makes the detached signatures of the manifest expire
+// validity after they are made, so clients reject a manifest that is not
+// re-signed with Resign in time as stale, rather than being kept on an old
+// release by whoever serves them an old manifest.
+func (p *Publisher) ExpireSignatures(validity time.Duration) {
+	p.signatureExpiry = validity
+}
+
+// TimestampWith makes Save obtain a trusted timestamp of the detached
+// signature of the manifest from tsa and store it under TimestampKey, which
+// proves when the manifest was signed even after the signing key expired.
+func (p *Publisher) TimestampWith(tsa *signing.TimestampAuthority) {
+	p.timestamps = tsa
+}
+
+// RecordIn makes Save record every signature of the manifest in the
+// transparency log and store the entries with their inclusion proofs under
+// TransparencyLogKey. The manifest itself cannot hold the entries of its own
+// signatures, which are made after it is written.
+func (p *Publisher) RecordIn(log *signing.TransparencyLog) {
+	p.log = log
+}
+
+// SignFilesWith makes Save also publish a detached signature of the manifest
+// in the format of each signer, stored under the key of the manifest with
+// the extension of the signer appended.
+func (p *Publisher) SignFilesWith(signers ...signing.FileSigner) {
+	p.fileSigners = signers
+}
+
+// KeepReleases limits the release history of a channel to the n newest
+// versions whenever a release is added to it. n <= 0 keeps every release.
+func (p *Publisher) KeepReleases(n int) {
+	p.keep = n
+}
+
+// CacheArtifactsIn makes AddRelease keep the previous artifacts it fetches
+// to generate patches in dir, named by their checksum, and read them from
+// there rather than from the backend when it needs them again.
+func (p *Publisher) CacheArtifactsIn(dir string) {
+	p.cache = dir
+}
+
+// UseKeyTemplate makes AddRelease store artifacts under keys laid out by t
+// instead of DefaultKeyTemplate.
+func (p *Publisher) UseKeyTemplate(t KeyTemplate) {
+	p.keys = t
+}
+
+// UseHashAlgorithm makes AddRelease hash artifacts with algo instead of
+// DefaultHashAlgorithm, as named by the Hash constants.
+func (p *Publisher) UseHashAlgorithm(algo string) error {
+	if _, err := NewHash(algo); err != nil {
+		return err
+	}
+	p.hash = algo
+	return nil
+}
+
+// Manifest returns the in-memory manifest.
+func (p *Publisher) Manifest() *Manifest {
+	return p.manifest
+}
+
+// Load fetches the current manifest. A missing manifest is treated as empty.
+func (p *Publisher) Load(ctx context.Context) error {
+	reader, info, err := p.backend.Get(ctx, ManifestKey(p.appID))
+	if errors.Is(err, storage.ErrNotExist) {
+		p.manifest, p.etag, p.exists, p.loaded = &Manifest{}, "", false, nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	p.manifest, p.etag, p.exists, p.loaded = &manifest, info.ETag, true, data
+	return nil
+}
+
+// Exists reports whether the loaded manifest was stored, rather than a new
+// empty manifest.
+func (p *Publisher) Exists() bool {
+	return p.exists
+}
+
+// Identities returns the identities of the keyless signatures of the
+// manifest written by the last Save by format, e.g. the certificate identity
+// of a cosign signature. Keyless signatures are made after the manifest is
+// written, so it cannot record them itself.
+func (p *Publisher) Identities() map[string]signing.Identity {
+	return p.identities
+}
+
+// Backup returns the key of the backup of the replaced manifest written by
+// the last Save, or "" if there was no manifest to replace.
+func (p *Publisher) Backup() string {
+	return p.backup
+}
+
+// Restore replaces the manifest with its backup stored under key, as
+// returned by Backup.
+func (p *Publisher) Restore(ctx context.Context, key string) error {
+	if !strings.HasPrefix(key, p.appID+"/history/") {
+		return fmt.Errorf("%s is not a manifest backup of %s", key, p.appID)
+	}
+
+	reader, _, err := p.backend.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest backup: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest backup: %w", err)
+	}
+
+	return p.change(func(m *Manifest) error {
+		var backup Manifest
+		if err := json.Unmarshal(data, &backup); err != nil {
+			return fmt.Errorf("failed to decode manifest backup: %w", err)
+		}
+		*m = backup
+		return nil
+	})
+}
+
+// change applies a modification to the manifest and remembers it for Save.
+func (p *Publisher) change(apply func(*Manifest) error) error {
+	if err := apply(p.manifest); err != nil {
+		return err
+	}
+	p.changes = append(p.changes, apply)
+	return nil
+}
+
+// AddRelease uploads the executable of req and records it in the manifest.
+// The manifest is not written until Save is called. Artifacts are content
+// addressed, so repeating a release whose Save failed uploads the same
+// objects again and completes it.
+func (p *Publisher) AddRelease(ctx context.Context, req ReleaseRequest) (*Artifact, error) {
+	if err := checkVersion(p.manifest, req); err != nil {
+		return nil, err
+	}
+	if req.Kind != "" && req.Variant != "" {
+		return nil, errors.New("artifact kinds have no variants")
+	}
+	if req.Sign && len(p.signers) == 0 && len(p.fileSigners) == 0 {
+		return nil, errors.New("no signing key to sign the artifact with")
+	}
+	if p.electron != nil && p.hash != HashSHA256 && p.hash != HashSHA512 {
+		return nil, fmt.Errorf("electron-updater cannot verify %s checksums, hash artifacts with sha256 or sha512", p.hash)
+	}
+	if p.tauri != nil && !(req.Sign && p.signsFiles(signing.FormatMinisign)) {
+		return nil, errors.New("the Tauri updater requires minisign signatures of the artifacts, sign them with a minisign key")
+	}
+	if p.token != nil {
+		if err := p.checkUploadToken(req); err != nil {
+			return nil, err
+		}
+	}
+
+	var appImage bool
+	if p.zsync != nil {
+		embedded, isAppImage, err := p.embedUpdateInformation(req)
+		if err != nil {
+			return nil, err
+		}
+		req.Executable, appImage = embedded, isAppImage
+	}
+
+	var key, checksum string
+	var err error
+	if p.token != nil {
+		if key, checksum, err = p.uploadToToken(ctx, req); err != nil {
+			return nil, err
+		}
+	} else {
+		if checksum, err = p.existingArtifact(ctx, req); err != nil {
+			return nil, err
+		}
+		if checksum == "" {
+			if checksum, err = p.uploadArtifact(ctx, req); err != nil {
+				return nil, err
+			}
+		}
+		key = p.keys.Key(p.appID, req, checksum)
+	}
+
+	if req.VerifyUpload {
+		if err := p.verifyUpload(ctx, key, p.hash, checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	var signature string
+	var signedBy []string
+	var signatures map[string]string
+	var identities map[string]signing.Identity
+	if req.Sign && len(p.signers) > 0 {
+		if signedBy, err = p.signArtifact(ctx, key, checksum); err != nil {
+			return nil, err
+		}
+		signature = ArtifactSignatureKey(key)
+	}
+	if req.Sign && len(p.fileSigners) > 0 {
+		if signatures, identities, err = p.signArtifactFile(ctx, key, req.Executable); err != nil {
+			return nil, err
+		}
+	}
+
+	var sidecar string
+	if req.Sidecar {
+		sidecar = SidecarKey(key, p.hash)
+		if err := p.putSidecar(ctx, sidecar, key, checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	var zsync string
+	if p.zsync != nil {
+		if zsync, err = p.putZsync(ctx, req, key, appImage); err != nil {
+			return nil, err
+		}
+	}
+
+	var compressed *Compressed
+	if req.Compress {
+		if compressed, err = p.uploadCompressed(ctx, key, req.Executable, req.Size); err != nil {
+			return nil, err
+		}
+		if compressed != nil && req.VerifyUpload {
+			if err := p.verifyUpload(ctx, compressed.Key, p.hash, compressed.Checksum); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var chunks *Chunks
+	if req.Chunks {
+		if chunks, err = p.uploadChunks(ctx, key, req.Executable); err != nil {
+			return nil, err
+		}
+		if req.VerifyUpload {
+			if err := p.verifyUpload(ctx, chunks.Key, p.hash, chunks.Checksum); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	patchVersions := max(req.PatchVersions, 1)
+	var deltas []Patch
+	if req.Patch {
+		bases := p.manifest.patchBases(req.Channel, req.Platform, req.Kind, req.Variant, p.hash, patchVersions)
+		if deltas, err = p.uploadPatches(ctx, req, bases, checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	var notesKey string
+	if req.Notes != "" && req.NotesObject {
+		notesKey = NotesKey(p.appID, req.Version)
+		if err := p.backend.Put(ctx, notesKey, strings.NewReader(req.Notes), int64(len(req.Notes)), storage.PutOptions{
+			ContentType:  "text/markdown; charset=utf-8",
+			CacheControl: p.caching.Mutable,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to upload release notes: %w", err)
+		}
+	}
+
+	var recorded *Artifact
+	err = p.change(func(m *Manifest) error {
+		if err := checkVersion(m, req); err != nil {
+			return err
+		}
+
+		previous := m.current(req.Channel, req.Platform, req.Kind, req.Variant)
+		bases := m.patchBases(req.Channel, req.Platform, req.Kind, req.Variant, p.hash, patchVersions)
+		channel := m.channel(req.Channel)
+		if channel.Version != req.Version {
+			// a new version starts without the yank and rollout state of the
+			// previous one, and without the variants and kinds of the
+			// platform, which must not be offered as builds of the new version
+			channel.Release = Release{Version: req.Version, Artifact: channel.Artifact, Rollout: req.Rollout}
+			if artifact := channel.FindArtifact(req.Platform); artifact != nil {
+				artifact.Variants, artifact.Kinds = nil, nil
+			}
+		}
+		channel.Build = req.Build
+		// the artifact of the platform published under a name that is not
+		// canonical moves to the canonical one
+		if key := channel.platformKey(req.Platform); key != req.Platform {
+			channel.Artifact[req.Platform] = channel.Artifact[key]
+			delete(channel.Artifact, key)
+		}
+		if req.Mandatory {
+			channel.Mandatory = true
+		}
+		if notesKey != "" {
+			channel.Notes, channel.NotesKey = "", notesKey
+		} else if req.Notes != "" {
+			channel.Notes, channel.NotesKey = req.Notes, ""
+		}
+
+		artifact := channel.artifact(req.Platform)
+		switch {
+		case req.Kind != "":
+			artifact = artifact.kind(req.Kind)
+		case req.Variant != "":
+			artifact = artifact.variant(req.Variant)
+		}
+		if artifact.Checksum != checksum {
+			// a patch is only recorded if the artifact it was made from is
+			// still one of the last versions, and as the patch from the
+			// previous one only if it still is the previous one
+			artifact.setPatch(Patch{})
+			artifact.Patches = nil
+			for _, base := range bases {
+				for _, delta := range deltas {
+					switch {
+					case delta.From != base.Checksum:
+					case delta.From == previous.Checksum:
+						artifact.setPatch(delta)
+					default:
+						artifact.Patches = append(artifact.Patches, delta)
+					}
+				}
+			}
+		}
+		artifact.Checksum = checksum
+		artifact.ChecksumAlgo = p.hash
+		artifact.Sidecar = sidecar
+		artifact.Zsync = zsync
+		artifact.Signature, artifact.SignedBy = signature, signedBy
+		artifact.Signatures, artifact.Identities = signatures, identities
+		artifact.Size = req.Size
+		artifact.MinOS = req.MinOS
+		artifact.Archive = req.Archive.Clone()
+		artifact.Compressed = compressed
+		artifact.Chunks = chunks
+		artifact.Binary = key
+
+		channel.record(req.Platform)
+		channel.trim(p.keep)
+
+		recorded = artifact
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recorded, nil
+}
+
+// existingArtifact returns the checksum of the executable of req if it is
+// already stored under its key, or "" when it has to be
+// uploaded. It only looks when req asks to skip existing artifacts.
+func (p *Publisher) existingArtifact(ctx context.Context, req ReleaseRequest) (string, error) {
+	if !req.SkipExisting {
+		return "", nil
+	}
+
+	if _, err := req.Executable.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to create checksum: %w", err)
+	}
+	checksum, err := newHashingReader(req.Executable, p.hasher()).checksum(req.Size)
+	if err != nil {
+		return "", fmt.Errorf("failed to create checksum: %w", err)
+	}
+	if _, err := req.Executable.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to create checksum: %w", err)
+	}
+
+	info, err := p.backend.Stat(ctx, p.keys.Key(p.appID, req, checksum))
+	if errors.Is(err, storage.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check for existing artifact: %w", err)
+	}
+	if info.Size != req.Size {
+		return "", nil
+	}
+	return checksum, nil
+}
+
+// uploadArtifact uploads the executable of req to the staging key of its
+// upload ID while computing its checksum, then moves it to its key.
+func (p *Publisher) uploadArtifact(ctx context.Context, req ReleaseRequest) (string, error) {
+	uploadID := req.UploadID
+	if uploadID == "" {
+		var id [16]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return "", fmt.Errorf("failed to generate staging key: %w", err)
+		}
+		uploadID = hex.EncodeToString(id[:])
+	}
+	staging := StagingKey(p.appID, uploadID)
+
+	hashed := newHashingReader(req.Executable, p.hasher())
+	if err := p.backend.Put(ctx, staging, hashed, req.Size, p.artifactOptions(req)); err != nil {
+		p.backend.Delete(context.WithoutCancel(ctx), staging)
+		return "", fmt.Errorf("failed to upload artifact: %w", err)
+	}
+
+	checksum, err := hashed.checksum(req.Size)
+	if err != nil {
+		p.backend.Delete(context.WithoutCancel(ctx), staging)
+		return "", fmt.Errorf("failed to create checksum: %w", err)
+	}
+
+	if err := p.backend.Move(ctx, staging, p.keys.Key(p.appID, req, checksum)); err != nil {
+		p.backend.Delete(context.WithoutCancel(ctx), staging)
+		return "", fmt.Errorf("failed to move artifact into place: %w", err)
+	}
+	return checksum, nil
+}
+
+// checkVersion validates the version of req as a semantic version and
+// rejects downgrades in m unless allowed. A current version that is not a
+// valid semantic version cannot be compared and does not block publishing.
+func checkVersion(m *Manifest, req ReleaseRequest) error {
+	version, err := semver.Parse(req.Version)
+	if err != nil {
+		return err
+	}
+
+	channel, ok := m.Channel[req.Channel]
+	if !ok || channel.Version == "" || req.AllowDowngrade {
+		return nil
+	}
+
+	current, err := semver.Parse(channel.Version)
+	if err != nil {
+		return nil
+	}
+
+	if version.Compare(current) < 0 {
+		return fmt.Errorf("%w: %s < %s in channel %s", ErrDowngrade, req.Version, channel.Version, req.Channel)
+	}
+	return nil
+}
+
+// merge loads the current manifest and applies the changes made to the
+// previously loaded one.
+func (p *Publisher) merge(ctx context.Context) error {
+	if err := p.Load(ctx); err != nil {
+		return err
+	}
+	for _, apply := range p.changes {
+		if err := apply(p.manifest); err != nil {
+			return fmt.Errorf("failed to merge with concurrently published manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+// backUp copies the loaded manifest to a new history key.
+func (p *Publisher) backUp(ctx context.Context) error {
+	p.backup = ""
+	if !p.exists {
+		return nil
+	}
+
+	key := HistoryKey(p.appID, time.Now())
+	if p.token != nil {
+		key = p.token.Backup
+	}
+	if err := p.backend.Put(ctx, key, bytes.NewReader(p.loaded), int64(len(p.loaded)), storage.PutOptions{
+		ContentType:  "application/json",
+		CacheControl: p.caching.Immutable,
+	}); err != nil {
+		return fmt.Errorf("failed to back up manifest: %w", err)
+	}
+	p.backup = key
+	return nil
+}
+
+// maxSaveAttempts bounds how often Save merges its changes into a manifest
+// written concurrently by another publisher.
+const maxSaveAttempts = 5
+
+// Save writes the manifest back to the backend, after backing up the manifest
+// it replaces under its HistoryKey. The write only succeeds if
+// the manifest was not replaced since it was loaded; otherwise the current
+// manifest is loaded again, the changes made since are applied to it and the
+// write is retried, so concurrent publishers merge instead of overwriting
+// each other. The objects written are purged by the Purger of PurgeWith
+// once all are written.
+func (p *Publisher) Save(ctx context.Context) error {
+	return p.purging(ctx, p.save)
+}
+
+// purging runs write, then has the Purger of PurgeWith, if any, purge the
+// objects it wrote.
+func (p *Publisher) purging(ctx context.Context, write func(context.Context) error) error {
+	if p.purger == nil {
+		return write(ctx)
+	}
+
+	recorder := &recordingBackend{Backend: p.backend}
+	p.backend = recorder
+	err := write(ctx)
+	p.backend = recorder.Backend
+	if err != nil {
+		return err
+	}
+	// the manifest is written again for every merge
+	slices.Sort(recorder.keys)
+	return p.purger.Purge(ctx, slices.Compact(recorder.keys))
+}
+
+func (p *Publisher) save(ctx context.Context) error {
+	p.staged = nil
+	required, err := p.requiredSignatures(ctx)
+	if err != nil {
+		return err
+	}
+	if required > len(p.signers) {
+		return p.stage(ctx)
+	}
+
+	var marshaledManifest []byte
+	for attempt := 1; ; attempt++ {
+		marshaledManifest, err = json.Marshal(p.manifest)
+		if err != nil {
+			return fmt.Errorf("failed to marshal manifest: %w", err)
+		}
+
+		if err := p.backUp(ctx); err != nil {
+			return err
+		}
+
+		opts := storage.PutOptions{ContentType: "application/json", CacheControl: p.caching.Mutable}
+		if p.exists {
+			opts.IfMatch = p.etag
+		} else {
+			opts.IfNoneMatch = true
+		}
+
+		err = p.backend.Put(ctx, ManifestKey(p.appID), bytes.NewReader(marshaledManifest), int64(len(marshaledManifest)), opts)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, storage.ErrPreconditionFailed) || attempt == maxSaveAttempts {
+			return fmt.Errorf("failed to upload manifest: %w", err)
+		}
+
+		if err := p.merge(ctx); err != nil {
+			return err
+		}
+	}
+	p.changes = nil
+
+	// upload tokens grant no URL for the key list, which an administrator
+	// publishes when changing the keys
+	if len(p.signers) > 0 && p.token == nil {
+		if err := p.writeKeyList(ctx); err != nil {
+			return err
+		}
+	}
+	return p.published(ctx, marshaledManifest, nil, nil)
+}
+
+// requiredSignatures returns the number of keys the manifest needs
+// signatures of: the threshold of the publisher, or of the published key list
+// if that is higher, as clients verify the manifest with it until the list
+// changes.
+func (p *Publisher) requiredSignatures(ctx context.Context) (int, error) {
+	if p.threshold == 0 && len(p.signers) == 0 {
+		return 0, nil
+	}
+	published, err := LoadKeyList(ctx, p.backend, p.appID)
+	if err != nil || published == nil {
+		return p.threshold, err
+	}
+	return max(p.threshold, published.RequiredSignatures()), nil
+}
+
+// published writes the objects derived from the manifest just written as
+// marshaledManifest: its signatures, feeds, TUF metadata and client
+// manifest. The detached signature is envelope, by the keys, or made by the
+// signers of the publisher if nil.
+func (p *Publisher) published(ctx context.Context, marshaledManifest []byte, envelope *signing.Envelope, keys []signing.PublicKey) error {
+	p.identities = nil
+	for _, signer := range p.fileSigners {
+		signature, err := p.signFile(ctx, signer, ManifestKey(p.appID), "manifest.json", bytes.NewReader(marshaledManifest))
+		if err != nil {
+			return err
+		}
+		if keyless, ok := signer.(signing.KeylessSigner); ok {
+			identity, err := keyless.Identity(signature)
+			if err != nil {
+				return err
+			}
+			if p.identities == nil {
+				p.identities = make(map[string]signing.Identity)
+			}
+			p.identities[signer.Format()] = identity
+		}
+	}
+
+	if p.appcast != nil {
+		if err := p.writeAppcasts(ctx); err != nil {
+			return err
+		}
+	}
+
+	if p.electron != nil {
+		if err := p.writeElectronFeeds(ctx); err != nil {
+			return err
+		}
+	}
+
+	if p.tauri != nil {
+		if err := p.writeTauriFeeds(ctx); err != nil {
+			return err
+		}
+	}
+
+	if p.squirrel != nil {
+		if err := p.writeSquirrelFeeds(ctx); err != nil {
+			return err
+		}
+	}
+
+	if p.zsync != nil {
+		if err := p.writeZsyncFeeds(ctx); err != nil {
+			return err
+		}
+	}
+
+	if p.tuf != nil {
+		if err := p.writeTUF(ctx, marshaledManifest, *p.tuf); err != nil {
+			return err
+		}
+	}
+
+	if p.presign != 0 {
+		if err := p.writeClientManifest(ctx, p.presign); err != nil {
+			return err
+		}
+	}
+
+	if envelope == nil {
+		if len(p.signers) == 0 {
+			return nil
+		}
+		var err error
+		if envelope, err = p.signManifest(ctx, marshaledManifest); err != nil {
+			return err
+		}
+		for _, signer := range p.signers {
+			keys = append(keys, signer.Public())
+		}
+	}
+	return p.writeSignature(ctx, marshaledManifest, envelope, keys)
+}
+
+// signManifest signs marshaledManifest with the signers, with signatures
+// expiring as ExpireSignatures set.
+func (p *Publisher) signManifest(ctx context.Context, marshaledManifest []byte) (*signing.Envelope, error) {
+	var expires *time.Time
+	if p.signatureExpiry > 0 {
+		at := time.Now().Add(p.signatureExpiry).UTC().Truncate(time.Second)
+		expires = &at
+	}
+	return signing.SignExpiring(ctx, marshaledManifest, expires, p.signers...)
+}
+
+// writeSignature uploads envelope, the signatures of marshaledManifest by
+// keys, and records and timestamps it as configured.
+func (p *Publisher) writeSignature(ctx context.Context, marshaledManifest []byte, envelope *signing.Envelope, keys []signing.PublicKey) error {
+	marshaledEnvelope, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal signature: %w", err)
+	}
+
+	if err := p.backend.Put(ctx, SignatureKey(p.appID), bytes.NewReader(marshaledEnvelope), int64(len(marshaledEnvelope)), storage.PutOptions{
+		ContentType:  "application/json",
+		CacheControl: p.caching.Mutable,
+	}); err != nil {
+		return fmt.Errorf("failed to upload signature: %w", err)
+	}
+
+	if p.log != nil {
+		if err := p.recordSignatures(ctx, marshaledManifest, envelope, keys); err != nil {
+			return err
+		}
+	}
+	if p.timestamps == nil {
+		return nil
+	}
+	response, _, err := p.timestamps.Timestamp(ctx, marshaledEnvelope)
+	if err != nil {
+		return err
+	}
+	if err := p.backend.Put(ctx, TimestampKey(p.appID), bytes.NewReader(response), int64(len(response)), storage.PutOptions{
+		ContentType:  "application/timestamp-reply",
+		CacheControl: p.caching.Mutable,
+	}); err != nil {
+		return fmt.Errorf("failed to upload timestamp: %w", err)
+	}
+	return nil
+}
+
+// AddChannel adds an empty channel to the manifest. Adding an existing
+// channel changes nothing.
+func (p *Publisher) AddChannel(name string) error {
+	if name == "" {
+		return errors.New("channel name is empty")
+	}
+	return p.change(func(m *Manifest) error {
+		m.channel(name)
+		return nil
+	})
+}
+
+// SetMinVersion sets the oldest client version channel supports. The empty
+// version supports every version.
+func (p *Publisher) SetMinVersion(channel, version string) error {
+	if version != "" {
+		if _, err := semver.Parse(version); err != nil {
+			return err
+		}
+	}
+	return p.change(func(m *Manifest) error {
+		ch, ok := m.Channel[channel]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrChannelNotFound, channel)
+		}
+		if version != "" && ch.Version != "" {
+			if c, err := semver.Compare(version, ch.Version); err == nil && c > 0 {
+				return fmt.Errorf("minimum version %s is newer than the version %s of channel %s", version, ch.Version, channel)
+			}
+		}
+		ch.MinVersion = version
+		return nil
+	})
+}
+
+// Promote copies the release of channel from into channel to: its version,
+// build time and artifacts including their patches. Artifacts are referenced,
+// not uploaded again. The release is recorded in the history of the target
+// channel, whose metadata is kept.
+func (p *Publisher) Promote(from, to string) error {
+	return p.change(func(m *Manifest) error {
+		source, ok := m.Channel[from]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrChannelNotFound, from)
+		}
+		if from == to {
+			return fmt.Errorf("cannot promote channel %s to itself", from)
+		}
+
+		target := m.channel(to)
+		target.Release = *source.Release.Clone()
+		for platform := range target.Artifact {
+			target.record(platform)
+		}
+		target.trim(p.keep)
+
+		return nil
+	})
+}
+
+// Prune drops all but the keep newest recorded releases of every channel and
+// returns how many it dropped. The record of the current release of a channel
+// is always kept. The artifacts of dropped releases are not deleted.
+func (p *Publisher) Prune(keep int) (int, error) {
+	var dropped int
+	err := p.change(func(m *Manifest) error {
+		dropped = 0
+		for _, channel := range m.Channel {
+			before := len(channel.Releases)
+			channel.trim(keep)
+			dropped += before - len(channel.Releases)
+		}
+		return nil
+	})
+	return dropped, err
+}
+
+// ErrReleaseNotFound is returned when a channel has no record of a version.
+var ErrReleaseNotFound = errors.New("release not found")
+
+// Rollback makes the recorded release of version the current release of
+// channel. Newer releases stay in the history.
+func (p *Publisher) Rollback(channel, version string) error {
+	return p.change(func(m *Manifest) error {
+		ch, ok := m.Channel[channel]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrChannelNotFound, channel)
+		}
+
+		release := ch.Find(version)
+		if release == nil {
+			return fmt.Errorf("%w: %s in channel %s", ErrReleaseNotFound, version, channel)
+		}
+		if release.Yanked {
+			return fmt.Errorf("release %s of channel %s is yanked", version, channel)
+		}
+
+		ch.Release = *release.Clone()
+		return nil
+	})
+}
+
+// Yank marks the recorded release of version as broken. Its artifacts are
+// kept. If it is the current release of channel, the newest older release
+// that is not yanked becomes current; with none left, the current release
+// stays but is marked as yanked so clients skip it.
+func (p *Publisher) Yank(channel, version, reason string) error {
+	return p.change(func(m *Manifest) error {
+		ch, ok := m.Channel[channel]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrChannelNotFound, channel)
+		}
+
+		release := ch.Find(version)
+		if release == nil {
+			return fmt.Errorf("%w: %s in channel %s", ErrReleaseNotFound, version, channel)
+		}
+		release.Yanked = true
+		release.YankReason = reason
+
+		if ch.Version != version {
+			return nil
+		}
+
+		if previous := ch.Previous(); previous != nil {
+			ch.Release = *previous.Clone()
+			return nil
+		}
+
+		ch.Yanked = true
+		ch.YankReason = reason
+		return nil
+	})
+}
+
+// Unyank clears the yanked mark of the recorded release of version. The
+// current release of channel is not changed.
+func (p *Publisher) Unyank(channel, version string) error {
+	return p.change(func(m *Manifest) error {
+		ch, ok := m.Channel[channel]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrChannelNotFound, channel)
+		}
+
+		release := ch.Find(version)
+		if release == nil {
+			return fmt.Errorf("%w: %s in channel %s", ErrReleaseNotFound, version, channel)
+		}
+		release.Yanked = false
+		release.YankReason = ""
+
+		if ch.Version == version {
+			ch.Yanked = false
+			ch.YankReason = ""
+		}
+		return nil
+	})
+}
+
+// SetRollout offers the current release of channel to percent of devices and
+// resumes a paused rollout.
+func (p *Publisher) SetRollout(channel string, percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("rollout percentage %d is not between 0 and 100", percent)
+	}
+
+	return p.updateCurrent(channel, func(release *Release) {
+		release.Rollout = &percent
+		release.RolloutPaused = false
+	})
+}
+
+// PauseRollout stops offering the current release of channel to devices that
+// have not installed it yet.
+func (p *Publisher) PauseRollout(channel string) error {
+	return p.updateCurrent(channel, func(release *Release) {
+		release.RolloutPaused = true
+	})
+}
+
+// ResumeRollout continues a paused rollout of the current release of channel.
+func (p *Publisher) ResumeRollout(channel string) error {
+	return p.updateCurrent(channel, func(release *Release) {
+		release.RolloutPaused = false
+	})
+}
+
+// updateCurrent applies update to the current release of channel and its
+// history entry.
+func (p *Publisher) updateCurrent(channel string, update func(*Release)) error {
+	return p.change(func(m *Manifest) error {
+		ch, ok := m.Channel[channel]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrChannelNotFound, channel)
+		}
+
+		update(&ch.Release)
+		if release := ch.Find(ch.Version); release != nil {
+			update(release)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,47 @@
+package manifest
+
+import (
+	"context"
+	"io"
+
+	"update-manifest/pkg/storage"
+)
+
+// Purger invalidates the copies a CDN caches of the objects under keys.
+type Purger interface {
+	Purge(ctx context.Context, keys []string) error
+}
+
+// PurgeWith makes Save purge every object it writes, the manifest, its
+// signatures, TUF metadata and feeds, from the cache of a CDN with purger,
+// so clients behind it see them at once rather than once their cached
+// copies expire.
+func (p *Publisher) PurgeWith(purger Purger) {
+	p.purger = purger
+}
+
+// recordingBackend records the keys of the objects written to Backend.
+type recordingBackend struct {
+	storage.Backend
+	keys []string
+}
+
+func (b *recordingBackend) Unwrap() storage.Backend {
+	return b.Backend
+}
+
+func (b *recordingBackend) Put(ctx context.Context, key string, r io.Reader, size int64, opts storage.PutOptions) error {
+	if err := b.Backend.Put(ctx, key, r, size, opts); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, key)
+	return nil
+}
+
+func (b *recordingBackend) Move(ctx context.Context, src, dst string) error {
+	if err := b.Backend.Move(ctx, src, dst); err != nil {
+		return err
+	}
+	b.keys = append(b.keys, dst)
+	return nil
+}
@@ -0,0 +1,186 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ScoopPackage describes the package the Scoop manifest of a release is
+// rendered for.
+type ScoopPackage struct {
+	// Description, Homepage and License describe the package. License is
+	// an SPDX identifier, e.g. MIT.
+	Description string
+	Homepage    string
+	License     string
+	// BaseURL is the public URL of the bucket. Scoop downloads the artifacts
+	// and checks for updates in the manifest at their keys resolved against
+	// it.
+	BaseURL string
+	// Kind, when set, installs the artifact of that kind, e.g. a zip of the
+	// application, instead of the artifact itself, leaving out the platforms
+	// without one.
+	Kind string
+	// Bin is the name the executable is installed as, e.g. myapp.exe.
+	Bin string
+}
+
+// scoopArch names the architectures of Scoop by GOARCH.
+var scoopArch = map[string]string{
+	"amd64": "64bit",
+	"386":   "32bit",
+	"arm64": "arm64",
+}
+
+// scoopMatch names the checkver match of the artifact key of each
+// architecture by GOARCH, which the autoupdate URL reads as $match<Name>.
+var scoopMatch = map[string]string{
+	"amd64": "amd64",
+	"386":   "x86",
+	"arm64": "arm64",
+}
+
+type scoopManifest struct {
+	Version      string                       `json:"version"`
+	Description  string                       `json:"description,omitempty"`
+	Homepage     string                       `json:"homepage,omitempty"`
+	License      string                       `json:"license,omitempty"`
+	Architecture map[string]scoopArchitecture `json:"architecture"`
+	Bin          any                          `json:"bin"`
+	Checkver     *scoopCheckver               `json:"checkver,omitempty"`
+	Autoupdate   *scoopAutoupdate             `json:"autoupdate,omitempty"`
+}
+
+type scoopArchitecture struct {
+	URL  string `json:"url"`
+	Hash any    `json:"hash,omitempty"`
+}
+
+type scoopCheckver struct {
+	Script []string `json:"script"`
+	Regex  string   `json:"regex"`
+}
+
+type scoopAutoupdate struct {
+	Architecture map[string]scoopArchitecture `json:"architecture"`
+}
+
+type scoopHash struct {
+	URL      string `json:"url"`
+	JSONPath string `json:"jsonpath"`
+}
+
+// Scoop renders the Scoop manifest of release of channel as pkg. Every
+// Windows platform of the release is installed on its architecture, an
+// executable renamed to Bin and an archive extracted. Artifact keys hold
+// their checksum, so the URLs of a newer version cannot be told by the
+// version alone: the checkver stanza reads the version and artifact keys of
+// channel from the manifest, which the autoupdate stanza downloads, and the
+// checksum of sha256 and sha512 artifacts too. Scoop verifies downloads by
+// their SHA-256, SHA-512, SHA-1 or MD5 digest, so artifacts hashed with
+// another algorithm are downloaded to compute their SHA-256 digest, and
+// by autoupdate on every update.
+func (p *Publisher) Scoop(ctx context.Context, channel string, release *Release, pkg ScoopPackage) ([]byte, error) {
+	if release.Version == "" {
+		return nil, errors.New("the release has no version")
+	}
+	if pkg.Bin == "" {
+		return nil, errors.New("a Scoop manifest needs the name of the executable it installs")
+	}
+
+	manifestURL := strings.TrimSuffix(pkg.BaseURL, "/") + "/" + ManifestKey(p.appID)
+	scoop := &scoopManifest{
+		Version:      release.Version,
+		Description:  pkg.Description,
+		Homepage:     pkg.Homepage,
+		License:      pkg.License,
+		Architecture: make(map[string]scoopArchitecture),
+		Bin:          pkg.Bin,
+		Autoupdate:   &scoopAutoupdate{Architecture: make(map[string]scoopArchitecture)},
+	}
+	// the script prints the version and the key of every architecture, which
+	// the regex matches
+	output, pattern := []string{"$($channel.version)"}, []string{`(?<version>\S+)`}
+	for _, platform := range sortedNames(release.Artifact) {
+		normalized, err := NormalizePlatform(platform)
+		if err != nil {
+			continue
+		}
+		goos, arch, _ := strings.Cut(normalized, "/")
+		artifact := release.Artifact[platform].Select(pkg.Kind, "")
+		if goos != "windows" || scoopArch[arch] == "" || artifact == nil || artifact.Binary == "" {
+			continue
+		}
+
+		url := strings.TrimSuffix(pkg.BaseURL, "/") + "/" + artifact.Binary
+		if artifact.Archive != nil {
+			// an executable of another name is installed under the name of
+			// Bin by its shim
+			if executable := artifact.Archive.Executable; executable != "" && path.Base(executable) == pkg.Bin {
+				scoop.Bin = executable
+			} else if executable != "" {
+				scoop.Bin = [][]string{{executable, strings.TrimSuffix(pkg.Bin, ".exe")}}
+			}
+		} else {
+			// Scoop names the download by the fragment of its URL, as
+			// content addressed keys have no extension
+			url += "#/" + path.Base(pkg.Bin)
+		}
+
+		entry := scoopArchitecture{URL: url}
+		switch artifact.HashAlgorithm() {
+		case HashSHA256:
+			entry.Hash = artifact.Checksum
+		case HashSHA512:
+			entry.Hash = "sha512:" + artifact.Checksum
+		default:
+			digest, err := p.sha256(ctx, artifact)
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash the artifact of %s: %w", platform, err)
+			}
+			entry.Hash = digest
+		}
+		scoop.Architecture[scoopArch[arch]] = entry
+
+		record, jsonPath := fmt.Sprintf("$channel.artifact.'%s'", platform), fmt.Sprintf("$.channel.%s.artifact['%s']", channel, platform)
+		if pkg.Kind != "" {
+			record += fmt.Sprintf(".kinds.'%s'", pkg.Kind)
+			jsonPath += fmt.Sprintf(".kinds.%s", pkg.Kind)
+		}
+		output = append(output, fmt.Sprintf("$(%s.binary)", record))
+		pattern = append(pattern, fmt.Sprintf(`(?<%s>\S+)`, scoopMatch[arch]))
+
+		name := scoopMatch[arch]
+		update := scoopArchitecture{URL: strings.Replace(url, artifact.Binary, "$match"+strings.ToUpper(name[:1])+name[1:], 1)}
+		// Scoop tells sha256 and sha512 checksums apart by their length,
+		// and downloads artifacts to hash them otherwise
+		if algo := artifact.HashAlgorithm(); algo == HashSHA256 || algo == HashSHA512 {
+			update.Hash = scoopHash{URL: manifestURL, JSONPath: jsonPath + ".checksum"}
+		}
+		scoop.Autoupdate.Architecture[scoopArch[arch]] = update
+	}
+	if len(scoop.Architecture) == 0 {
+		return nil, fmt.Errorf("release %s has no Windows artifact", release.Version)
+	}
+	scoop.Checkver = &scoopCheckver{
+		Script: []string{
+			fmt.Sprintf("$channel = (Invoke-RestMethod -Uri '%s').channel.'%s'", manifestURL, channel),
+			fmt.Sprintf(`Write-Output "%s"`, strings.Join(output, " ")),
+		},
+		Regex: "^" + strings.Join(pattern, " ") + "$",
+	}
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(scoop); err != nil {
+		return nil, fmt.Errorf("failed to encode Scoop manifest: %w", err)
+	}
+	return data.Bytes(), nil
+}
@@ -0,0 +1,45 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"update-manifest/pkg/storage"
+)
+
+// sidecarExtensions are the extensions of sidecar checksum files by
+// checksum algorithm, those the usual command line tools are known by.
+var sidecarExtensions = map[string]string{
+	HashBlake2b: ".b2",
+	HashSHA256:  ".sha256",
+	HashSHA512:  ".sha512",
+	HashBlake3:  ".b3",
+}
+
+// SidecarKey returns the object key of the sidecar checksum file of the
+// artifact stored under key with a checksum made with algo, e.g. the key
+// with .sha256 appended.
+func SidecarKey(key, algo string) string {
+	if algo == "" {
+		algo = DefaultHashAlgorithm
+	}
+	return key + sidecarExtensions[algo]
+}
+
+// putSidecar uploads the sidecar checksum file of the artifact stored under
+// key to sidecar. It holds one line in the format of sha256sum and its
+// siblings, naming the artifact by the last element of its key, so that
+// `sha256sum -c` verifies a manually downloaded artifact next to it. BLAKE2b
+// checksums are checked with `b2sum -l 256 -c`.
+func (p *Publisher) putSidecar(ctx context.Context, sidecar, key, checksum string) error {
+	line := fmt.Sprintf("%s  %s\n", checksum, path.Base(key))
+	if err := p.backend.Put(ctx, sidecar, strings.NewReader(line), int64(len(line)), storage.PutOptions{
+		ContentType:  "text/plain; charset=utf-8",
+		CacheControl: p.caching.Immutable,
+	}); err != nil {
+		return fmt.Errorf("failed to upload checksum file: %w", err)
+	}
+	return nil
+}
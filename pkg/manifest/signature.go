@@ -0,0 +1,214 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"time"
+
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage"
+)
+
+// ArtifactSignatureKey returns the object key of the detached signature of
+// the artifact stored under key.
+func ArtifactSignatureKey(key string) string {
+	return key + ".sig"
+}
+
+// ArtifactMessage returns the message the detached signature of an artifact
+// signs: its checksum and the algorithm of the checksum. The signature covers
+// the content of the artifact through the checksum, so neither signing nor
+// verifying it needs the whole artifact in memory.
+func ArtifactMessage(algo, checksum string) []byte {
+	if algo == "" {
+		algo = DefaultHashAlgorithm
+	}
+	return []byte("update-manifest artifact\x00" + algo + "\x00" + checksum)
+}
+
+// signArtifact uploads a detached signature of the artifact stored under key
+// by every signer of the publisher. It returns the IDs of the signing keys.
+func (p *Publisher) signArtifact(ctx context.Context, key, checksum string) ([]string, error) {
+	envelope, err := signing.Sign(ctx, ArtifactMessage(p.hash, checksum), p.signers...)
+	if err != nil {
+		return nil, err
+	}
+
+	marshaledEnvelope, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal signature: %w", err)
+	}
+	if err := p.backend.Put(ctx, ArtifactSignatureKey(key), bytes.NewReader(marshaledEnvelope), int64(len(marshaledEnvelope)), storage.PutOptions{
+		ContentType:  "application/json",
+		CacheControl: p.caching.Mutable,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to upload artifact signature: %w", err)
+	}
+
+	keyIDs := make([]string, len(envelope.Signatures))
+	for i, signature := range envelope.Signatures {
+		keyIDs[i] = signature.KeyID
+	}
+	return keyIDs, nil
+}
+
+// Resign signs the stored manifest again, unchanged, with new signatures
+// expiring as ExpireSignatures sets, before the published ones expire. When
+// the signers are fewer than the manifest needs signatures of, the signed
+// manifest is staged instead, like Save stages it, for SignPending to add the
+// other signatures to and Finalize to publish it then.
+func (p *Publisher) Resign(ctx context.Context) error {
+	if !p.exists {
+		return errors.New("no manifest to re-sign")
+	}
+	if len(p.signers) == 0 {
+		return errors.New("re-signing the manifest needs a signing key")
+	}
+	required, err := p.requiredSignatures(ctx)
+	if err != nil {
+		return err
+	}
+
+	envelope, err := p.signManifest(ctx, p.loaded)
+	if err != nil {
+		return err
+	}
+	if required > len(p.signers) {
+		pending := &PendingManifest{
+			AppID:      p.appID,
+			Staged:     time.Now().UTC().Truncate(time.Second),
+			Replaces:   p.etag,
+			Manifest:   p.loaded,
+			Signatures: *envelope,
+		}
+		if err := p.putPending(ctx, pending, ""); err != nil {
+			return err
+		}
+		p.staged = pending
+		return nil
+	}
+	keys := make([]signing.PublicKey, len(p.signers))
+	for i, signer := range p.signers {
+		keys[i] = signer.Public()
+	}
+	return p.purging(ctx, func(ctx context.Context) error {
+		return p.writeSignature(ctx, p.loaded, envelope, keys)
+	})
+}
+
+// recordSignatures records the signatures of envelope, made over manifest
+// by keys, in the transparency log of the publisher and uploads the entries.
+func (p *Publisher) recordSignatures(ctx context.Context, manifest []byte, envelope *signing.Envelope, keys []signing.PublicKey) error {
+	entries := make([]*signing.LogEntry, len(envelope.Signatures))
+	for i, signature := range envelope.Signatures {
+		index := slices.IndexFunc(keys, func(key signing.PublicKey) bool { return key.ID == signature.KeyID })
+		if index < 0 {
+			return fmt.Errorf("failed to record signature of key %s: unknown key", signature.KeyID)
+		}
+		entry, err := p.log.Record(ctx, envelope.SignedMessage(manifest), signature, keys[index])
+		if err != nil {
+			return fmt.Errorf("failed to record signature of key %s: %w", signature.KeyID, err)
+		}
+		entries[i] = entry
+	}
+
+	marshaledEntries, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transparency log entries: %w", err)
+	}
+	if err := p.backend.Put(ctx, TransparencyLogKey(p.appID), bytes.NewReader(marshaledEntries), int64(len(marshaledEntries)), storage.PutOptions{
+		ContentType:  "application/json",
+		CacheControl: p.caching.Mutable,
+	}); err != nil {
+		return fmt.Errorf("failed to upload transparency log entries: %w", err)
+	}
+	return nil
+}
+
+// signArtifactFile uploads a detached signature of the executable stored
+// under key by every file signer of the publisher, under the key with the
+// extension of the signer appended. It returns the keys of the signatures
+// and the identities of the keyless ones by format.
+func (p *Publisher) signArtifactFile(ctx context.Context, key string, executable io.ReadSeeker) (map[string]string, map[string]signing.Identity, error) {
+	signatures := make(map[string]string, len(p.fileSigners))
+	var identities map[string]signing.Identity
+	for _, signer := range p.fileSigners {
+		if _, err := executable.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, fmt.Errorf("failed to seek to beginning of executable: %w", err)
+		}
+		signature, err := p.signFile(ctx, signer, key, path.Base(key), executable)
+		if err != nil {
+			return nil, nil, err
+		}
+		signatures[signer.Format()] = key + signer.Extension()
+
+		if keyless, ok := signer.(signing.KeylessSigner); ok {
+			identity, err := keyless.Identity(signature)
+			if err != nil {
+				return nil, nil, err
+			}
+			if identities == nil {
+				identities = make(map[string]signing.Identity)
+			}
+			identities[signer.Format()] = identity
+		}
+	}
+	return signatures, identities, nil
+}
+
+// signsFiles reports whether the publisher has a file signer of format.
+func (p *Publisher) signsFiles(format string) bool {
+	for _, signer := range p.fileSigners {
+		if signer.Format() == format {
+			return true
+		}
+	}
+	return false
+}
+
+// signFile signs the content of r, published under key as name, with signer
+// and uploads the signature under key with the extension of the signer
+// appended, returning the signature.
+func (p *Publisher) signFile(ctx context.Context, signer signing.FileSigner, key, name string, r io.Reader) ([]byte, error) {
+	signature, err := signer.SignFile(ctx, name, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign %s with %s: %w", name, signer.Format(), err)
+	}
+
+	contentType := "text/plain; charset=utf-8"
+	if json.Valid(signature) {
+		contentType = "application/json"
+	}
+	if err := p.backend.Put(ctx, key+signer.Extension(), bytes.NewReader(signature), int64(len(signature)), storage.PutOptions{
+		ContentType:  contentType,
+		CacheControl: p.caching.Mutable,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to upload %s signature of %s: %w", signer.Format(), name, err)
+	}
+	return signature, nil
+}
+
+// readSignature fetches the signature in format stored under key, e.g. for a
+// feed that embeds the signatures of the artifacts it offers.
+func (p *Publisher) readSignature(ctx context.Context, format, key string) ([]byte, error) {
+	reader, _, err := p.backend.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, fmt.Errorf("%s signature %s does not exist", format, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s signature: %w", format, err)
+	}
+	defer reader.Close()
+
+	signature, err := io.ReadAll(io.LimitReader(reader, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s signature: %w", format, err)
+	}
+	return signature, nil
+}
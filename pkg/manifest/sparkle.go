@@ -0,0 +1,178 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage"
+)
+
+// sparkleNamespace is the XML namespace of the Sparkle elements of an
+// appcast.
+const sparkleNamespace = "http://www.andymatuschak.org/xml-namespaces/sparkle"
+
+// Appcast selects the artifacts offered by the Sparkle appcasts Save writes
+// for every channel.
+type Appcast struct {
+	// BaseURL is the public URL of the bucket. Sparkle downloads the
+	// artifacts and release notes from their keys resolved against it.
+	BaseURL string
+	// Platform is the platform whose artifact is offered, the first darwin
+	// platform of a release when empty, e.g. that of a universal build.
+	Platform string
+	// Kind, when set, offers the artifact of that kind of the platform
+	// instead, e.g. a zip or dmg of the application bundle, which Sparkle
+	// installs rather than a bare executable.
+	Kind string
+}
+
+// AppcastKey returns the object key of the Sparkle appcast of channel.
+func AppcastKey(appID, channel string) string {
+	return fmt.Sprintf("%s/sparkle/%s/appcast.xml", appID, channel)
+}
+
+type appcastRSS struct {
+	XMLName xml.Name       `xml:"rss"`
+	Version string         `xml:"version,attr"`
+	Sparkle string         `xml:"xmlns:sparkle,attr"`
+	Channel appcastChannel `xml:"channel"`
+}
+
+type appcastChannel struct {
+	Title string        `xml:"title"`
+	Items []appcastItem `xml:"item"`
+}
+
+type appcastItem struct {
+	Title                string           `xml:"title"`
+	PubDate              string           `xml:"pubDate,omitempty"`
+	Version              string           `xml:"sparkle:version"`
+	ShortVersionString   string           `xml:"sparkle:shortVersionString"`
+	MinimumSystemVersion string           `xml:"sparkle:minimumSystemVersion,omitempty"`
+	CriticalUpdate       *appcastCritical `xml:"sparkle:criticalUpdate"`
+	ReleaseNotesLink     string           `xml:"sparkle:releaseNotesLink,omitempty"`
+	Description          string           `xml:"description,omitempty"`
+	Enclosure            appcastEnclosure `xml:"enclosure"`
+}
+
+// appcastCritical marks an update as critical, only for clients older than
+// Version if set.
+type appcastCritical struct {
+	Version string `xml:"sparkle:version,attr,omitempty"`
+}
+
+type appcastEnclosure struct {
+	URL         string `xml:"url,attr"`
+	Length      int64  `xml:"length,attr,omitempty"`
+	Type        string `xml:"type,attr"`
+	EdSignature string `xml:"sparkle:edSignature,attr,omitempty"`
+}
+
+// WriteAppcasts makes Save also write a Sparkle appcast of every channel
+// under AppcastKey, offering the artifacts appcast selects. Sparkle compares
+// sparkle:version with CFBundleVersion, so the application must carry the
+// version it is published as there. Artifacts are only signed for Sparkle
+// when the publisher has a signing.SparkleKey among its file signers.
+func (p *Publisher) WriteAppcasts(appcast Appcast) {
+	p.appcast = &appcast
+}
+
+// writeAppcasts uploads the appcast of every channel of the manifest.
+func (p *Publisher) writeAppcasts(ctx context.Context) error {
+	for _, name := range sortedNames(p.manifest.Channel) {
+		rss, err := p.appcastOf(ctx, name, p.manifest.Channel[name])
+		if err != nil {
+			return err
+		}
+
+		data, err := xml.MarshalIndent(rss, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode appcast of channel %s: %w", name, err)
+		}
+		data = append([]byte(xml.Header), append(data, '\n')...)
+		if err := p.backend.Put(ctx, AppcastKey(p.appID, name), bytes.NewReader(data), int64(len(data)), storage.PutOptions{
+			ContentType:  "application/rss+xml; charset=utf-8",
+			CacheControl: p.caching.Mutable,
+		}); err != nil {
+			return fmt.Errorf("failed to upload appcast of channel %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// appcastOf returns the appcast of channel, listing the releases it offers
+// every client.
+func (p *Publisher) appcastOf(ctx context.Context, name string, channel *Channel) (*appcastRSS, error) {
+	rss := &appcastRSS{
+		Version: "2.0",
+		Sparkle: sparkleNamespace,
+		Channel: appcastChannel{Title: fmt.Sprintf("%s %s", p.appID, name)},
+	}
+
+	for i, release := range channel.offered() {
+		artifact := p.appcastArtifact(release)
+		if artifact == nil || artifact.Binary == "" {
+			continue
+		}
+
+		item := appcastItem{
+			Title:                "Version " + release.Version,
+			Version:              release.Version,
+			ShortVersionString:   release.Version,
+			MinimumSystemVersion: artifact.MinOS,
+			Description:          release.Notes,
+			Enclosure: appcastEnclosure{
+				URL:    p.appcastURL(artifact.Binary),
+				Length: artifact.Size,
+				Type:   "application/octet-stream",
+			},
+		}
+		if !release.Build.IsZero() {
+			item.PubDate = release.Build.UTC().Format(time.RFC1123Z)
+		}
+		if release.NotesKey != "" {
+			item.ReleaseNotesLink = p.appcastURL(release.NotesKey)
+		}
+		switch {
+		case release.Mandatory:
+			item.CriticalUpdate = &appcastCritical{}
+		case i == 0 && channel.MinVersion != "":
+			// clients older than the minimum version must update
+			item.CriticalUpdate = &appcastCritical{Version: channel.MinVersion}
+		}
+
+		if key := artifact.Signatures[signing.FormatSparkle]; key != "" {
+			signature, err := p.readSignature(ctx, signing.FormatSparkle, key)
+			if err != nil {
+				return nil, err
+			}
+			item.Enclosure.EdSignature = strings.TrimSpace(string(signature))
+		}
+		rss.Channel.Items = append(rss.Channel.Items, item)
+	}
+	return rss, nil
+}
+
+// appcastArtifact returns the artifact of release the appcast offers, or nil
+// if it has none.
+func (p *Publisher) appcastArtifact(release *Release) *Artifact {
+	if p.appcast.Platform != "" {
+		return release.FindArtifact(p.appcast.Platform).Select(p.appcast.Kind, "")
+	}
+	for _, platform := range sortedNames(release.Artifact) {
+		if normalized, err := NormalizePlatform(platform); err == nil && strings.HasPrefix(normalized, "darwin/") {
+			return release.Artifact[platform].Select(p.appcast.Kind, "")
+		}
+	}
+	return nil
+}
+
+// appcastURL returns the public URL of the object stored under key.
+func (p *Publisher) appcastURL(key string) string {
+	return strings.TrimSuffix(p.appcast.BaseURL, "/") + "/" + key
+}
@@ -0,0 +1,191 @@
+package manifest
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"update-manifest/pkg/storage"
+)
+
+// SquirrelFeed selects the packages offered by the Squirrel.Windows feeds
+// Save writes for every channel.
+type SquirrelFeed struct {
+	// Package is the NuGet package ID of the application the packages are
+	// named by, the app ID if empty.
+	Package string
+	// Kind, when set, offers the artifact of that kind of the Windows
+	// platform, the full nupkg package `Squirrel --releasify` builds,
+	// instead of the artifact itself.
+	Kind string
+}
+
+// SquirrelKey returns the object key of the file name of the Squirrel.Windows
+// feed of channel, e.g. RELEASES. The directory of the files is the update
+// URL of Squirrel.Windows.
+func SquirrelKey(appID, channel, name string) string {
+	return fmt.Sprintf("%s/squirrel/%s/%s", appID, channel, name)
+}
+
+// squirrelEntry is a line of a RELEASES file: the SHA-1 digest, file name
+// and size of a package.
+type squirrelEntry struct {
+	SHA1 string
+	Name string
+	Size int64
+}
+
+func (e squirrelEntry) String() string {
+	return fmt.Sprintf("%s %s %d", strings.ToUpper(e.SHA1), e.Name, e.Size)
+}
+
+// WriteSquirrelFeeds makes Save also write the RELEASES file of
+// Squirrel.Windows for every channel under SquirrelKey, offering the full
+// package of the newest release it offers every client, as Squirrel.Windows
+// has no rollouts. Squirrel.Windows finds the version of a package by its
+// file name, so the package is copied next to the RELEASES file under the
+// name Squirrel.Windows expects, e.g. MyApp-1.2.0-full.nupkg, and the copy
+// of the previously offered release is deleted. Delta packages are not
+// offered.
+func (p *Publisher) WriteSquirrelFeeds(feed SquirrelFeed) {
+	p.squirrel = &feed
+}
+
+// writeSquirrelFeeds uploads the RELEASES file and package of every
+// channel, and deletes them for a channel without a release to offer.
+func (p *Publisher) writeSquirrelFeeds(ctx context.Context) error {
+	pkg := p.squirrel.Package
+	if pkg == "" {
+		pkg = p.appID
+	}
+
+	for _, name := range sortedNames(p.manifest.Channel) {
+		releasesKey := SquirrelKey(p.appID, name, "RELEASES")
+		previous, err := p.loadSquirrelReleases(ctx, releasesKey)
+		if err != nil {
+			return err
+		}
+
+		var release *Release
+		var artifact *Artifact
+		for _, offered := range p.manifest.Channel[name].offered() {
+			if artifact = p.squirrelArtifact(offered); artifact != nil {
+				release = offered
+				break
+			}
+		}
+
+		var entries []squirrelEntry
+		if release != nil {
+			entry := squirrelEntry{Name: fmt.Sprintf("%s-%s-full.nupkg", pkg, release.Version), Size: artifact.Size}
+			for _, known := range previous {
+				if known.Name == entry.Name && known.Size == entry.Size && entry.Size > 0 {
+					entry.SHA1 = known.SHA1
+				}
+			}
+			if entry.SHA1 == "" {
+				if entry.SHA1, entry.Size, err = p.copySquirrelPackage(ctx, artifact.Binary, SquirrelKey(p.appID, name, entry.Name)); err != nil {
+					return err
+				}
+			}
+			entries = append(entries, entry)
+		}
+
+		if len(entries) == 0 {
+			if err := p.backend.Delete(ctx, releasesKey); err != nil {
+				return fmt.Errorf("failed to delete Squirrel.Windows feed %s: %w", releasesKey, err)
+			}
+		} else {
+			var file strings.Builder
+			for _, entry := range entries {
+				fmt.Fprintln(&file, entry)
+			}
+			if err := p.backend.Put(ctx, releasesKey, strings.NewReader(file.String()), int64(file.Len()), storage.PutOptions{
+				ContentType:  "text/plain; charset=utf-8",
+				CacheControl: p.caching.Mutable,
+			}); err != nil {
+				return fmt.Errorf("failed to upload Squirrel.Windows feed %s: %w", releasesKey, err)
+			}
+		}
+
+		for _, known := range previous {
+			if len(entries) == 0 || known.Name != entries[0].Name {
+				if err := p.backend.Delete(ctx, SquirrelKey(p.appID, name, known.Name)); err != nil {
+					return fmt.Errorf("failed to delete Squirrel.Windows package %s: %w", known.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// squirrelArtifact returns the artifact of the first Windows platform of
+// release the feed offers, or nil if it has none.
+func (p *Publisher) squirrelArtifact(release *Release) *Artifact {
+	for _, platform := range sortedNames(release.Artifact) {
+		if normalized, err := NormalizePlatform(platform); err == nil && strings.HasPrefix(normalized, "windows/") {
+			if artifact := release.Artifact[platform].Select(p.squirrel.Kind, ""); artifact != nil && artifact.Binary != "" {
+				return artifact
+			}
+		}
+	}
+	return nil
+}
+
+// loadSquirrelReleases returns the entries of the RELEASES file stored under
+// key, or nil if there is none. Squirrel.Windows names packages by URLs in
+// RELEASES files it did not write itself, which are not ours to delete, so
+// those are skipped.
+func (p *Publisher) loadSquirrelReleases(ctx context.Context, key string) ([]squirrelEntry, error) {
+	reader, _, err := p.backend.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Squirrel.Windows feed %s: %w", key, err)
+	}
+	defer reader.Close()
+
+	var entries []squirrelEntry
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || strings.Contains(fields[1], "/") {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, squirrelEntry{SHA1: fields[0], Name: fields[1], Size: size})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch Squirrel.Windows feed %s: %w", key, err)
+	}
+	return entries, nil
+}
+
+// copySquirrelPackage copies the artifact stored under src to dst and
+// returns its SHA-1 digest, which RELEASES files name packages by, and size.
+func (p *Publisher) copySquirrelPackage(ctx context.Context, src, dst string) (string, int64, error) {
+	reader, info, err := p.backend.Get(ctx, src)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to fetch Squirrel.Windows package: %w", err)
+	}
+	defer reader.Close()
+
+	hasher := sha1.New()
+	if err := p.backend.Put(ctx, dst, io.TeeReader(reader, hasher), info.Size, storage.PutOptions{
+		ContentType:  "application/octet-stream",
+		CacheControl: p.caching.Mutable,
+	}); err != nil {
+		return "", 0, fmt.Errorf("failed to upload Squirrel.Windows package: %w", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), info.Size, nil
+}
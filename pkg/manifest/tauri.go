@@ -0,0 +1,150 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage"
+)
+
+// TauriFeed selects the artifacts offered by the Tauri updater feeds Save
+// writes for every channel.
+type TauriFeed struct {
+	// BaseURL is the public URL of the bucket. The Tauri updater downloads
+	// the artifacts from their keys resolved against it.
+	BaseURL string
+	// Kind, when set, offers the artifact of that kind of each platform that
+	// has one instead of the artifact itself, e.g. the .app.tar.gz, AppImage
+	// or NSIS installer the Tauri bundler builds for updates.
+	Kind string
+}
+
+// TauriKey returns the object key of the Tauri updater feed of channel, the
+// static endpoint the updater configuration of the application names.
+func TauriKey(appID, channel string) string {
+	return fmt.Sprintf("%s/tauri/%s/latest.json", appID, channel)
+}
+
+// tauriArch names the architectures of the Tauri updater by GOARCH.
+var tauriArch = map[string]string{
+	"amd64": "x86_64",
+	"386":   "i686",
+	"arm64": "aarch64",
+	"arm":   "armv7",
+}
+
+type tauriUpdate struct {
+	Version   string                   `json:"version"`
+	Notes     string                   `json:"notes,omitempty"`
+	PubDate   string                   `json:"pub_date,omitempty"`
+	Platforms map[string]tauriPlatform `json:"platforms"`
+}
+
+type tauriPlatform struct {
+	// Signature is the minisign signature file of the artifact, base64
+	// encoded.
+	Signature string `json:"signature"`
+	URL       string `json:"url"`
+}
+
+// WriteTauriFeeds makes Save also write the feed of the Tauri updater of
+// every channel under TauriKey, offering the newest release it offers every
+// client, as the feed cannot offer a rollout to only some. The Tauri updater
+// requires the minisign signature of every artifact, so artifacts must be
+// signed by a signing.MinisignKey, such as the key of `tauri signer
+// generate`.
+func (p *Publisher) WriteTauriFeeds(feed TauriFeed) {
+	p.tauri = &feed
+}
+
+// tauriTarget returns the target the Tauri updater names platform by, e.g.
+// darwin-aarch64, or "" if it does not update on it.
+func tauriTarget(platform string) string {
+	normalized, err := NormalizePlatform(platform)
+	if err != nil {
+		return ""
+	}
+	goos, arch, _ := strings.Cut(normalized, "/")
+	if goos != "darwin" && goos != "linux" && goos != "windows" || tauriArch[arch] == "" {
+		return ""
+	}
+	return goos + "-" + tauriArch[arch]
+}
+
+// writeTauriFeeds uploads the feed of every channel, and deletes that of a
+// channel without a release to offer.
+func (p *Publisher) writeTauriFeeds(ctx context.Context) error {
+	for _, name := range sortedNames(p.manifest.Channel) {
+		key := TauriKey(p.appID, name)
+		offered := p.manifest.Channel[name].offered()
+		if len(offered) == 0 {
+			if err := p.backend.Delete(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete Tauri feed %s: %w", key, err)
+			}
+			continue
+		}
+
+		update, err := p.tauriUpdate(ctx, offered[0])
+		if err != nil {
+			return err
+		}
+		// release notes are shown as written, not as HTML
+		var data bytes.Buffer
+		encoder := json.NewEncoder(&data)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(update); err != nil {
+			return fmt.Errorf("failed to encode Tauri feed %s: %w", key, err)
+		}
+		if err := p.backend.Put(ctx, key, &data, int64(data.Len()), storage.PutOptions{
+			ContentType:  "application/json",
+			CacheControl: p.caching.Mutable,
+		}); err != nil {
+			return fmt.Errorf("failed to upload Tauri feed %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// tauriUpdate returns the feed offering release.
+func (p *Publisher) tauriUpdate(ctx context.Context, release *Release) (*tauriUpdate, error) {
+	update := &tauriUpdate{
+		Version:   release.Version,
+		Notes:     release.Notes,
+		Platforms: make(map[string]tauriPlatform),
+	}
+	if !release.Build.IsZero() {
+		update.PubDate = release.Build.UTC().Format(time.RFC3339)
+	}
+
+	for _, platform := range sortedNames(release.Artifact) {
+		target := tauriTarget(platform)
+		artifact := release.Artifact[platform]
+		if kind := artifact.Select(p.tauri.Kind, ""); kind != nil {
+			artifact = kind
+		}
+		if target == "" || artifact.Binary == "" {
+			continue
+		}
+
+		key := artifact.Signatures[signing.FormatMinisign]
+		if key == "" {
+			return nil, fmt.Errorf("the artifact of %s has no minisign signature, which the Tauri updater requires", platform)
+		}
+		signature, err := p.readSignature(ctx, signing.FormatMinisign, key)
+		if err != nil {
+			return nil, err
+		}
+		update.Platforms[target] = tauriPlatform{
+			Signature: base64.StdEncoding.EncodeToString(signature),
+			URL:       strings.TrimSuffix(p.tauri.BaseURL, "/") + "/" + artifact.Binary,
+		}
+	}
+	return update, nil
+}
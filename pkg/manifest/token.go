@@ -0,0 +1,147 @@
+package manifest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"update-manifest/pkg/storage"
+)
+
+// UploadToken grants publishing a release of an application through URLs
+// presigned by a machine holding credentials for the bucket, so the machine
+// publishing it needs none. The URLs are used with a backend from
+// presigned.New(token.Get, token.Put).
+type UploadToken struct {
+	AppID string `json:"app_id"`
+	// Expires is when the URLs stop being valid.
+	Expires time.Time `json:"expires"`
+	// Get and Put are the URLs presigned to download and upload the objects
+	// under their keys: the manifest, its backup and signatures, and the
+	// artifacts.
+	Get map[string]string `json:"get"`
+	Put map[string]string `json:"put"`
+	// Artifacts are the keys among Put the artifacts are uploaded under, in
+	// order. They name no checksum, which is unknown when they are signed.
+	Artifacts []string `json:"artifacts"`
+	// Backup is the key among Put the replaced manifest is backed up under.
+	Backup string `json:"backup"`
+}
+
+// manifestSignatureExtensions are the extensions of the detached signatures
+// of the manifest in the formats of other tools, stored next to it.
+var manifestSignatureExtensions = []string{".minisig", ".asc", ".sigstore.json"}
+
+// IssueUploadToken presigns the URLs of backend publishing a release of up
+// to artifacts artifacts of appID, valid for expiry. Releases published with
+// it upload nothing but their artifacts and the manifest and its signatures.
+func IssueUploadToken(ctx context.Context, backend storage.Backend, appID string, artifacts int, expiry time.Duration) (*UploadToken, error) {
+	now := time.Now()
+	token := &UploadToken{
+		AppID:   appID,
+		Expires: now.Add(expiry).UTC().Truncate(time.Second),
+		Get:     make(map[string]string),
+		Put:     make(map[string]string),
+		Backup:  HistoryKey(appID, now),
+	}
+
+	put := []string{ManifestKey(appID), SignatureKey(appID), TimestampKey(appID), TransparencyLogKey(appID), token.Backup}
+	for _, extension := range manifestSignatureExtensions {
+		put = append(put, ManifestKey(appID)+extension)
+	}
+	for range artifacts {
+		var id [16]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return nil, fmt.Errorf("failed to generate artifact key: %w", err)
+		}
+		key := ArtifactKey(appID, hex.EncodeToString(id[:]))
+		token.Artifacts = append(token.Artifacts, key)
+		put = append(put, key)
+	}
+
+	url, err := storage.PresignGet(ctx, backend, ManifestKey(appID), expiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign download URL of %s: %w", ManifestKey(appID), err)
+	}
+	token.Get[ManifestKey(appID)] = url
+	for _, key := range put {
+		url, err := storage.PresignPut(ctx, backend, key, expiry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign upload URL of %s: %w", key, err)
+		}
+		token.Put[key] = url
+	}
+	return token, nil
+}
+
+// UseUploadToken makes AddRelease upload every artifact straight to the next
+// artifact key of token and Save back up the manifest under its backup key,
+// as the backend of the publisher only has the URLs of token. Releases that
+// would write other objects, such as patches, checksum files or feeds, are
+// refused.
+func (p *Publisher) UseUploadToken(token *UploadToken) {
+	p.token = token
+}
+
+// checkUploadToken fails if publishing req writes objects an upload token
+// has no URLs for or needs to read objects other than the manifest.
+func (p *Publisher) checkUploadToken(req ReleaseRequest) error {
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"patches", req.Patch},
+		{"artifact signatures", req.Sign},
+		{"checksum files", req.Sidecar},
+		{"compressed copies", req.Compress},
+		{"chunks", req.Chunks},
+		{"release notes objects", req.NotesObject},
+		{"skipping existing artifacts", req.SkipExisting},
+		{"verifying uploads", req.VerifyUpload},
+		{"Sparkle appcasts", p.appcast != nil},
+		{"electron-updater feeds", p.electron != nil},
+		{"Tauri feeds", p.tauri != nil},
+		{"Squirrel.Windows feeds", p.squirrel != nil},
+		{"zsync control files", p.zsync != nil},
+		{"TUF metadata", p.tuf != nil},
+		{"client manifests", p.presign != 0},
+	} {
+		if option.set {
+			return fmt.Errorf("%s cannot be published with an upload token", option.name)
+		}
+	}
+	if p.uploaded == len(p.token.Artifacts) {
+		return fmt.Errorf("upload token has no URL left for an artifact, it was issued for %d", len(p.token.Artifacts))
+	}
+	if time.Now().After(p.token.Expires) {
+		return fmt.Errorf("upload token expired at %s", p.token.Expires.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// uploadToToken uploads the executable of req to the next artifact key of
+// the upload token while computing its checksum, and returns both.
+func (p *Publisher) uploadToToken(ctx context.Context, req ReleaseRequest) (string, string, error) {
+	key := p.token.Artifacts[p.uploaded]
+	p.uploaded++
+
+	// a token used twice must not replace the artifacts of the release it
+	// published first
+	opts := p.artifactOptions(req)
+	opts.IfNoneMatch = true
+	hashed := newHashingReader(req.Executable, p.hasher())
+	if err := p.backend.Put(ctx, key, hashed, req.Size, opts); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return "", "", fmt.Errorf("upload token was used already: %s exists", key)
+		}
+		return "", "", fmt.Errorf("failed to upload artifact: %w", err)
+	}
+	checksum, err := hashed.checksum(req.Size)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create checksum: %w", err)
+	}
+	return key, checksum, nil
+}
@@ -0,0 +1,423 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"update-manifest/pkg/signing"
+	"update-manifest/pkg/storage"
+)
+
+// tufSpecVersion is the version of the TUF specification the metadata
+// follows.
+const tufSpecVersion = "1.0.31"
+
+// TUFExpiry is how long each role of TUF metadata is valid after it is
+// signed. Clients refuse expired metadata, so a withheld timestamp is
+// noticed once it expires.
+type TUFExpiry struct {
+	Root      time.Duration
+	Targets   time.Duration
+	Snapshot  time.Duration
+	Timestamp time.Duration
+}
+
+// DefaultTUFExpiry keeps the root for a year and the timestamp for a day,
+// which must be refreshed daily with RefreshTUF between releases.
+var DefaultTUFExpiry = TUFExpiry{
+	Root:      365 * 24 * time.Hour,
+	Targets:   90 * 24 * time.Hour,
+	Snapshot:  7 * 24 * time.Hour,
+	Timestamp: 24 * time.Hour,
+}
+
+// TUFKey returns the object key of the TUF metadata file name of appID,
+// e.g. timestamp.json, kept in the tuf directory next to the manifest. The
+// manifest is the only target, stored as manifest.json relative to the
+// directory of appID, and covers the artifacts by their checksums.
+func TUFKey(appID, name string) string {
+	return fmt.Sprintf("%s/tuf/%s", appID, name)
+}
+
+type tufEnvelope struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []tufSignature  `json:"signatures"`
+}
+
+type tufSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+type tufMetadata struct {
+	Type        string `json:"_type"`
+	SpecVersion string `json:"spec_version"`
+	Version     int64  `json:"version"`
+	Expires     string `json:"expires"`
+
+	// root
+	ConsistentSnapshot *bool              `json:"consistent_snapshot,omitempty"`
+	Keys               map[string]tufKey  `json:"keys,omitempty"`
+	Roles              map[string]tufRole `json:"roles,omitempty"`
+	// targets
+	Targets map[string]tufFile `json:"targets,omitempty"`
+	// snapshot and timestamp
+	Meta map[string]tufFile `json:"meta,omitempty"`
+}
+
+type tufKey struct {
+	KeyType string `json:"keytype"`
+	Scheme  string `json:"scheme"`
+	KeyVal  struct {
+		Public string `json:"public"`
+	} `json:"keyval"`
+}
+
+type tufRole struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+type tufFile struct {
+	Version int64             `json:"version,omitempty"`
+	Length  int64             `json:"length,omitempty"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+}
+
+// MaintainTUF makes Save also write TUF root, targets, snapshot and
+// timestamp metadata listing the manifest, signed by the signers of the
+// publisher, so TUF clients are protected from rollback and freeze attacks.
+// The signing keys become the keys of every role in the first root; a root
+// listing other keys is not rotated.
+func (p *Publisher) MaintainTUF(expiry TUFExpiry) {
+	p.tuf = &expiry
+}
+
+// RefreshTUF signs new TUF metadata for the loaded manifest, which extends
+// the expiry of the timestamp without publishing a release. Run it more
+// often than the timestamp expires.
+func (p *Publisher) RefreshTUF(ctx context.Context) error {
+	if !p.exists {
+		return errors.New("no manifest to refresh the TUF metadata of")
+	}
+	expiry := DefaultTUFExpiry
+	if p.tuf != nil {
+		expiry = *p.tuf
+	}
+	return p.writeTUF(ctx, p.loaded, expiry)
+}
+
+// writeTUF writes new targets, snapshot and timestamp metadata for the
+// stored manifest, and a new root when there is none yet or it is about to
+// expire.
+func (p *Publisher) writeTUF(ctx context.Context, manifest []byte, expiry TUFExpiry) error {
+	if len(p.signers) == 0 {
+		return errors.New("TUF metadata needs a signing key")
+	}
+	now := time.Now().UTC()
+
+	keys := make(map[string]tufKey, len(p.signers))
+	keyIDs := make([]string, 0, len(p.signers))
+	for _, signer := range p.signers {
+		id, key, err := tufPublicKey(signer)
+		if err != nil {
+			return err
+		}
+		keys[id] = key
+		keyIDs = append(keyIDs, id)
+	}
+	sort.Strings(keyIDs)
+
+	root, err := p.loadTUF(ctx, "root.json")
+	if err != nil {
+		return err
+	}
+	if root != nil {
+		trusted := slices.Clone(root.Roles["root"].KeyIDs)
+		sort.Strings(trusted)
+		if !slices.Equal(trusted, keyIDs) {
+			return errors.New("the TUF root lists other keys than the signing keys, rotate the root before signing with them")
+		}
+	}
+	if expires, _ := time.Parse(time.RFC3339, rootExpires(root)); root == nil || expires.Before(now.Add(expiry.Root/4)) {
+		consistent := false
+		role := tufRole{KeyIDs: keyIDs, Threshold: 1}
+		renewed := &tufMetadata{
+			Type:               "root",
+			Version:            nextVersion(root),
+			Expires:            tufExpires(now, expiry.Root),
+			ConsistentSnapshot: &consistent,
+			Keys:               keys,
+			Roles:              map[string]tufRole{"root": role, "targets": role, "snapshot": role, "timestamp": role},
+		}
+		// clients update their root by fetching every version in turn
+		signed, err := p.putTUF(ctx, fmt.Sprintf("%d.root.json", renewed.Version), renewed)
+		if err != nil {
+			return err
+		}
+		if err := p.putTUFObject(ctx, "root.json", signed); err != nil {
+			return err
+		}
+	}
+
+	targets, err := p.loadTUF(ctx, "targets.json")
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(manifest)
+	targets = &tufMetadata{
+		Type:    "targets",
+		Version: nextVersion(targets),
+		Expires: tufExpires(now, expiry.Targets),
+		Targets: map[string]tufFile{
+			"manifest.json": {Length: int64(len(manifest)), Hashes: map[string]string{"sha256": hex.EncodeToString(digest[:])}},
+		},
+	}
+	if _, err := p.putTUF(ctx, "targets.json", targets); err != nil {
+		return err
+	}
+
+	snapshot, err := p.loadTUF(ctx, "snapshot.json")
+	if err != nil {
+		return err
+	}
+	snapshot = &tufMetadata{
+		Type:    "snapshot",
+		Version: nextVersion(snapshot),
+		Expires: tufExpires(now, expiry.Snapshot),
+		Meta:    map[string]tufFile{"targets.json": {Version: targets.Version}},
+	}
+	signedSnapshot, err := p.putTUF(ctx, "snapshot.json", snapshot)
+	if err != nil {
+		return err
+	}
+
+	// the timestamp is written last, as it makes clients fetch the rest
+	timestamp, err := p.loadTUF(ctx, "timestamp.json")
+	if err != nil {
+		return err
+	}
+	digest = sha256.Sum256(signedSnapshot)
+	timestamp = &tufMetadata{
+		Type:    "timestamp",
+		Version: nextVersion(timestamp),
+		Expires: tufExpires(now, expiry.Timestamp),
+		Meta: map[string]tufFile{
+			"snapshot.json": {Version: snapshot.Version, Length: int64(len(signedSnapshot)), Hashes: map[string]string{"sha256": hex.EncodeToString(digest[:])}},
+		},
+	}
+	_, err = p.putTUF(ctx, "timestamp.json", timestamp)
+	return err
+}
+
+// loadTUF returns the signed part of the TUF metadata file name, or nil if
+// it does not exist.
+func (p *Publisher) loadTUF(ctx context.Context, name string) (*tufMetadata, error) {
+	reader, _, err := p.backend.Get(ctx, TUFKey(p.appID, name))
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch TUF %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	var envelope tufEnvelope
+	if err := json.NewDecoder(reader).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode TUF %s: %w", name, err)
+	}
+	var metadata tufMetadata
+	if err := json.Unmarshal(envelope.Signed, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode TUF %s: %w", name, err)
+	}
+	return &metadata, nil
+}
+
+// putTUF signs metadata with every signer of the publisher and uploads it
+// as the TUF metadata file name. It returns the uploaded file.
+func (p *Publisher) putTUF(ctx context.Context, name string, metadata *tufMetadata) ([]byte, error) {
+	metadata.SpecVersion = tufSpecVersion
+	signed, err := canonicalJSON(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode TUF %s: %w", name, err)
+	}
+
+	envelope := tufEnvelope{Signed: signed}
+	if !json.Valid(signed) {
+		// canonical JSON leaves control characters of strings, such as the
+		// line breaks of PEM encoded ECDSA keys, unescaped, so the signed part
+		// is embedded as JSON instead, which verifiers canonicalize again
+		if envelope.Signed, err = json.Marshal(metadata); err != nil {
+			return nil, fmt.Errorf("failed to encode TUF %s: %w", name, err)
+		}
+	}
+	for _, signer := range p.signers {
+		id, _, err := tufPublicKey(signer)
+		if err != nil {
+			return nil, err
+		}
+
+		sig, err := signer.Sign(ctx, signed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign TUF %s: %w", name, err)
+		}
+		envelope.Signatures = append(envelope.Signatures, tufSignature{KeyID: id, Sig: hex.EncodeToString(sig)})
+	}
+
+	// the signed part must stay byte for byte as it was signed, which HTML
+	// escaping would change
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(envelope); err != nil {
+		return nil, fmt.Errorf("failed to encode TUF %s: %w", name, err)
+	}
+	return data.Bytes(), p.putTUFObject(ctx, name, data.Bytes())
+}
+
+func (p *Publisher) putTUFObject(ctx context.Context, name string, data []byte) error {
+	if err := p.backend.Put(ctx, TUFKey(p.appID, name), bytes.NewReader(data), int64(len(data)), storage.PutOptions{
+		ContentType:  "application/json",
+		CacheControl: p.caching.Mutable,
+	}); err != nil {
+		return fmt.Errorf("failed to upload TUF %s: %w", name, err)
+	}
+	return nil
+}
+
+// tufPublicKey returns the TUF key of signer and the ID TUF gives it: the
+// SHA-256 digest of its canonical JSON encoding.
+func tufPublicKey(signer signing.Signer) (string, tufKey, error) {
+	var key tufKey
+	switch public := signer.Public().Key.(type) {
+	case ed25519.PublicKey:
+		key = tufKey{KeyType: "ed25519", Scheme: "ed25519"}
+		key.KeyVal.Public = hex.EncodeToString(public)
+	case *ecdsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return "", tufKey{}, err
+		}
+		key = tufKey{KeyType: "ecdsa", Scheme: "ecdsa-sha2-nistp256"}
+		key.KeyVal.Public = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	default:
+		return "", tufKey{}, fmt.Errorf("unsupported TUF key type %T", signer.Public().Key)
+	}
+
+	encoded, err := canonicalJSON(key)
+	if err != nil {
+		return "", tufKey{}, err
+	}
+	digest := sha256.Sum256(encoded)
+	return hex.EncodeToString(digest[:]), key, nil
+}
+
+func nextVersion(metadata *tufMetadata) int64 {
+	if metadata == nil {
+		return 1
+	}
+	return metadata.Version + 1
+}
+
+func rootExpires(root *tufMetadata) string {
+	if root == nil {
+		return ""
+	}
+	return root.Expires
+}
+
+func tufExpires(now time.Time, validity time.Duration) string {
+	return now.Add(validity).Truncate(time.Second).Format("2006-01-02T15:04:05Z")
+}
+
+// canonicalJSON encodes v in the canonical JSON TUF signs: object keys
+// sorted, no insignificant whitespace, and strings escaping only quotes and
+// backslashes.
+func canonicalJSON(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(w *bytes.Buffer, value any) error {
+	switch value := value.(type) {
+	case nil:
+		w.WriteString("null")
+	case bool:
+		w.WriteString(strconv.FormatBool(value))
+	case json.Number:
+		if _, err := value.Int64(); err != nil {
+			return fmt.Errorf("canonical JSON has no number %s", value)
+		}
+		w.WriteString(value.String())
+	case string:
+		w.WriteByte('"')
+		for i := 0; i < len(value); i++ {
+			if value[i] == '"' || value[i] == '\\' {
+				w.WriteByte('\\')
+			}
+			w.WriteByte(value[i])
+		}
+		w.WriteByte('"')
+	case []any:
+		w.WriteByte('[')
+		for i, element := range value {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			if err := writeCanonical(w, element); err != nil {
+				return err
+			}
+		}
+		w.WriteByte(']')
+	case map[string]any:
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w.WriteByte('{')
+		for i, name := range names {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			if err := writeCanonical(w, name); err != nil {
+				return err
+			}
+			w.WriteByte(':')
+			if err := writeCanonical(w, value[name]); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('}')
+	default:
+		return fmt.Errorf("unsupported JSON value %T", value)
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+package manifest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"update-manifest/pkg/chunk"
+	"update-manifest/pkg/patch"
+	"update-manifest/pkg/semver"
+)
+
+// Problem is a defect found in a manifest.
+type Problem struct {
+	// Path locates the defect, e.g. channel.stable.artifact.linux/amd64.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Path == "" {
+		return p.Message
+	}
+	return p.Path + ": " + p.Message
+}
+
+// Validate checks the encoded manifest data against the schema: it reports
+// unknown fields, missing required fields, malformed checksums and versions
+// that are not semantic versions. It returns no problems for a valid
+// manifest.
+func Validate(data []byte) []Problem {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return []Problem{{Message: fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+	problems := unknownFields("", raw, reflect.TypeOf(Manifest{}))
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return append(problems, Problem{Message: fmt.Sprintf("invalid manifest: %v", err)})
+	}
+
+	for _, name := range sortedNames(m.Channel) {
+		path := "channel." + name
+		channel := m.Channel[name]
+		if channel == nil {
+			problems = append(problems, Problem{path, "channel is null"})
+			continue
+		}
+
+		// a channel without a version is one nothing was published to yet
+		if channel.Version == "" && len(channel.Artifact) == 0 && len(channel.Releases) == 0 {
+			continue
+		}
+		problems = append(problems, validateRelease(path, &channel.Release)...)
+		if channel.MinVersion != "" {
+			if _, err := semver.Parse(channel.MinVersion); err != nil {
+				problems = append(problems, Problem{path + ".min_version", err.Error()})
+			} else if c, err := semver.Compare(channel.MinVersion, channel.Version); err == nil && c > 0 {
+				problems = append(problems, Problem{path + ".min_version", fmt.Sprintf("minimum version %s is newer than the version %s", channel.MinVersion, channel.Version)})
+			}
+		}
+
+		seen := make(map[string]bool)
+		for i, release := range channel.Releases {
+			path := fmt.Sprintf("%s.releases[%d]", path, i)
+			if release == nil {
+				problems = append(problems, Problem{path, "release is null"})
+				continue
+			}
+			if seen[release.Version] {
+				problems = append(problems, Problem{path, fmt.Sprintf("version %s is recorded more than once", release.Version)})
+			}
+			seen[release.Version] = true
+			problems = append(problems, validateRelease(path, release)...)
+		}
+	}
+	return problems
+}
+
+// validateRelease checks the release at path.
+func validateRelease(path string, release *Release) []Problem {
+	var problems []Problem
+	if release.Version == "" {
+		problems = append(problems, Problem{path + ".version", "version is missing"})
+	} else if _, err := semver.Parse(release.Version); err != nil {
+		problems = append(problems, Problem{path + ".version", err.Error()})
+	}
+	if release.Build.IsZero() {
+		problems = append(problems, Problem{path + ".build", "build time is missing"})
+	}
+	if release.Rollout != nil && (*release.Rollout < 0 || *release.Rollout > 100) {
+		problems = append(problems, Problem{path + ".rollout", fmt.Sprintf("rollout percentage %d is not between 0 and 100", *release.Rollout)})
+	}
+	if len(release.Artifact) == 0 {
+		problems = append(problems, Problem{path + ".artifact", "release has no artifacts"})
+	}
+
+	platforms := make(map[string]string)
+	for _, platform := range sortedNames(release.Artifact) {
+		if canonical, err := NormalizePlatform(platform); err == nil {
+			if other, ok := platforms[canonical]; ok {
+				problems = append(problems, Problem{path + ".artifact." + platform, fmt.Sprintf("platform is the same as %s", other)})
+			}
+			platforms[canonical] = platform
+		}
+		problems = append(problems, validateArtifact(path+".artifact."+platform, release.Artifact[platform])...)
+	}
+	return problems
+}
+
+// validateArtifact checks the artifact at path, its variants and kinds.
+func validateArtifact(path string, artifact *Artifact) []Problem {
+	if artifact == nil {
+		return []Problem{{path, "artifact is null"}}
+	}
+
+	var problems []Problem
+	algo := artifact.HashAlgorithm()
+	if _, err := NewHash(algo); err != nil {
+		problems = append(problems, Problem{path + ".checksum_algo", err.Error()})
+	}
+	// the artifact a variant or kind is published before may not be
+	// published yet
+	if artifact.Binary != "" || len(artifact.Variants) == 0 && len(artifact.Kinds) == 0 {
+		if artifact.Binary == "" {
+			problems = append(problems, Problem{path + ".binary", "object key is missing"})
+		}
+		problems = append(problems, validateChecksum(path+".checksum", artifact.Checksum, algo)...)
+	}
+	if artifact.MinOS != "" && !ValidOSVersion(artifact.MinOS) {
+		problems = append(problems, Problem{path + ".min_os", fmt.Sprintf("operating system version %q is not dot separated numbers", artifact.MinOS)})
+	}
+	if artifact.Size < 0 {
+		problems = append(problems, Problem{path + ".size", fmt.Sprintf("size %d is negative", artifact.Size)})
+	}
+	if artifact.Signature != "" && len(artifact.SignedBy) == 0 {
+		problems = append(problems, Problem{path + ".signed_by", "signature names no signing key"})
+	}
+	if artifact.Archive != nil {
+		problems = append(problems, validateArchive(path+".archive", artifact.Archive, algo)...)
+	}
+	if compressed := artifact.Compressed; compressed != nil {
+		if compressed.Format != CompressionZstd {
+			problems = append(problems, Problem{path + ".compressed.format", fmt.Sprintf("unknown compression format %q", compressed.Format)})
+		}
+		if compressed.Key == "" {
+			problems = append(problems, Problem{path + ".compressed.key", "object key is missing"})
+		}
+		problems = append(problems, validateChecksum(path+".compressed.checksum", compressed.Checksum, algo)...)
+		if compressed.Size < 0 {
+			problems = append(problems, Problem{path + ".compressed.size", fmt.Sprintf("size %d is negative", compressed.Size)})
+		}
+	}
+	if chunks := artifact.Chunks; chunks != nil {
+		if chunks.Format != chunk.Format {
+			problems = append(problems, Problem{path + ".chunks.format", fmt.Sprintf("unknown chunk format %q", chunks.Format)})
+		}
+		if chunks.Key == "" {
+			problems = append(problems, Problem{path + ".chunks.key", "object key is missing"})
+		}
+		if chunks.Prefix == "" {
+			problems = append(problems, Problem{path + ".chunks.prefix", "chunk prefix is missing"})
+		}
+		problems = append(problems, validateChecksum(path+".chunks.checksum", chunks.Checksum, algo)...)
+		if chunks.Size < 0 {
+			problems = append(problems, Problem{path + ".chunks.size", fmt.Sprintf("size %d is negative", chunks.Size)})
+		}
+	}
+
+	if artifact.Patch != "" {
+		problems = append(problems, validateChecksum(path+".patch_checksum", artifact.PatchChecksum, algo)...)
+		problems = append(problems, validateChecksum(path+".patch_from", artifact.PatchFrom, algo)...)
+		if artifact.PatchFrom != "" && artifact.PatchFrom == artifact.Checksum {
+			problems = append(problems, Problem{path + ".patch_from", "patch is made from the artifact itself"})
+		}
+		if artifact.PatchSize < 0 {
+			problems = append(problems, Problem{path + ".patch_size", fmt.Sprintf("size %d is negative", artifact.PatchSize)})
+		}
+		if !patch.Supported(artifact.PatchFormat) {
+			problems = append(problems, Problem{path + ".patch_format", fmt.Sprintf("unknown patch format %q", artifact.PatchFormat)})
+		}
+	}
+	// each patch is made from a different older artifact
+	from := map[string]bool{artifact.PatchFrom: artifact.Patch != ""}
+	for i, delta := range artifact.Patches {
+		path := fmt.Sprintf("%s.patches[%d]", path, i)
+		if delta.Key == "" {
+			problems = append(problems, Problem{path + ".key", "object key is missing"})
+		}
+		problems = append(problems, validateChecksum(path+".checksum", delta.Checksum, algo)...)
+		problems = append(problems, validateChecksum(path+".from", delta.From, algo)...)
+		switch {
+		case delta.From == artifact.Checksum:
+			problems = append(problems, Problem{path + ".from", "patch is made from the artifact itself"})
+		case from[delta.From]:
+			problems = append(problems, Problem{path + ".from", "another patch is made from the same artifact"})
+		}
+		from[delta.From] = true
+		if delta.Size < 0 {
+			problems = append(problems, Problem{path + ".size", fmt.Sprintf("size %d is negative", delta.Size)})
+		}
+		if !patch.Supported(delta.Format) {
+			problems = append(problems, Problem{path + ".format", fmt.Sprintf("unknown patch format %q", delta.Format)})
+		}
+	}
+
+	for _, field := range []string{"variants", "kinds"} {
+		nested := artifact.Variants
+		if field == "kinds" {
+			nested = artifact.Kinds
+		}
+		for _, name := range sortedNames(nested) {
+			path := path + "." + field + "." + name
+			if !ValidVariant(name) {
+				problems = append(problems, Problem{path, "name is not lower case letters, digits and dots"})
+			}
+			if artifact := nested[name]; artifact != nil && len(artifact.Variants)+len(artifact.Kinds) > 0 {
+				problems = append(problems, Problem{path, "variants and kinds cannot have variants or kinds"})
+			}
+			problems = append(problems, validateArtifact(path, nested[name])...)
+		}
+	}
+	return problems
+}
+
+// validateArchive checks the archive description at path.
+func validateArchive(path string, archive *Archive, algo string) []Problem {
+	var problems []Problem
+	if archive.Format != ArchiveTarGz && archive.Format != ArchiveZip {
+		problems = append(problems, Problem{path + ".format", fmt.Sprintf("unknown archive format %q", archive.Format)})
+	}
+	if archive.Root != "" && (!ValidArchivePath(archive.Root) || strings.Contains(archive.Root, "/")) {
+		problems = append(problems, Problem{path + ".root", fmt.Sprintf("%q is not the name of a directory", archive.Root)})
+	}
+	for i, file := range archive.Files {
+		filePath := fmt.Sprintf("%s.files[%d]", path, i)
+		switch {
+		case !ValidArchivePath(file.Path):
+			problems = append(problems, Problem{filePath + ".path", fmt.Sprintf("%q is not a relative path inside the archive", file.Path)})
+		case i > 0 && file.Path <= archive.Files[i-1].Path:
+			problems = append(problems, Problem{filePath + ".path", "files are not sorted by path or listed twice"})
+		}
+		if file.Mode&^fs.ModePerm != 0 {
+			problems = append(problems, Problem{filePath + ".mode", fmt.Sprintf("mode %#o has more than permission bits", uint32(file.Mode))})
+		}
+		if file.Link == "" {
+			problems = append(problems, validateChecksum(filePath+".checksum", file.Checksum, algo)...)
+		}
+	}
+	if archive.Executable == "" {
+		return problems
+	}
+	for _, file := range archive.Files {
+		if file.Path == archive.Executable && file.Link == "" {
+			return problems
+		}
+	}
+	return append(problems, Problem{path + ".executable", fmt.Sprintf("archive has no file %s", archive.Executable)})
+}
+
+// validateChecksum checks that checksum at path is a hex digest of the
+// checksum algorithm algo. Digests of unknown algorithms are not checked.
+func validateChecksum(path, checksum, algo string) []Problem {
+	if checksum == "" {
+		return []Problem{{path, "checksum is missing"}}
+	}
+	hasher, err := NewHash(algo)
+	if err != nil {
+		return nil
+	}
+	if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != hasher.Size() || strings.ToLower(checksum) != checksum {
+		return []Problem{{path, fmt.Sprintf("checksum %q is not %d lowercase hex digits", checksum, 2*hasher.Size())}}
+	}
+	return nil
+}
+
+// unknownFields reports the object keys of the decoded JSON value at path
+// that the type t has no field for.
+func unknownFields(path string, value any, t reflect.Type) []Problem {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var problems []Problem
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok || t == reflect.TypeOf(time.Time{}) {
+			return nil
+		}
+		fields := jsonFields(t)
+		for _, name := range sortedNames(object) {
+			field, ok := fields[name]
+			if !ok {
+				problems = append(problems, Problem{joinPath(path, name), "unknown field"})
+				continue
+			}
+			problems = append(problems, unknownFields(joinPath(path, name), object[name], field)...)
+		}
+
+	case reflect.Map:
+		object, ok := value.(map[string]any)
+		// maps of arbitrary values such as metadata are free-form
+		if !ok || t.Elem().Kind() == reflect.Interface {
+			return nil
+		}
+		for _, name := range sortedNames(object) {
+			problems = append(problems, unknownFields(joinPath(path, name), object[name], t.Elem())...)
+		}
+
+	case reflect.Slice:
+		array, ok := value.([]any)
+		if !ok {
+			return nil
+		}
+		for i, element := range array {
+			problems = append(problems, unknownFields(fmt.Sprintf("%s[%d]", path, i), element, t.Elem())...)
+		}
+	}
+	return problems
+}
+
+// jsonFields returns the types of the fields of the struct type t by their
+// JSON names, including those of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch {
+		case name == "-":
+			continue
+		case name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct:
+			for name, t := range jsonFields(field.Type) {
+				fields[name] = t
+			}
+			continue
+		case name == "":
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// sortedNames returns the keys of m in lexical order.
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,271 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WinGetManifestVersion is the version of the WinGet manifest schema the
+// manifests are rendered in.
+const WinGetManifestVersion = "1.6.0"
+
+// WinGetPackage describes the package the WinGet manifests of a release are
+// rendered for.
+type WinGetPackage struct {
+	// Identifier is the package identifier, e.g. Publisher.App.
+	Identifier string
+	// Publisher and Name name the publisher and package in the default
+	// locale, by the first and last segment of Identifier if empty.
+	Publisher string
+	Name      string
+	// License and ShortDescription are required by winget-pkgs.
+	License          string
+	ShortDescription string
+	// Locale is the default locale of the package, en-US if empty.
+	Locale string
+	// BaseURL is the public URL of the bucket. winget downloads the
+	// installers from their keys resolved against it.
+	BaseURL string
+	// Kind, when set, installs the artifact of that kind, e.g. installer,
+	// instead of the artifact itself, leaving out the platforms without one.
+	Kind string
+	// InstallerType is the winget installer type, e.g. msi or nullsoft. If
+	// empty it is told by the extension of the installer, and is exe for
+	// another kind of artifact and portable for the artifact itself.
+	InstallerType string
+	// Silent is the switch installing an exe installer without interaction,
+	// which winget-pkgs requires of exe installers.
+	Silent string
+}
+
+// WinGetFile is a manifest file of a WinGet package version.
+type WinGetFile struct {
+	Name string
+	Data []byte
+}
+
+// WinGetDir returns the directory of the manifests of version of the
+// package identifier, which must not be empty, in the winget-pkgs
+// repository, e.g. manifests/p/Publisher/App/1.2.0.
+func WinGetDir(identifier, version string) string {
+	segments := strings.Split(identifier, ".")
+	return path.Join(append(append([]string{"manifests", strings.ToLower(identifier[:1])}, segments...), version)...)
+}
+
+// wingetArch names the architectures of winget by GOARCH.
+var wingetArch = map[string]string{
+	"amd64": "x64",
+	"386":   "x86",
+	"arm64": "arm64",
+	"arm":   "arm",
+}
+
+// wingetInstallerTypes names the installer types told by their extension.
+var wingetInstallerTypes = map[string]string{
+	".msi":        "msi",
+	".msix":       "msix",
+	".msixbundle": "msix",
+	".appx":       "appx",
+	".appxbundle": "appx",
+	".zip":        "zip",
+}
+
+type wingetVersion struct {
+	PackageIdentifier string `yaml:"PackageIdentifier"`
+	PackageVersion    string `yaml:"PackageVersion"`
+	DefaultLocale     string `yaml:"DefaultLocale"`
+	ManifestType      string `yaml:"ManifestType"`
+	ManifestVersion   string `yaml:"ManifestVersion"`
+}
+
+type wingetInstallerManifest struct {
+	PackageIdentifier string            `yaml:"PackageIdentifier"`
+	PackageVersion    string            `yaml:"PackageVersion"`
+	InstallerType     string            `yaml:"InstallerType"`
+	InstallerSwitches *wingetSwitches   `yaml:"InstallerSwitches,omitempty"`
+	ReleaseDate       string            `yaml:"ReleaseDate,omitempty"`
+	Installers        []wingetInstaller `yaml:"Installers"`
+	ManifestType      string            `yaml:"ManifestType"`
+	ManifestVersion   string            `yaml:"ManifestVersion"`
+}
+
+type wingetSwitches struct {
+	Silent             string `yaml:"Silent"`
+	SilentWithProgress string `yaml:"SilentWithProgress"`
+}
+
+type wingetInstaller struct {
+	Architecture     string `yaml:"Architecture"`
+	InstallerURL     string `yaml:"InstallerUrl"`
+	InstallerSha256  string `yaml:"InstallerSha256"`
+	MinimumOSVersion string `yaml:"MinimumOSVersion,omitempty"`
+}
+
+type wingetLocale struct {
+	PackageIdentifier string `yaml:"PackageIdentifier"`
+	PackageVersion    string `yaml:"PackageVersion"`
+	PackageLocale     string `yaml:"PackageLocale"`
+	Publisher         string `yaml:"Publisher"`
+	PackageName       string `yaml:"PackageName"`
+	License           string `yaml:"License"`
+	ShortDescription  string `yaml:"ShortDescription"`
+	ReleaseNotes      string `yaml:"ReleaseNotes,omitempty"`
+	ReleaseNotesURL   string `yaml:"ReleaseNotesUrl,omitempty"`
+	ManifestType      string `yaml:"ManifestType"`
+	ManifestVersion   string `yaml:"ManifestVersion"`
+}
+
+// WinGetManifests renders the version, installer and default locale
+// manifests of release as pkg, the files of its WinGetDir. Every
+// Windows platform of the release is an installer of its architecture.
+// winget verifies installers by their SHA-256 digest, so artifacts hashed
+// with another algorithm are downloaded to compute it.
+func (p *Publisher) WinGetManifests(ctx context.Context, release *Release, pkg WinGetPackage) ([]WinGetFile, error) {
+	segments := strings.Split(pkg.Identifier, ".")
+	if len(segments) < 2 || strings.ContainsAny(pkg.Identifier, " \\/:*?\"<>|") || strings.Contains(pkg.Identifier, "..") {
+		return nil, fmt.Errorf("invalid WinGet package identifier %q, want e.g. Publisher.App", pkg.Identifier)
+	}
+	if release.Version == "" {
+		return nil, errors.New("the release has no version")
+	}
+	if pkg.License == "" || pkg.ShortDescription == "" {
+		return nil, errors.New("winget-pkgs requires the license and short description of a package")
+	}
+	if pkg.Publisher == "" {
+		pkg.Publisher = segments[0]
+	}
+	if pkg.Name == "" {
+		pkg.Name = segments[len(segments)-1]
+	}
+	if pkg.Locale == "" {
+		pkg.Locale = "en-US"
+	}
+
+	installers := &wingetInstallerManifest{
+		PackageIdentifier: pkg.Identifier,
+		PackageVersion:    release.Version,
+		InstallerType:     pkg.InstallerType,
+		ManifestType:      "installer",
+		ManifestVersion:   WinGetManifestVersion,
+	}
+	if !release.Build.IsZero() {
+		installers.ReleaseDate = release.Build.UTC().Format("2006-01-02")
+	}
+	for _, platform := range sortedNames(release.Artifact) {
+		normalized, err := NormalizePlatform(platform)
+		if err != nil {
+			continue
+		}
+		goos, arch, _ := strings.Cut(normalized, "/")
+		artifact := release.Artifact[platform].Select(pkg.Kind, "")
+		if goos != "windows" || wingetArch[arch] == "" || artifact == nil || artifact.Binary == "" {
+			continue
+		}
+
+		installerType := wingetInstallerTypes[strings.ToLower(path.Ext(artifact.Binary))]
+		switch {
+		case installerType != "":
+		case pkg.Kind == "":
+			installerType = "portable"
+		default:
+			installerType = "exe"
+		}
+		if installers.InstallerType == "" {
+			installers.InstallerType = installerType
+		} else if pkg.InstallerType == "" && installers.InstallerType != installerType {
+			return nil, fmt.Errorf("the installers of release %s are of different types, set the installer type", release.Version)
+		}
+
+		digest, err := p.sha256(ctx, artifact)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash the installer of %s: %w", platform, err)
+		}
+		installers.Installers = append(installers.Installers, wingetInstaller{
+			Architecture:     wingetArch[arch],
+			InstallerURL:     strings.TrimSuffix(pkg.BaseURL, "/") + "/" + artifact.Binary,
+			InstallerSha256:  strings.ToUpper(digest),
+			MinimumOSVersion: artifact.MinOS,
+		})
+	}
+	if len(installers.Installers) == 0 {
+		return nil, fmt.Errorf("release %s has no Windows artifact", release.Version)
+	}
+	if installers.InstallerType == "exe" {
+		if pkg.Silent == "" {
+			return nil, errors.New("winget-pkgs requires the silent switch of exe installers")
+		}
+		installers.InstallerSwitches = &wingetSwitches{Silent: pkg.Silent, SilentWithProgress: pkg.Silent}
+	}
+
+	locale := &wingetLocale{
+		PackageIdentifier: pkg.Identifier,
+		PackageVersion:    release.Version,
+		PackageLocale:     pkg.Locale,
+		Publisher:         pkg.Publisher,
+		PackageName:       pkg.Name,
+		License:           pkg.License,
+		ShortDescription:  pkg.ShortDescription,
+		ReleaseNotes:      release.Notes,
+		ManifestType:      "defaultLocale",
+		ManifestVersion:   WinGetManifestVersion,
+	}
+	if release.NotesKey != "" {
+		locale.ReleaseNotesURL = strings.TrimSuffix(pkg.BaseURL, "/") + "/" + release.NotesKey
+	}
+
+	manifests := []struct {
+		name     string
+		schema   string
+		manifest any
+	}{
+		{pkg.Identifier + ".yaml", "version", &wingetVersion{
+			PackageIdentifier: pkg.Identifier,
+			PackageVersion:    release.Version,
+			DefaultLocale:     pkg.Locale,
+			ManifestType:      "version",
+			ManifestVersion:   WinGetManifestVersion,
+		}},
+		{pkg.Identifier + ".installer.yaml", "installer", installers},
+		{pkg.Identifier + ".locale." + pkg.Locale + ".yaml", "defaultLocale", locale},
+	}
+	files := make([]WinGetFile, 0, len(manifests))
+	for _, m := range manifests {
+		var data bytes.Buffer
+		fmt.Fprintf(&data, "# yaml-language-server: $schema=https://aka.ms/winget-manifest.%s.%s.schema.json\n\n", m.schema, WinGetManifestVersion)
+		encoder := yaml.NewEncoder(&data)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(m.manifest); err != nil {
+			return nil, fmt.Errorf("failed to encode WinGet manifest %s: %w", m.name, err)
+		}
+		files = append(files, WinGetFile{Name: m.name, Data: data.Bytes()})
+	}
+	return files, nil
+}
+
+// sha256 returns the hex SHA-256 digest of artifact, from its checksum if
+// hashed with SHA-256 and by downloading it otherwise.
+func (p *Publisher) sha256(ctx context.Context, artifact *Artifact) (string, error) {
+	if artifact.HashAlgorithm() == HashSHA256 {
+		return artifact.Checksum, nil
+	}
+	reader, _, err := p.backend.Get(ctx, artifact.Binary)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,324 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/md4"
+
+	"update-manifest/pkg/storage"
+)
+
+// ZsyncFeed configures the zsync control files published for the artifacts
+// and for the release of every channel.
+type ZsyncFeed struct {
+	// BaseURL is the public URL of the bucket. The update information
+	// embedded into AppImages and the control files of every channel name
+	// their files by their keys resolved against it.
+	BaseURL string
+}
+
+// ZsyncKey returns the object key of the zsync control file of the artifact
+// stored under key, the key with .zsync appended.
+func ZsyncKey(key string) string {
+	return key + ".zsync"
+}
+
+// ZsyncFeedKey returns the object key of the zsync control file of the
+// artifact of platform in channel, e.g. linux-amd64.zsync, or that of the
+// variant or kind name of the artifact, e.g. linux-amd64-appimage.zsync.
+// zsync and AppImageUpdate download the current release of the channel by
+// it.
+func ZsyncFeedKey(appID, channel, platform, name string) string {
+	file := strings.ReplaceAll(platform, "/", "-")
+	if name != "" {
+		file += "-" + name
+	}
+	return fmt.Sprintf("%s/zsync/%s/%s.zsync", appID, channel, file)
+}
+
+// appImageArch names the architectures of AppImages by GOARCH, as their
+// file names do.
+var appImageArch = map[string]string{
+	"amd64": "x86_64",
+	"386":   "i686",
+	"arm64": "aarch64",
+	"arm":   "armhf",
+}
+
+// WriteZsyncFeeds makes AddRelease upload a zsync control file next to
+// every artifact, named by ZsyncKey, and Save also write the control file of
+// every artifact of the newest release each channel offers every client
+// under ZsyncFeedKey, as zsync has no rollouts. The update information of
+// an AppImage is replaced by that of its control file under ZsyncFeedKey
+// before it is published, so AppImageUpdate downloads only the blocks of a
+// newer release that changed.
+func (p *Publisher) WriteZsyncFeeds(feed ZsyncFeed) {
+	p.zsync = &feed
+}
+
+// embedUpdateInformation returns the executable of req with the update
+// information naming the control file of its channel embedded and true, if
+// it is an AppImage, or the executable unchanged and false otherwise.
+func (p *Publisher) embedUpdateInformation(req ReleaseRequest) (io.ReadSeeker, bool, error) {
+	r := seekingReaderAt{req.Executable}
+	defer req.Executable.Seek(0, io.SeekStart)
+	// AppImages of type 2 mark their ELF header, and reserve the section
+	// the update information is written to
+	var magic [3]byte
+	if _, err := r.ReadAt(magic[:], 8); err != nil || string(magic[:]) != "AI\x02" {
+		return req.Executable, false, nil
+	}
+	file, err := elf.NewFile(io.NewSectionReader(r, 0, req.Size))
+	if err != nil {
+		return req.Executable, false, nil
+	}
+	section := file.Section(".upd_info")
+	if section == nil {
+		return req.Executable, false, nil
+	}
+
+	platform, err := NormalizePlatform(req.Platform)
+	if err != nil {
+		return nil, false, err
+	}
+	info := "zsync|" + strings.TrimSuffix(p.zsync.BaseURL, "/") + "/" + ZsyncFeedKey(p.appID, req.Channel, platform, req.Kind+req.Variant)
+	if uint64(len(info)) > section.Size {
+		return nil, false, fmt.Errorf("the update information %s does not fit into the %d bytes the AppImage reserves", info, section.Size)
+	}
+	data := make([]byte, section.Size)
+	copy(data, info)
+	return &overlayReader{r: req.Executable, offset: int64(section.Offset), data: data}, true, nil
+}
+
+// seekingReaderAt reads r at an offset by seeking to it.
+type seekingReaderAt struct {
+	r io.ReadSeeker
+}
+
+func (s seekingReaderAt) ReadAt(b []byte, offset int64) (int, error) {
+	if _, err := s.r.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(s.r, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// overlayReader reads r with the bytes from offset replaced by data.
+type overlayReader struct {
+	r      io.ReadSeeker
+	pos    int64
+	offset int64
+	data   []byte
+}
+
+func (o *overlayReader) Read(b []byte) (int, error) {
+	n, err := o.r.Read(b)
+	start, end := max(o.pos, o.offset), min(o.pos+int64(n), o.offset+int64(len(o.data)))
+	if start < end {
+		copy(b[start-o.pos:end-o.pos], o.data[start-o.offset:end-o.offset])
+	}
+	o.pos += int64(n)
+	return n, err
+}
+
+func (o *overlayReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := o.r.Seek(offset, whence)
+	if err == nil {
+		o.pos = pos
+	}
+	return pos, err
+}
+
+// putZsync uploads the zsync control file of the executable of req stored
+// under key, which names the artifact by the last element of key so that
+// zsync downloads it from next to the control file. An AppImage is saved
+// under the usual name of AppImages, e.g. MyApp-1.2.0-x86_64.AppImage, and
+// other executables under the last element of key.
+func (p *Publisher) putZsync(ctx context.Context, req ReleaseRequest, key string, appImage bool) (string, error) {
+	name := path.Base(key)
+	if appImage {
+		platform, _ := NormalizePlatform(req.Platform)
+		_, arch, _ := strings.Cut(platform, "/")
+		if appImageArch[arch] != "" {
+			arch = appImageArch[arch]
+		}
+		name = fmt.Sprintf("%s-%s-%s.AppImage", p.appID, req.Version, arch)
+	}
+
+	if _, err := req.Executable.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to create zsync control file: %w", err)
+	}
+	var control bytes.Buffer
+	if err := writeZsync(&control, req.Executable, req.Size, name, path.Base(key), req.Build); err != nil {
+		return "", fmt.Errorf("failed to create zsync control file: %w", err)
+	}
+
+	zsync := ZsyncKey(key)
+	if err := p.backend.Put(ctx, zsync, &control, int64(control.Len()), storage.PutOptions{
+		ContentType:  "application/x-zsync",
+		CacheControl: p.caching.Immutable,
+	}); err != nil {
+		return "", fmt.Errorf("failed to upload zsync control file: %w", err)
+	}
+	return zsync, nil
+}
+
+// writeZsync writes the zsync control file of the size bytes read from r to
+// w, as zsyncmake does without looking into compressed files. The control
+// file names the file name, downloaded from url.
+func writeZsync(w io.Writer, r io.Reader, size int64, name, url string, mtime time.Time) error {
+	blockSize := 2048
+	if size >= 100<<20 {
+		blockSize = 4096
+	}
+
+	// the shortest checksums that tell the blocks of the file apart
+	length, blocks := float64(max(size, 1)), float64(size/int64(blockSize))
+	seqMatches := 1
+	if size > int64(blockSize) {
+		seqMatches = 2
+	}
+	rsumLength := int(math.Ceil(((math.Log(length)+math.Log(float64(blockSize)))/math.Log(2) - 8.6) / float64(seqMatches) / 8))
+	rsumLength = min(max(rsumLength, 2), 4)
+	checksumLength := int(math.Ceil((20 + (math.Log(length)+math.Log(1+blocks))/math.Log(2)) / float64(seqMatches) / 8))
+	checksumLength = min(max(checksumLength, int((7.9+(20+math.Log(1+blocks)/math.Log(2)))/8)), 16)
+
+	var sums bytes.Buffer
+	digest := sha1.New()
+	block := make([]byte, blockSize)
+	var read int64
+	for read < size {
+		n, err := io.ReadFull(r, block[:min(int64(blockSize), size-read)])
+		if err != nil {
+			return err
+		}
+		digest.Write(block[:n])
+		read += int64(n)
+		// the last block is checked padded with zeros
+		clear(block[n:])
+
+		var a, b uint16
+		for i, c := range block {
+			a += uint16(c)
+			b += uint16(blockSize-i) * uint16(c)
+		}
+		var rsum [4]byte
+		binary.BigEndian.PutUint16(rsum[:2], a)
+		binary.BigEndian.PutUint16(rsum[2:], b)
+		sums.Write(rsum[4-rsumLength:])
+		checksum := md4.New()
+		checksum.Write(block)
+		sums.Write(checksum.Sum(nil)[:checksumLength])
+	}
+
+	header := bufio.NewWriter(w)
+	fmt.Fprintf(header, "zsync: 0.6.2\n")
+	fmt.Fprintf(header, "Filename: %s\n", name)
+	if !mtime.IsZero() {
+		fmt.Fprintf(header, "MTime: %s\n", mtime.UTC().Format(time.RFC1123Z))
+	}
+	fmt.Fprintf(header, "Blocksize: %d\n", blockSize)
+	fmt.Fprintf(header, "Length: %d\n", size)
+	fmt.Fprintf(header, "Hash-Lengths: %d,%d,%d\n", seqMatches, rsumLength, checksumLength)
+	fmt.Fprintf(header, "URL: %s\n", url)
+	fmt.Fprintf(header, "SHA-1: %s\n\n", hex.EncodeToString(digest.Sum(nil)))
+	if _, err := sums.WriteTo(header); err != nil {
+		return err
+	}
+	return header.Flush()
+}
+
+// writeZsyncFeeds uploads the control files of the release every channel
+// offers, and deletes those of a channel without a release to offer.
+func (p *Publisher) writeZsyncFeeds(ctx context.Context) error {
+	for _, name := range sortedNames(p.manifest.Channel) {
+		channel := p.manifest.Channel[name]
+		release, remove := channel.Current(), true
+		if offered := channel.offered(); len(offered) > 0 {
+			release, remove = offered[0], false
+		}
+
+		for _, platform := range sortedNames(release.Artifact) {
+			normalized, err := NormalizePlatform(platform)
+			if err != nil {
+				continue
+			}
+			artifact := release.Artifact[platform]
+			artifacts := map[string]*Artifact{"": artifact}
+			for variant, a := range artifact.Variants {
+				artifacts[variant] = a
+			}
+			for kind, a := range artifact.Kinds {
+				artifacts[kind] = a
+			}
+
+			for _, variant := range sortedNames(artifacts) {
+				artifact := artifacts[variant]
+				key := ZsyncFeedKey(p.appID, name, normalized, variant)
+				switch {
+				case artifact.Zsync == "":
+				case remove:
+					if err := p.backend.Delete(ctx, key); err != nil {
+						return fmt.Errorf("failed to delete zsync control file %s: %w", key, err)
+					}
+				default:
+					if err := p.copyZsync(ctx, artifact, key); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// copyZsync copies the control file of artifact to key, naming the artifact
+// by its URL, as the control file no longer lies next to it.
+func (p *Publisher) copyZsync(ctx context.Context, artifact *Artifact, key string) error {
+	reader, _, err := p.backend.Get(ctx, artifact.Zsync)
+	if err != nil {
+		return fmt.Errorf("failed to fetch zsync control file %s: %w", artifact.Zsync, err)
+	}
+	defer reader.Close()
+	control, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to fetch zsync control file %s: %w", artifact.Zsync, err)
+	}
+
+	header, sums, ok := bytes.Cut(control, []byte("\n\n"))
+	if !ok {
+		return fmt.Errorf("zsync control file %s has no block checksums", artifact.Zsync)
+	}
+	var copied bytes.Buffer
+	for _, line := range strings.Split(string(header), "\n") {
+		if strings.HasPrefix(line, "URL: ") {
+			line = "URL: " + strings.TrimSuffix(p.zsync.BaseURL, "/") + "/" + artifact.Binary
+		}
+		copied.WriteString(line + "\n")
+	}
+	copied.WriteString("\n")
+	copied.Write(sums)
+
+	if err := p.backend.Put(ctx, key, &copied, int64(copied.Len()), storage.PutOptions{
+		ContentType:  "application/x-zsync",
+		CacheControl: p.caching.Mutable,
+	}); err != nil {
+		return fmt.Errorf("failed to upload zsync control file %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxChatNotes is the most characters of the release notes a chat message
+// quotes, the rest being left to the link to them.
+const maxChatNotes = 1500
+
+// Slack posts a message announcing each release to a Slack incoming webhook.
+type Slack struct {
+	// URL is the incoming webhook, https://hooks.slack.com/services/...
+	URL string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// Notify posts the announcement of release to the channel of the webhook.
+func (s *Slack) Notify(ctx context.Context, release *Release) error {
+	var text strings.Builder
+	fmt.Fprintf(&text, "*%s %s* released to *%s*\n", slackEscape(release.AppID), slackEscape(release.Version), slackEscape(release.Channel))
+	fmt.Fprintf(&text, "Platforms: %s", slackEscape(strings.Join(release.Platforms(), ", ")))
+	if notes := chatNotes(release); notes != "" {
+		fmt.Fprintf(&text, "\n>%s", strings.ReplaceAll(slackEscape(notes), "\n", "\n>"))
+	}
+	if release.NotesURL != "" {
+		fmt.Fprintf(&text, "\n<%s|Release notes>", release.NotesURL)
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text.String()})
+	if err != nil {
+		return err
+	}
+	if err := post(ctx, s.Client, s.URL, body, nil); err != nil {
+		return fmt.Errorf("failed to post to Slack: %w", err)
+	}
+	return nil
+}
+
+// slackEscape escapes the characters Slack reads as markup in s.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// Discord posts a message announcing each release to a Discord webhook.
+type Discord struct {
+	// URL is the webhook, https://discord.com/api/webhooks/...
+	URL string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+type discordMessage struct {
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Fields      []discordField `json:"fields"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// Notify posts the announcement of release, linking its notes if they have
+// a URL, to the channel of the webhook.
+func (d *Discord) Notify(ctx context.Context, release *Release) error {
+	platforms := strings.Join(release.Platforms(), ", ")
+	if platforms == "" {
+		platforms = "-"
+	}
+	message := discordMessage{Embeds: []discordEmbed{{
+		Title:       fmt.Sprintf("%s %s released", release.AppID, release.Version),
+		URL:         release.NotesURL,
+		Description: chatNotes(release),
+		Fields: []discordField{
+			{Name: "Channel", Value: release.Channel, Inline: true},
+			{Name: "Platforms", Value: platforms, Inline: true},
+		},
+		Timestamp: release.Published.UTC().Format(time.RFC3339),
+	}}}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if err := post(ctx, d.Client, d.URL, body, nil); err != nil {
+		return fmt.Errorf("failed to post to Discord: %w", err)
+	}
+	return nil
+}
+
+// chatNotes returns the release notes of release to quote in a chat message,
+// cut short if they are long.
+func chatNotes(release *Release) string {
+	notes := strings.TrimSpace(release.Notes)
+	if runes := []rune(notes); len(runes) > maxChatNotes {
+		notes = strings.TrimSpace(string(runes[:maxChatNotes])) + "…"
+	}
+	return notes
+}
@@ -0,0 +1,168 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Email mails an announcement of each release, with its notes and download
+// links, e.g. to a mailing list of customers.
+type Email struct {
+	// Addr is the host:port of the SMTP server. Port 465 is spoken to over
+	// TLS, others upgrade to it with STARTTLS when the server offers it.
+	Addr string
+	// Username and Password log in to the server if set, which needs TLS
+	// unless the server is on the local host.
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Notify mails the announcement of release to the recipients.
+func (e *Email) Notify(ctx context.Context, release *Release) error {
+	from, err := mail.ParseAddress(e.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address %q: %w", e.From, err)
+	}
+	to := make([]*mail.Address, len(e.To))
+	for i, address := range e.To {
+		if to[i], err = mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("invalid recipient address %q: %w", address, err)
+		}
+	}
+	if len(to) == 0 {
+		return errors.New("no recipient address")
+	}
+
+	message, err := e.message(from, to, release)
+	if err != nil {
+		return err
+	}
+	if err := e.send(ctx, from, to, message); err != nil {
+		return fmt.Errorf("failed to send email through %s: %w", e.Addr, err)
+	}
+	return nil
+}
+
+// message returns the announcement of release from from to to.
+func (e *Email) message(from *mail.Address, to []*mail.Address, release *Release) ([]byte, error) {
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s %s has been released to the %s channel.\n", release.AppID, release.Version, release.Channel)
+	if notes := strings.TrimSpace(release.Notes); notes != "" {
+		fmt.Fprintf(&body, "\nRelease notes:\n\n%s\n", notes)
+	}
+	if release.NotesURL != "" {
+		fmt.Fprintf(&body, "\nRead the release notes at %s\n", release.NotesURL)
+	}
+	if len(release.Artifacts) > 0 {
+		body.WriteString("\nDownloads:\n")
+		for _, artifact := range release.Artifacts {
+			link := artifact.URL
+			if link == "" {
+				link = artifact.Key
+			}
+			fmt.Fprintf(&body, "\n  %s: %s\n  %s %s\n", artifact.Name(), link, artifact.ChecksumAlgo, artifact.Checksum)
+		}
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	recipients := make([]string, len(to))
+	for i, address := range to {
+		recipients[i] = address.String()
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", fmt.Sprintf("%s %s released", release.AppID, release.Version)))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(&message)
+	if _, err := w.Write([]byte(strings.ReplaceAll(body.String(), "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return message.Bytes(), nil
+}
+
+// send delivers message from from to to through the server.
+func (e *Email) send(ctx context.Context, from *mail.Address, to []*mail.Address, message []byte) error {
+	host, port, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return err
+	}
+	var conn net.Conn
+	if port == "465" {
+		conn, err = (&tls.Dialer{Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", e.Addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", e.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// net/smtp knows no contexts, so the deadline of ctx bounds the whole
+	// conversation
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && port != "465" {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := client.Rcpt(address.Address); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
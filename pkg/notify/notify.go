@@ -0,0 +1,94 @@
+// Package notify tells other systems about published releases, e.g. a
+// deployment pipeline through a webhook.
+package notify
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Release describes a published release.
+type Release struct {
+	AppID   string `json:"app_id"`
+	Channel string `json:"channel"`
+	Version string `json:"version"`
+	// ManifestURL is the public URL of the manifest, empty if unknown.
+	ManifestURL string `json:"manifest_url,omitempty"`
+	// Notes are the release notes, and NotesURL their public URL when they
+	// are stored as an object of their own.
+	Notes     string     `json:"notes,omitempty"`
+	NotesURL  string     `json:"notes_url,omitempty"`
+	Artifacts []Artifact `json:"artifacts"`
+	Published time.Time  `json:"published"`
+}
+
+// Artifact is an artifact of a Release.
+type Artifact struct {
+	Platform     string `json:"platform"`
+	Variant      string `json:"variant,omitempty"`
+	Kind         string `json:"kind,omitempty"`
+	Key          string `json:"key"`
+	Checksum     string `json:"checksum"`
+	ChecksumAlgo string `json:"checksum_algo"`
+	// URL is the public URL of the artifact, empty if unknown.
+	URL string `json:"url,omitempty"`
+}
+
+// Notifier tells a system about releases.
+type Notifier interface {
+	Notify(ctx context.Context, release *Release) error
+}
+
+// Platforms returns the names of the artifacts of r, e.g. linux/amd64 v3,
+// each once.
+func (r *Release) Platforms() []string {
+	var names []string
+	for _, artifact := range r.Artifacts {
+		if name := artifact.Name(); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Name describes a, e.g. linux/amd64 v3.
+func (a Artifact) Name() string {
+	return strings.Join(strings.Fields(a.Platform+" "+a.Variant+" "+a.Kind), " ")
+}
+
+// OnChannels returns a Notifier notifying n of the releases of the channels
+// only, or of those of every channel if none is given, e.g. to post beta
+// releases to a testers' chat only.
+func OnChannels(n Notifier, channels ...string) Notifier {
+	if len(channels) == 0 {
+		return n
+	}
+	return &channelNotifier{Notifier: n, channels: channels}
+}
+
+type channelNotifier struct {
+	Notifier
+	channels []string
+}
+
+func (n *channelNotifier) Notify(ctx context.Context, release *Release) error {
+	if !slices.Contains(n.channels, release.Channel) {
+		return nil
+	}
+	return n.Notifier.Notify(ctx, release)
+}
+
+// All notifies every notifier of release, even when some fail, and returns
+// the errors of those that did.
+func All(ctx context.Context, release *Release, notifiers ...Notifier) error {
+	var errs []error
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(ctx, release); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
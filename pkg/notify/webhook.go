@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SignatureHeader is the header a Webhook sends the HMAC-SHA256 of the
+// request body in, as sha256=<hex>, when it has a secret.
+const SignatureHeader = "X-Update-Manifest-Signature"
+
+// EventHeader is the header a Webhook names the event of the request in.
+const EventHeader = "X-Update-Manifest-Event"
+
+// Webhook POSTs a JSON document describing each release to URL.
+type Webhook struct {
+	URL string
+	// Secret, if set, is the key of the HMAC of the body the request is
+	// signed with, so the receiver can tell it from forged ones. The
+	// document carries the time it was sent, which the HMAC covers, so the
+	// receiver can also refuse old requests played again.
+	Secret string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// webhookPayload is the document a Webhook sends.
+type webhookPayload struct {
+	Event string `json:"event"`
+	Sent  int64  `json:"sent"`
+	*Release
+}
+
+// Notify sends release to the webhook, failing unless it answers with a 2xx
+// status.
+func (w *Webhook) Notify(ctx context.Context, release *Release) error {
+	body, err := json.Marshal(webhookPayload{Event: "release", Sent: time.Now().Unix(), Release: release})
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{EventHeader: {"release"}}
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	if err := post(ctx, w.Client, w.URL, body, header); err != nil {
+		return fmt.Errorf("failed to call webhook %s: %w", redactURL(w.URL), err)
+	}
+	return nil
+}
+
+// post POSTs the JSON document body to rawURL with the headers header using
+// client, or http.DefaultClient if nil, failing unless it is answered with a
+// 2xx status.
+func post(ctx context.Context, client *http.Client, rawURL string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid url")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// the error of the request names the whole url
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// redactURL returns rawURL without its path and query, which often hold the
+// token of a webhook, for messages.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "(invalid url)"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,274 @@
+package patch
+
+import "bytes"
+
+// The suffix sorting and matching below is a port of Colin Percival's
+// bsdiff 4.3.
+
+func split(I, V []int, start, length, h int) {
+	var i, j, k, x, jj, kk int
+
+	if length < 16 {
+		for k = start; k < start+length; k += j {
+			j = 1
+			x = V[I[k]+h]
+			for i = 1; k+i < start+length; i++ {
+				if V[I[k+i]+h] < x {
+					x = V[I[k+i]+h]
+					j = 0
+				}
+				if V[I[k+i]+h] == x {
+					I[k+i], I[k+j] = I[k+j], I[k+i]
+					j++
+				}
+			}
+			for i = 0; i < j; i++ {
+				V[I[k+i]] = k + j - 1
+			}
+			if j == 1 {
+				I[k] = -1
+			}
+		}
+		return
+	}
+
+	x = V[I[start+length/2]+h]
+	for i = start; i < start+length; i++ {
+		if V[I[i]+h] < x {
+			jj++
+		}
+		if V[I[i]+h] == x {
+			kk++
+		}
+	}
+	jj += start
+	kk += jj
+
+	i = start
+	for i < jj {
+		if V[I[i]+h] < x {
+			i++
+		} else if V[I[i]+h] == x {
+			I[i], I[jj+j] = I[jj+j], I[i]
+			j++
+		} else {
+			I[i], I[kk+k] = I[kk+k], I[i]
+			k++
+		}
+	}
+
+	for jj+j < kk {
+		if V[I[jj+j]+h] == x {
+			j++
+		} else {
+			I[jj+j], I[kk+k] = I[kk+k], I[jj+j]
+			k++
+		}
+	}
+
+	if jj > start {
+		split(I, V, start, jj-start, h)
+	}
+
+	for i = 0; i < kk-jj; i++ {
+		V[I[jj+i]] = kk - 1
+	}
+	if jj == kk-1 {
+		I[jj] = -1
+	}
+
+	if start+length > kk {
+		split(I, V, kk, start+length-kk, h)
+	}
+}
+
+// qsufsort returns the suffix array of buf using Larsson-Sadakane sorting.
+func qsufsort(buf []byte) []int {
+	var buckets [256]int
+	I := make([]int, len(buf)+1)
+	V := make([]int, len(buf)+1)
+
+	for _, c := range buf {
+		buckets[c]++
+	}
+	for i := 1; i < 256; i++ {
+		buckets[i] += buckets[i-1]
+	}
+	copy(buckets[1:], buckets[:255])
+	buckets[0] = 0
+
+	for i, c := range buf {
+		buckets[c]++
+		I[buckets[c]] = i
+	}
+
+	I[0] = len(buf)
+	for i, c := range buf {
+		V[i] = buckets[c]
+	}
+	V[len(buf)] = 0
+
+	for i := 1; i < 256; i++ {
+		if buckets[i] == buckets[i-1]+1 {
+			I[buckets[i]] = -1
+		}
+	}
+	I[0] = -1
+
+	for h := 1; I[0] != -(len(buf) + 1); h += h {
+		n, i := 0, 0
+		for i < len(buf)+1 {
+			if I[i] < 0 {
+				n -= I[i]
+				i -= I[i]
+			} else {
+				if n != 0 {
+					I[i-n] = -n
+				}
+				n = V[I[i]] + 1 - i
+				split(I, V, i, n, h)
+				i += n
+				n = 0
+			}
+		}
+		if n != 0 {
+			I[i-n] = -n
+		}
+	}
+
+	for i := 0; i < len(buf)+1; i++ {
+		I[V[i]] = i
+	}
+	return I
+}
+
+func matchlen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// search finds the longest prefix of target that occurs in old.
+func search(I []int, old, target []byte, st, en int) (pos, n int) {
+	if en-st < 2 {
+		x := matchlen(old[I[st]:], target)
+		y := matchlen(old[I[en]:], target)
+		if x > y {
+			return I[st], x
+		}
+		return I[en], y
+	}
+
+	x := st + (en-st)/2
+	suffix := old[I[x]:]
+	m := min(len(suffix), len(target))
+	if bytes.Compare(suffix[:m], target[:m]) < 0 {
+		return search(I, old, target, x, en)
+	}
+	return search(I, old, target, st, x)
+}
+
+// control is one bsdiff instruction: add diff bytes to old, copy extra bytes
+// verbatim, then move the old cursor by seek.
+type control struct {
+	diff  []byte
+	extra []byte
+	seek  int
+}
+
+// bsdiff calls emit for each instruction that turns old, suffix sorted into
+// I by qsufsort, into new.
+func bsdiff(I []int, old, new []byte, emit func(control) error) error {
+	var scan, pos, length, lastscan, lastpos, lastoffset int
+	for scan < len(new) {
+		oldscore := 0
+		scan += length
+		for scsc := scan; scan < len(new); scan++ {
+			pos, length = search(I, old, new[scan:], 0, len(old))
+
+			for ; scsc < scan+length; scsc++ {
+				if scsc+lastoffset < len(old) && old[scsc+lastoffset] == new[scsc] {
+					oldscore++
+				}
+			}
+
+			if (length == oldscore && length != 0) || length > oldscore+8 {
+				break
+			}
+
+			if scan+lastoffset < len(old) && old[scan+lastoffset] == new[scan] {
+				oldscore--
+			}
+		}
+
+		if length == oldscore && scan != len(new) {
+			continue
+		}
+
+		var s, sf, lenf int
+		for i := 0; lastscan+i < scan && lastpos+i < len(old); {
+			if old[lastpos+i] == new[lastscan+i] {
+				s++
+			}
+			i++
+			if s*2-i > sf*2-lenf {
+				sf = s
+				lenf = i
+			}
+		}
+
+		lenb := 0
+		if scan < len(new) {
+			var s, sb int
+			for i := 1; scan >= lastscan+i && pos >= i; i++ {
+				if old[pos-i] == new[scan-i] {
+					s++
+				}
+				if s*2-i > sb*2-lenb {
+					sb = s
+					lenb = i
+				}
+			}
+		}
+
+		if lastscan+lenf > scan-lenb {
+			overlap := (lastscan + lenf) - (scan - lenb)
+			var s, ss, lens int
+			for i := 0; i < overlap; i++ {
+				if new[lastscan+lenf-overlap+i] == old[lastpos+lenf-overlap+i] {
+					s++
+				}
+				if new[scan-lenb+i] == old[pos-lenb+i] {
+					s--
+				}
+				if s > ss {
+					ss = s
+					lens = i + 1
+				}
+			}
+			lenf += lens - overlap
+			lenb -= lens
+		}
+
+		diff := make([]byte, lenf)
+		for i := range diff {
+			diff[i] = new[lastscan+i] - old[lastpos+i]
+		}
+
+		if err := emit(control{
+			diff:  diff,
+			extra: new[lastscan+lenf : scan-lenb],
+			seek:  (pos - lenb) - (lastpos + lenf),
+		}); err != nil {
+			return err
+		}
+
+		lastscan = scan - lenb
+		lastpos = pos - lenb
+		lastoffset = pos - scan
+	}
+
+	return nil
+}
@@ -0,0 +1,491 @@
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// FormatExecutable identifies patches of executables whose branches were
+// rewritten before diffing.
+//
+// Changing the code of an executable moves the functions after the change,
+// which changes the relative target of every call and jump across it: a few
+// changed bytes become changes all over the code, each of which bsdiff
+// stores. As Courgette and Zucchini do, the patch is made in two passes
+// instead. The first aligns the new executable with the old one, and the
+// second diffs the old executable against the new one with the target of
+// every call and jump of its code sections rewritten to where the aligned
+// instruction would branch to in the old executable, which leaves the
+// branches of unchanged code unchanged. Applying the patch rewrites them
+// back by the alignment it records, with the code sections of both
+// executables, so it needs no knowledge of executable formats:
+//
+//	"UMPATCH2" | uint64 new size | arch | old sections | new sections |
+//	zstd(alignment, unaligned branches, bsdiff instructions)
+//
+// Sections are a uvarint count followed by the uvarint file offset, size and
+// virtual address of each, the alignment a uvarint count followed by the
+// uvarint offsets in new and old and length of each aligned range, and the
+// unaligned branches a uvarint count followed by the uvarint distances
+// between the offsets of the branches left as they were. A patch of arch 0,
+// made when rewriting branches does not make it smaller, has neither
+// sections nor alignment:
+//
+//	"UMPATCH2" | uint64 new size | 0 | zstd(bsdiff instructions)
+const FormatExecutable = "bsdiff-zstd-exe"
+
+const magicExecutable = "UMPATCH2"
+
+// ErrNotExecutable is returned by DiffExecutable when either file is not an
+// ELF or PE executable of a supported architecture, or they are of different
+// architectures.
+var ErrNotExecutable = errors.New("not an executable of a supported architecture")
+
+// Supported reports whether Apply applies patches of format.
+func Supported(format string) bool {
+	return format == Format || format == FormatExecutable
+}
+
+// arch is an instruction set whose branches are rewritten.
+type arch byte
+
+const (
+	archX86 arch = iota + 1
+	archARM64
+)
+
+// section is a code section of an executable.
+type section struct {
+	offset, size, addr uint64
+}
+
+// DiffExecutable writes a patch of format FormatExecutable that turns the
+// executable old into the executable new to w, or returns ErrNotExecutable.
+func DiffExecutable(old, new []byte, w io.Writer) error {
+	a, oldSections := codeSections(old)
+	newArch, newSections := codeSections(new)
+	if a == 0 || a != newArch {
+		return ErrNotExecutable
+	}
+
+	// the plain patch aligns the executables
+	I := qsufsort(old)
+	m := &alignment{old: oldSections, new: newSections}
+	var plain bytes.Buffer
+	if err := compressed(&plain, func(w io.Writer) error {
+		return writeInstructions(w, I, old, new, func(newpos, oldpos, n int) {
+			m.ranges = append(m.ranges, aligned{uint64(newpos), uint64(oldpos), uint64(n)})
+		})
+	}); err != nil {
+		return err
+	}
+	m.index()
+
+	rewritten, unaligned := m.rewrite(a, new)
+	var patch bytes.Buffer
+	writeSections(&patch, oldSections)
+	writeSections(&patch, newSections)
+	if err := compressed(&patch, func(w io.Writer) error {
+		values := []uint64{uint64(len(m.ranges))}
+		for _, r := range m.ranges {
+			values = append(values, r.newpos, r.oldpos, r.size)
+		}
+		values = append(values, uint64(len(unaligned)))
+		var last uint64
+		for _, at := range unaligned {
+			values = append(values, at-last)
+			last = at
+		}
+		if err := writeUvarints(w, values); err != nil {
+			return err
+		}
+		return writeInstructions(w, I, old, rewritten, nil)
+	}); err != nil {
+		return err
+	}
+
+	header := make([]byte, len(magicExecutable)+9)
+	copy(header, magicExecutable)
+	binary.LittleEndian.PutUint64(header[len(magicExecutable):], uint64(len(new)))
+	if patch.Len() >= plain.Len() {
+		patch, a = plain, 0
+	}
+	header[len(header)-1] = byte(a)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := patch.WriteTo(w)
+	return err
+}
+
+// compressed writes the zstd stream of what write writes to w.
+func compressed(w io.Writer, write func(w io.Writer) error) error {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := write(zw); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// applyExecutable reconstructs the size bytes of the new executable from old
+// and the rest of a FormatExecutable patch read from br.
+func applyExecutable(old []byte, br *bufio.Reader, size uint64) ([]byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	a := arch(b)
+	if a > archARM64 {
+		return nil, fmt.Errorf("%w: unknown architecture %d", ErrCorrupt, a)
+	}
+	m := &alignment{}
+	if a != 0 {
+		if m.old, err = readSections(br, uint64(len(old))); err != nil {
+			return nil, err
+		}
+		if m.new, err = readSections(br, size); err != nil {
+			return nil, err
+		}
+	}
+
+	zr, err := zstd.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	r := bufio.NewReader(zr)
+	if a == 0 {
+		return applyInstructions(old, r, size)
+	}
+
+	count, err := readCount(r, size)
+	if err != nil {
+		return nil, err
+	}
+	m.ranges = make([]aligned, count)
+	for i := range m.ranges {
+		for _, v := range []*uint64{&m.ranges[i].newpos, &m.ranges[i].oldpos, &m.ranges[i].size} {
+			if *v, err = binary.ReadUvarint(r); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+			}
+		}
+		if i > 0 && m.ranges[i].newpos < m.ranges[i-1].newpos+m.ranges[i-1].size {
+			return nil, fmt.Errorf("%w: aligned ranges overlap", ErrCorrupt)
+		}
+	}
+	m.index()
+	if count, err = readCount(r, size); err != nil {
+		return nil, err
+	}
+	unaligned := make(map[uint64]bool, count)
+	var at uint64
+	for range count {
+		distance, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		at += distance
+		unaligned[at] = true
+	}
+
+	rewritten, err := applyInstructions(old, r, size)
+	if err != nil {
+		return nil, err
+	}
+	if !m.restore(a, rewritten, unaligned) {
+		return nil, fmt.Errorf("%w: branch outside the alignment", ErrCorrupt)
+	}
+	return rewritten, nil
+}
+
+// codeSections returns the architecture and code sections of the ELF or PE
+// executable data, or 0 if it is neither or of another architecture.
+// Sections overlapping one before them are left out.
+func codeSections(data []byte) (arch, []section) {
+	var a arch
+	var sections []section
+	r := bytes.NewReader(data)
+	if file, err := elf.NewFile(r); err == nil {
+		switch file.Machine {
+		case elf.EM_X86_64, elf.EM_386:
+			a = archX86
+		case elf.EM_AARCH64:
+			a = archARM64
+		default:
+			return 0, nil
+		}
+		for _, s := range file.Sections {
+			if s.Type == elf.SHT_PROGBITS && s.Flags&elf.SHF_EXECINSTR != 0 {
+				sections = append(sections, section{s.Offset, s.Size, s.Addr})
+			}
+		}
+	} else if file, err := pe.NewFile(r); err == nil {
+		switch file.Machine {
+		case pe.IMAGE_FILE_MACHINE_AMD64, pe.IMAGE_FILE_MACHINE_I386:
+			a = archX86
+		case pe.IMAGE_FILE_MACHINE_ARM64:
+			a = archARM64
+		default:
+			return 0, nil
+		}
+		var base uint64
+		switch header := file.OptionalHeader.(type) {
+		case *pe.OptionalHeader32:
+			base = uint64(header.ImageBase)
+		case *pe.OptionalHeader64:
+			base = header.ImageBase
+		}
+		for _, s := range file.Sections {
+			// the raw data of a section is padded to the file alignment
+			if s.Characteristics&(pe.IMAGE_SCN_CNT_CODE|pe.IMAGE_SCN_MEM_EXECUTE) != 0 {
+				sections = append(sections, section{uint64(s.Offset), uint64(min(s.Size, s.VirtualSize)), base + uint64(s.VirtualAddress)})
+			}
+		}
+	} else {
+		return 0, nil
+	}
+
+	sort.Slice(sections, func(i, j int) bool { return sections[i].offset < sections[j].offset })
+	kept := sections[:0]
+	for _, s := range sections {
+		if s.offset+s.size > uint64(len(data)) || len(kept) > 0 && s.offset < kept[len(kept)-1].offset+kept[len(kept)-1].size {
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return a, kept
+}
+
+func writeSections(w *bytes.Buffer, sections []section) {
+	values := []uint64{uint64(len(sections))}
+	for _, s := range sections {
+		values = append(values, s.offset, s.size, s.addr)
+	}
+	writeUvarints(w, values)
+}
+
+func writeUvarints(w io.Writer, values []uint64) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, v := range values {
+		if _, err := w.Write(buf[:binary.PutUvarint(buf, v)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCount reads the number of items recorded of an executable of size
+// bytes.
+func readCount(r *bufio.Reader, size uint64) (uint64, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if count > size {
+		return 0, fmt.Errorf("%w: count %d exceeds the executable", ErrCorrupt, count)
+	}
+	return count, nil
+}
+
+// readSections reads the code sections of an executable of size bytes.
+func readSections(r *bufio.Reader, size uint64) ([]section, error) {
+	count, err := readCount(r, size)
+	if err != nil {
+		return nil, err
+	}
+	sections := make([]section, count)
+	for i := range sections {
+		for _, v := range []*uint64{&sections[i].offset, &sections[i].size, &sections[i].addr} {
+			if *v, err = binary.ReadUvarint(r); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+			}
+		}
+		s := sections[i]
+		if s.offset > size || s.size > size-s.offset {
+			return nil, fmt.Errorf("%w: section exceeds the executable", ErrCorrupt)
+		}
+		if i > 0 && s.offset < sections[i-1].offset+sections[i-1].size {
+			return nil, fmt.Errorf("%w: sections overlap", ErrCorrupt)
+		}
+	}
+	return sections, nil
+}
+
+// aligned is a range of the new executable made from one of old.
+type aligned struct {
+	newpos, oldpos, size uint64
+}
+
+// alignment maps the addresses of the code of the new executable to those
+// of the old one and back.
+type alignment struct {
+	old, new []section
+	// ranges are sorted by their position in new, and byOld by that in old
+	ranges, byOld []aligned
+}
+
+func (m *alignment) index() {
+	m.byOld = append([]aligned(nil), m.ranges...)
+	sort.SliceStable(m.byOld, func(i, j int) bool { return m.byOld[i].oldpos < m.byOld[j].oldpos })
+}
+
+// toOld returns the address in old aligned with addr in new.
+func (m *alignment) toOld(addr uint64) (uint64, bool) {
+	offset, ok := fileOffset(m.new, addr)
+	if !ok {
+		return 0, false
+	}
+	i := sort.Search(len(m.ranges), func(i int) bool { return m.ranges[i].newpos+m.ranges[i].size > offset })
+	if i == len(m.ranges) || m.ranges[i].newpos > offset {
+		return 0, false
+	}
+	return virtualAddr(m.old, m.ranges[i].oldpos+offset-m.ranges[i].newpos)
+}
+
+// toNew returns the address in new aligned with addr in old by the range of
+// old containing it that starts last, which is not the one toOld aligns it by
+// where ranges of old overlap.
+func (m *alignment) toNew(addr uint64) (uint64, bool) {
+	offset, ok := fileOffset(m.old, addr)
+	if !ok {
+		return 0, false
+	}
+	for i := sort.Search(len(m.byOld), func(i int) bool { return m.byOld[i].oldpos > offset }) - 1; i >= 0; i-- {
+		if r := m.byOld[i]; r.oldpos+r.size > offset {
+			return virtualAddr(m.new, r.newpos+offset-r.oldpos)
+		}
+	}
+	return 0, false
+}
+
+func fileOffset(sections []section, addr uint64) (uint64, bool) {
+	for _, s := range sections {
+		if addr >= s.addr && addr-s.addr < s.size {
+			return s.offset + addr - s.addr, true
+		}
+	}
+	return 0, false
+}
+
+func virtualAddr(sections []section, offset uint64) (uint64, bool) {
+	for _, s := range sections {
+		if offset >= s.offset && offset-s.offset < s.size {
+			return s.addr + offset - s.offset, true
+		}
+	}
+	return 0, false
+}
+
+// rewrite returns a copy of data, the new executable, with the branches of
+// its code rewritten to where the aligned instruction branches to in old, and
+// the offsets of the branches left as they were, as either end is not
+// aligned or restore would not find the way back.
+func (m *alignment) rewrite(a arch, data []byte) ([]byte, []uint64) {
+	rewritten := bytes.Clone(data)
+	var unaligned []uint64
+	for _, s := range m.new {
+		branches(a, rewritten[s.offset:s.offset+s.size], s.addr, func(at int, addr uint64, target int64) (int64, bool) {
+			to := uint64(int64(addr) + target)
+			oldAddr, ok := m.toOld(addr)
+			oldTo, ok2 := m.toOld(to)
+			back, ok3 := m.toNew(oldTo)
+			if !ok || !ok2 || !ok3 || back != to || !branchable(a, int64(oldTo-oldAddr)) {
+				unaligned = append(unaligned, s.offset+uint64(at))
+				return 0, false
+			}
+			return int64(oldTo - oldAddr), true
+		})
+	}
+	return rewritten, unaligned
+}
+
+// restore rewrites the branches of the code of data, the new executable
+// rewritten by rewrite, back in place. It reports false if a branch is not
+// aligned.
+func (m *alignment) restore(a arch, data []byte, unaligned map[uint64]bool) bool {
+	ok := true
+	for _, s := range m.new {
+		branches(a, data[s.offset:s.offset+s.size], s.addr, func(at int, addr uint64, target int64) (int64, bool) {
+			if unaligned[s.offset+uint64(at)] {
+				return 0, false
+			}
+			oldAddr, found := m.toOld(addr)
+			if !found {
+				ok = false
+				return 0, false
+			}
+			to, found := m.toNew(uint64(int64(oldAddr) + target))
+			if !found || !branchable(a, int64(to-addr)) {
+				ok = false
+				return 0, false
+			}
+			return int64(to - addr), true
+		})
+	}
+	return ok
+}
+
+// branchable reports whether a branch of a can have the target relative to
+// its instruction.
+func branchable(a arch, target int64) bool {
+	switch a {
+	case archX86:
+		displacement := target - 5
+		return displacement >= -1<<24 && displacement < 1<<24
+	case archARM64:
+		return target%4 == 0 && target/4 >= -1<<25 && target/4 < 1<<25
+	}
+	return false
+}
+
+// branches calls fn with the offset in code at addr, the address and the
+// target relative to the instruction of every branch, and replaces the
+// target by the one fn returns with true.
+//
+// x86 branches are the near calls and jumps, opcodes E8 and E9, with a 32 bit
+// displacement within 16 MiB, as others are rarely branches. A displacement
+// is skipped either way, so the bytes looked at for opcodes are never
+// rewritten, and a branch rewritten to a target within the same range is
+// found again. arm64 branches are the BL instructions.
+func branches(a arch, code []byte, addr uint64, fn func(at int, addr uint64, target int64) (int64, bool)) {
+	switch a {
+	case archX86:
+		for i := 0; i+5 <= len(code); i++ {
+			if code[i] != 0xe8 && code[i] != 0xe9 {
+				continue
+			}
+			target := int64(int32(binary.LittleEndian.Uint32(code[i+1:]))) + 5
+			if branchable(a, target) {
+				if target, ok := fn(i, addr+uint64(i), target); ok {
+					binary.LittleEndian.PutUint32(code[i+1:], uint32(int32(target-5)))
+				}
+			}
+			i += 4
+		}
+	case archARM64:
+		// instructions are aligned to 4 bytes in memory
+		for i := int((4 - addr%4) % 4); i+4 <= len(code); i += 4 {
+			insn := binary.LittleEndian.Uint32(code[i:])
+			if insn&0xfc000000 != 0x94000000 {
+				continue
+			}
+			target := int64(int32(insn<<6)>>6) * 4
+			if target, ok := fn(i, addr+uint64(i), target); ok {
+				binary.LittleEndian.PutUint32(code[i:], 0x94000000|uint32(target/4)&0x03ffffff)
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package patch creates and applies binary delta patches between artifacts.
+//
+// Patches use the bsdiff algorithm. Instead of the bzip2 blocks of the
+// original BSDIFF40 container, instructions are interleaved with their data
+// in a single zstd stream:
+//
+//	"UMPATCH1" | uint64 new size | zstd(varint diff len, varint extra len,
+//	varint seek, diff bytes, extra bytes, ...)
+//
+// Patches of executables can instead be made by DiffExecutable, in the
+// format FormatExecutable, which Apply applies as well.
+package patch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format identifies the patch container in the manifest.
+const Format = "bsdiff-zstd"
+
+const magic = "UMPATCH1"
+
+// ErrCorrupt is returned when a patch cannot be applied.
+var ErrCorrupt = errors.New("corrupt patch")
+
+// Diff writes a patch that turns old into new to w.
+func Diff(old, new []byte, w io.Writer) error {
+	header := make([]byte, len(magic)+8)
+	copy(header, magic)
+	binary.LittleEndian.PutUint64(header[len(magic):], uint64(len(new)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := writeInstructions(zw, qsufsort(old), old, new, nil); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// writeInstructions writes the bsdiff instructions turning old, suffix sorted
+// into I, into new to w. matched, if set, is called with the position in new
+// and old and the length of every range of new made by adding diff bytes to
+// old.
+func writeInstructions(w io.Writer, I []int, old, new []byte, matched func(newpos, oldpos, n int)) error {
+	buf := make([]byte, 3*binary.MaxVarintLen64)
+	var oldpos, newpos int
+	return bsdiff(I, old, new, func(c control) error {
+		if matched != nil && len(c.diff) > 0 {
+			matched(newpos, oldpos, len(c.diff))
+		}
+		newpos += len(c.diff) + len(c.extra)
+		oldpos += len(c.diff) + c.seek
+
+		n := binary.PutUvarint(buf, uint64(len(c.diff)))
+		n += binary.PutUvarint(buf[n:], uint64(len(c.extra)))
+		n += binary.PutVarint(buf[n:], int64(c.seek))
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(c.diff); err != nil {
+			return err
+		}
+		_, err := w.Write(c.extra)
+		return err
+	})
+}
+
+// Apply reconstructs the new artifact from old and the patch read from r, of
+// either format.
+func Apply(old []byte, r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(magic)+8)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	size := binary.LittleEndian.Uint64(header[len(magic):])
+	switch string(header[:len(magic)]) {
+	case magic:
+	case magicExecutable:
+		return applyExecutable(old, br, size)
+	default:
+		return nil, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+
+	zr, err := zstd.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return applyInstructions(old, bufio.NewReader(zr), size)
+}
+
+// applyInstructions reconstructs the size bytes of the new artifact from old
+// and the bsdiff instructions read from br.
+func applyInstructions(old []byte, br *bufio.Reader, size uint64) ([]byte, error) {
+	new := make([]byte, size)
+	var oldpos, newpos int64
+	for newpos < int64(size) {
+		diffLen, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		extraLen, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		seek, err := binary.ReadVarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+
+		if diffLen > size-uint64(newpos) || extraLen > size-uint64(newpos)-diffLen {
+			return nil, fmt.Errorf("%w: instruction exceeds output size", ErrCorrupt)
+		}
+
+		diff := new[newpos : newpos+int64(diffLen)]
+		if _, err := io.ReadFull(br, diff); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		for i := range diff {
+			if oldpos+int64(i) >= 0 && oldpos+int64(i) < int64(len(old)) {
+				diff[i] += old[oldpos+int64(i)]
+			}
+		}
+		newpos += int64(diffLen)
+		oldpos += int64(diffLen)
+
+		if _, err := io.ReadFull(br, new[newpos:newpos+int64(extraLen)]); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		newpos += int64(extraLen)
+		oldpos += seek
+	}
+
+	return new, nil
+}
@@ -0,0 +1,195 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// AWSKMS signs with asymmetric keys of AWS KMS, which never leave it, so the
+// signing machine only needs the kms:Sign and kms:GetPublicKey permissions.
+type AWSKMS struct {
+	// Region is the region of the keys.
+	Region string
+	// Endpoint is the URL of KMS, https://kms.<Region>.amazonaws.com when
+	// empty.
+	Endpoint    string
+	Credentials *credentials.Credentials
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+type awsKMSSigner struct {
+	kms    *AWSKMS
+	keyID  string
+	public PublicKey
+}
+
+// Signer returns a signer using the ECC_NIST_P256 key keyID, its ID, ARN or
+// alias such as alias/releases. The public key is fetched right away, so a
+// key that cannot be used is reported before anything is signed.
+func (k *AWSKMS) Signer(ctx context.Context, keyID string) (Signer, error) {
+	var resp struct {
+		KeySpec   string `json:"KeySpec"`
+		PublicKey []byte `json:"PublicKey"`
+	}
+	if err := k.call(ctx, "GetPublicKey", map[string]string{"KeyId": keyID}, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get public key of AWS KMS key %s: %w", keyID, err)
+	}
+	if resp.KeySpec != "ECC_NIST_P256" {
+		return nil, fmt.Errorf("AWS KMS key %s is of spec %s, not ECC_NIST_P256", keyID, resp.KeySpec)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key of AWS KMS key %s: %w", keyID, err)
+	}
+	public, err := NewPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &awsKMSSigner{kms: k, keyID: keyID, public: public}, nil
+}
+
+func (s *awsKMSSigner) Public() PublicKey {
+	return s.public
+}
+
+func (s *awsKMSSigner) Sign(ctx context.Context, message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	var resp struct {
+		Signature []byte `json:"Signature"`
+	}
+	if err := s.kms.call(ctx, "Sign", map[string]any{
+		"KeyId":            s.keyID,
+		"Message":          digest[:],
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": "ECDSA_SHA_256",
+	}, &resp); err != nil {
+		return nil, fmt.Errorf("failed to sign with AWS KMS key %s: %w", s.keyID, err)
+	}
+	return resp.Signature, nil
+}
+
+// call invokes action of the KMS API with the JSON body params and decodes
+// the JSON response into result.
+func (k *AWSKMS) call(ctx context.Context, action string, params, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	endpoint := k.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com", k.Region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	creds, err := k.Credentials.Get()
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+	signV4(req, body, creds, k.Region, "kms", time.Now())
+
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &failure) == nil && failure.Type != "" {
+			return fmt.Errorf("%s: %s", failure.Type[strings.LastIndex(failure.Type, "#")+1:], failure.Message)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.Unmarshal(data, result)
+}
+
+// signV4 signs req, whose body is body, for service in region with AWS
+// Signature Version 4.
+func signV4(req *http.Request, body []byte, creds credentials.Value, region, service string, now time.Time) {
+	timestamp := now.UTC().Format("20060102T150405Z")
+	date := timestamp[:8]
+	payload := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", timestamp)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", timestamp, scope, hex.EncodeToString(hashed[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
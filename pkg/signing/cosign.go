@@ -0,0 +1,166 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FormatCosign identifies Sigstore bundles made by cosign.
+const FormatCosign = "cosign"
+
+// The extensions of Fulcio certificates naming the OIDC issuer, the first
+// DER encoded and the second, deprecated one raw.
+var (
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+	oidFulcioIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+)
+
+// CosignSigner signs files keyless with the cosign command. cosign obtains a
+// short-lived certificate for the OIDC identity of the environment from
+// Fulcio, e.g. that of a GitHub Actions workflow allowed to request an ID
+// token, and records the signature in the Rekor transparency log, so no
+// private key has to be kept. Its signatures are the Sigstore bundles cosign
+// writes, verified with `cosign verify-blob --bundle`.
+type CosignSigner struct {
+	// Command is the cosign executable, cosign on the PATH if empty.
+	Command string
+}
+
+func (s *CosignSigner) Format() string {
+	return FormatCosign
+}
+
+func (s *CosignSigner) Extension() string {
+	return ".sigstore.json"
+}
+
+// SignFile runs `cosign sign-blob` on the content of r and returns the
+// bundle it writes.
+func (s *CosignSigner) SignFile(ctx context.Context, name string, r io.Reader) ([]byte, error) {
+	dir, err := os.MkdirTemp("", ".cosign-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the file keeps its published name, which cosign prints
+	file := filepath.Join(dir, filepath.Base(name))
+	w, err := os.Create(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging file: %w", err)
+	}
+	_, err = io.Copy(w, r)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write staging file: %w", err)
+	}
+
+	command := s.Command
+	if command == "" {
+		command = "cosign"
+	}
+	bundle := filepath.Join(dir, "bundle"+s.Extension())
+	cmd := exec.CommandContext(ctx, command, "sign-blob", "--yes", "--bundle", bundle, file)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("cosign sign-blob failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	signature, err := os.ReadFile(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cosign bundle: %w", err)
+	}
+	return signature, nil
+}
+
+// Identity returns the identity of the signing certificate in the bundle
+// signature.
+func (s *CosignSigner) Identity(signature []byte) (Identity, error) {
+	return CosignIdentity(signature)
+}
+
+// CosignIdentity returns the identity of the signing certificate in the
+// Sigstore bundle, either the one of cosign --bundle or of the Sigstore
+// bundle specification. It does not verify the certificate.
+func CosignIdentity(bundle []byte) (Identity, error) {
+	var parsed struct {
+		// cosign --bundle
+		Cert string `json:"cert"`
+		// the Sigstore bundle specification
+		VerificationMaterial struct {
+			Certificate *struct {
+				RawBytes []byte `json:"rawBytes"`
+			} `json:"certificate"`
+			X509CertificateChain *struct {
+				Certificates []struct {
+					RawBytes []byte `json:"rawBytes"`
+				} `json:"certificates"`
+			} `json:"x509CertificateChain"`
+		} `json:"verificationMaterial"`
+	}
+	if err := json.Unmarshal(bundle, &parsed); err != nil {
+		return Identity{}, fmt.Errorf("failed to decode cosign bundle: %w", err)
+	}
+
+	var der []byte
+	material := parsed.VerificationMaterial
+	switch {
+	case parsed.Cert != "":
+		certPEM, err := base64.StdEncoding.DecodeString(parsed.Cert)
+		if err != nil {
+			return Identity{}, fmt.Errorf("failed to decode cosign certificate: %w", err)
+		}
+		block, _ := pem.Decode(certPEM)
+		if block == nil {
+			return Identity{}, errors.New("cosign certificate is not PEM encoded")
+		}
+		der = block.Bytes
+	case material.Certificate != nil:
+		der = material.Certificate.RawBytes
+	case material.X509CertificateChain != nil && len(material.X509CertificateChain.Certificates) > 0:
+		der = material.X509CertificateChain.Certificates[0].RawBytes
+	default:
+		return Identity{}, errors.New("cosign bundle holds no certificate, it was not signed keyless")
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to parse cosign certificate: %w", err)
+	}
+
+	var identity Identity
+	switch {
+	case len(cert.URIs) > 0:
+		identity.Subject = cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		identity.Subject = cert.EmailAddresses[0]
+	default:
+		return Identity{}, errors.New("cosign certificate names no identity")
+	}
+	for _, extension := range cert.Extensions {
+		switch {
+		case extension.Id.Equal(oidFulcioIssuerV2):
+			if _, err := asn1.Unmarshal(extension.Value, &identity.Issuer); err != nil {
+				return Identity{}, fmt.Errorf("failed to decode certificate issuer: %w", err)
+			}
+		case extension.Id.Equal(oidFulcioIssuer) && identity.Issuer == "":
+			identity.Issuer = string(extension.Value)
+		}
+	}
+	return identity, nil
+}
@@ -0,0 +1,137 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// GCPKMS signs with asymmetric keys of Google Cloud KMS, which never leave
+// it, so the signing machine only needs the roles/cloudkms.signerVerifier
+// role on the key.
+type GCPKMS struct {
+	// Token returns the OAuth 2.0 access token requests are authorized with.
+	Token func(ctx context.Context) (string, error)
+	// Endpoint is the URL of Cloud KMS, https://cloudkms.googleapis.com when
+	// empty.
+	Endpoint string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+type gcpKMSSigner struct {
+	kms    *GCPKMS
+	name   string
+	public PublicKey
+}
+
+// Signer returns a signer using the EC_SIGN_P256_SHA256 key version name,
+// e.g. projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1.
+// The public key is fetched right away, so a key that cannot be used is
+// reported before anything is signed.
+func (k *GCPKMS) Signer(ctx context.Context, name string) (Signer, error) {
+	var resp struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := k.call(ctx, http.MethodGet, name+"/publicKey", nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get public key of Cloud KMS key %s: %w", name, err)
+	}
+	if resp.Algorithm != "EC_SIGN_P256_SHA256" {
+		return nil, fmt.Errorf("Cloud KMS key %s has the algorithm %s, not EC_SIGN_P256_SHA256", name, resp.Algorithm)
+	}
+
+	block, _ := pem.Decode([]byte(resp.PEM))
+	if block == nil {
+		return nil, fmt.Errorf("Cloud KMS key %s has no PEM encoded public key", name)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key of Cloud KMS key %s: %w", name, err)
+	}
+	public, err := NewPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &gcpKMSSigner{kms: k, name: name, public: public}, nil
+}
+
+func (s *gcpKMSSigner) Public() PublicKey {
+	return s.public
+}
+
+func (s *gcpKMSSigner) Sign(ctx context.Context, message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	var resp struct {
+		Signature []byte `json:"signature"`
+	}
+	body := map[string]any{"digest": map[string][]byte{"sha256": digest[:]}}
+	if err := s.kms.call(ctx, http.MethodPost, s.name+":asymmetricSign", body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to sign with Cloud KMS key %s: %w", s.name, err)
+	}
+	return resp.Signature, nil
+}
+
+// call sends a request with the JSON body to the resource path of the Cloud
+// KMS API and decodes the JSON response into result.
+func (k *GCPKMS) call(ctx context.Context, method, path string, body, result any) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+	endpoint := k.Endpoint
+	if endpoint == "" {
+		endpoint = "https://cloudkms.googleapis.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(endpoint, "/")+"/v1/"+path, payload)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token, err := k.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get Google Cloud access token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+				Status  string `json:"status"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &failure) == nil && failure.Error.Message != "" {
+			return fmt.Errorf("%s: %s", failure.Error.Status, failure.Error.Message)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.Unmarshal(data, result)
+}
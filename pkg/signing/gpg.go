@@ -0,0 +1,72 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// FormatOpenPGP identifies ASCII-armored OpenPGP signatures.
+const FormatOpenPGP = "openpgp"
+
+// GPGSigner signs files with a key of the keyring of the gpg command,
+// producing ASCII-armored detached OpenPGP signatures that
+// `gpg --verify` checks. Keys held by gpg-agent or a smartcard work as
+// they do for gpg itself.
+type GPGSigner struct {
+	// Command is the gpg executable, gpg on the PATH if empty.
+	Command string
+	// Key selects the signing key, e.g. its fingerprint or email address.
+	Key string
+	// Passphrase unlocks the key without asking gpg-agent for it, if set.
+	Passphrase string
+}
+
+func (s *GPGSigner) Format() string {
+	return FormatOpenPGP
+}
+
+func (s *GPGSigner) Extension() string {
+	return ".asc"
+}
+
+// SignFile runs `gpg --detach-sign --armor` on the content of r.
+func (s *GPGSigner) SignFile(ctx context.Context, name string, r io.Reader) ([]byte, error) {
+	command := s.Command
+	if command == "" {
+		command = "gpg"
+	}
+	args := []string{"--batch", "--no-tty", "--local-user", s.Key, "--armor", "--detach-sign", "--output", "-"}
+
+	var passphrase *os.File
+	if s.Passphrase != "" {
+		// the content is read from stdin, so the passphrase is passed on a
+		// pipe of its own
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("failed to pass passphrase to gpg: %w", err)
+		}
+		defer reader.Close()
+		go func() {
+			io.WriteString(writer, s.Passphrase)
+			writer.Close()
+		}()
+		passphrase = reader
+		args = append([]string{"--pinentry-mode", "loopback", "--passphrase-fd", "3"}, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	if passphrase != nil {
+		cmd.ExtraFiles = []*os.File{passphrase}
+	}
+	var signature, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = r, &signature, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg --detach-sign failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return signature.Bytes(), nil
+}
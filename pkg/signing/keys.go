@@ -0,0 +1,111 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// PublicKey is a key trusted to sign manifests.
+type PublicKey struct {
+	ID        string
+	Algorithm string
+	Key       crypto.PublicKey
+}
+
+// NewPublicKey wraps key, deriving its key ID and algorithm.
+func NewPublicKey(key crypto.PublicKey) (PublicKey, error) {
+	id, err := KeyID(key)
+	if err != nil {
+		return PublicKey{}, err
+	}
+
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return PublicKey{ID: id, Algorithm: AlgorithmEd25519, Key: key}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			return PublicKey{ID: id, Algorithm: AlgorithmECDSAP256, Key: key}, nil
+		}
+		return PublicKey{}, fmt.Errorf("unsupported ECDSA curve %s", key.Curve.Params().Name)
+	}
+	return PublicKey{}, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// KeyID returns the identifier of key: the first 8 bytes of the blake2b-256
+// digest of its PKIX encoding, hex encoded.
+func KeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := blake2b.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX public key, or a base64 encoded
+// raw Ed25519 or PKIX DER public key.
+func ParsePublicKey(s string) (PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return PublicKey{}, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		return NewPublicKey(key)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("failed to decode public key: %w", err)
+	}
+	if len(raw) == ed25519.PublicKeySize {
+		return NewPublicKey(ed25519.PublicKey(raw))
+	}
+
+	key, err := x509.ParsePKIXPublicKey(raw)
+	if err != nil {
+		return PublicKey{}, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return NewPublicKey(key)
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS#8 Ed25519 private key, as
+// produced by `openssl genpkey -algorithm ed25519`, or a base64 encoded
+// Ed25519 seed or private key.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return privateKey, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode private key: %w", err)
+	}
+
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, errors.New("private key has invalid length")
+}
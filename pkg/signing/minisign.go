@@ -0,0 +1,315 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// FormatMinisign identifies signatures in the format of minisign.
+const FormatMinisign = "minisign"
+
+// minisign key and signature blobs start with the signature algorithm: Ed
+// for Ed25519 over the message and ED for Ed25519 over its BLAKE2b-512
+// digest, which is all current minisign versions produce.
+const (
+	minisignAlgorithm       = "Ed"
+	minisignHashedAlgorithm = "ED"
+	minisignKeyIDSize       = 8
+)
+
+// minisignSecretKeySize is the length of a decoded minisign secret key: the
+// signature, KDF and checksum algorithms, the scrypt salt, opslimit and
+// memlimit, and the key ID, Ed25519 private key and checksum encrypted by
+// the KDF.
+const minisignSecretKeySize = 2 + 2 + 2 + 32 + 8 + 8 + minisignKeyIDSize + ed25519.PrivateKeySize + blake2b.Size256
+
+// MinisignKey is a minisign secret key. It signs files in the format
+// `minisign -V` verifies.
+type MinisignKey struct {
+	id  [minisignKeyIDSize]byte
+	key ed25519.PrivateKey
+}
+
+// ParseMinisignKey parses a minisign secret key file, as written by
+// `minisign -G`, or the base64 encoded file `tauri signer generate` writes,
+// decrypting it with password unless it was created without one.
+func ParseMinisignKey(data []byte, password string) (*MinisignKey, error) {
+	file := string(data)
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(file)); err == nil && strings.HasPrefix(string(decoded), "untrusted comment:") {
+		file = string(decoded)
+	}
+	raw, err := decodeMinisign(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode minisign key: %w", err)
+	}
+	if len(raw) != minisignSecretKeySize || string(raw[:2]) != minisignAlgorithm || string(raw[4:6]) != "B2" {
+		return nil, errors.New("not a minisign Ed25519 secret key")
+	}
+
+	kdf, salt, secret := string(raw[2:4]), raw[6:38], raw[54:]
+	switch kdf {
+	case "Sc":
+		n, r, p := minisignScryptParams(binary.LittleEndian.Uint64(raw[38:46]), binary.LittleEndian.Uint64(raw[46:54]))
+		stream, err := scrypt.Key([]byte(password), salt, n, r, p, len(secret))
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive minisign key: %w", err)
+		}
+		for i := range secret {
+			secret[i] ^= stream[i]
+		}
+	case "\x00\x00":
+	default:
+		return nil, fmt.Errorf("unsupported minisign key derivation %q", kdf)
+	}
+
+	k := &MinisignKey{key: ed25519.PrivateKey(secret[minisignKeyIDSize : minisignKeyIDSize+ed25519.PrivateKeySize])}
+	copy(k.id[:], secret[:minisignKeyIDSize])
+
+	checksum := blake2b.Sum256(append([]byte(minisignAlgorithm), secret[:minisignKeyIDSize+ed25519.PrivateKeySize]...))
+	if subtle.ConstantTimeCompare(checksum[:], secret[minisignKeyIDSize+ed25519.PrivateKeySize:]) != 1 {
+		return nil, errors.New("wrong password for minisign key")
+	}
+	return k, nil
+}
+
+// minisign encrypts the secret keys it generates with these scrypt limits,
+// its defaults, for libsodium to derive N = 2^20, r = 8 and p = 1 from.
+const (
+	minisignOpsLimit = 1 << 25
+	minisignMemLimit = 1 << 30
+)
+
+// GenerateMinisignKey returns a new minisign secret key with a random key ID.
+func GenerateMinisignKey() (*MinisignKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	k := &MinisignKey{key: key}
+	if _, err := rand.Read(k.id[:]); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Marshal returns k in the format of a minisign secret key file, which
+// ParseMinisignKey and `minisign -S` read, encrypted with password unless it
+// is empty.
+func (k *MinisignKey) Marshal(password string) ([]byte, error) {
+	secret := append(k.id[:], k.key...)
+	checksum := blake2b.Sum256(append([]byte(minisignAlgorithm), secret...))
+	secret = append(secret, checksum[:]...)
+
+	raw := make([]byte, 0, minisignSecretKeySize)
+	raw = append(raw, minisignAlgorithm...)
+	salt := make([]byte, 32)
+	limits := make([]byte, 16)
+	if password == "" {
+		raw = append(raw, 0, 0)
+	} else {
+		raw = append(raw, "Sc"...)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		binary.LittleEndian.PutUint64(limits, minisignOpsLimit)
+		binary.LittleEndian.PutUint64(limits[8:], minisignMemLimit)
+
+		n, r, p := minisignScryptParams(minisignOpsLimit, minisignMemLimit)
+		stream, err := scrypt.Key([]byte(password), salt, n, r, p, len(secret))
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive minisign key: %w", err)
+		}
+		for i := range secret {
+			secret[i] ^= stream[i]
+		}
+	}
+	raw = append(raw, "B2"...)
+	raw = append(raw, salt...)
+	raw = append(raw, limits...)
+	raw = append(raw, secret...)
+
+	comment := "minisign encrypted secret key"
+	if password == "" {
+		comment = "minisign unencrypted secret key"
+	}
+	return []byte(fmt.Sprintf("untrusted comment: %s\n%s\n", comment, base64.StdEncoding.EncodeToString(raw))), nil
+}
+
+// minisignScryptParams returns the scrypt parameters libsodium derives from
+// the opslimit and memlimit stored in a minisign key.
+func minisignScryptParams(opslimit, memlimit uint64) (n, r, p int) {
+	opslimit = max(opslimit, 32768)
+	r = 8
+
+	maxN := memlimit / (uint64(r) * 128)
+	if opslimit < memlimit/32 {
+		maxN = opslimit / (uint64(r) * 4)
+	}
+	logN := 1
+	for ; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+	if opslimit < memlimit/32 {
+		return 1 << logN, r, 1
+	}
+
+	maxrp := min((opslimit/4)/(uint64(1)<<logN), 0x3fffffff)
+	return 1 << logN, r, int(maxrp / uint64(r))
+}
+
+// ID returns the key ID as minisign prints it.
+func (k *MinisignKey) ID() string {
+	return minisignKeyID(k.id)
+}
+
+// PublicKey returns the minisign public key matching k.
+func (k *MinisignKey) PublicKey() *MinisignPublicKey {
+	return &MinisignPublicKey{id: k.id, key: k.key.Public().(ed25519.PublicKey)}
+}
+
+func (k *MinisignKey) Format() string {
+	return FormatMinisign
+}
+
+func (k *MinisignKey) Extension() string {
+	return ".minisig"
+}
+
+// SignFile signs the BLAKE2b-512 digest of the content of r, as
+// `minisign -S` does, with a trusted comment holding the time and name.
+func (k *MinisignKey) SignFile(_ context.Context, name string, r io.Reader) ([]byte, error) {
+	hasher, _ := blake2b.New512(nil)
+	if _, err := io.Copy(hasher, r); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	blob := append([]byte(minisignHashedAlgorithm), k.id[:]...)
+	signature := ed25519.Sign(k.key, hasher.Sum(nil))
+	blob = append(blob, signature...)
+
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), name)
+	global := ed25519.Sign(k.key, append(signature, trusted...))
+
+	var file bytes.Buffer
+	fmt.Fprintf(&file, "untrusted comment: signature from minisign secret key %s\n", k.ID())
+	fmt.Fprintf(&file, "%s\n", base64.StdEncoding.EncodeToString(blob))
+	fmt.Fprintf(&file, "trusted comment: %s\n", trusted)
+	fmt.Fprintf(&file, "%s\n", base64.StdEncoding.EncodeToString(global))
+	return file.Bytes(), nil
+}
+
+// MinisignPublicKey is a minisign public key.
+type MinisignPublicKey struct {
+	id  [minisignKeyIDSize]byte
+	key ed25519.PublicKey
+}
+
+// ParseMinisignPublicKey parses a minisign public key file, as written by
+// `minisign -G`, or the base64 encoded key on its own, as given to
+// `minisign -P`.
+func ParseMinisignPublicKey(s string) (*MinisignPublicKey, error) {
+	raw, err := decodeMinisign(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode minisign public key: %w", err)
+	}
+	if len(raw) != 2+minisignKeyIDSize+ed25519.PublicKeySize || string(raw[:2]) != minisignAlgorithm {
+		return nil, errors.New("not a minisign Ed25519 public key")
+	}
+
+	k := &MinisignPublicKey{key: ed25519.PublicKey(raw[2+minisignKeyIDSize:])}
+	copy(k.id[:], raw[2:])
+	return k, nil
+}
+
+// ID returns the key ID as minisign prints it.
+func (k *MinisignPublicKey) ID() string {
+	return minisignKeyID(k.id)
+}
+
+// String returns the public key in the format of a minisign public key file.
+func (k *MinisignPublicKey) String() string {
+	raw := append(append([]byte(minisignAlgorithm), k.id[:]...), k.key...)
+	return fmt.Sprintf("untrusted comment: minisign public key %s\n%s\n", k.ID(), base64.StdEncoding.EncodeToString(raw))
+}
+
+// Verify checks that signature, the content of a .minisig file, is a valid
+// signature of the content of r by k, including its trusted comment, which
+// it returns.
+func (k *MinisignPublicKey) Verify(r io.Reader, signature []byte) (string, error) {
+	lines := strings.Split(strings.TrimSpace(string(signature)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "untrusted comment:") || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return "", errors.New("not a minisign signature")
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(blob) != 2+minisignKeyIDSize+ed25519.SignatureSize {
+		return "", errors.New("not a minisign signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", errors.New("not a minisign signature")
+	}
+	if !bytes.Equal(blob[2:2+minisignKeyIDSize], k.id[:]) {
+		return "", fmt.Errorf("%w: signed by minisign key %s", ErrNoValidSignature, minisignKeyID([minisignKeyIDSize]byte(blob[2:2+minisignKeyIDSize])))
+	}
+
+	var message []byte
+	switch string(blob[:2]) {
+	case minisignHashedAlgorithm:
+		hasher, _ := blake2b.New512(nil)
+		if _, err := io.Copy(hasher, r); err != nil {
+			return "", err
+		}
+		message = hasher.Sum(nil)
+	case minisignAlgorithm:
+		if message, err = io.ReadAll(r); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unsupported minisign signature algorithm %q", blob[:2])
+	}
+
+	trusted := strings.TrimSuffix(strings.TrimPrefix(lines[2], "trusted comment: "), "\r")
+	value := blob[2+minisignKeyIDSize:]
+	if !ed25519.Verify(k.key, message, value) || !ed25519.Verify(k.key, append(append([]byte(nil), value...), trusted...), global) {
+		return "", ErrNoValidSignature
+	}
+	return trusted, nil
+}
+
+// decodeMinisign decodes the base64 line of a minisign key or signature
+// file, skipping its comments.
+func decodeMinisign(s string) ([]byte, error) {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(line)
+	}
+	return nil, errors.New("no key")
+}
+
+// minisignKeyID formats id as the little endian number minisign prints.
+func minisignKeyID(id [minisignKeyIDSize]byte) string {
+	var reversed [minisignKeyIDSize]byte
+	for i, b := range id {
+		reversed[len(id)-1-i] = b
+	}
+	return strings.ToUpper(hex.EncodeToString(reversed[:]))
+}
@@ -0,0 +1,270 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidInclusionProof is returned when a log entry is not proven to be
+// part of the transparency log.
+var ErrInvalidInclusionProof = errors.New("invalid inclusion proof")
+
+// TransparencyLog records signatures in a Rekor transparency log, e.g.
+// https://rekor.sigstore.dev, which publishes every entry so a signature
+// made for a targeted or rollback attack cannot stay hidden.
+type TransparencyLog struct {
+	URL string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// LogEntry is an entry of a Rekor transparency log recording a signature.
+type LogEntry struct {
+	UUID           string `json:"uuid"`
+	KeyID          string `json:"keyid"`
+	LogID          string `json:"log_id"`
+	LogIndex       int64  `json:"log_index"`
+	IntegratedTime int64  `json:"integrated_time"`
+	// Body is the entry as the log stores it, base64 encoded.
+	Body           string         `json:"body"`
+	InclusionProof InclusionProof `json:"inclusion_proof"`
+	// SignedEntryTimestamp is the promise of the log to include the entry,
+	// signed by the log.
+	SignedEntryTimestamp []byte `json:"signed_entry_timestamp,omitempty"`
+}
+
+// InclusionProof proves that an entry is a leaf of the Merkle tree of the
+// log with the root hash RootHash, as specified by RFC 9162.
+type InclusionProof struct {
+	LogIndex   int64    `json:"log_index"`
+	TreeSize   int64    `json:"tree_size"`
+	RootHash   string   `json:"root_hash"`
+	Hashes     []string `json:"hashes"`
+	Checkpoint string   `json:"checkpoint,omitempty"`
+}
+
+// rekordEntry is a Rekor entry of kind rekord, the signature of data by a
+// PKIX public key.
+type rekordEntry struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Signature struct {
+			Format    string `json:"format"`
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+		Data struct {
+			Content []byte `json:"content,omitempty"`
+			Hash    *struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash,omitempty"`
+		} `json:"data"`
+	} `json:"spec"`
+}
+
+// Record adds signature of message by key to the log and returns the entry
+// with its inclusion proof.
+func (l *TransparencyLog) Record(ctx context.Context, message []byte, signature Signature, key PublicKey) (*LogEntry, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry rekordEntry
+	entry.APIVersion, entry.Kind = "0.0.1", "rekord"
+	entry.Spec.Signature.Format = "x509"
+	entry.Spec.Signature.Content = signature.Value
+	entry.Spec.Signature.PublicKey.Content = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	entry.Spec.Data.Content = message
+	proposed, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode log entry: %w", err)
+	}
+
+	entriesURL := strings.TrimSuffix(l.URL, "/") + "/api/v1/log/entries"
+	status, location, body, err := l.do(ctx, http.MethodPost, entriesURL, proposed)
+	if err != nil {
+		return nil, err
+	}
+	// Ed25519 signatures are deterministic, so signing the same manifest
+	// again proposes an entry the log already holds, which it answers with
+	// a conflict naming the entry
+	if status == http.StatusConflict && location != "" {
+		existing, err := resolveURL(entriesURL, location)
+		if err != nil {
+			return nil, err
+		}
+		if status, _, body, err = l.do(ctx, http.MethodGet, existing, nil); err != nil {
+			return nil, err
+		}
+		if status == http.StatusOK {
+			status = http.StatusCreated
+		}
+	}
+	if status != http.StatusCreated {
+		return nil, fmt.Errorf("failed to record signature in transparency log: unexpected status %d: %s", status, strings.TrimSpace(string(body)))
+	}
+
+	var entries map[string]struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+		Verification   struct {
+			InclusionProof *struct {
+				LogIndex   int64    `json:"logIndex"`
+				TreeSize   int64    `json:"treeSize"`
+				RootHash   string   `json:"rootHash"`
+				Hashes     []string `json:"hashes"`
+				Checkpoint string   `json:"checkpoint"`
+			} `json:"inclusionProof"`
+			SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+		} `json:"verification"`
+	}
+	if err := json.Unmarshal(body, &entries); err != nil || len(entries) != 1 {
+		return nil, errors.New("transparency log returned no entry")
+	}
+	var uuid string
+	for uuid = range entries {
+	}
+	recorded := entries[uuid]
+	proof := recorded.Verification.InclusionProof
+	if proof == nil {
+		return nil, errors.New("transparency log returned no inclusion proof")
+	}
+
+	logged := &LogEntry{
+		UUID:           uuid,
+		KeyID:          signature.KeyID,
+		LogID:          recorded.LogID,
+		LogIndex:       recorded.LogIndex,
+		IntegratedTime: recorded.IntegratedTime,
+		Body:           recorded.Body,
+		InclusionProof: InclusionProof{
+			LogIndex:   proof.LogIndex,
+			TreeSize:   proof.TreeSize,
+			RootHash:   proof.RootHash,
+			Hashes:     proof.Hashes,
+			Checkpoint: proof.Checkpoint,
+		},
+		SignedEntryTimestamp: recorded.Verification.SignedEntryTimestamp,
+	}
+	if err := logged.Verify(message, signature.Value); err != nil {
+		return nil, err
+	}
+	return logged, nil
+}
+
+// do sends a request with the JSON body to the log and returns the status,
+// location and body of the response.
+func (l *TransparencyLog) do(ctx context.Context, method, target string, body []byte) (int, string, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("failed to record signature in transparency log: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("failed to read log entry: %w", err)
+	}
+	return resp.StatusCode, resp.Header.Get("Location"), data, nil
+}
+
+// resolveURL resolves the location ref against base.
+func resolveURL(base, ref string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return u.ResolveReference(r).String(), nil
+}
+
+// Verify checks that the entry records signature of message and that its
+// inclusion proof leads to its root hash. It does not verify the checkpoint
+// or signed entry timestamp, which are signed by the log.
+func (e *LogEntry) Verify(message, signature []byte) error {
+	body, err := base64.StdEncoding.DecodeString(e.Body)
+	if err != nil {
+		return fmt.Errorf("failed to decode log entry: %w", err)
+	}
+	var entry rekordEntry
+	if err := json.Unmarshal(body, &entry); err != nil || entry.Kind != "rekord" {
+		return errors.New("log entry is not a rekord entry")
+	}
+	if !bytes.Equal(entry.Spec.Signature.Content, signature) {
+		return errors.New("log entry records another signature")
+	}
+	digest := sha256.Sum256(message)
+	if hash := entry.Spec.Data.Hash; hash == nil || hash.Algorithm != "sha256" || hash.Value != hex.EncodeToString(digest[:]) {
+		return errors.New("log entry records the signature of another message")
+	}
+
+	return e.InclusionProof.verify(append([]byte{0}, body...))
+}
+
+// verify checks that leaf is the leaf at LogIndex of the tree of TreeSize
+// leaves with the root RootHash, following RFC 9162 2.1.3.2.
+func (p *InclusionProof) verify(leaf []byte) error {
+	if p.LogIndex < 0 || p.LogIndex >= p.TreeSize {
+		return ErrInvalidInclusionProof
+	}
+	root, err := hex.DecodeString(p.RootHash)
+	if err != nil {
+		return ErrInvalidInclusionProof
+	}
+
+	fn, sn := p.LogIndex, p.TreeSize-1
+	r := sha256.Sum256(leaf)
+	for _, encoded := range p.Hashes {
+		hash, err := hex.DecodeString(encoded)
+		if err != nil || sn == 0 {
+			return ErrInvalidInclusionProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = sha256.Sum256(append(append([]byte{1}, hash...), r[:]...))
+			for fn&1 == 0 && fn != 0 {
+				fn, sn = fn>>1, sn>>1
+			}
+		} else {
+			r = sha256.Sum256(append(append([]byte{1}, r[:]...), hash...))
+		}
+		fn, sn = fn>>1, sn>>1
+	}
+	if sn != 0 || !bytes.Equal(r[:], root) {
+		return ErrInvalidInclusionProof
+	}
+	return nil
+}
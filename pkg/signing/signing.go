@@ -0,0 +1,224 @@
+// Package signing signs manifests and verifies their detached signatures.
+package signing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// AlgorithmEd25519 identifies pure Ed25519 signatures.
+const AlgorithmEd25519 = "ed25519"
+
+// AlgorithmECDSAP256 identifies ASN.1 DER encoded ECDSA signatures on the
+// P-256 curve of the SHA-256 digest of the message, as made by cloud KMS
+// keys.
+const AlgorithmECDSAP256 = "ecdsa-p256-sha256"
+
+// ErrNoValidSignature is returned when no signature verifies against a
+// trusted key.
+var ErrNoValidSignature = errors.New("no valid signature from a trusted key")
+
+// ErrExpired is returned when the signatures of an envelope are valid but
+// expired.
+var ErrExpired = errors.New("signature expired")
+
+// Signer produces signatures with a private key.
+type Signer interface {
+	// Public returns the public key matching the signing key.
+	Public() PublicKey
+	Sign(ctx context.Context, message []byte) ([]byte, error)
+}
+
+// FileSigner produces detached signatures of whole files in a format of
+// other tools, published next to the signed object so users can verify it
+// with those tools, e.g. minisign.
+type FileSigner interface {
+	// Format names the signature format, e.g. minisign.
+	Format() string
+	// Extension is appended to the key of the signed object to form the key
+	// of its signature, e.g. .minisig.
+	Extension() string
+	// SignFile signs the content read from r of the file published as name.
+	SignFile(ctx context.Context, name string, r io.Reader) ([]byte, error)
+}
+
+// Identity names who made a keyless signature: the subject of its signing
+// certificate, e.g. the workflow of a GitHub Actions run, and the OIDC
+// issuer that vouched for it.
+type Identity struct {
+	Subject string `json:"subject"`
+	Issuer  string `json:"issuer"`
+}
+
+// KeylessSigner is a FileSigner without a key of its own, whose signatures
+// name the identity that made them instead.
+type KeylessSigner interface {
+	FileSigner
+	// Identity returns the identity signature was made by.
+	Identity(signature []byte) (Identity, error)
+}
+
+// Envelope is the detached signature document published next to a manifest.
+type Envelope struct {
+	// Expires is when the signatures stop being valid, if ever. They sign it
+	// along with the message, so an old manifest cannot be served in place
+	// of newer ones forever.
+	Expires    *time.Time  `json:"expires,omitempty"`
+	Signatures []Signature `json:"signatures"`
+}
+
+// SignedMessage returns what the signatures of e sign for message: message
+// itself, or message bound to the expiry of e.
+func (e *Envelope) SignedMessage(message []byte) []byte {
+	if e.Expires == nil {
+		return message
+	}
+	expires := e.Expires.UTC().Format(time.RFC3339)
+	return slices.Concat([]byte("update-manifest expiring\x00"+expires+"\x00"), message)
+}
+
+// expired returns an error if e has expired.
+func (e *Envelope) expired() error {
+	if e.Expires != nil && time.Now().After(*e.Expires) {
+		return fmt.Errorf("%w at %s", ErrExpired, e.Expires.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Signature is a single signature in an Envelope.
+type Signature struct {
+	KeyID     string `json:"keyid"`
+	Algorithm string `json:"algorithm"`
+	Value     []byte `json:"signature"`
+}
+
+type ed25519Signer struct {
+	key    ed25519.PrivateKey
+	public PublicKey
+}
+
+// NewEd25519Signer returns a Signer using key.
+func NewEd25519Signer(key ed25519.PrivateKey) (Signer, error) {
+	public, err := NewPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &ed25519Signer{key: key, public: public}, nil
+}
+
+func (s *ed25519Signer) Public() PublicKey {
+	return s.public
+}
+
+func (s *ed25519Signer) Sign(_ context.Context, message []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, message), nil
+}
+
+// Sign signs message with every signer.
+func Sign(ctx context.Context, message []byte, signers ...Signer) (*Envelope, error) {
+	return SignExpiring(ctx, message, nil, signers...)
+}
+
+// SignExpiring signs message with every signer like Sign, with signatures
+// that expire at expires unless it is nil.
+func SignExpiring(ctx context.Context, message []byte, expires *time.Time, signers ...Signer) (*Envelope, error) {
+	envelope := &Envelope{Expires: expires}
+	signed := envelope.SignedMessage(message)
+	for _, signer := range signers {
+		value, err := signer.Sign(ctx, signed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign with key %s: %w", signer.Public().ID, err)
+		}
+
+		envelope.Signatures = append(envelope.Signatures, Signature{
+			KeyID:     signer.Public().ID,
+			Algorithm: signer.Public().Algorithm,
+			Value:     value,
+		})
+	}
+	return envelope, nil
+}
+
+// Verify checks that envelope holds a valid signature of message by one of
+// the trusted keys, and has not expired.
+func Verify(message []byte, envelope *Envelope, trusted ...PublicKey) error {
+	signed := envelope.SignedMessage(message)
+	for _, signature := range envelope.Signatures {
+		for _, key := range trusted {
+			if key.ID == signature.KeyID && key.verify(signed, signature) {
+				return envelope.expired()
+			}
+		}
+	}
+	return ErrNoValidSignature
+}
+
+// VerifyDetached parses the encoded envelope and verifies message against it.
+func VerifyDetached(message, envelope []byte, trusted ...PublicKey) error {
+	return VerifyDetachedThreshold(message, envelope, 1, trusted...)
+}
+
+// ValidSignatures returns the IDs of the trusted keys envelope holds a valid
+// signature of message by, each once.
+func ValidSignatures(message []byte, envelope *Envelope, trusted ...PublicKey) []string {
+	signed := envelope.SignedMessage(message)
+	var ids []string
+	for _, key := range trusted {
+		if slices.Contains(ids, key.ID) {
+			continue
+		}
+		for _, signature := range envelope.Signatures {
+			if key.ID == signature.KeyID && key.verify(signed, signature) {
+				ids = append(ids, key.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// VerifyThreshold checks that envelope holds valid signatures of message by
+// threshold different trusted keys, e.g. 2 of the keys of three release
+// engineers, and has not expired.
+func VerifyThreshold(message []byte, envelope *Envelope, threshold int, trusted ...PublicKey) error {
+	if threshold <= 1 {
+		return Verify(message, envelope, trusted...)
+	}
+	if valid := len(ValidSignatures(message, envelope, trusted...)); valid < threshold {
+		return fmt.Errorf("%w: signed by %d of the %d keys required", ErrNoValidSignature, valid, threshold)
+	}
+	return envelope.expired()
+}
+
+// VerifyDetachedThreshold parses the encoded envelope and verifies message
+// against it like VerifyThreshold.
+func VerifyDetachedThreshold(message, envelope []byte, threshold int, trusted ...PublicKey) error {
+	var parsed Envelope
+	if err := json.Unmarshal(envelope, &parsed); err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+	return VerifyThreshold(message, &parsed, threshold, trusted...)
+}
+
+func (k PublicKey) verify(message []byte, signature Signature) bool {
+	if signature.Algorithm != k.Algorithm {
+		return false
+	}
+
+	switch key := k.Key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, signature.Value)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, digest[:], signature.Value)
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package signing
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FormatSparkle identifies the EdDSA signatures of Sparkle, the update
+// framework for macOS applications.
+const FormatSparkle = "sparkle"
+
+// sparkleLegacyKeySize is the length of the keys of Sparkle versions before
+// 2.0: an expanded Ed25519 private key followed by its public key, which
+// the seed cannot be recovered from.
+const sparkleLegacyKeySize = 96
+
+// SparkleKey is a Sparkle EdDSA private key. It signs files the way
+// sign_update does, producing the sparkle:edSignature of an appcast item.
+type SparkleKey struct {
+	key ed25519.PrivateKey
+}
+
+// ParseSparkleKey parses a Sparkle private key as exported by
+// `generate_keys -x`: the base64 encoded Ed25519 seed.
+func ParseSparkleKey(data []byte) (*SparkleKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Sparkle key: %w", err)
+	}
+	switch len(seed) {
+	case ed25519.SeedSize:
+		return &SparkleKey{key: ed25519.NewKeyFromSeed(seed)}, nil
+	case sparkleLegacyKeySize:
+		return nil, errors.New("Sparkle key is in the format of Sparkle 1, export it again with the generate_keys of Sparkle 2")
+	}
+	return nil, errors.New("not a Sparkle EdDSA private key")
+}
+
+// PublicKey returns the public key matching k in the form of the
+// SUPublicEDKey entry of Info.plist.
+func (k *SparkleKey) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(k.key.Public().(ed25519.PublicKey))
+}
+
+func (k *SparkleKey) Format() string {
+	return FormatSparkle
+}
+
+func (k *SparkleKey) Extension() string {
+	return ".edsig"
+}
+
+// SignFile signs the content of r with pure Ed25519 and returns the base64
+// encoded signature. Ed25519 signs the whole message rather than a digest
+// of it, so the content is read into memory, as sign_update does.
+func (k *SparkleKey) SignFile(_ context.Context, _ string, r io.Reader) ([]byte, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(k.key, content))), nil
+}
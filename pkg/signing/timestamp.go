@@ -0,0 +1,212 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+)
+
+// ErrTimestampMismatch is returned when a timestamp token does not cover
+// the message it is checked against.
+var ErrTimestampMismatch = errors.New("timestamp does not cover the signature")
+
+// TimestampAuthority obtains RFC 3161 timestamp tokens, which prove that a
+// message existed at the time the authority signed, from a timestamp
+// authority, e.g. https://freetsa.org/tsr.
+type TimestampAuthority struct {
+	URL string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int
+	CertReq        bool
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo struct {
+		EContentType asn1.ObjectIdentifier
+		EContent     []byte `asn1:"explicit,tag:0"`
+	}
+}
+
+// Timestamp requests a timestamp token of the SHA-256 digest of message and
+// returns the timestamp response of the authority, which `openssl ts
+// -verify -in` checks, and the time it vouches for.
+func (a *TimestampAuthority) Timestamp(ctx context.Context, message []byte) ([]byte, time.Time, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	digest := sha256.Sum256(message)
+	request, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest[:],
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to encode timestamp request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(request))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to request timestamp: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("failed to request timestamp: unexpected status %s", resp.Status)
+	}
+	response, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read timestamp: %w", err)
+	}
+
+	genTime, tokenNonce, err := parseTimestamp(response, message)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if tokenNonce == nil || tokenNonce.Cmp(nonce) != 0 {
+		return nil, time.Time{}, errors.New("timestamp does not answer the request")
+	}
+	return response, genTime, nil
+}
+
+// VerifyTimestamp checks that the timestamp response covers message and
+// returns the time it vouches for. It does not verify the signature of the
+// timestamp authority, which `openssl ts -verify` does against its
+// certificate.
+func VerifyTimestamp(response, message []byte) (time.Time, error) {
+	genTime, _, err := parseTimestamp(response, message)
+	return genTime, err
+}
+
+// parseTimestamp decodes the timestamp response, checking it was granted
+// for the SHA-256 digest of message, and returns its time and nonce.
+func parseTimestamp(response, message []byte) (time.Time, *big.Int, error) {
+	var resp timeStampResp
+	if _, err := asn1.Unmarshal(response, &resp); err != nil {
+		return time.Time{}, nil, fmt.Errorf("failed to decode timestamp: %w", err)
+	}
+	// 0 is granted, 1 granted with modifications
+	if resp.Status.Status > 1 {
+		return time.Time{}, nil, fmt.Errorf("timestamp authority refused the request with status %d: %s", resp.Status.Status, strings.Join(resp.Status.StatusString, " "))
+	}
+
+	var token contentInfo
+	if _, err := asn1.Unmarshal(resp.TimeStampToken.FullBytes, &token); err != nil || !token.ContentType.Equal(oidSignedData) {
+		return time.Time{}, nil, errors.New("timestamp holds no signed token")
+	}
+	var signed signedData
+	if _, err := asn1.Unmarshal(token.Content.Bytes, &signed); err != nil || !signed.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return time.Time{}, nil, errors.New("timestamp holds no signed token")
+	}
+
+	genTime, imprint, nonce, err := parseTSTInfo(signed.EncapContentInfo.EContent)
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("failed to decode timestamp: %w", err)
+	}
+	digest := sha256.Sum256(message)
+	if !imprint.HashAlgorithm.Algorithm.Equal(oidSHA256) || !bytes.Equal(imprint.HashedMessage, digest[:]) {
+		return time.Time{}, nil, ErrTimestampMismatch
+	}
+	return genTime, nonce, nil
+}
+
+// parseTSTInfo decodes the fields of a TSTInfo up to its time, and its
+// nonce. The optional fields between them are not told apart by their tags,
+// so they are read in turn rather than by encoding/asn1.
+func parseTSTInfo(der []byte) (time.Time, messageImprint, *big.Int, error) {
+	var info asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return time.Time{}, messageImprint{}, nil, err
+	}
+
+	var version int
+	var policy asn1.ObjectIdentifier
+	var imprint messageImprint
+	var serial *big.Int
+	var genTime time.Time
+	rest := info.Bytes
+	for _, field := range []any{&version, &policy, &imprint, &serial} {
+		var err error
+		if rest, err = asn1.Unmarshal(rest, field); err != nil {
+			return time.Time{}, messageImprint{}, nil, err
+		}
+	}
+	rest, err := asn1.UnmarshalWithParams(rest, &genTime, "generalized")
+	if err != nil {
+		return time.Time{}, messageImprint{}, nil, err
+	}
+
+	// accuracy and ordering may precede the nonce, the only integer left
+	for len(rest) > 0 {
+		var field asn1.RawValue
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return time.Time{}, messageImprint{}, nil, err
+		}
+		if field.Class == asn1.ClassUniversal && field.Tag == asn1.TagInteger {
+			var nonce *big.Int
+			if _, err := asn1.Unmarshal(field.FullBytes, &nonce); err != nil {
+				return time.Time{}, messageImprint{}, nil, err
+			}
+			return genTime, imprint, nonce, nil
+		}
+	}
+	return genTime, imprint, nil, nil
+}
@@ -0,0 +1,209 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"update-manifest/pkg/manifest"
+)
+
+// download is what the download of an object counts towards.
+type download struct {
+	version  string
+	platform string
+}
+
+// Counter counts the downloads listed in access logs by the release and
+// platform of the objects downloaded.
+type Counter struct {
+	downloads map[string]download
+}
+
+// NewCounter returns a Counter of the downloads of the artifacts, compressed
+// copies, chunk indexes and patches of the releases m references, including
+// those of its release history. Checksum files, signatures and zsync control
+// files are fetched alongside an artifact, so they are not counted.
+func NewCounter(m *manifest.Manifest) *Counter {
+	c := &Counter{downloads: make(map[string]download)}
+	add := func(key string, d download) {
+		if _, exists := c.downloads[key]; key != "" && !exists {
+			c.downloads[key] = d
+		}
+	}
+
+	channels := make([]string, 0, len(m.Channel))
+	for name := range m.Channel {
+		channels = append(channels, name)
+	}
+	sort.Strings(channels)
+	for _, name := range channels {
+		channel := m.Channel[name]
+		for _, release := range append([]*manifest.Release{&channel.Release}, channel.Releases...) {
+			for platform, artifact := range release.Artifact {
+				d := download{version: release.Version, platform: platform}
+				for _, artifact := range artifact.All() {
+					add(artifact.Binary, d)
+					if artifact.Compressed != nil {
+						add(artifact.Compressed.Key, d)
+					}
+					if artifact.Chunks != nil {
+						add(artifact.Chunks.Key, d)
+					}
+					for _, delta := range artifact.AllPatches() {
+						add(delta.Key, d)
+					}
+				}
+			}
+		}
+	}
+	return c
+}
+
+// Count adds the downloads the access log r lists to s and returns their
+// number and that of the other successful downloads it ignored, e.g. of the
+// manifest or of the artifacts of pruned releases. The log is gzip compressed
+// or not, and holds S3 server access log lines or Cloudflare Logpush HTTP
+// request records, one JSON object per line, as pushed for R2 buckets served
+// from a custom domain. Only complete downloads are counted: the partial
+// ones of range requests would count resumed downloads more than once.
+func (c *Counter) Count(s *Stats, r io.Reader) (counted, ignored int64, err error) {
+	buffered := bufio.NewReader(r)
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to decompress log: %w", err)
+		}
+		defer gz.Close()
+		buffered = bufio.NewReader(gz)
+	}
+
+	scanner := bufio.NewScanner(buffered)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var paths []string
+		if strings.HasPrefix(text, "{") {
+			paths, err = parseLogpush(text)
+		} else {
+			paths, err = parseS3Log(text)
+		}
+		if err != nil {
+			return counted, ignored, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		if d, ok := c.lookup(paths); ok {
+			s.Add(d.version, d.platform, 1)
+			counted++
+		} else {
+			ignored++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return counted, ignored, fmt.Errorf("failed to read log: %w", err)
+	}
+	return counted, ignored, nil
+}
+
+// lookup returns the download of the first of paths that is the key of a
+// counted object.
+func (c *Counter) lookup(paths []string) (download, bool) {
+	for _, path := range paths {
+		if d, ok := c.downloads[path]; ok {
+			return d, true
+		}
+	}
+	return download{}, false
+}
+
+// logpushRecord holds the fields of a Cloudflare Logpush HTTP request record
+// Count reads.
+type logpushRecord struct {
+	ClientRequestMethod string `json:"ClientRequestMethod"`
+	ClientRequestPath   string `json:"ClientRequestPath"`
+	ClientRequestURI    string `json:"ClientRequestURI"`
+	EdgeResponseStatus  int    `json:"EdgeResponseStatus"`
+}
+
+// parseLogpush returns the keys the object downloaded by the successful GET
+// request of the Logpush record line may have, or none for other requests.
+// The path is the key when the bucket is served from a domain of its own, and
+// the key prefixed by the bucket when it is served path style.
+func parseLogpush(line string) ([]string, error) {
+	var record logpushRecord
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return nil, fmt.Errorf("failed to decode Logpush record: %w", err)
+	}
+	if record.ClientRequestMethod != http.MethodGet || record.EdgeResponseStatus != http.StatusOK {
+		return nil, nil
+	}
+	path := record.ClientRequestPath
+	if path == "" {
+		path, _, _ = strings.Cut(record.ClientRequestURI, "?")
+	}
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request path %q", path)
+	}
+	key := strings.TrimPrefix(unescaped, "/")
+	paths := []string{key}
+	if _, withoutBucket, ok := strings.Cut(key, "/"); ok {
+		paths = append(paths, withoutBucket)
+	}
+	return paths, nil
+}
+
+// parseS3Log returns the key of the object downloaded by the successful GET
+// request of the S3 server access log line, or none for other requests.
+func parseS3Log(line string) ([]string, error) {
+	fields := logFields(line)
+	// bucket owner, bucket, time, remote IP, requester, request ID,
+	// operation, key, request URI, HTTP status, ...
+	if len(fields) < 10 {
+		return nil, fmt.Errorf("not an S3 access log line: %d fields", len(fields))
+	}
+	status, err := strconv.Atoi(fields[9])
+	if err != nil && fields[9] != "-" {
+		return nil, fmt.Errorf("invalid HTTP status %q", fields[9])
+	}
+	if fields[6] != "REST.GET.OBJECT" || status != http.StatusOK || fields[7] == "-" {
+		return nil, nil
+	}
+	key, err := url.PathUnescape(fields[7])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %q", fields[7])
+	}
+	return []string{key}, nil
+}
+
+// logFields splits an access log line at spaces, keeping the fields quoted
+// or in brackets whole, without the quotes or brackets.
+func logFields(line string) []string {
+	var fields []string
+	for line = strings.TrimLeft(line, " "); line != ""; line = strings.TrimLeft(line, " ") {
+		end := " "
+		switch line[0] {
+		case '"':
+			end, line = "\"", line[1:]
+		case '[':
+			end, line = "]", line[1:]
+		}
+		field, rest, _ := strings.Cut(line, end)
+		fields = append(fields, field)
+		line = rest
+	}
+	return fields
+}
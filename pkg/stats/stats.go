@@ -0,0 +1,99 @@
+// Package stats counts the downloads of the releases of an application from
+// the access logs of the bucket or CDN serving them.
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"update-manifest/pkg/storage"
+)
+
+// Stats holds the download counts of an application.
+type Stats struct {
+	AppID   string    `json:"app_id"`
+	Updated time.Time `json:"updated"`
+	// Downloads counts the downloads of artifacts and patches by version,
+	// then platform.
+	Downloads map[string]map[string]int64 `json:"downloads"`
+	// Ingested names the logs counted so far, by key for those in the
+	// backend and by SHA-256 digest for files, so none is counted twice.
+	Ingested []string `json:"ingested,omitempty"`
+}
+
+// Key returns the object key of the stats of appID.
+func Key(appID string) string {
+	return fmt.Sprintf("%s/stats.json", appID)
+}
+
+// Load returns the stats of appID, empty ones if none are stored, and their
+// ETag, empty if none are.
+func Load(ctx context.Context, backend storage.Backend, appID string) (*Stats, string, error) {
+	stats := &Stats{AppID: appID, Downloads: make(map[string]map[string]int64)}
+	reader, info, err := backend.Get(ctx, Key(appID))
+	if errors.Is(err, storage.ErrNotExist) {
+		return stats, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch stats: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch stats: %w", err)
+	}
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, "", fmt.Errorf("failed to decode stats: %w", err)
+	}
+	if stats.Downloads == nil {
+		stats.Downloads = make(map[string]map[string]int64)
+	}
+	return stats, info.ETag, nil
+}
+
+// Save stores s, if the stored stats still have the ETag etag, or none are
+// stored when it is empty, so counts ingested concurrently are not lost.
+func Save(ctx context.Context, backend storage.Backend, s *Stats, etag string) error {
+	s.Updated = time.Now().UTC().Truncate(time.Second)
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stats: %w", err)
+	}
+	opts := storage.PutOptions{ContentType: "application/json", IfMatch: etag, IfNoneMatch: etag == ""}
+	if err := backend.Put(ctx, Key(s.AppID), bytes.NewReader(data), int64(len(data)), opts); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return errors.New("the stats were updated concurrently, ingest the logs again")
+		}
+		return fmt.Errorf("failed to upload stats: %w", err)
+	}
+	return nil
+}
+
+// Add counts n downloads of the artifact of version for platform.
+func (s *Stats) Add(version, platform string, n int64) {
+	if s.Downloads[version] == nil {
+		s.Downloads[version] = make(map[string]int64)
+	}
+	s.Downloads[version][platform] += n
+}
+
+// Total returns the downloads of version on every platform.
+func (s *Stats) Total(version string) int64 {
+	var total int64
+	for _, n := range s.Downloads[version] {
+		total += n
+	}
+	return total
+}
+
+// HasIngested reports whether the log named source was counted.
+func (s *Stats) HasIngested(source string) bool {
+	return slices.Contains(s.Ingested, source)
+}
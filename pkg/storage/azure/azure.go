@@ -0,0 +1,278 @@
+// Package azure implements storage.Backend on top of Azure Blob Storage.
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+
+	"update-manifest/pkg/storage"
+)
+
+// Backend stores objects as blobs in a single container.
+type Backend struct {
+	client      *azblob.Client
+	container   string
+	blockSize   int64
+	concurrency int
+	sasToken    bool
+}
+
+// Options configures a connection to a storage account.
+type Options struct {
+	// ServiceURL is the blob endpoint of the account, e.g.
+	// https://myaccount.blob.core.windows.net.
+	ServiceURL string
+	Container  string
+
+	// SASToken authenticates with a shared access signature. When empty,
+	// Credential is used.
+	SASToken string
+	// Credential authenticates with Azure AD, e.g. a service principal.
+	Credential azcore.TokenCredential
+
+	// BlockSize is the size of the blocks uploads are split into, 1 MiB
+	// when 0.
+	BlockSize int64
+	// Concurrency is the number of blocks uploaded in parallel. Every block
+	// in flight is buffered in memory.
+	Concurrency int
+}
+
+// New connects to the container described by opts.
+func New(opts Options) (*Backend, error) {
+	serviceURL := strings.TrimSuffix(opts.ServiceURL, "/") + "/"
+
+	var client *azblob.Client
+	var err error
+	switch {
+	case opts.SASToken != "":
+		client, err = azblob.NewClientWithNoCredential(serviceURL+"?"+strings.TrimPrefix(opts.SASToken, "?"), nil)
+	case opts.Credential != nil:
+		client, err = azblob.NewClient(serviceURL, opts.Credential, nil)
+	default:
+		return nil, errors.New("no SAS token or credential given")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backend{
+		client:      client,
+		container:   opts.Container,
+		blockSize:   opts.BlockSize,
+		concurrency: opts.Concurrency,
+		sasToken:    opts.SASToken != "",
+	}, nil
+}
+
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	resp, err := b.client.DownloadStream(ctx, b.container, key, nil)
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+
+	info := objectInfo(key, resp.ContentLength, resp.ETag, resp.ContentType, resp.LastModified)
+	return resp.Body, info, nil
+}
+
+func (b *Backend) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := b.client.DownloadStream(ctx, b.container, key, &azblob.DownloadStreamOptions{
+		Range: azblob.HTTPRange{Offset: offset, Count: length},
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return resp.Body, nil
+}
+
+func (b *Backend) Stat(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	resp, err := b.client.ServiceClient().NewContainerClient(b.container).NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return objectInfo(key, resp.ContentLength, resp.ETag, resp.ContentType, resp.LastModified), nil
+}
+
+func (b *Backend) Put(ctx context.Context, key string, r io.Reader, size int64, opts storage.PutOptions) error {
+	headers := &blob.HTTPHeaders{}
+	if opts.ContentType != "" {
+		headers.BlobContentType = &opts.ContentType
+	}
+	if opts.CacheControl != "" {
+		headers.BlobCacheControl = &opts.CacheControl
+	}
+	if opts.ContentDisposition != "" {
+		headers.BlobContentDisposition = &opts.ContentDisposition
+	}
+
+	var conditions *blob.AccessConditions
+	if opts.IfMatch != "" || opts.IfNoneMatch {
+		conditions = &blob.AccessConditions{ModifiedAccessConditions: &blob.ModifiedAccessConditions{}}
+		if opts.IfMatch != "" {
+			// objectInfo strips the quotes the service sends ETags with
+			etag := azcore.ETag(`"` + opts.IfMatch + `"`)
+			conditions.ModifiedAccessConditions.IfMatch = &etag
+		}
+		if opts.IfNoneMatch {
+			etag := azcore.ETagAny
+			conditions.ModifiedAccessConditions.IfNoneMatch = &etag
+		}
+	}
+
+	_, err := b.client.UploadStream(ctx, b.container, key, r, &azblob.UploadStreamOptions{
+		HTTPHeaders:      headers,
+		BlockSize:        b.blockSize,
+		Concurrency:      b.concurrency,
+		AccessConditions: conditions,
+	})
+	return translateError(err)
+}
+
+// Move copies src to dst on the server, waits for the copy to complete and
+// deletes src.
+func (b *Backend) Move(ctx context.Context, src, dst string) error {
+	container := b.client.ServiceClient().NewContainerClient(b.container)
+	source := container.NewBlobClient(src)
+	target := container.NewBlobClient(dst)
+
+	resp, err := target.StartCopyFromURL(ctx, source.URL(), nil)
+	if err != nil {
+		return translateError(err)
+	}
+
+	status := resp.CopyStatus
+	for status != nil && *status == blob.CopyStatusTypePending {
+		select {
+		case <-ctx.Done():
+			if resp.CopyID != nil {
+				target.AbortCopyFromURL(context.WithoutCancel(ctx), *resp.CopyID, nil)
+			}
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+
+		props, err := target.GetProperties(ctx, nil)
+		if err != nil {
+			return translateError(err)
+		}
+		status = props.CopyStatus
+	}
+	if status != nil && *status != blob.CopyStatusTypeSuccess {
+		return fmt.Errorf("copy of %s to %s ended with status %s", src, dst, *status)
+	}
+
+	return b.Delete(ctx, src)
+}
+
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	_, err := b.client.DeleteBlob(ctx, b.container, key, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil
+	}
+	return translateError(err)
+}
+
+func (b *Backend) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var objects []storage.ObjectInfo
+	pager := b.client.NewListBlobsFlatPager(b.container, &azblob.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil || item.Properties == nil {
+				continue
+			}
+			properties := item.Properties
+			objects = append(objects, *objectInfo(*item.Name, properties.ContentLength, properties.ETag, properties.ContentType, properties.LastModified))
+		}
+	}
+	return objects, nil
+}
+
+// PresignGet signs a user delegation SAS, which needs Azure AD credentials.
+// Backends authenticated with a SAS token cannot sign URLs.
+func (b *Backend) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return b.presign(ctx, key, expiry, sas.BlobPermissions{Read: true})
+}
+
+// PresignPut signs a user delegation SAS like PresignGet. Requests to the URL
+// must set the x-ms-blob-type header to BlockBlob.
+func (b *Backend) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return b.presign(ctx, key, expiry, sas.BlobPermissions{Create: true, Write: true})
+}
+
+func (b *Backend) presign(ctx context.Context, key string, expiry time.Duration, permissions sas.BlobPermissions) (string, error) {
+	if b.sasToken {
+		return "", fmt.Errorf("signing URLs needs Azure AD credentials: %w", errors.ErrUnsupported)
+	}
+
+	start := time.Now().UTC().Add(-5 * time.Minute)
+	end := time.Now().UTC().Add(expiry)
+	credential, err := b.client.ServiceClient().GetUserDelegationCredential(ctx, service.KeyInfo{
+		Start:  to.Ptr(start.Format(sas.TimeFormat)),
+		Expiry: to.Ptr(end.Format(sas.TimeFormat)),
+	}, nil)
+	if err != nil {
+		return "", translateError(err)
+	}
+
+	query, err := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPS,
+		StartTime:     start,
+		ExpiryTime:    end,
+		Permissions:   permissions.String(),
+		ContainerName: b.container,
+		BlobName:      key,
+	}.SignWithUserDelegation(credential)
+	if err != nil {
+		return "", err
+	}
+
+	target := b.client.ServiceClient().NewContainerClient(b.container).NewBlobClient(key)
+	return target.URL() + "?" + query.Encode(), nil
+}
+
+func objectInfo(key string, size *int64, etag *azcore.ETag, contentType *string, lastModified *time.Time) *storage.ObjectInfo {
+	info := &storage.ObjectInfo{Key: key}
+	if size != nil {
+		info.Size = *size
+	}
+	if etag != nil {
+		info.ETag = strings.Trim(string(*etag), `"`)
+	}
+	if contentType != nil {
+		info.ContentType = *contentType
+	}
+	if lastModified != nil {
+		info.LastModified = *lastModified
+	}
+	return info
+}
+
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return fmt.Errorf("%w: %v", storage.ErrNotExist, err)
+	}
+	if bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobAlreadyExists) {
+		return fmt.Errorf("%w: %v", storage.ErrPreconditionFailed, err)
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// DryRun is a Backend that reads from another backend but only records the
+// writes and deletions made to it. Moved and deleted objects are only renamed
+// or dropped in the record.
+type DryRun struct {
+	Backend
+
+	mu      sync.Mutex
+	writes  []Write
+	deletes []string
+}
+
+// Write describes an object a DryRun backend was asked to store.
+type Write struct {
+	Key         string
+	Size        int64
+	ContentType string
+}
+
+// NewDryRun returns a DryRun backend reading from backend.
+func NewDryRun(backend Backend) *DryRun {
+	return &DryRun{Backend: backend}
+}
+
+// Put drains r and records the write without storing anything.
+func (d *DryRun) Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) error {
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.writes = append(d.writes, Write{Key: key, Size: n, ContentType: opts.ContentType})
+	return nil
+}
+
+// Move records the write of src as one of dst.
+func (d *DryRun) Move(_ context.Context, src, dst string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.writes {
+		if d.writes[i].Key == src {
+			d.writes[i].Key = dst
+		}
+	}
+	return nil
+}
+
+// Delete forgets the recorded write of key, or records the deletion of an
+// object of the other backend.
+func (d *DryRun) Delete(_ context.Context, key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	writes := d.writes[:0]
+	for _, write := range d.writes {
+		if write.Key != key {
+			writes = append(writes, write)
+		}
+	}
+	if len(writes) == len(d.writes) {
+		d.deletes = append(d.deletes, key)
+	}
+	d.writes = writes
+	return nil
+}
+
+func (d *DryRun) Unwrap() Backend {
+	return d.Backend
+}
+
+// Writes returns the recorded writes in the order they were made.
+func (d *DryRun) Writes() []Write {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Write(nil), d.writes...)
+}
+
+// Deletes returns the keys of the objects of the other backend that were
+// deleted, in the order they were.
+func (d *DryRun) Deletes() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.deletes...)
+}
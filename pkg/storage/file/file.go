@@ -0,0 +1,250 @@
+// Package file implements storage.Backend on a local directory, using the
+// same layout as the bucket so the tree can be synced to a server as is.
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"update-manifest/pkg/storage"
+)
+
+// Backend stores objects as files below a root directory.
+type Backend struct {
+	root string
+}
+
+// New returns a Backend rooted at dir, creating it if needed.
+func New(dir string) (*Backend, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Backend{root: dir}, nil
+}
+
+func (b *Backend) Get(_ context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	name, err := b.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, objectInfo(key, stat), nil
+}
+
+func (b *Backend) GetRange(_ context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	name, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (b *Backend) Stat(_ context.Context, key string) (*storage.ObjectInfo, error) {
+	name, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(name)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return objectInfo(key, stat), nil
+}
+
+// Put writes the object to a temporary file and renames it into place, so
+// readers never observe partial content.
+func (b *Backend) Put(ctx context.Context, key string, r io.Reader, size int64, opts storage.PutOptions) error {
+	name, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, storage.NewContextReader(ctx, r))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if size >= 0 && n != size {
+		tmp.Close()
+		return fmt.Errorf("short write: wrote %d of %d bytes", n, size)
+	}
+
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return b.replace(key, tmp.Name(), name, opts)
+}
+
+// replace moves the file tmp to name if the conditions of opts hold. Other
+// processes may still replace name between checking and renaming, so the
+// conditions only guard against writes that did not overlap.
+func (b *Backend) replace(key, tmp, name string, opts storage.PutOptions) error {
+	if opts.IfNoneMatch {
+		if err := os.Link(tmp, name); errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%w: %s exists", storage.ErrPreconditionFailed, key)
+		} else if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if opts.IfMatch != "" {
+		stat, err := os.Stat(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %s does not exist", storage.ErrPreconditionFailed, key)
+		}
+		if err != nil {
+			return err
+		}
+		if etag := objectInfo(key, stat).ETag; etag != opts.IfMatch {
+			return fmt.Errorf("%w: %s has ETag %s", storage.ErrPreconditionFailed, key, etag)
+		}
+	}
+
+	return os.Rename(tmp, name)
+}
+
+func (b *Backend) Move(_ context.Context, src, dst string) error {
+	from, err := b.path(src)
+	if err != nil {
+		return err
+	}
+	to, err := b.path(dst)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	return translateError(os.Rename(from, to))
+}
+
+func (b *Backend) Delete(_ context.Context, key string) error {
+	name, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (b *Backend) List(_ context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var objects []storage.ObjectInfo
+	err := filepath.WalkDir(b.root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(b.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			// only descend into directories that can hold matching keys
+			if key != "." && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(path.Base(key), ".tmp-") {
+			return nil
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, *objectInfo(key, stat))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// a directory sorts before files extending its name, unlike its keys
+	slices.SortFunc(objects, func(a, b storage.ObjectInfo) int { return strings.Compare(a.Key, b.Key) })
+	return objects, nil
+}
+
+// path maps key to a file below the root, rejecting keys that escape it.
+func (b *Backend) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "\\") || clean != "/"+key {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(b.root, filepath.FromSlash(clean[1:])), nil
+}
+
+func objectInfo(key string, stat fs.FileInfo) *storage.ObjectInfo {
+	return &storage.ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ETag:         strconv.FormatInt(stat.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(stat.Size(), 16),
+		ContentType:  mime.TypeByExtension(path.Ext(key)),
+		LastModified: stat.ModTime(),
+	}
+}
+
+func translateError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %v", storage.ErrNotExist, err)
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// UploadJournal remembers unfinished multipart uploads, so backends that
+// support it can resume them instead of starting over.
+type UploadJournal interface {
+	// Load returns the record of the unfinished upload to key.
+	Load(key string) (UploadRecord, bool)
+	// Save records an upload to key that was started.
+	Save(key string, record UploadRecord) error
+	// Remove forgets the upload to key.
+	Remove(key string) error
+}
+
+// UploadRecord describes an unfinished multipart upload.
+type UploadRecord struct {
+	UploadID string `json:"upload_id"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"part_size"`
+}
+
+// FileJournal is an UploadJournal stored as a JSON file.
+type FileJournal struct {
+	path string
+
+	mu      sync.Mutex
+	records map[string]UploadRecord
+}
+
+// OpenFileJournal reads the journal at path. A missing file is an empty
+// journal.
+func OpenFileJournal(path string) (*FileJournal, error) {
+	j := &FileJournal{path: path, records: make(map[string]UploadRecord)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload journal: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &j.records); err != nil {
+		return nil, fmt.Errorf("failed to decode upload journal: %w", err)
+	}
+	return j, nil
+}
+
+func (j *FileJournal) Load(key string) (UploadRecord, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	record, ok := j.records[key]
+	return record, ok
+}
+
+func (j *FileJournal) Save(key string, record UploadRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.records[key] = record
+	return j.write()
+}
+
+func (j *FileJournal) Remove(key string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.records[key]; !ok {
+		return nil
+	}
+	delete(j.records, key)
+	return j.write()
+}
+
+// write replaces the journal file with the records.
+func (j *FileJournal) write() error {
+	data, err := json.MarshalIndent(j.records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode upload journal: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o700); err != nil {
+		return fmt.Errorf("failed to write upload journal: %w", err)
+	}
+
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write upload journal: %w", err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return fmt.Errorf("failed to write upload journal: %w", err)
+	}
+	return nil
+}
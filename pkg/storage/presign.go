@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Presigner is implemented by backends that can sign URLs granting temporary
+// access to an object.
+type Presigner interface {
+	// PresignGet returns a URL to download the object stored under key that
+	// is valid for expiry.
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// PresignPut returns a URL to store an object under key with a PUT
+	// request that is valid for expiry.
+	PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// Wrapper is implemented by backends decorating another backend.
+type Wrapper interface {
+	// Unwrap returns the decorated backend.
+	Unwrap() Backend
+}
+
+// PresignGet signs a download URL for key with backend or the first backend
+// it decorates that is a Presigner. It returns errors.ErrUnsupported if there
+// is none.
+func PresignGet(ctx context.Context, backend Backend, key string, expiry time.Duration) (string, error) {
+	presigner, err := findPresigner(backend)
+	if err != nil {
+		return "", err
+	}
+	return presigner.PresignGet(ctx, key, expiry)
+}
+
+// PresignPut signs an upload URL for key like PresignGet signs a download
+// URL.
+func PresignPut(ctx context.Context, backend Backend, key string, expiry time.Duration) (string, error) {
+	presigner, err := findPresigner(backend)
+	if err != nil {
+		return "", err
+	}
+	return presigner.PresignPut(ctx, key, expiry)
+}
+
+func findPresigner(backend Backend) (Presigner, error) {
+	for backend != nil {
+		if presigner, ok := backend.(Presigner); ok {
+			return presigner, nil
+		}
+
+		wrapper, ok := backend.(Wrapper)
+		if !ok {
+			break
+		}
+		backend = wrapper.Unwrap()
+	}
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,162 @@
+// Package presigned implements storage.Backend on URLs presigned for single
+// objects, so a machine without credentials for the bucket can download and
+// upload the objects it was granted. Every other operation is unsupported.
+package presigned
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"update-manifest/pkg/storage"
+)
+
+// Backend downloads and uploads objects through presigned URLs.
+type Backend struct {
+	get, put map[string]string
+	client   *http.Client
+}
+
+// New returns a Backend downloading the objects under the keys of get from
+// their URLs and uploading those under the keys of put to theirs with PUT
+// requests. Requests are sent with client, http.DefaultClient if nil.
+func New(get, put map[string]string, client *http.Client) *Backend {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Backend{get: get, put: put, client: client}
+}
+
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	resp, err := b.download(ctx, key, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &storage.ObjectInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ETag:        strings.Trim(resp.Header.Get("ETag"), `"`),
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = modified
+	}
+	return resp.Body, info, nil
+}
+
+func (b *Backend) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := b.download(ctx, key, fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *Backend) download(ctx context.Context, key, byteRange string) (*http.Response, error) {
+	url, ok := b.get[key]
+	if !ok {
+		return nil, unsupported("download", key)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, storage.ErrNotExist
+	default:
+		return nil, statusError(resp)
+	}
+}
+
+func (b *Backend) Stat(_ context.Context, key string) (*storage.ObjectInfo, error) {
+	return nil, unsupported("stat", key)
+}
+
+func (b *Backend) Put(ctx context.Context, key string, r io.Reader, size int64, opts storage.PutOptions) error {
+	url, ok := b.put[key]
+	if !ok {
+		return unsupported("upload", key)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	for header, value := range map[string]string{
+		"Content-Type":        opts.ContentType,
+		"Cache-Control":       opts.CacheControl,
+		"Content-Disposition": opts.ContentDisposition,
+	} {
+		if value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	if opts.IfMatch != "" {
+		req.Header.Set("If-Match", `"`+opts.IfMatch+`"`)
+	}
+	if opts.IfNoneMatch {
+		req.Header.Set("If-None-Match", "*")
+	}
+	// Azure Blob Storage needs the type of the blob created, S3 ignores it
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		resp.Body.Close()
+		return nil
+	case resp.StatusCode == http.StatusPreconditionFailed,
+		resp.StatusCode == http.StatusConflict && (opts.IfMatch != "" || opts.IfNoneMatch):
+		resp.Body.Close()
+		return storage.ErrPreconditionFailed
+	default:
+		return statusError(resp)
+	}
+}
+
+func (b *Backend) Move(_ context.Context, src, _ string) error {
+	return unsupported("move", src)
+}
+
+func (b *Backend) Delete(_ context.Context, key string) error {
+	return unsupported("delete", key)
+}
+
+func (b *Backend) List(_ context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	return nil, unsupported("list", prefix)
+}
+
+func unsupported(op, key string) error {
+	return fmt.Errorf("no presigned URL to %s %s: %w", op, key, errors.ErrUnsupported)
+}
+
+// statusError describes the unexpected response resp with the start of its
+// body, which holds the reason stores give, e.g. an expired signature, and
+// closes it.
+func statusError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if message := strings.TrimSpace(string(body)); message != "" {
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, message)
+	}
+	return fmt.Errorf("unexpected status %s", resp.Status)
+}
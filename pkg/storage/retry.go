@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy controls how a Retry backend retries failed operations.
+type RetryPolicy struct {
+	// Retries is the number of times an operation is retried after its first
+	// attempt failed.
+	Retries int
+	// BaseDelay is the upper bound of the delay before the first retry. It
+	// doubles with every further retry, up to MaxDelay. The actual delay is
+	// chosen at random below the bound.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// OnRetry, when set, is called before waiting to retry op on key.
+	OnRetry func(op, key string, attempt int, err error)
+}
+
+// Retry is a Backend retrying the failed operations of another backend with
+// exponential backoff. Missing objects and canceled contexts are not retried,
+// and neither are writes whose reader cannot be rewound.
+type Retry struct {
+	backend Backend
+	policy  RetryPolicy
+}
+
+// NewRetry returns backend retrying failed operations according to policy.
+func NewRetry(backend Backend, policy RetryPolicy) *Retry {
+	return &Retry{backend: backend, policy: policy}
+}
+
+func (b *Retry) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	var reader io.ReadCloser
+	var info *ObjectInfo
+	err := b.do(ctx, "get", key, nil, func() (err error) {
+		reader, info, err = b.backend.Get(ctx, key)
+		return err
+	})
+	return reader, info, err
+}
+
+func (b *Retry) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	err := b.do(ctx, "get", key, nil, func() (err error) {
+		reader, err = b.backend.GetRange(ctx, key, offset, length)
+		return err
+	})
+	return reader, err
+}
+
+func (b *Retry) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	var info *ObjectInfo
+	err := b.do(ctx, "stat", key, nil, func() (err error) {
+		info, err = b.backend.Stat(ctx, key)
+		return err
+	})
+	return info, err
+}
+
+func (b *Retry) Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) error {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return b.backend.Put(ctx, key, r, size, opts)
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return b.backend.Put(ctx, key, r, size, opts)
+	}
+
+	rewind := func() error {
+		_, err := seeker.Seek(start, io.SeekStart)
+		return err
+	}
+	return b.do(ctx, "put", key, rewind, func() error {
+		return b.backend.Put(ctx, key, r, size, opts)
+	})
+}
+
+func (b *Retry) Move(ctx context.Context, src, dst string) error {
+	return b.do(ctx, "move", src, nil, func() error {
+		return b.backend.Move(ctx, src, dst)
+	})
+}
+
+func (b *Retry) Delete(ctx context.Context, key string) error {
+	return b.do(ctx, "delete", key, nil, func() error {
+		return b.backend.Delete(ctx, key)
+	})
+}
+
+func (b *Retry) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := b.do(ctx, "list", prefix, nil, func() (err error) {
+		objects, err = b.backend.List(ctx, prefix)
+		return err
+	})
+	return objects, err
+}
+
+func (b *Retry) Unwrap() Backend {
+	return b.backend
+}
+
+// do runs op until it succeeds, fails permanently or runs out of retries.
+// rewind, if set, is called before every retry.
+func (b *Retry) do(ctx context.Context, name, key string, rewind func() error, op func() error) error {
+	err := op()
+	for attempt := 1; attempt <= b.policy.Retries && retryable(err); attempt++ {
+		if b.policy.OnRetry != nil {
+			b.policy.OnRetry(name, key, attempt, err)
+		}
+
+		timer := time.NewTimer(b.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-timer.C:
+		}
+
+		if rewind != nil {
+			if rewindErr := rewind(); rewindErr != nil {
+				return fmt.Errorf("failed to rewind for retry: %w (last error: %v)", rewindErr, err)
+			}
+		}
+		err = op()
+	}
+	return err
+}
+
+// delay returns a random delay below the backoff bound of attempt.
+func (b *Retry) delay(attempt int) time.Duration {
+	bound := b.policy.BaseDelay << (attempt - 1)
+	if bound <= 0 || b.policy.MaxDelay > 0 && bound > b.policy.MaxDelay {
+		bound = b.policy.MaxDelay
+	}
+	if bound <= 0 {
+		return 0
+	}
+	return rand.N(bound)
+}
+
+func retryable(err error) bool {
+	return err != nil &&
+		!errors.Is(err, ErrNotExist) &&
+		!errors.Is(err, ErrPreconditionFailed) &&
+		!errors.Is(err, errors.ErrUnsupported) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
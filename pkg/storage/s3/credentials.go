@@ -0,0 +1,190 @@
+package s3
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"gopkg.in/ini.v1"
+)
+
+// DefaultCredentials returns the credentials of the first source of the
+// standard AWS chain that has some: the environment, i.e. $AWS_ACCESS_KEY_ID,
+// $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN, the profile $AWS_PROFILE of
+// the shared credentials file, including its credential_process, an IAM
+// Identity Center (SSO) session of the profile started with `aws sso login`,
+// and the role of the machine: that of a web identity token file as on EKS,
+// of the ECS task or of the EC2 instance profile. They are anonymous if no
+// source has any.
+func DefaultCredentials() *credentials.Credentials {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&ssoCredentials{client: client},
+		&credentials.IAM{Client: client},
+	})
+}
+
+// ssoCredentials retrieves the credentials of the role of the IAM Identity
+// Center profile $AWS_PROFILE of the AWS config file, $AWS_CONFIG_FILE or
+// ~/.aws/config, with the access token `aws sso login` caches.
+type ssoCredentials struct {
+	credentials.Expiry
+	client *http.Client
+}
+
+// ssoProfile is the part of a profile of the AWS config file naming the
+// role of an IAM Identity Center account.
+type ssoProfile struct {
+	// session is the sso-session section the start URL and region are read
+	// from, if the profile has one.
+	session   string
+	startURL  string
+	region    string
+	accountID string
+	roleName  string
+}
+
+func (s *ssoCredentials) Retrieve() (credentials.Value, error) {
+	profile, err := loadSSOProfile()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	token, err := cachedSSOToken(profile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_SSO")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://portal.sso.%s.amazonaws.com", profile.region)
+	}
+	query := url.Values{"account_id": {profile.accountID}, "role_name": {profile.roleName}}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(endpoint, "/")+"/federation/credentials?"+query.Encode(), nil)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	req.Header.Set("x-amz-sso_bearer_token", token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to fetch SSO role credentials: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return credentials.Value{}, fmt.Errorf("failed to fetch SSO role credentials: unexpected status %s", resp.Status)
+	}
+
+	var body struct {
+		RoleCredentials struct {
+			AccessKeyID     string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			SessionToken    string `json:"sessionToken"`
+			// Expiration is in milliseconds since the epoch.
+			Expiration int64 `json:"expiration"`
+		} `json:"roleCredentials"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to decode SSO role credentials: %w", err)
+	}
+
+	role := body.RoleCredentials
+	expiration := time.UnixMilli(role.Expiration)
+	s.SetExpiration(expiration, credentials.DefaultExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     role.AccessKeyID,
+		SecretAccessKey: role.SecretAccessKey,
+		SessionToken:    role.SessionToken,
+		Expiration:      expiration,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+// loadSSOProfile reads the profile $AWS_PROFILE, or default, of the AWS
+// config file.
+func loadSSOProfile() (*ssoProfile, error) {
+	path := os.Getenv("AWS_CONFIG_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".aws", "config")
+	}
+	config, err := ini.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name := os.Getenv("AWS_PROFILE")
+	if name == "" {
+		name = "default"
+	}
+	section := "profile " + name
+	if name == "default" {
+		section = name
+	}
+	values, err := config.GetSection(section)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &ssoProfile{
+		session:   values.Key("sso_session").String(),
+		startURL:  values.Key("sso_start_url").String(),
+		region:    values.Key("sso_region").String(),
+		accountID: values.Key("sso_account_id").String(),
+		roleName:  values.Key("sso_role_name").String(),
+	}
+	if profile.session != "" {
+		session, err := config.GetSection("sso-session " + profile.session)
+		if err != nil {
+			return nil, err
+		}
+		profile.startURL = session.Key("sso_start_url").String()
+		profile.region = session.Key("sso_region").String()
+	}
+	if profile.startURL == "" || profile.region == "" || profile.accountID == "" || profile.roleName == "" {
+		return nil, fmt.Errorf("AWS profile %s is not an SSO profile", name)
+	}
+	return profile, nil
+}
+
+// cachedSSOToken returns the access token of profile cached by
+// `aws sso login`, named by the SHA-1 digest of its session or start URL.
+func cachedSSOToken(profile *ssoProfile) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	name := profile.session
+	if name == "" {
+		name = profile.startURL
+	}
+	digest := sha1.Sum([]byte(name))
+	data, err := os.ReadFile(filepath.Join(home, ".aws", "sso", "cache", hex.EncodeToString(digest[:])+".json"))
+	if err != nil {
+		return "", fmt.Errorf("no SSO session, run aws sso login: %w", err)
+	}
+
+	var cached struct {
+		AccessToken string    `json:"accessToken"`
+		ExpiresAt   time.Time `json:"expiresAt"`
+	}
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return "", fmt.Errorf("failed to decode cached SSO token: %w", err)
+	}
+	if cached.AccessToken == "" || time.Now().After(cached.ExpiresAt) {
+		return "", errors.New("SSO session expired, run aws sso login")
+	}
+	return cached.AccessToken, nil
+}
@@ -0,0 +1,113 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+
+	"update-manifest/pkg/storage"
+)
+
+const (
+	// defaultPartSize is the part size of resumable uploads when none is set.
+	defaultPartSize = 64 << 20
+	minPartSize     = 5 << 20
+	maxParts        = 10000
+)
+
+// resumable reports whether an upload of size bytes goes through the journal.
+func (b *Backend) resumable(size int64) bool {
+	return b.journal != nil && !b.upload.DisableMultipart && size > b.partSize(size)
+}
+
+// partSize returns the part size of a resumable upload of size bytes.
+func (b *Backend) partSize(size int64) int64 {
+	partSize := int64(b.upload.PartSize)
+	if partSize == 0 {
+		partSize = defaultPartSize
+	}
+	partSize = max(partSize, minPartSize, (size+maxParts-1)/maxParts)
+	return partSize
+}
+
+// putResumable uploads r in parts, recording the upload in the journal so a
+// later call for the same key skips the parts already stored. An interrupted
+// upload is kept, not aborted, for that reason.
+func (b *Backend) putResumable(ctx context.Context, key string, r io.ReadSeeker, size int64, opts storage.PutOptions) error {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	journalKey := b.bucket + "/" + key
+	partSize := b.partSize(size)
+
+	var uploadID string
+	uploaded := make(map[int]minio.ObjectPart)
+	if record, ok := b.journal.Load(journalKey); ok && record.Size == size && record.PartSize == partSize {
+		parts, err := b.listParts(ctx, key, record.UploadID)
+		if err == nil {
+			uploadID = record.UploadID
+			for _, part := range parts {
+				uploaded[part.PartNumber] = part
+			}
+		}
+	}
+
+	if uploadID == "" {
+		if uploadID, err = b.core.NewMultipartUpload(ctx, b.bucket, key, minio.PutObjectOptions{
+			ContentType:          opts.ContentType,
+			CacheControl:         opts.CacheControl,
+			ContentDisposition:   opts.ContentDisposition,
+			ServerSideEncryption: b.sse,
+		}); err != nil {
+			return translateError(err)
+		}
+		if err := b.journal.Save(journalKey, storage.UploadRecord{UploadID: uploadID, Size: size, PartSize: partSize}); err != nil {
+			return err
+		}
+	}
+
+	var complete []minio.CompletePart
+	for number, offset := 1, int64(0); offset < size; number, offset = number+1, offset+partSize {
+		length := min(partSize, size-offset)
+
+		if part, ok := uploaded[number]; ok && part.Size == length {
+			complete = append(complete, minio.CompletePart{PartNumber: number, ETag: part.ETag})
+			continue
+		}
+
+		if _, err := r.Seek(start+offset, io.SeekStart); err != nil {
+			return err
+		}
+		part, err := b.core.PutObjectPart(ctx, b.bucket, key, uploadID, number, io.LimitReader(r, length), length, minio.PutObjectPartOptions{SSE: b.customerKey()})
+		if err != nil {
+			return fmt.Errorf("failed to upload part %d: %w", number, translateError(err))
+		}
+		complete = append(complete, minio.CompletePart{PartNumber: number, ETag: part.ETag})
+	}
+
+	if _, err := b.core.CompleteMultipartUpload(ctx, b.bucket, key, uploadID, complete, minio.PutObjectOptions{ServerSideEncryption: b.customerKey()}); err != nil {
+		return translateError(err)
+	}
+	return b.journal.Remove(journalKey)
+}
+
+// listParts returns every part stored by the multipart upload uploadID.
+func (b *Backend) listParts(ctx context.Context, key, uploadID string) ([]minio.ObjectPart, error) {
+	var parts []minio.ObjectPart
+	marker := 0
+	for {
+		result, err := b.core.ListObjectParts(ctx, b.bucket, key, uploadID, marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, result.ObjectParts...)
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
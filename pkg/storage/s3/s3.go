@@ -0,0 +1,340 @@
+// Package s3 implements storage.Backend on top of S3-compatible object stores.
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+
+	"update-manifest/pkg/storage"
+)
+
+// Backend stores objects in a single bucket.
+type Backend struct {
+	core    *minio.Core
+	bucket  string
+	upload  minio.PutObjectOptions
+	sse     encrypt.ServerSide
+	journal storage.UploadJournal
+}
+
+// Options configures a connection to an S3-compatible endpoint.
+type Options struct {
+	// Endpoint is a host[:port] or an http(s) URL. Without a scheme, https
+	// is used.
+	Endpoint     string
+	AccessKey    string
+	AccessSecret string
+	// Credentials, when set, are used instead of AccessKey and AccessSecret,
+	// e.g. temporary ones obtained from an STS and renewed as they expire.
+	Credentials *credentials.Credentials
+	Region      string
+	Bucket      string
+	// PathStyle addresses the bucket as a path component instead of a
+	// subdomain, as required by most self-hosted MinIO deployments.
+	PathStyle bool
+	// Encryption, when set, has the store encrypt uploaded objects at rest
+	// with a key it manages (SSE-S3), a KMS key (SSE-KMS) or a key of the
+	// client (SSE-C), which every request for the objects then carries and
+	// which rules out presigned URLs.
+	Encryption encrypt.ServerSide
+
+	// PartSize is the size of the parts of multipart uploads, chosen from
+	// the object size when 0.
+	PartSize uint64
+	// Concurrency is the number of parts uploaded in parallel. Every part
+	// in flight is buffered in memory.
+	Concurrency uint
+	// DisableMultipart uploads every object in a single request, which
+	// limits objects to 5 GiB.
+	DisableMultipart bool
+	// Journal, when set, records multipart uploads so that an interrupted
+	// upload of a seekable reader to the same key resumes where it stopped.
+	Journal storage.UploadJournal
+}
+
+// New connects to the bucket described by opts.
+func New(opts Options) (*Backend, error) {
+	host, secure, err := parseEndpoint(opts.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := minio.BucketLookupAuto
+	if opts.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	creds := opts.Credentials
+	if creds == nil {
+		creds = credentials.NewStaticV4(opts.AccessKey, opts.AccessSecret, "")
+	}
+	transport, err := minio.DefaultTransport(secure)
+	if err != nil {
+		return nil, err
+	}
+	core, err := minio.NewCore(host, &minio.Options{
+		Secure:       secure,
+		Creds:        creds,
+		Region:       opts.Region,
+		BucketLookup: lookup,
+		Transport:    &conditionalTransport{base: transport},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backend{
+		core:   core,
+		bucket: opts.Bucket,
+		upload: minio.PutObjectOptions{
+			PartSize:              opts.PartSize,
+			NumThreads:            opts.Concurrency,
+			ConcurrentStreamParts: opts.Concurrency > 1,
+			DisableMultipart:      opts.DisableMultipart,
+			ServerSideEncryption:  opts.Encryption,
+		},
+		sse:     opts.Encryption,
+		journal: opts.Journal,
+	}, nil
+}
+
+// NewR2 connects to a Cloudflare R2 bucket.
+func NewR2(accountID, accessKey, accessSecret, bucket string) (*Backend, error) {
+	return New(Options{
+		Endpoint:     fmt.Sprintf("%s.r2.cloudflarestorage.com", accountID),
+		AccessKey:    accessKey,
+		AccessSecret: accessSecret,
+		Region:       "auto",
+		Bucket:       bucket,
+	})
+}
+
+func parseEndpoint(endpoint string) (host string, secure bool, err error) {
+	if !strings.Contains(endpoint, "://") {
+		return endpoint, true, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	switch u.Scheme {
+	case "https":
+		return u.Host, true, nil
+	case "http":
+		return u.Host, false, nil
+	}
+	return "", false, fmt.Errorf("invalid endpoint: unsupported scheme %q", u.Scheme)
+}
+
+// customerKey returns the SSE-C key objects are encrypted with, which
+// requests reading them must carry, or nil.
+func (b *Backend) customerKey() encrypt.ServerSide {
+	if b.sse != nil && b.sse.Type() == encrypt.SSEC {
+		return b.sse
+	}
+	return nil
+}
+
+func (b *Backend) Get(ctx context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	reader, info, _, err := b.core.GetObject(ctx, b.bucket, key, minio.GetObjectOptions{ServerSideEncryption: b.customerKey()})
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+
+	return reader, objectInfo(info), nil
+}
+
+func (b *Backend) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{ServerSideEncryption: b.customerKey()}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+
+	reader, _, _, err := b.core.GetObject(ctx, b.bucket, key, opts)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return reader, nil
+}
+
+func (b *Backend) Stat(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	info, err := b.core.Client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{ServerSideEncryption: b.customerKey()})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return objectInfo(info), nil
+}
+
+func (b *Backend) Put(ctx context.Context, key string, r io.Reader, size int64, opts storage.PutOptions) error {
+	conditional := opts.IfMatch != "" || opts.IfNoneMatch
+	if seeker, ok := r.(io.ReadSeeker); ok && !conditional && b.resumable(size) {
+		return b.putResumable(ctx, key, seeker, size, opts)
+	}
+
+	upload := b.upload
+	upload.ContentType = opts.ContentType
+	upload.CacheControl = opts.CacheControl
+	upload.ContentDisposition = opts.ContentDisposition
+	if opts.IfMatch != "" {
+		upload.SetMatchETag(opts.IfMatch)
+	}
+	if opts.IfNoneMatch {
+		// minio-go quotes the ETag it sends in If-None-Match, which S3 does
+		// not take for the wildcard, so conditionalTransport sets the header,
+		// on a single request that the bucket refuses if the object exists
+		ctx = context.WithValue(ctx, ifNoneMatchKey{}, true)
+		upload.DisableMultipart = true
+	}
+
+	_, err := b.core.Client.PutObject(ctx, b.bucket, key, r, size, upload)
+	if err != nil && ctx.Err() != nil {
+		b.abort(ctx, key)
+	}
+	return translateError(err)
+}
+
+// ifNoneMatchKey marks the context of an upload that must only create the
+// object.
+type ifNoneMatchKey struct{}
+
+// conditionalTransport sends If-None-Match: * with the uploads whose context
+// carries ifNoneMatchKey. S3 and R2 then refuse to replace an existing
+// object, atomically, with 412 Precondition Failed.
+type conditionalTransport struct {
+	base http.RoundTripper
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPut && req.Context().Value(ifNoneMatchKey{}) != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", "*")
+	}
+	return t.base.RoundTrip(req)
+}
+
+// abort removes the parts of an interrupted multipart upload of key. minio-go
+// tries the same with the canceled context of the upload, which cannot work.
+func (b *Backend) abort(ctx context.Context, key string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	b.core.Client.RemoveIncompleteUpload(ctx, b.bucket, key)
+}
+
+// maxCopySize is the largest object copied in a single request.
+const maxCopySize = 5 << 30
+
+// Move copies src to dst on the server, in parts for objects over 5 GiB, and
+// removes src. The copy is encrypted like uploads.
+func (b *Backend) Move(ctx context.Context, src, dst string) error {
+	info, err := b.core.Client.StatObject(ctx, b.bucket, src, minio.StatObjectOptions{ServerSideEncryption: b.customerKey()})
+	if err != nil {
+		return translateError(err)
+	}
+
+	// multipart copies do not carry the content headers over by themselves
+	metadata := map[string]string{"Content-Type": info.ContentType}
+	for _, header := range []string{"Cache-Control", "Content-Disposition"} {
+		if value := info.Metadata.Get(header); value != "" {
+			metadata[header] = value
+		}
+	}
+	dstOpts := minio.CopyDestOptions{
+		Bucket:          b.bucket,
+		Object:          dst,
+		UserMetadata:    metadata,
+		ReplaceMetadata: true,
+		Encryption:      b.sse,
+	}
+	srcOpts := minio.CopySrcOptions{
+		Bucket:     b.bucket,
+		Object:     src,
+		Encryption: b.customerKey(),
+	}
+
+	if info.Size <= maxCopySize {
+		_, err = b.core.Client.CopyObject(ctx, dstOpts, srcOpts)
+	} else {
+		_, err = b.core.Client.ComposeObject(ctx, dstOpts, srcOpts)
+	}
+	if err != nil {
+		return translateError(err)
+	}
+
+	return b.Delete(ctx, src)
+}
+
+func (b *Backend) Delete(ctx context.Context, key string) error {
+	return translateError(b.core.Client.RemoveObject(ctx, b.bucket, key, minio.RemoveObjectOptions{}))
+}
+
+func (b *Backend) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var objects []storage.ObjectInfo
+	for info := range b.core.Client.ListObjects(ctx, b.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, translateError(info.Err)
+		}
+		objects = append(objects, *objectInfo(info))
+	}
+	return objects, nil
+}
+
+func (b *Backend) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if b.customerKey() != nil {
+		return "", fmt.Errorf("objects encrypted with a customer key cannot be downloaded through presigned URLs: %w", errors.ErrUnsupported)
+	}
+	u, err := b.core.Client.PresignedGetObject(ctx, b.bucket, key, expiry, nil)
+	if err != nil {
+		return "", translateError(err)
+	}
+	return u.String(), nil
+}
+
+// PresignPut fails if uploads are encrypted, as the URL would upload objects
+// encrypted by the default of the bucket instead. Default encryption of the
+// bucket covers uploads through presigned URLs.
+func (b *Backend) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if b.sse != nil {
+		return "", fmt.Errorf("uploads encrypted with %s cannot be presigned, set it as the default encryption of the bucket instead: %w", b.sse.Type(), errors.ErrUnsupported)
+	}
+	u, err := b.core.Client.PresignedPutObject(ctx, b.bucket, key, expiry)
+	if err != nil {
+		return "", translateError(err)
+	}
+	return u.String(), nil
+}
+
+func objectInfo(info minio.ObjectInfo) *storage.ObjectInfo {
+	return &storage.ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}
+}
+
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NotFound":
+		return fmt.Errorf("%w: %v", storage.ErrNotExist, err)
+	case "PreconditionFailed":
+		return fmt.Errorf("%w: %v", storage.ErrPreconditionFailed, err)
+	}
+	return err
+}
@@ -0,0 +1,278 @@
+// Package sftp implements storage.Backend on a remote directory reached over
+// SSH.
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"update-manifest/pkg/storage"
+)
+
+// Backend stores objects as files below a remote root directory.
+type Backend struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+	root string
+}
+
+// Options configures an SSH connection.
+type Options struct {
+	// Addr is the host:port of the SSH server.
+	Addr string
+	User string
+	// Auth lists the accepted authentication methods, e.g. ssh.PublicKeys.
+	Auth            []ssh.AuthMethod
+	HostKeyCallback ssh.HostKeyCallback
+	// Root is the remote directory holding the bucket layout.
+	Root string
+}
+
+// New connects to the server described by opts.
+func New(opts Options) (*Backend, error) {
+	conn, err := ssh.Dial("tcp", opts.Addr, &ssh.ClientConfig{
+		User:            opts.User,
+		Auth:            opts.Auth,
+		HostKeyCallback: opts.HostKeyCallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	root := opts.Root
+	if root == "" {
+		root = "."
+	}
+	return &Backend{ssh: conn, sftp: client, root: root}, nil
+}
+
+// Close terminates the connection.
+func (b *Backend) Close() error {
+	return errors.Join(b.sftp.Close(), b.ssh.Close())
+}
+
+func (b *Backend) Get(_ context.Context, key string) (io.ReadCloser, *storage.ObjectInfo, error) {
+	name, err := b.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := b.sftp.Open(name)
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, translateError(err)
+	}
+
+	return f, objectInfo(key, stat), nil
+}
+
+func (b *Backend) GetRange(_ context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	name, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := b.sftp.Open(name)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (b *Backend) Stat(_ context.Context, key string) (*storage.ObjectInfo, error) {
+	name, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := b.sftp.Stat(name)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return objectInfo(key, stat), nil
+}
+
+// Put uploads the object to a temporary file and renames it into place with
+// the posix-rename extension, so clients never fetch a partial manifest.
+func (b *Backend) Put(ctx context.Context, key string, r io.Reader, size int64, opts storage.PutOptions) error {
+	name, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := b.sftp.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+
+	tmp := path.Join(path.Dir(name), ".tmp-"+path.Base(name))
+	f, err := b.sftp.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	n, err := f.ReadFrom(storage.NewContextReader(ctx, r))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("short write: wrote %d of %d bytes", n, size)
+	}
+	if err != nil {
+		b.sftp.Remove(tmp)
+		return err
+	}
+
+	if err := b.checkPrecondition(key, name, opts); err != nil {
+		b.sftp.Remove(tmp)
+		return err
+	}
+	if err := b.sftp.PosixRename(tmp, name); err != nil {
+		b.sftp.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// checkPrecondition checks the conditions of opts against the file name.
+// Another client may still replace it before the upload is renamed into
+// place, and its ETag only changes when the modification time does by a
+// second or the size changes, so the conditions only guard against writes
+// that are far enough apart.
+func (b *Backend) checkPrecondition(key, name string, opts storage.PutOptions) error {
+	if !opts.IfNoneMatch && opts.IfMatch == "" {
+		return nil
+	}
+
+	stat, err := b.sftp.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		if opts.IfMatch != "" {
+			return fmt.Errorf("%w: %s does not exist", storage.ErrPreconditionFailed, key)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.IfNoneMatch {
+		return fmt.Errorf("%w: %s exists", storage.ErrPreconditionFailed, key)
+	}
+	if etag := objectInfo(key, stat).ETag; etag != opts.IfMatch {
+		return fmt.Errorf("%w: %s has ETag %s", storage.ErrPreconditionFailed, key, etag)
+	}
+	return nil
+}
+
+func (b *Backend) Move(_ context.Context, src, dst string) error {
+	from, err := b.path(src)
+	if err != nil {
+		return err
+	}
+	to, err := b.path(dst)
+	if err != nil {
+		return err
+	}
+
+	if err := b.sftp.MkdirAll(path.Dir(to)); err != nil {
+		return err
+	}
+	return translateError(b.sftp.PosixRename(from, to))
+}
+
+func (b *Backend) Delete(_ context.Context, key string) error {
+	name, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := b.sftp.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (b *Backend) List(_ context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var objects []storage.ObjectInfo
+	walker := b.sftp.Walk(b.root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) && walker.Path() == b.root {
+				break
+			}
+			return nil, err
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), b.root), "/")
+		stat := walker.Stat()
+		if stat.IsDir() {
+			// only descend into directories that can hold matching keys
+			if key != "" && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(path.Base(key), ".tmp-") {
+			continue
+		}
+		objects = append(objects, *objectInfo(key, stat))
+	}
+
+	// a directory sorts before files extending its name, unlike its keys
+	slices.SortFunc(objects, func(a, b storage.ObjectInfo) int { return strings.Compare(a.Key, b.Key) })
+	return objects, nil
+}
+
+// path maps key below the root, rejecting keys that escape it.
+func (b *Backend) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "\\") || clean != "/"+key {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return path.Join(b.root, clean[1:]), nil
+}
+
+func objectInfo(key string, stat fs.FileInfo) *storage.ObjectInfo {
+	return &storage.ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ETag:         strconv.FormatInt(stat.ModTime().Unix(), 16) + "-" + strconv.FormatInt(stat.Size(), 16),
+		LastModified: stat.ModTime(),
+	}
+}
+
+func translateError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %v", storage.ErrNotExist, err)
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+// Package storage defines the object store abstraction used to publish
+// manifests and artifacts.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotExist is returned when the requested object does not exist.
+var ErrNotExist = errors.New("object does not exist")
+
+// ErrPreconditionFailed is returned by Put when the conditions of its
+// PutOptions do not hold.
+var ErrPreconditionFailed = errors.New("object was modified")
+
+// Backend is an object store holding manifests and artifacts.
+type Backend interface {
+	// Get opens the object stored under key. The caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// GetRange opens length bytes of the object starting at offset.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat returns the metadata of the object stored under key.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Put stores size bytes read from r under key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) error
+	// Move renames the object stored under src to dst, replacing dst.
+	Move(ctx context.Context, src, dst string) error
+	// Delete removes the object stored under key. Deleting an object that
+	// does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose keys start with prefix, sorted by key.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+}
+
+// PutOptions controls how an object is stored.
+type PutOptions struct {
+	ContentType string
+	// CacheControl and ContentDisposition are the headers of the same names
+	// the object is served with, by the backends that store them.
+	CacheControl       string
+	ContentDisposition string
+	// IfMatch, when set, only stores the object if the stored object under
+	// key has this ETag.
+	IfMatch string
+	// IfNoneMatch only stores the object if there is none under key yet.
+	IfNoneMatch bool
+}
@@ -0,0 +1,241 @@
+// Package telemetry records the outcome of the updates clients install, as
+// they report them to the serve command, so the health of a rollout can be
+// measured.
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"update-manifest/pkg/storage"
+)
+
+// Report is the outcome of an update installed by a client.
+type Report struct {
+	AppID   string `json:"app_id"`
+	Channel string `json:"channel,omitempty"`
+	// Version is the version updated to, and FromVersion the one updated
+	// from.
+	Version     string `json:"version"`
+	FromVersion string `json:"from_version,omitempty"`
+	Platform    string `json:"platform"`
+	Success     bool   `json:"success"`
+	// Error describes why the update failed.
+	Error    string `json:"error,omitempty"`
+	DeviceID string `json:"device_id,omitempty"`
+	// Time is when the report was received.
+	Time time.Time `json:"time"`
+}
+
+// Health summarizes the reports of the updates to a release.
+type Health struct {
+	// Devices is the number of devices that reported, and Failures the
+	// number of those whose last update failed. Reports without a device ID
+	// are counted as devices of their own.
+	Devices  int
+	Failures int
+}
+
+// FailureRate returns the share of the devices whose update failed, 0 when
+// none reported.
+func (h Health) FailureRate() float64 {
+	if h.Devices == 0 {
+		return 0
+	}
+	return float64(h.Failures) / float64(h.Devices)
+}
+
+// Summarize returns the health of the updates to version in channel among
+// reports, oldest first. Reports without a channel count towards every
+// channel. A device retrying a failed update counts once, by the outcome of
+// its last attempt.
+func Summarize(reports []Report, channel, version string) Health {
+	var health Health
+	failed := make(map[string]bool)
+	for _, report := range reports {
+		if report.Version != version || (report.Channel != "" && report.Channel != channel) {
+			continue
+		}
+		if report.DeviceID == "" {
+			health.Devices++
+			if !report.Success {
+				health.Failures++
+			}
+			continue
+		}
+		failed[report.DeviceID] = !report.Success
+	}
+	for _, failure := range failed {
+		health.Devices++
+		if failure {
+			health.Failures++
+		}
+	}
+	return health
+}
+
+// ErrBusy is returned by Record when too many reports wait to be written.
+var ErrBusy = errors.New("too many reports waiting to be written")
+
+// maxPending is the most reports a Recorder holds before refusing more.
+const maxPending = 10000
+
+// Prefix returns the prefix of the keys of the report batches of appID.
+func Prefix(appID string) string {
+	return appID + "/telemetry/"
+}
+
+// batchKey returns the key of a batch of reports of appID written at t,
+// named so batches sort by the time they were written.
+func batchKey(appID string, t time.Time) (string, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s-%s.jsonl", Prefix(appID), t.UTC().Format("20060102T150405Z"), hex.EncodeToString(id)), nil
+}
+
+// Recorder collects reports and writes them to a backend in batches, as
+// objects of one report per line under Prefix, since objects cannot be
+// appended to.
+type Recorder struct {
+	backend storage.Backend
+
+	mu      sync.Mutex
+	pending map[string][]Report
+	count   int
+}
+
+// NewRecorder returns a Recorder writing to backend.
+func NewRecorder(backend storage.Backend) *Recorder {
+	return &Recorder{backend: backend, pending: make(map[string][]Report)}
+}
+
+// Record adds report to the next batch of its app.
+func (r *Recorder) Record(report Report) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.count >= maxPending {
+		return ErrBusy
+	}
+	r.pending[report.AppID] = append(r.pending[report.AppID], report)
+	r.count++
+	return nil
+}
+
+// Flush writes the reports recorded since the last flush. Those of an app
+// whose batch cannot be written are kept for the next one.
+func (r *Recorder) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending, r.count = make(map[string][]Report), 0
+	r.mu.Unlock()
+
+	var errs []error
+	for appID, reports := range pending {
+		if err := r.write(ctx, appID, reports); err != nil {
+			errs = append(errs, err)
+			r.mu.Lock()
+			r.pending[appID] = append(reports, r.pending[appID]...)
+			r.count += len(reports)
+			r.mu.Unlock()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run flushes the recorded reports every interval until ctx is done.
+func (r *Recorder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				slog.Error("failed to write telemetry", "err", err)
+			}
+		}
+	}
+}
+
+func (r *Recorder) write(ctx context.Context, appID string, reports []Report) error {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for _, report := range reports {
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	}
+	key, err := batchKey(appID, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := r.backend.Put(ctx, key, &data, int64(data.Len()), storage.PutOptions{ContentType: "application/x-ndjson"}); err != nil {
+		return fmt.Errorf("failed to upload telemetry of %s: %w", appID, err)
+	}
+	return nil
+}
+
+// Load returns the reports of appID received since since, oldest first.
+func Load(ctx context.Context, backend storage.Backend, appID string, since time.Time) ([]Report, error) {
+	objects, err := backend.List(ctx, Prefix(appID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list telemetry: %w", err)
+	}
+	// a batch holds the reports received before it was written
+	first := Prefix(appID) + since.UTC().Format("20060102T150405Z")
+
+	var reports []Report
+	for _, object := range objects {
+		if object.Key < first || !strings.HasSuffix(object.Key, ".jsonl") {
+			continue
+		}
+		batch, err := readBatch(ctx, backend, object.Key)
+		if err != nil {
+			return nil, err
+		}
+		for _, report := range batch {
+			if !report.Time.Before(since) {
+				reports = append(reports, report)
+			}
+		}
+	}
+	return reports, nil
+}
+
+func readBatch(ctx context.Context, backend storage.Backend, key string) ([]Report, error) {
+	reader, _, err := backend.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch telemetry %s: %w", key, err)
+	}
+	defer reader.Close()
+
+	var reports []Report
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var report Report
+		if err := json.Unmarshal(scanner.Bytes(), &report); err != nil {
+			return nil, fmt.Errorf("failed to decode telemetry %s: %w", key, err)
+		}
+		reports = append(reports, report)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch telemetry %s: %w", key, err)
+	}
+	return reports, nil
+}
@@ -0,0 +1,202 @@
+package updater
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"update-manifest/pkg/client"
+	"update-manifest/pkg/manifest"
+)
+
+// Extract downloads the archive of update and extracts the application
+// bundle it holds into dir, or into the directory inside dir named by the
+// root of the archive if it has one, which is created if needed. The archive
+// and every file extracted from it are checked against the checksums
+// recorded in the manifest, and an archive holding files the manifest does
+// not list is rejected. Extract into a new directory and swap it with the
+// installed bundle afterwards, as a failed extraction leaves dir partially
+// written.
+func (u *Updater) Extract(ctx context.Context, update *client.Update, dir string) error {
+	archive := update.Archive
+	if archive == nil {
+		return errors.New("update is not an archive")
+	}
+	if _, err := manifest.NewHash(update.ChecksumAlgo); err != nil {
+		return err
+	}
+	if err := u.verifySignature(ctx, update); err != nil {
+		return err
+	}
+
+	downloaded, err := os.CreateTemp("", ".update-*")
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+	defer os.Remove(downloaded.Name())
+	defer downloaded.Close()
+
+	if err := u.downloadArtifact(ctx, update, downloaded); err != nil {
+		return err
+	}
+	size, err := downloaded.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if archive.Root != "" {
+		if !manifest.ValidArchivePath(archive.Root) || strings.Contains(archive.Root, "/") {
+			return fmt.Errorf("archive root %q is not the name of a directory", archive.Root)
+		}
+		dir = filepath.Join(dir, archive.Root)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	x := &extraction{archive: archive, algo: update.ChecksumAlgo, dir: dir, extracted: make(map[string]bool)}
+	switch archive.Format {
+	case manifest.ArchiveTarGz:
+		err = x.tarGz(io.NewSectionReader(downloaded, 0, size))
+	case manifest.ArchiveZip:
+		err = x.zip(downloaded, size)
+	default:
+		err = fmt.Errorf("unknown archive format %q", archive.Format)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, file := range archive.Files {
+		if !x.extracted[file.Path] {
+			return fmt.Errorf("archive lacks %s", file.Path)
+		}
+	}
+	return nil
+}
+
+// extraction writes the files of an archive into dir.
+type extraction struct {
+	archive   *manifest.Archive
+	algo      string
+	dir       string
+	extracted map[string]bool
+}
+
+func (x *extraction) tarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if err := x.entry(header.Name, header.FileInfo().Mode(), header.Linkname, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extraction) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	for _, entry := range zr.File {
+		content, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", entry.Name, err)
+		}
+
+		var link string
+		if entry.Mode()&fs.ModeSymlink != 0 {
+			target, err := io.ReadAll(io.LimitReader(content, 4096))
+			if err != nil {
+				content.Close()
+				return fmt.Errorf("failed to read %s: %w", entry.Name, err)
+			}
+			link = string(target)
+		}
+
+		err = x.entry(entry.Name, entry.Mode(), link, content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry extracts the archive entry name, checking it against the file the
+// manifest lists under its path.
+func (x *extraction) entry(name string, mode fs.FileMode, link string, content io.Reader) error {
+	name = strings.TrimPrefix(name, "./")
+	if mode.IsDir() {
+		return nil
+	}
+
+	file := x.archive.Find(name)
+	if file == nil || !manifest.ValidArchivePath(name) {
+		return fmt.Errorf("archive holds %s, which the manifest does not list", name)
+	}
+	if x.extracted[name] {
+		return fmt.Errorf("archive holds %s twice", name)
+	}
+	x.extracted[name] = true
+
+	target := filepath.Join(x.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %w", name, err)
+	}
+
+	if file.Link != "" {
+		if mode&fs.ModeSymlink == 0 || link != file.Link {
+			return fmt.Errorf("%w: %s is not a link to %s", ErrChecksumMismatch, name, file.Link)
+		}
+		os.Remove(target)
+		if err := os.Symlink(filepath.FromSlash(file.Link), target); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+		return nil
+	}
+	if !mode.IsRegular() {
+		return fmt.Errorf("%w: %s is not a regular file", ErrChecksumMismatch, name)
+	}
+
+	w, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode.Perm())
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	defer w.Close()
+
+	hasher := newHasher(x.algo)
+	if _, err := io.Copy(io.MultiWriter(w, hasher), content); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if err := verify(hasher, file.Checksum); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	// the mode given to OpenFile is masked by the umask and ignored for
+	// existing files
+	return os.Chmod(target, file.Mode.Perm())
+}
@@ -0,0 +1,110 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"update-manifest/pkg/chunk"
+	"update-manifest/pkg/client"
+	"update-manifest/pkg/manifest"
+)
+
+// span locates a chunk in a file.
+type span struct {
+	offset, size int64
+}
+
+// stageChunks assembles the artifact of update next to executable from the
+// chunks executable shares with it, downloading only the others. It returns
+// an empty path if the artifact was not published as chunks the updater can
+// split the executable into.
+func (u *Updater) stageChunks(ctx context.Context, executable string, update *client.Update) (string, error) {
+	if update.ChunkIndexURL == "" || update.Chunks == nil || update.Chunks.Format != chunk.Format {
+		return "", nil
+	}
+
+	var data bytes.Buffer
+	if err := u.Download(ctx, update.ChunkIndexURL, update.ChecksumAlgo, update.Chunks.Checksum, &data); err != nil {
+		return "", err
+	}
+	index, err := manifest.ReadChunkIndex(&data)
+	if err != nil {
+		return "", err
+	}
+
+	current, err := os.Open(executable)
+	if err != nil {
+		return "", err
+	}
+	defer current.Close()
+
+	// the chunks of the running executable by checksum
+	local := make(map[string]span)
+	var offset int64
+	if err := chunk.Split(current, func(data []byte) error {
+		hasher := newHasher(update.ChecksumAlgo)
+		hasher.Write(data)
+		checksum := hex.EncodeToString(hasher.Sum(nil))
+		if _, ok := local[checksum]; !ok {
+			local[checksum] = span{offset, int64(len(data))}
+		}
+		offset += int64(len(data))
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("failed to read executable: %w", err)
+	}
+
+	staged, err := os.CreateTemp(filepath.Dir(executable), ".update-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging file: %w", err)
+	}
+	defer staged.Close()
+
+	if err := u.assembleChunks(ctx, update, index, current, local, staged); err != nil {
+		os.Remove(staged.Name())
+		return "", err
+	}
+	if err := staged.Close(); err != nil {
+		os.Remove(staged.Name())
+		return "", err
+	}
+	return staged.Name(), nil
+}
+
+// assembleChunks writes the chunks of index to staged, copying those found in
+// local from current and those written before from staged, and verifies the
+// assembled artifact.
+func (u *Updater) assembleChunks(ctx context.Context, update *client.Update, index *manifest.ChunkIndex, current *os.File, local map[string]span, staged *os.File) error {
+	hasher := newHasher(update.ChecksumAlgo)
+	w := io.MultiWriter(staged, hasher)
+	written := make(map[string]span)
+	var offset int64
+	for _, c := range index.Chunks {
+		// a chunk repeated in the artifact is downloaded once
+		var from io.ReaderAt
+		s, ok := local[c.Checksum]
+		if ok && s.size == c.Size {
+			from = current
+		} else if s, ok = written[c.Checksum]; ok && s.size == c.Size {
+			from = staged
+		}
+
+		if from != nil {
+			if _, err := io.Copy(w, io.NewSectionReader(from, s.offset, s.size)); err != nil {
+				return fmt.Errorf("failed to copy chunk %s: %w", c.Checksum, err)
+			}
+		} else {
+			if err := u.Download(ctx, update.ChunkPrefixURL+c.Checksum, update.ChecksumAlgo, c.Checksum, w); err != nil {
+				return err
+			}
+			written[c.Checksum] = span{offset, c.Size}
+		}
+		offset += c.Size
+	}
+	return verify(hasher, update.Checksum)
+}
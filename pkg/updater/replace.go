@@ -0,0 +1,35 @@
+//go:build !windows
+
+package updater
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Replace atomically moves the file at staged over executable. Both must be
+// on the same file system.
+func Replace(executable, staged string) error {
+	if err := os.Rename(staged, executable); err != nil {
+		return fmt.Errorf("failed to replace executable: %w", err)
+	}
+	return nil
+}
+
+// Restart replaces the current process with a fresh instance of the
+// executable, keeping its arguments and environment.
+func Restart() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
+
+// Cleanup removes leftovers of a previous Replace. It is a no-op outside
+// Windows.
+func Cleanup() error {
+	return nil
+}
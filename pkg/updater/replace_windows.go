@@ -0,0 +1,64 @@
+//go:build windows
+
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Replace moves the file at staged over executable. A running executable
+// cannot be overwritten on Windows but it can be renamed, so the current file
+// is moved aside to "<executable>.old" first and restored if the swap fails.
+// Call Cleanup on the next start to remove it.
+func Replace(executable, staged string) error {
+	old := executable + ".old"
+	_ = os.Remove(old)
+
+	if err := os.Rename(executable, old); err != nil {
+		return fmt.Errorf("failed to move executable aside: %w", err)
+	}
+
+	if err := os.Rename(staged, executable); err != nil {
+		if restoreErr := os.Rename(old, executable); restoreErr != nil {
+			return fmt.Errorf("failed to replace executable: %w", errors.Join(err, restoreErr))
+		}
+		return fmt.Errorf("failed to replace executable: %w", err)
+	}
+
+	return nil
+}
+
+// Restart starts a fresh instance of the executable with the same arguments
+// and environment, then exits the current process.
+func Restart() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to restart: %w", err)
+	}
+
+	os.Exit(0)
+	return nil
+}
+
+// Cleanup removes the executable moved aside by a previous Replace.
+func Cleanup() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(executable + ".old"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
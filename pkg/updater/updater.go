@@ -0,0 +1,299 @@
+// Package updater downloads a release found by the client package, verifies
+// it and replaces the running executable with it.
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+
+	"update-manifest/pkg/client"
+	"update-manifest/pkg/manifest"
+	"update-manifest/pkg/patch"
+	"update-manifest/pkg/signing"
+)
+
+// ErrChecksumMismatch is returned when downloaded content does not match the
+// checksum recorded in the manifest.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Updater applies updates to an executable.
+type Updater struct {
+	// HTTPClient is used for downloads. http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// Executable is the path of the executable to replace. The running
+	// executable when empty.
+	Executable string
+	// TrustedKeys, when set, makes Apply and Extract refuse artifacts
+	// without a detached signature by one of these keys, so artifacts
+	// fetched from a mirror are known to be authentic.
+	TrustedKeys []signing.PublicKey
+}
+
+// Apply downloads update and replaces the executable with it. A published
+// patch is used when one was made from the current executable. Otherwise,
+// or if patching fails, an artifact published as chunks is assembled from the
+// chunks it shares with the current executable and the others downloaded,
+// and the full artifact is downloaded if there are none or that fails too.
+// Updates to other kinds of artifacts than the executable, such as
+// installers, are not applied; fetch them with Download instead, nor are
+// archives, which Extract installs.
+func (u *Updater) Apply(ctx context.Context, update *client.Update) error {
+	if update.Kind != "" {
+		return fmt.Errorf("cannot replace the executable with a %s artifact", update.Kind)
+	}
+	if update.Archive != nil {
+		return fmt.Errorf("cannot replace the executable with a %s archive", update.Archive.Format)
+	}
+	if _, err := manifest.NewHash(update.ChecksumAlgo); err != nil {
+		return err
+	}
+	if err := u.verifySignature(ctx, update); err != nil {
+		return err
+	}
+
+	executable, err := u.executable()
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(executable)
+	if err != nil {
+		return fmt.Errorf("failed to stat executable: %w", err)
+	}
+
+	staged, err := u.stagePatched(ctx, executable, update)
+	if err != nil || staged == "" {
+		staged, err = u.stageChunks(ctx, executable, update)
+	}
+	if err != nil || staged == "" {
+		staged, err = u.stageDownload(ctx, executable, update)
+		if err != nil {
+			return err
+		}
+	}
+	defer os.Remove(staged)
+
+	if err := os.Chmod(staged, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+
+	return Replace(executable, staged)
+}
+
+// Download writes the content at url to w and verifies its checksum made with
+// the checksum algorithm algo, the default algorithm of the manifest package
+// when empty.
+func (u *Updater) Download(ctx context.Context, url, algo, checksum string, w io.Writer) error {
+	hasher, err := manifest.NewHash(algo)
+	if err != nil {
+		return err
+	}
+
+	body, err := u.get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if _, err := io.Copy(io.MultiWriter(w, hasher), body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+
+	return verify(hasher, checksum)
+}
+
+// maxSignatureSize bounds the detached signature fetched for an artifact.
+const maxSignatureSize = 1 << 20
+
+// verifySignature checks the detached signature of the artifact of update
+// against the trusted keys, if there are any. As the signature covers the
+// checksum of the artifact, the content it is checked against afterwards is
+// authentic too.
+func (u *Updater) verifySignature(ctx context.Context, update *client.Update) error {
+	if len(u.TrustedKeys) == 0 {
+		return nil
+	}
+	if update.SignatureURL == "" {
+		return fmt.Errorf("%w: artifact is not signed", signing.ErrNoValidSignature)
+	}
+
+	body, err := u.get(ctx, update.SignatureURL)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	envelope, err := io.ReadAll(io.LimitReader(body, maxSignatureSize))
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", update.SignatureURL, err)
+	}
+	return signing.VerifyDetached(manifest.ArtifactMessage(update.ChecksumAlgo, update.Checksum), envelope, u.TrustedKeys...)
+}
+
+// get requests url and returns the body of the response, which must be OK.
+func (u *Updater) get(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := u.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download %s: unexpected status %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// downloadArtifact writes the artifact of update to w, downloading its
+// compressed copy instead if one was published in a format the updater
+// understands, and verifies it.
+func (u *Updater) downloadArtifact(ctx context.Context, update *client.Update, w io.Writer) error {
+	if update.CompressedURL == "" || update.Compressed == nil || update.Compressed.Format != manifest.CompressionZstd {
+		return u.Download(ctx, update.ArtifactURL, update.ChecksumAlgo, update.Checksum, w)
+	}
+
+	compressed, pw := io.Pipe()
+	defer compressed.Close()
+	go func() {
+		pw.CloseWithError(u.Download(ctx, update.CompressedURL, update.ChecksumAlgo, update.Compressed.Checksum, pw))
+	}()
+
+	decoder, err := zstd.NewReader(compressed, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return fmt.Errorf("failed to decompress %s: %w", update.CompressedURL, err)
+	}
+	defer decoder.Close()
+
+	hasher := newHasher(update.ChecksumAlgo)
+	if _, err := io.Copy(io.MultiWriter(w, hasher), decoder); err != nil {
+		return fmt.Errorf("failed to decompress %s: %w", update.CompressedURL, err)
+	}
+	return verify(hasher, update.Checksum)
+}
+
+// stageDownload downloads the full artifact next to executable.
+func (u *Updater) stageDownload(ctx context.Context, executable string, update *client.Update) (string, error) {
+	staged, err := os.CreateTemp(filepath.Dir(executable), ".update-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging file: %w", err)
+	}
+	defer staged.Close()
+
+	if err := u.downloadArtifact(ctx, update, staged); err != nil {
+		os.Remove(staged.Name())
+		return "", err
+	}
+
+	if err := staged.Close(); err != nil {
+		os.Remove(staged.Name())
+		return "", err
+	}
+	return staged.Name(), nil
+}
+
+// stagePatched applies the published patch made from executable to it. It
+// returns an empty path if no usable patch exists.
+func (u *Updater) stagePatched(ctx context.Context, executable string, update *client.Update) (string, error) {
+	patches := update.Patches
+	if update.PatchURL != "" {
+		patches = append([]client.Patch{{URL: update.PatchURL, Checksum: update.PatchChecksum, From: update.PatchFrom, Format: update.PatchFormat, Size: update.PatchSize}}, patches...)
+	}
+	if len(patches) == 0 {
+		return "", nil
+	}
+
+	current, err := os.ReadFile(executable)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := newHasher(update.ChecksumAlgo)
+	hasher.Write(current)
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	i := slices.IndexFunc(patches, func(delta client.Patch) bool {
+		return delta.From == checksum && patch.Supported(delta.Format)
+	})
+	if i < 0 {
+		return "", nil
+	}
+
+	var delta bytes.Buffer
+	if err := u.Download(ctx, patches[i].URL, update.ChecksumAlgo, patches[i].Checksum, &delta); err != nil {
+		return "", err
+	}
+
+	patched, err := patch.Apply(current, &delta)
+	if err != nil {
+		return "", err
+	}
+
+	hasher.Reset()
+	hasher.Write(patched)
+	if err := verify(hasher, update.Checksum); err != nil {
+		return "", err
+	}
+
+	staged, err := os.CreateTemp(filepath.Dir(executable), ".update-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging file: %w", err)
+	}
+	defer staged.Close()
+
+	if _, err := staged.Write(patched); err != nil {
+		os.Remove(staged.Name())
+		return "", err
+	}
+	if err := staged.Close(); err != nil {
+		os.Remove(staged.Name())
+		return "", err
+	}
+	return staged.Name(), nil
+}
+
+func (u *Updater) executable() (string, error) {
+	if u.Executable != "" {
+		return u.Executable, nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate executable: %w", err)
+	}
+	return filepath.EvalSymlinks(executable)
+}
+
+func (u *Updater) httpClient() *http.Client {
+	if u.HTTPClient != nil {
+		return u.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// newHasher returns a hash of the checksum algorithm algo, which the caller
+// made sure is known.
+func newHasher(algo string) hash.Hash {
+	hasher, _ := manifest.NewHash(algo)
+	return hasher
+}
+
+func verify(hasher hash.Hash, checksum string) error {
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != checksum {
+		return fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, got, checksum)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package vault
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"update-manifest/pkg/signing"
+)
+
+// TransitSigner signs with an Ed25519 key of a transit secrets engine, which
+// never leaves Vault.
+type TransitSigner struct {
+	client  *Client
+	mount   string
+	name    string
+	version int
+	public  signing.PublicKey
+}
+
+// TransitSigner returns a signer using the latest version of the key name of
+// the transit engine mounted at mount. The version is pinned, so a rotation
+// of the key while signing does not mix keys.
+func (c *Client) TransitSigner(ctx context.Context, mount, name string) (*TransitSigner, error) {
+	var resp struct {
+		Data struct {
+			Type          string                     `json:"type"`
+			LatestVersion int                        `json:"latest_version"`
+			Keys          map[string]json.RawMessage `json:"keys"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, mount+"/keys/"+name, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to read Vault transit key %s: %w", name, err)
+	}
+	if resp.Data.Type != "ed25519" {
+		return nil, fmt.Errorf("Vault transit key %s is of type %s, not ed25519", name, resp.Data.Type)
+	}
+
+	var key struct {
+		PublicKey string `json:"public_key"`
+	}
+	version := resp.Data.LatestVersion
+	if err := json.Unmarshal(resp.Data.Keys[strconv.Itoa(version)], &key); err != nil {
+		return nil, fmt.Errorf("failed to decode Vault transit key %s: %w", name, err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Vault transit key %s has no valid Ed25519 public key", name)
+	}
+	public, err := signing.NewPublicKey(ed25519.PublicKey(raw))
+	if err != nil {
+		return nil, err
+	}
+	return &TransitSigner{client: c, mount: mount, name: name, version: version, public: public}, nil
+}
+
+func (s *TransitSigner) Public() signing.PublicKey {
+	return s.public
+}
+
+func (s *TransitSigner) Sign(ctx context.Context, message []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+	body := map[string]any{
+		"input":       base64.StdEncoding.EncodeToString(message),
+		"key_version": s.version,
+	}
+	if err := s.client.do(ctx, http.MethodPost, s.mount+"/sign/"+s.name, body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to sign with Vault transit key %s: %w", s.name, err)
+	}
+
+	// signatures are prefixed with vault:v<version>:
+	encoded := resp.Data.Signature[strings.LastIndex(resp.Data.Signature, ":")+1:]
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("Vault transit key %s returned an invalid signature", s.name)
+	}
+	return signature, nil
+}
@@ -0,0 +1,122 @@
+// Package vault reads secrets from HashiCorp Vault and signs with keys of its
+// transit secrets engine, through the HTTP API of the server.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Client sends requests to a Vault server.
+type Client struct {
+	// Address is the URL of the server, e.g. https://vault.example.com:8200.
+	Address string
+	// Token authenticates the requests.
+	Token string
+	// Namespace is the Vault Enterprise namespace of the requests, if any.
+	Namespace string
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// Login authenticates as role with the JWT auth method mounted at mount,
+// presenting jwt, e.g. the OIDC ID token of a CI job, and authenticates the
+// following requests with the token it grants.
+func (c *Client) Login(ctx context.Context, mount, role, jwt string) error {
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role": role, "jwt": jwt}
+	if err := c.do(ctx, http.MethodPost, "auth/"+mount+"/login", body, &resp); err != nil {
+		return fmt.Errorf("failed to log in to Vault: %w", err)
+	}
+	if resp.Auth.ClientToken == "" {
+		return fmt.Errorf("failed to log in to Vault: no token granted")
+	}
+	c.Token = resp.Auth.ClientToken
+	return nil
+}
+
+// ReadSecret returns the fields of the secret at path of a KV secrets
+// engine. Paths of secrets of version 2 engines include their data
+// component, e.g. secret/data/releases.
+func (c *Client) ReadSecret(ctx context.Context, path string) (map[string]any, error) {
+	var resp struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to read Vault secret %s: %w", path, err)
+	}
+
+	// version 2 engines wrap the fields along with the metadata of the
+	// version read
+	if data, ok := resp.Data["data"].(map[string]any); ok {
+		if _, versioned := resp.Data["metadata"]; versioned {
+			return data, nil
+		}
+	}
+	return resp.Data, nil
+}
+
+// do sends a request with the JSON body to the API path and decodes the JSON
+// response into result.
+func (c *Client) do(ctx context.Context, method, path string, body, result any) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	target := strings.TrimSuffix(c.Address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, target, payload)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Vault-Token", c.Token)
+	}
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &failure) == nil && len(failure.Errors) > 0 {
+			return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.Join(failure.Errors, "; "))
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}